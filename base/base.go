@@ -9,6 +9,7 @@ const (
 	GlobalStoreSystemModeMaintenance = "MAINTENANCE"
 	GlobalStoreSystemModeNormal      = "NORMAL"
 	PrivilegeNameIdSetMaintenance    = "GLOBAL.SET_MAINTENANCE_MODE"
+	PrivilegeNameIdImpersonateUser   = "USER.IMPERSONATE"
 )
 
 var (
@@ -0,0 +1,172 @@
+package lib
+
+import (
+	"sync"
+	"time"
+)
+
+type CircuitBreakerState int
+
+const (
+	CircuitBreakerStateClosed CircuitBreakerState = iota
+	CircuitBreakerStateOpen
+	CircuitBreakerStateHalfOpen
+)
+
+func (s CircuitBreakerState) String() string {
+	switch s {
+	case CircuitBreakerStateClosed:
+		return "CLOSED"
+	case CircuitBreakerStateOpen:
+		return "OPEN"
+	case CircuitBreakerStateHalfOpen:
+		return "HALF_OPEN"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// CircuitBreaker stops calls to a failing dependency (Redis, a push gateway, ...) for a cool-down period.
+//
+// Closed: calls pass, consecutive failures are counted, reaching threshold opens the breaker.
+// Open: calls are refused until timeout has passed since the breaker opened, then it turns half-open.
+// Half-open: at most maxHalfOpenProbes calls pass at the same time; one success closes the breaker, one failure opens it again.
+//
+// Every call allowed by CanExecute must be followed by exactly one RecordSuccess or RecordFailure,
+// otherwise a half-open probe slot stays taken.
+type CircuitBreaker struct {
+	threshold         int
+	timeout           time.Duration
+	maxHalfOpenProbes int
+
+	mutex            sync.Mutex
+	state            CircuitBreakerState
+	failureCount     int
+	openedAt         time.Time
+	halfOpenInFlight int
+	onStateChange    func(from CircuitBreakerState, to CircuitBreakerState)
+}
+
+// CircuitBreakerSnapshot is a consistent copy of the breaker state for status endpoints
+type CircuitBreakerSnapshot struct {
+	State            CircuitBreakerState
+	FailureCount     int
+	OpenedAt         time.Time
+	HalfOpenInFlight int
+}
+
+// NewCircuitBreaker creates a closed breaker; threshold and maxHalfOpenProbes below 1 are treated as 1
+func NewCircuitBreaker(threshold int, timeout time.Duration, maxHalfOpenProbes int) *CircuitBreaker {
+	if threshold < 1 {
+		threshold = 1
+	}
+	if maxHalfOpenProbes < 1 {
+		maxHalfOpenProbes = 1
+	}
+	return &CircuitBreaker{
+		threshold:         threshold,
+		timeout:           timeout,
+		maxHalfOpenProbes: maxHalfOpenProbes,
+		state:             CircuitBreakerStateClosed,
+	}
+}
+
+// OnStateChange registers a callback run after every state transition, outside the breaker lock
+func (cb *CircuitBreaker) OnStateChange(f func(from CircuitBreakerState, to CircuitBreakerState)) {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	cb.onStateChange = f
+}
+
+// CanExecute reports whether a call may go through, reserving a probe slot when half-open
+func (cb *CircuitBreaker) CanExecute() bool {
+	cb.mutex.Lock()
+	allowed, from, changed := cb.canExecuteLocked()
+	callback := cb.onStateChange
+	cb.mutex.Unlock()
+
+	if changed && callback != nil {
+		callback(from, CircuitBreakerStateHalfOpen)
+	}
+	return allowed
+}
+
+func (cb *CircuitBreaker) canExecuteLocked() (allowed bool, from CircuitBreakerState, changed bool) {
+	switch cb.state {
+	case CircuitBreakerStateClosed:
+		return true, cb.state, false
+	case CircuitBreakerStateOpen:
+		if time.Since(cb.openedAt) < cb.timeout {
+			return false, cb.state, false
+		}
+		cb.state = CircuitBreakerStateHalfOpen
+		cb.halfOpenInFlight = 1
+		return true, CircuitBreakerStateOpen, true
+	default:
+		if cb.halfOpenInFlight >= cb.maxHalfOpenProbes {
+			return false, cb.state, false
+		}
+		cb.halfOpenInFlight++
+		return true, cb.state, false
+	}
+}
+
+// RecordSuccess resets the failure count and closes a half-open breaker
+func (cb *CircuitBreaker) RecordSuccess() {
+	cb.mutex.Lock()
+	from := cb.state
+	cb.failureCount = 0
+	if cb.state == CircuitBreakerStateHalfOpen {
+		cb.state = CircuitBreakerStateClosed
+		cb.halfOpenInFlight = 0
+	}
+	to := cb.state
+	callback := cb.onStateChange
+	cb.mutex.Unlock()
+
+	if from != to && callback != nil {
+		callback(from, to)
+	}
+}
+
+// RecordFailure counts a failure, opening the breaker at threshold or on a failed half-open probe
+func (cb *CircuitBreaker) RecordFailure() {
+	cb.mutex.Lock()
+	from := cb.state
+	cb.failureCount++
+	switch cb.state {
+	case CircuitBreakerStateClosed:
+		if cb.failureCount >= cb.threshold {
+			cb.state = CircuitBreakerStateOpen
+			cb.openedAt = time.Now()
+		}
+	case CircuitBreakerStateHalfOpen:
+		cb.state = CircuitBreakerStateOpen
+		cb.openedAt = time.Now()
+		cb.halfOpenInFlight = 0
+	}
+	to := cb.state
+	callback := cb.onStateChange
+	cb.mutex.Unlock()
+
+	if from != to && callback != nil {
+		callback(from, to)
+	}
+}
+
+func (cb *CircuitBreaker) GetState() CircuitBreakerState {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	return cb.state
+}
+
+func (cb *CircuitBreaker) Snapshot() CircuitBreakerSnapshot {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	return CircuitBreakerSnapshot{
+		State:            cb.state,
+		FailureCount:     cb.failureCount,
+		OpenedAt:         cb.openedAt,
+		HalfOpenInFlight: cb.halfOpenInFlight,
+	}
+}
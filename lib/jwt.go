@@ -0,0 +1,130 @@
+package lib
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// JWT (RFC 7519) signed with EdDSA over Ed25519 (RFC 8037), compact serialization only.
+// Tokens carry the kid of the signing key in their header, so keys can be rotated: a new key becomes the signing key
+// while the previous ones stay in the key set for verification until the tokens they signed have expired.
+
+const JWTAlgorithmEdDSA = "EdDSA"
+
+var (
+	ErrJWTMalformed        = errors.New("JWT_MALFORMED")
+	ErrJWTUnsupportedAlg   = errors.New("JWT_UNSUPPORTED_ALGORITHM")
+	ErrJWTUnknownKid       = errors.New("JWT_UNKNOWN_KID")
+	ErrJWTInvalidSignature = errors.New("JWT_INVALID_SIGNATURE")
+	ErrJWTExpired          = errors.New("JWT_EXPIRED")
+	ErrJWTNotYetValid      = errors.New("JWT_NOT_YET_VALID")
+	ErrJWTNoSigningKey     = errors.New("JWT_NO_SIGNING_KEY")
+)
+
+var jwtBase64 = base64.RawURLEncoding
+
+// JWTKeySet is immutable once built; swap the whole set to rotate keys
+type JWTKeySet struct {
+	SigningKid  string
+	PrivateKeys map[string]ed25519.PrivateKey
+	PublicKeys  map[string]ed25519.PublicKey
+}
+
+// Sign returns a token for claims signed with the signing key
+func (ks *JWTKeySet) Sign(claims map[string]any) (string, error) {
+	privateKey, ok := ks.PrivateKeys[ks.SigningKid]
+	if !ok || ks.SigningKid == "" {
+		return "", ErrJWTNoSigningKey
+	}
+	header, err := json.Marshal(map[string]any{
+		"alg": JWTAlgorithmEdDSA,
+		"typ": "JWT",
+		"kid": ks.SigningKid,
+	})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := jwtBase64.EncodeToString(header) + "." + jwtBase64.EncodeToString(payload)
+	signature := ed25519.Sign(privateKey, []byte(signingInput))
+	return signingInput + "." + jwtBase64.EncodeToString(signature), nil
+}
+
+// Verify checks the signature against the key named by kid and the exp and nbf claims at now, then returns the claims.
+// Numeric claims are decoded as json.Number.
+func (ks *JWTKeySet) Verify(token string, now time.Time) (claims map[string]any, err error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrJWTMalformed
+	}
+	headerAsBytes, err := jwtBase64.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrJWTMalformed
+	}
+	header := map[string]any{}
+	err = json.Unmarshal(headerAsBytes, &header)
+	if err != nil {
+		return nil, ErrJWTMalformed
+	}
+	if alg, _ := header["alg"].(string); alg != JWTAlgorithmEdDSA {
+		return nil, ErrJWTUnsupportedAlg
+	}
+	kid, _ := header["kid"].(string)
+	publicKey, ok := ks.PublicKeys[kid]
+	if !ok {
+		return nil, ErrJWTUnknownKid
+	}
+	signature, err := jwtBase64.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrJWTMalformed
+	}
+	if !ed25519.Verify(publicKey, []byte(parts[0]+"."+parts[1]), signature) {
+		return nil, ErrJWTInvalidSignature
+	}
+
+	payload, err := jwtBase64.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrJWTMalformed
+	}
+	decoder := json.NewDecoder(strings.NewReader(string(payload)))
+	decoder.UseNumber()
+	err = decoder.Decode(&claims)
+	if err != nil || claims == nil {
+		return nil, ErrJWTMalformed
+	}
+	if exp, ok := jwtNumericDate(claims, "exp"); ok && now.Unix() >= exp {
+		return nil, ErrJWTExpired
+	}
+	if nbf, ok := jwtNumericDate(claims, "nbf"); ok && now.Unix() < nbf {
+		return nil, ErrJWTNotYetValid
+	}
+	return claims, nil
+}
+
+func jwtNumericDate(claims map[string]any, name string) (int64, bool) {
+	n, ok := claims[name].(json.Number)
+	if !ok {
+		return 0, false
+	}
+	v, err := n.Int64()
+	if err != nil {
+		f, err := n.Float64()
+		if err != nil {
+			return 0, false
+		}
+		v = int64(f)
+	}
+	return v, true
+}
+
+// JWTIsCompact reports whether a bearer token looks like a compact JWT rather than an opaque session key
+func JWTIsCompact(token string) bool {
+	return strings.Count(token, ".") == 2
+}
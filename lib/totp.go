@@ -0,0 +1,101 @@
+package lib
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP (RFC 6238) with the parameters authenticator apps assume when the provisioning URI does not override them:
+// HMAC-SHA1, 6 digits, 30 second time step.
+const (
+	TOTPDigits          = 6
+	TOTPPeriodInSeconds = 30
+	totpSecretSize      = 20
+)
+
+var totpBase32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TOTPGenerateSecret returns a new random 160 bit secret, base32 encoded without padding
+func TOTPGenerateSecret() (string, error) {
+	secret := make([]byte, totpSecretSize)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", err
+	}
+	return totpBase32.EncodeToString(secret), nil
+}
+
+// TOTPTimeStep returns the RFC 6238 counter for t
+func TOTPTimeStep(t time.Time) int64 {
+	return t.Unix() / TOTPPeriodInSeconds
+}
+
+// TOTPCode computes the code for one time step
+func TOTPCode(secret string, timeStep int64) (string, error) {
+	key, err := totpBase32.DecodeString(strings.ToUpper(strings.TrimRight(strings.ReplaceAll(secret, " ", ""), "=")))
+	if err != nil {
+		return "", err
+	}
+
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, uint64(timeStep))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter)
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation, RFC 4226 section 5.3
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	modulo := uint32(1)
+	for i := 0; i < TOTPDigits; i++ {
+		modulo *= 10
+	}
+	return fmt.Sprintf("%0*d", TOTPDigits, value%modulo), nil
+}
+
+// TOTPVerify checks code against the time steps within skewSteps of t and returns the matching step.
+// Callers must reject a step that is not greater than the last accepted one, otherwise a code can be replayed.
+func TOTPVerify(secret string, code string, t time.Time, skewSteps int) (matchedTimeStep int64, isValid bool, err error) {
+	code = strings.TrimSpace(code)
+	if len(code) != TOTPDigits {
+		return 0, false, nil
+	}
+	currentTimeStep := TOTPTimeStep(t)
+	for i := -skewSteps; i <= skewSteps; i++ {
+		timeStep := currentTimeStep + int64(i)
+		expected, err := TOTPCode(secret, timeStep)
+		if err != nil {
+			return 0, false, err
+		}
+		if hmac.Equal([]byte(expected), []byte(code)) {
+			return timeStep, true, nil
+		}
+	}
+	return 0, false, nil
+}
+
+// TOTPProvisioningURI builds the otpauth:// URI that authenticator apps read from a QR code
+func TOTPProvisioningURI(issuer string, accountName string, secret string) string {
+	label := url.PathEscape(accountName)
+	if issuer != "" {
+		label = url.PathEscape(issuer) + ":" + label
+	}
+	v := url.Values{}
+	v.Set("secret", secret)
+	if issuer != "" {
+		v.Set("issuer", issuer)
+	}
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprintf("%d", TOTPDigits))
+	v.Set("period", fmt.Sprintf("%d", TOTPPeriodInSeconds))
+	// Some authenticator apps show a literal '+' in the issuer, encode spaces as %20 instead
+	return "otpauth://totp/" + label + "?" + strings.ReplaceAll(v.Encode(), "+", "%20")
+}
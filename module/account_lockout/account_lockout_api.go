@@ -3,6 +3,7 @@ package account_lockout
 import (
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/donnyhardyanto/dxlib/api"
 	"github.com/donnyhardyanto/dxlib/utils"
@@ -33,7 +34,7 @@ func (al *DXMAccountLockout) GetStatusByUserUid(aepr *api.DXAPIEndPointRequest,
 	}
 
 	// Check if module is enabled
-	if !al.Config.Enabled {
+	if !al.GetConfig().Enabled {
 		aepr.WriteResponseAsJSON(http.StatusOK, nil, utils.JSON{
 			"is_enabled": false,
 			"is_locked":  false,
@@ -83,7 +84,7 @@ func (al *DXMAccountLockout) UnlockByUserUid(aepr *api.DXAPIEndPointRequest, use
 	}
 
 	// Check if module is enabled
-	if !al.Config.Enabled {
+	if !al.GetConfig().Enabled {
 		return aepr.WriteResponseAndNewErrorf(http.StatusBadRequest, "MODULE_DISABLED", "Account lockout module is disabled")
 	}
 
@@ -150,7 +151,7 @@ func (al *DXMAccountLockout) GetHistoryByUserUid(aepr *api.DXAPIEndPointRequest,
 	}
 
 	// Check if module is enabled
-	if !al.Config.Enabled {
+	if !al.GetConfig().Enabled {
 		aepr.WriteResponseAsJSON(http.StatusOK, nil, utils.JSON{
 			"is_enabled": false,
 			"events":     []utils.JSON{},
@@ -175,3 +176,139 @@ func (al *DXMAccountLockout) GetHistoryByUserUid(aepr *api.DXAPIEndPointRequest,
 	})
 	return nil
 }
+
+// UnblockIP removes a source IP block
+// This is the FIXED/HOW logic separated from API configuration
+func (al *DXMAccountLockout) UnblockIP(aepr *api.DXAPIEndPointRequest, attemptIP string, reason string) error {
+	// Check if module is enabled
+	if !al.GetConfig().Enabled {
+		return aepr.WriteResponseAndNewErrorf(http.StatusBadRequest, "MODULE_DISABLED", "Account lockout module is disabled")
+	}
+
+	// Get admin user info from session
+	adminUserUid := aepr.CurrentUser.Uid
+	adminUserId := int64(0)
+	if aepr.CurrentUser.Id != "" {
+		if id, parseErr := strconv.ParseInt(aepr.CurrentUser.Id, 10, 64); parseErr == nil {
+			adminUserId = id
+		}
+	}
+
+	err := al.UnblockSource(aepr.Context, attemptIP, adminUserId, adminUserUid, reason)
+	if err != nil {
+		aepr.WriteResponseAndLogAsError(http.StatusInternalServerError, "FAILED_TO_UNBLOCK_IP", err)
+		return err
+	}
+
+	aepr.WriteResponseAsJSON(http.StatusOK, nil, utils.JSON{
+		"message":         "IP unblocked successfully",
+		"ip":              attemptIP,
+		"unlocked_by_uid": adminUserUid,
+		"reason":          reason,
+	})
+	return nil
+}
+
+// parseStatisticsRange parses an inclusive YYYY-MM-DD range; an empty end date means today
+func parseStatisticsRange(startDateAsString string, endDateAsString string) (time.Time, time.Time, error) {
+	startDate, err := time.ParseInLocation(statisticsDateLayoutAPI, startDateAsString, time.Local)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	endDate := time.Now()
+	if endDateAsString != "" {
+		endDate, err = time.ParseInLocation(statisticsDateLayoutAPI, endDateAsString, time.Local)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+	}
+	endDate = time.Date(endDate.Year(), endDate.Month(), endDate.Day(), 0, 0, 0, 0, time.Local)
+	return startDate, endDate, nil
+}
+
+// GetStatisticsTimeSeriesByDateRange returns the daily lockout counters for a date range
+// This is the FIXED/HOW logic separated from API configuration
+func (al *DXMAccountLockout) GetStatisticsTimeSeriesByDateRange(aepr *api.DXAPIEndPointRequest, startDateAsString string, endDateAsString string) error {
+	startDate, endDate, err := parseStatisticsRange(startDateAsString, endDateAsString)
+	if err != nil {
+		return aepr.WriteResponseAndNewErrorf(http.StatusBadRequest, "INVALID_DATE_RANGE", "NOT_ERROR:INVALID_DATE_RANGE:%s", err.Error())
+	}
+	if endDate.Before(startDate) {
+		return aepr.WriteResponseAndNewErrorf(http.StatusBadRequest, "INVALID_DATE_RANGE", "NOT_ERROR:INVALID_DATE_RANGE:END_DATE_BEFORE_START_DATE")
+	}
+	if endDate.Sub(startDate) > 366*24*time.Hour {
+		return aepr.WriteResponseAndNewErrorf(http.StatusBadRequest, "INVALID_DATE_RANGE", "NOT_ERROR:INVALID_DATE_RANGE:RANGE_TOO_LONG")
+	}
+
+	days, err := al.GetStatisticsTimeSeries(aepr.Context, startDate, endDate)
+	if err != nil {
+		aepr.WriteResponseAndLogAsError(http.StatusInternalServerError, "FAILED_TO_GET_LOCKOUT_STATISTICS", err)
+		return err
+	}
+
+	aepr.WriteResponseAsJSON(http.StatusOK, nil, utils.JSON{
+		"start_date":     startDate.Format(statisticsDateLayoutAPI),
+		"end_date":       endDate.Format(statisticsDateLayoutAPI),
+		"retention_days": al.GetConfig().StatisticsRetentionDays,
+		"days":           days,
+	})
+	return nil
+}
+
+// GetStatisticsTopLockedByDateRange returns the most locked users or blocked IPs for a date range
+// This is the FIXED/HOW logic separated from API configuration
+func (al *DXMAccountLockout) GetStatisticsTopLockedByDateRange(aepr *api.DXAPIEndPointRequest, kind string, startDateAsString string, endDateAsString string, limit int64) error {
+	if kind != "users" && kind != "ips" {
+		return aepr.WriteResponseAndNewErrorf(http.StatusBadRequest, "INVALID_KIND", "NOT_ERROR:INVALID_KIND:%s", kind)
+	}
+	// Validate and set default limit
+	if limit <= 0 {
+		limit = 10 // default
+	}
+	if limit > 100 {
+		limit = 100 // max
+	}
+
+	startDate, endDate, err := parseStatisticsRange(startDateAsString, endDateAsString)
+	if err != nil {
+		return aepr.WriteResponseAndNewErrorf(http.StatusBadRequest, "INVALID_DATE_RANGE", "NOT_ERROR:INVALID_DATE_RANGE:%s", err.Error())
+	}
+	if endDate.Before(startDate) {
+		return aepr.WriteResponseAndNewErrorf(http.StatusBadRequest, "INVALID_DATE_RANGE", "NOT_ERROR:INVALID_DATE_RANGE:END_DATE_BEFORE_START_DATE")
+	}
+	if endDate.Sub(startDate) > 366*24*time.Hour {
+		return aepr.WriteResponseAndNewErrorf(http.StatusBadRequest, "INVALID_DATE_RANGE", "NOT_ERROR:INVALID_DATE_RANGE:RANGE_TOO_LONG")
+	}
+
+	items, err := al.GetStatisticsTopLocked(aepr.Context, kind, startDate, endDate, int(limit))
+	if err != nil {
+		aepr.WriteResponseAndLogAsError(http.StatusInternalServerError, "FAILED_TO_GET_LOCKOUT_STATISTICS", err)
+		return err
+	}
+
+	aepr.WriteResponseAsJSON(http.StatusOK, nil, utils.JSON{
+		"kind":       kind,
+		"start_date": startDate.Format(statisticsDateLayoutAPI),
+		"end_date":   endDate.Format(statisticsDateLayoutAPI),
+		"items":      items,
+		"count":      len(items),
+	})
+	return nil
+}
+
+// ReloadConfigByAdmin reloads the account lockout configuration and reports what changed
+// This is the FIXED/HOW logic separated from API configuration
+func (al *DXMAccountLockout) ReloadConfigByAdmin(aepr *api.DXAPIEndPointRequest) error {
+	changes, err := al.ReloadConfig()
+	if err != nil {
+		return aepr.WriteResponseAndNewErrorf(http.StatusBadRequest, "INVALID_ACCOUNT_LOCKOUT_CONFIGURATION", "NOT_ERROR:INVALID_ACCOUNT_LOCKOUT_CONFIGURATION:%s", err.Error())
+	}
+
+	aepr.Log.Infof("Account lockout configuration reloaded by %s: %d change(s)", aepr.CurrentUser.Uid, len(changes))
+	aepr.WriteResponseAsJSON(http.StatusOK, nil, utils.JSON{
+		"message": "Account lockout configuration reloaded",
+		"changes": changes,
+		"count":   len(changes),
+	})
+	return nil
+}
@@ -48,3 +48,69 @@ func (al *DXMAccountLockout) AccountLockoutHistoryByUserUidHandler(aepr *api.DXA
 
 	return al.GetHistoryByUserUid(aepr, userUid, limit)
 }
+
+// AccountLockoutUnblockIPHandler - Complete handler in module (FIXED/HOW)
+func (al *DXMAccountLockout) AccountLockoutUnblockIPHandler(aepr *api.DXAPIEndPointRequest) error {
+	_, ip, err := aepr.GetParameterValueAsString("ip")
+	if err != nil {
+		return aepr.WriteResponseAndNewErrorf(http.StatusBadRequest, "PARAMETER_IP_REQUIRED", "Parameter ip is required")
+	}
+
+	_, reason, err := aepr.GetParameterValueAsString("reason")
+	if err != nil {
+		return aepr.WriteResponseAndNewErrorf(http.StatusBadRequest, "PARAMETER_REASON_REQUIRED", "Parameter reason is required")
+	}
+
+	return al.UnblockIP(aepr, ip, reason)
+}
+
+// AccountLockoutStatisticsTimeSeriesHandler - Complete handler in module (FIXED/HOW)
+func (al *DXMAccountLockout) AccountLockoutStatisticsTimeSeriesHandler(aepr *api.DXAPIEndPointRequest) error {
+	_, startDate, err := aepr.GetParameterValueAsString("start_date")
+	if err != nil {
+		return aepr.WriteResponseAndNewErrorf(http.StatusBadRequest, "PARAMETER_START_DATE_REQUIRED", "Parameter start_date is required")
+	}
+
+	_, endDate, err := aepr.GetParameterValueAsString("end_date")
+	if err != nil {
+		endDate = "" // Will use today
+	}
+
+	return al.GetStatisticsTimeSeriesByDateRange(aepr, startDate, endDate)
+}
+
+// AccountLockoutStatisticsTopLockedHandler - Complete handler in module (FIXED/HOW)
+func (al *DXMAccountLockout) AccountLockoutStatisticsTopLockedHandler(aepr *api.DXAPIEndPointRequest) error {
+	_, kind, err := aepr.GetParameterValueAsString("kind")
+	if err != nil {
+		return aepr.WriteResponseAndNewErrorf(http.StatusBadRequest, "PARAMETER_KIND_REQUIRED", "Parameter kind is required")
+	}
+
+	_, startDate, err := aepr.GetParameterValueAsString("start_date")
+	if err != nil {
+		return aepr.WriteResponseAndNewErrorf(http.StatusBadRequest, "PARAMETER_START_DATE_REQUIRED", "Parameter start_date is required")
+	}
+
+	_, endDate, err := aepr.GetParameterValueAsString("end_date")
+	if err != nil {
+		endDate = "" // Will use today
+	}
+
+	_, limit, err := aepr.GetParameterValueAsInt64("limit")
+	if err != nil {
+		limit = 0 // Will use module's default
+	}
+
+	return al.GetStatisticsTopLockedByDateRange(aepr, kind, startDate, endDate, limit)
+}
+
+// AccountLockoutAuditWriterMetricsHandler - Complete handler in module (FIXED/HOW)
+func (al *DXMAccountLockout) AccountLockoutAuditWriterMetricsHandler(aepr *api.DXAPIEndPointRequest) error {
+	aepr.WriteResponseAsJSON(http.StatusOK, nil, al.GetAuditWriterMetrics())
+	return nil
+}
+
+// AccountLockoutReloadConfigHandler - Complete handler in module (FIXED/HOW)
+func (al *DXMAccountLockout) AccountLockoutReloadConfigHandler(aepr *api.DXAPIEndPointRequest) error {
+	return al.ReloadConfigByAdmin(aepr)
+}
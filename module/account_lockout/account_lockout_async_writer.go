@@ -0,0 +1,317 @@
+package account_lockout
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/donnyhardyanto/dxlib/log"
+	"github.com/donnyhardyanto/dxlib/utils"
+	goredis "github.com/go-redis/redis/v8"
+)
+
+const (
+	defaultAuditBatchSize            = 100
+	defaultAuditFlushIntervalSeconds = 5
+	defaultAuditBlockTimeoutMs       = 1000
+	defaultAuditSpillMaxLength       = 100000
+
+	// spillDrainBatches bounds how many spilled batches one tick re-inserts, so a large backlog does not starve the queue
+	spillDrainBatches = 10
+
+	QueueFullPolicyBlock = "BLOCK"
+	QueueFullPolicyDrop  = "DROP"
+	QueueFullPolicySpill = "SPILL"
+)
+
+// Redis key patterns (audit writer):
+// pgn:partner:lockout:audit:spill -> list of JSON encoded LockoutEvent waiting to be inserted, the newest
+// audit_logging.spill_max_length kept
+
+func (al *DXMAccountLockout) redisKeyAuditSpill() string {
+	return fmt.Sprintf("%s:audit:spill", redisKeyPrefix)
+}
+
+// AuditWriterMetrics counts what happened to audit events handed to the async writer
+type AuditWriterMetrics struct {
+	Queued        atomic.Int64
+	Flushed       atomic.Int64
+	Dropped       atomic.Int64
+	Spilled       atomic.Int64
+	SpillRestored atomic.Int64
+	FlushFailures atomic.Int64
+}
+
+// asyncWriter is the state of one running writer goroutine
+// Queue capacity, batch size and flush interval are fixed per writer, a config reload that changes them starts a new writer
+type asyncWriter struct {
+	queue         chan *LockoutEvent
+	batchSize     int
+	flushInterval time.Duration
+	cancel        context.CancelFunc
+	done          chan struct{}
+	// mu is held shared by a producer for its whole enqueue and exclusively to set stopped, so once stopped is set no
+	// send is in flight and nothing can land in the queue after the final drain
+	mu sync.RWMutex
+	// stopped is set before the final drain, producers then write synchronously instead of queueing
+	stopped atomic.Bool
+}
+
+// startAsyncWriter starts the background writer; spilled events left by a previous process are drained on the first tick
+// and returns the writer it replaced, which the caller must stop
+func (al *DXMAccountLockout) startAsyncWriter(cfg *AccountLockoutConfig) *asyncWriter {
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &asyncWriter{
+		queue:         make(chan *LockoutEvent, cfg.AsyncQueueSize),
+		batchSize:     cfg.BatchSize,
+		flushInterval: time.Duration(cfg.FlushIntervalSeconds) * time.Second,
+		cancel:        cancel,
+		done:          make(chan struct{}),
+	}
+	go al.asyncDBWriter(ctx, w)
+	return al.asyncWriter.Swap(w)
+}
+
+// Shutdown stops the config watcher and the async audit writer, flushing every queued event.
+// It returns ctx.Err() when the final flush does not finish before ctx is done.
+func (al *DXMAccountLockout) Shutdown(ctx context.Context) error {
+	al.stopConfigWatcher()
+	return al.stopAsyncWriter(ctx, al.asyncWriter.Swap(nil))
+}
+
+// stopAsyncWriter cancels one writer and waits for its final flush
+func (al *DXMAccountLockout) stopAsyncWriter(ctx context.Context, w *asyncWriter) error {
+	if w == nil {
+		return nil
+	}
+	// The writer keeps reading while the lock waits, so a producer blocked on a full queue gets through
+	w.mu.Lock()
+	isStopping := w.stopped.CompareAndSwap(false, true)
+	w.mu.Unlock()
+	if !isStopping {
+		return nil
+	}
+	w.cancel()
+
+	select {
+	case <-w.done:
+		log.Log.Infof("Account lockout audit writer stopped: flushed=%d, dropped=%d, spilled=%d",
+			al.AuditMetrics.Flushed.Load(), al.AuditMetrics.Dropped.Load(), al.AuditMetrics.Spilled.Load())
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// asyncDBWriter runs in background goroutine until ctx is cancelled, then drains the queue and flushes once more
+func (al *DXMAccountLockout) asyncDBWriter(ctx context.Context, w *asyncWriter) {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*LockoutEvent, 0, w.batchSize)
+
+	for {
+		select {
+		case event := <-w.queue:
+			batch = append(batch, event)
+
+			// Flush if batch is full
+			if len(batch) >= w.batchSize {
+				al.flushBatch(batch)
+				batch = make([]*LockoutEvent, 0, w.batchSize)
+			}
+
+		case <-ticker.C:
+			// Periodic flush
+			if len(batch) > 0 {
+				al.flushBatch(batch)
+				batch = make([]*LockoutEvent, 0, w.batchSize)
+			}
+			al.drainSpill(w.batchSize)
+
+		case <-ctx.Done():
+			// stopped was set under mu before cancel, whatever is in the queue now is the last of it
+			for {
+				select {
+				case event := <-w.queue:
+					batch = append(batch, event)
+					if len(batch) >= w.batchSize {
+						al.flushBatch(batch)
+						batch = make([]*LockoutEvent, 0, w.batchSize)
+					}
+					continue
+				default:
+				}
+				break
+			}
+			if len(batch) > 0 {
+				al.flushBatch(batch)
+			}
+			return
+		}
+	}
+}
+
+// enqueueAuditEvent hands the event to the async writer, applying the queue full policy when there is no room.
+// It returns false when the writer is stopping, the caller then writes the event synchronously.
+func (al *DXMAccountLockout) enqueueAuditEvent(ctx context.Context, cfg *AccountLockoutConfig, w *asyncWriter, event *LockoutEvent) (isHandled bool) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.stopped.Load() {
+		return false
+	}
+
+	select {
+	case w.queue <- event:
+		al.AuditMetrics.Queued.Add(1)
+		return true
+	default:
+	}
+
+	switch cfg.AsyncQueueFullPolicy {
+	case QueueFullPolicyBlock:
+		timer := time.NewTimer(time.Duration(cfg.AsyncBlockTimeoutMs) * time.Millisecond)
+		defer timer.Stop()
+		select {
+		case w.queue <- event:
+			al.AuditMetrics.Queued.Add(1)
+		case <-timer.C:
+			al.AuditMetrics.Dropped.Add(1)
+			log.Log.Warnf("Audit write queue full for %dms, dropped %s event for user_id=%d", cfg.AsyncBlockTimeoutMs, event.EventType, event.UserID)
+		case <-ctx.Done():
+			al.AuditMetrics.Dropped.Add(1)
+			log.Log.Warnf("Request ended while waiting on full audit write queue, dropped %s event for user_id=%d", event.EventType, event.UserID)
+		}
+	case QueueFullPolicyDrop:
+		al.AuditMetrics.Dropped.Add(1)
+		log.Log.Warnf("Audit write queue full, dropped %s event for user_id=%d", event.EventType, event.UserID)
+	default:
+		al.spillEvents(cfg, []*LockoutEvent{event})
+	}
+	return true
+}
+
+// flushBatch writes a batch of events to database, spilling it to Redis when the insert fails
+func (al *DXMAccountLockout) flushBatch(batch []*LockoutEvent) {
+	err := al.insertAuditEvents(context.Background(), batch)
+	if err != nil {
+		al.AuditMetrics.FlushFailures.Add(1)
+		log.Log.Errorf(err, "Failed to flush %d audit log events, spilling to Redis", len(batch))
+		al.spillEvents(al.GetConfig(), batch)
+		return
+	}
+	al.AuditMetrics.Flushed.Add(int64(len(batch)))
+	log.Log.Infof("Flushed %d audit log events to database", len(batch))
+}
+
+// spillEvents appends events to the Redis spill list; only when Redis is also unavailable are they dropped
+func (al *DXMAccountLockout) spillEvents(cfg *AccountLockoutConfig, events []*LockoutEvent) {
+	values := make([]interface{}, 0, len(events))
+	for _, event := range events {
+		v, err := json.Marshal(event)
+		if err != nil {
+			al.AuditMetrics.Dropped.Add(1)
+			log.Log.Errorf(err, "Failed to encode %s audit event for spill", event.EventType)
+			continue
+		}
+		values = append(values, v)
+	}
+	if len(values) == 0 {
+		return
+	}
+
+	ctx := al.Redis.Context
+	key := al.redisKeyAuditSpill()
+	maxLength := int64(cfg.AsyncSpillMaxLength)
+	var lengthCmd *goredis.IntCmd
+	_, err := al.Redis.Connection.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+		lengthCmd = pipe.RPush(ctx, key, values...)
+		pipe.LTrim(ctx, key, -maxLength, -1)
+		return nil
+	})
+	if err != nil {
+		al.AuditMetrics.Dropped.Add(int64(len(values)))
+		log.Log.Errorf(err, "Failed to spill %d audit events to Redis, events dropped", len(values))
+		return
+	}
+	al.AuditMetrics.Spilled.Add(int64(len(values)))
+	if trimmed := lengthCmd.Val() - maxLength; trimmed > 0 {
+		al.AuditMetrics.Dropped.Add(trimmed)
+		log.Log.Warnf("Audit spill list over %d events, dropped the %d oldest", maxLength, trimmed)
+	}
+}
+
+// drainSpill re-inserts spilled events, oldest first; a failed insert puts the batch back at the head of the list
+func (al *DXMAccountLockout) drainSpill(batchSize int) {
+	key := al.redisKeyAuditSpill()
+	for i := 0; i < spillDrainBatches; i++ {
+		values, err := al.Redis.Connection.LPopCount(al.Redis.Context, key, batchSize).Result()
+		if err != nil || len(values) == 0 {
+			return
+		}
+
+		batch := make([]*LockoutEvent, 0, len(values))
+		for _, v := range values {
+			event := &LockoutEvent{}
+			if err := json.Unmarshal([]byte(v), event); err != nil {
+				al.AuditMetrics.Dropped.Add(1)
+				log.Log.Errorf(err, "Discarding undecodable spilled audit event")
+				continue
+			}
+			batch = append(batch, event)
+		}
+
+		err = al.insertAuditEvents(context.Background(), batch)
+		if err != nil {
+			al.AuditMetrics.FlushFailures.Add(1)
+			log.Log.Errorf(err, "Failed to re-insert %d spilled audit events, keeping them in Redis", len(batch))
+			// LPush reverses its arguments, push in reverse to keep the original order
+			restore := make([]interface{}, 0, len(values))
+			for j := len(values) - 1; j >= 0; j-- {
+				restore = append(restore, values[j])
+			}
+			al.Redis.Connection.LPush(al.Redis.Context, key, restore...)
+			return
+		}
+		al.AuditMetrics.Flushed.Add(int64(len(batch)))
+		al.AuditMetrics.SpillRestored.Add(int64(len(batch)))
+	}
+}
+
+// GetAuditWriterMetrics returns the async audit writer counters and current queue/spill depth
+func (al *DXMAccountLockout) GetAuditWriterMetrics() utils.JSON {
+	queueLength := 0
+	queueCapacity := 0
+	isRunning := false
+	if w := al.asyncWriter.Load(); w != nil {
+		queueLength = len(w.queue)
+		queueCapacity = cap(w.queue)
+		isRunning = !w.stopped.Load()
+	}
+
+	spillLength, err := al.Redis.Connection.LLen(al.Redis.Context, al.redisKeyAuditSpill()).Result()
+	if err != nil {
+		spillLength = -1
+	}
+
+	cfg := al.GetConfig()
+	return utils.JSON{
+		"async_enabled":     cfg.AsyncDBWrites,
+		"is_running":        isRunning,
+		"queue_full_policy": cfg.AsyncQueueFullPolicy,
+		"queue_length":      queueLength,
+		"queue_capacity":    queueCapacity,
+		"spill_length":      spillLength,
+		"queued":            al.AuditMetrics.Queued.Load(),
+		"flushed":           al.AuditMetrics.Flushed.Load(),
+		"dropped":           al.AuditMetrics.Dropped.Load(),
+		"spilled":           al.AuditMetrics.Spilled.Load(),
+		"spill_restored":    al.AuditMetrics.SpillRestored.Load(),
+		"flush_failures":    al.AuditMetrics.FlushFailures.Load(),
+	}
+}
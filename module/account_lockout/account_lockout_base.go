@@ -2,14 +2,15 @@ package account_lockout
 
 import (
 	"context"
-	"fmt"
-	"time"
+	"sync"
+	"sync/atomic"
 
 	"github.com/donnyhardyanto/dxlib/databases"
 	dxlibModule "github.com/donnyhardyanto/dxlib/module"
 	"github.com/donnyhardyanto/dxlib/redis"
 	"github.com/donnyhardyanto/dxlib/tables"
 	"github.com/donnyhardyanto/dxlib/utils"
+	"github.com/donnyhardyanto/dxlib_module/lib"
 )
 
 const (
@@ -22,8 +23,19 @@ const (
 	EventTypeAccountUnlockedAuto  = "ACCOUNT_UNLOCKED_AUTO"
 	EventTypeAccountUnlockedAdmin = "ACCOUNT_UNLOCKED_ADMIN"
 
+	EventTypeFailedAttemptUnknownUser   = "FAILED_ATTEMPT_UNKNOWN_USER"
+	EventTypeIPBlocked                  = "IP_BLOCKED"
+	EventTypeFingerprintBlocked         = "FINGERPRINT_BLOCKED"
+	EventTypeCredentialStuffingDetected = "CREDENTIAL_STUFFING_DETECTED"
+	EventTypeIPUnblockedAdmin           = "IP_UNBLOCKED_ADMIN"
+	EventTypeSuccessfulLogin            = "SUCCESSFUL_LOGIN"
+
+	LockScopeAccount = "ACCOUNT"
+	LockScopeSource  = "SOURCE"
+
 	AttemptTypePassword = "password"
 	AttemptTypeLDAP     = "ldap"
+	AttemptTypeTOTP     = "totp"
 
 	RedisFailModeFailThenLock = "REDIS_FAIL_THEN_LOCK"
 	RedisFailModeKeepUnlock  = "REDIS_FAIL_KEEP_UNLOCK"
@@ -32,8 +44,10 @@ const (
 type DXMAccountLockout struct {
 	dxlibModule.DXModule
 
-	// Configuration
-	Config *AccountLockoutConfig
+	// Configuration, an immutable snapshot swapped as a whole on reload (see GetConfig)
+	config              atomic.Pointer[AccountLockoutConfig]
+	reloadMutex         sync.Mutex
+	configWatcherCancel context.CancelFunc
 
 	// Redis (hot path - active lockouts)
 	Redis *redis.DXRedis
@@ -46,11 +60,16 @@ type DXMAccountLockout struct {
 	AccountLockoutEvents *tables.DXRawTable
 	AccountLockoutConfig *tables.DXTable
 
-	// Circuit Breaker
-	CircuitBreaker *CircuitBreaker
+	// Circuit Breaker, rebuilt on reload when its parameters change (see GetCircuitBreaker)
+	circuitBreaker atomic.Pointer[lib.CircuitBreaker]
+
+	// Async audit writer, nil when async_db_writes is off
+	asyncWriter  atomic.Pointer[asyncWriter]
+	AuditMetrics AuditWriterMetrics
 
-	// Async writer queue
-	asyncWriteQueue chan *LockoutEvent
+	// Per-organization policy cache (organization_id -> policy)
+	policyCache      map[int64]*policyCacheEntry
+	policyCacheMutex sync.RWMutex
 }
 
 type AccountLockoutConfig struct {
@@ -62,9 +81,10 @@ type AccountLockoutConfig struct {
 
 	// Failure Handling
 	RedisFailMode           string
-	CircuitBreakerEnabled   bool
-	CircuitBreakerThreshold int
-	CircuitBreakerTimeout   int
+	CircuitBreakerEnabled        bool
+	CircuitBreakerThreshold      int
+	CircuitBreakerTimeout        int
+	CircuitBreakerHalfOpenProbes int
 
 	// Audit Logging
 	LogFailedAttempts    bool
@@ -72,17 +92,41 @@ type AccountLockoutConfig struct {
 	AsyncDBWrites        bool
 	BatchSize            int
 	FlushIntervalSeconds int
+	AsyncQueueSize       int
+	AsyncQueueFullPolicy string
+	AsyncBlockTimeoutMs  int
+	AsyncSpillMaxLength  int
 
 	// Tracking
-	TrackLDAPFailures     bool
-	TrackPasswordFailures bool
-	ResetCounterOnSuccess bool
-	GracePeriodMinutes    int
+	TrackLDAPFailures          bool
+	TrackPasswordFailures      bool
+	ResetCounterOnSuccess      bool
+	GracePeriodMinutes         int
+	FailedAttemptWindowMinutes int
 
 	// Progressive
-	ProgressiveEnabled    bool
-	ProgressiveMultiplier int
-	ProgressiveMaxDuration int
+	ProgressiveEnabled           bool
+	ProgressiveMultiplier        int
+	ProgressiveMaxDuration       int
+	ProgressiveWindowMinutes     int
+	ProgressiveResetAfterMinutes int
+
+	// IP / source tracking
+	IPTrackingEnabled                  bool
+	IPMaxFailedAttempts                int
+	IPWindowMinutes                    int
+	IPBlockDurationMinutes             int
+	IPFingerprintEnabled               bool
+	IPFingerprintMaxFailedAttempts     int
+	CredentialStuffingDistinctLoginIDs int
+	CredentialStuffingWindowMinutes    int
+
+	// Daily statistics
+	StatisticsEnabled       bool
+	StatisticsRetentionDays int
+
+	// Hot reload
+	ReloadIntervalSeconds int
 }
 
 type LockoutEvent struct {
@@ -96,6 +140,7 @@ type LockoutEvent struct {
 	LockoutReason          string
 	FailedAttemptsCount    int
 	LockoutDurationSeconds int
+	LockoutTier            int
 	LockedAt               string
 	UnlockAt               string
 	UnlockedByUserID       int64
@@ -132,7 +177,7 @@ func (al *DXMAccountLockout) Init(
 		[][]string{{"user_id"}, {"user_uid"}, {"event_timestamp"}},
 		[]string{"user_id", "user_uid", "user_loginid", "event_type", "event_timestamp"},
 		[]string{"id", "uid", "event_type", "event_timestamp", "user_id", "user_uid",
-			"user_loginid", "organization_id", "lockout_reason", "failed_attempts_count",
+			"user_loginid", "organization_id", "lockout_reason", "failed_attempts_count", "lockout_tier",
 			"locked_at", "unlock_at", "attempt_type", "attempt_ip_address"},
 		[]string{"id", "uid", "user_id", "user_uid", "organization_id", "event_type", "attempt_type", "event_timestamp", "locked_at", "unlock_at", "created_at"},
 	)
@@ -151,45 +196,28 @@ func (al *DXMAccountLockout) Init(
 		[]string{"id", "uid", "organization_id", "lockout_type", "is_active", "created_at", "last_modified_at", "is_deleted"},
 	)
 
+	cfg := al.GetConfig()
+
+	// CheckLockStatusRedis records every Redis outcome on the breaker, so it must exist even when disabled
+	al.circuitBreaker.Store(al.newRedisCircuitBreaker(cfg))
+
 	// Initialize async write queue if enabled
-	if al.Config.AsyncDBWrites {
-		al.asyncWriteQueue = make(chan *LockoutEvent, al.Config.BatchSize*2)
-		go al.asyncDBWriter()
+	if cfg.AsyncDBWrites {
+		al.startAsyncWriter(cfg)
 	}
-}
 
-// asyncDBWriter runs in background goroutine
-func (al *DXMAccountLockout) asyncDBWriter() {
-	ticker := time.NewTicker(time.Duration(al.Config.FlushIntervalSeconds) * time.Second)
-	defer ticker.Stop()
-
-	batch := make([]*LockoutEvent, 0, al.Config.BatchSize)
-
-	for {
-		select {
-		case event := <-al.asyncWriteQueue:
-			batch = append(batch, event)
-
-			// Flush if batch is full
-			if len(batch) >= al.Config.BatchSize {
-				al.flushBatch(batch)
-				batch = make([]*LockoutEvent, 0, al.Config.BatchSize)
-			}
-
-		case <-ticker.C:
-			// Periodic flush
-			if len(batch) > 0 {
-				al.flushBatch(batch)
-				batch = make([]*LockoutEvent, 0, al.Config.BatchSize)
-			}
-		}
+	// Pick up configuration changes without a restart
+	if cfg.ReloadIntervalSeconds > 0 {
+		al.startConfigWatcher(cfg.ReloadIntervalSeconds)
 	}
 }
 
-// flushBatch writes a batch of events to database
-func (al *DXMAccountLockout) flushBatch(batch []*LockoutEvent) {
-	for _, event := range batch {
-		al.writeAuditLogSync(context.Background(), event)
-	}
-	fmt.Printf("Flushed %d audit log events to database\n", len(batch))
+// GetConfig returns the current configuration snapshot; callers must not modify it
+func (al *DXMAccountLockout) GetConfig() *AccountLockoutConfig {
+	return al.config.Load()
+}
+
+// GetCircuitBreaker returns the Redis circuit breaker of the current configuration
+func (al *DXMAccountLockout) GetCircuitBreaker() *lib.CircuitBreaker {
+	return al.circuitBreaker.Load()
 }
@@ -8,22 +8,41 @@ import (
 	"github.com/donnyhardyanto/dxlib/utils"
 )
 
+const (
+	defaultFailedAttemptWindowMinutes   = 60
+	defaultCircuitBreakerHalfOpenProbes = 1
+)
+
 func (al *DXMAccountLockout) LoadConfig() error {
+	cfg, err := al.readConfig()
+	if err != nil {
+		return err
+	}
+
+	al.config.Store(cfg)
+	log.Log.Infof("Account Lockout configuration loaded: enabled=%v, max_attempts=%d, duration=%d min",
+		cfg.Enabled, cfg.MaxFailedAttempts, cfg.LockoutDurationMinutes)
+
+	return nil
+}
+
+// readConfig builds and validates a new configuration snapshot from the configuration manager
+func (al *DXMAccountLockout) readConfig() (*AccountLockoutConfig, error) {
 	cfg := &AccountLockoutConfig{}
 
 	// Get main configuration
 	mainConfig, exists := configuration.Manager.Configurations["account_lockout"]
 	if !exists {
-		return fmt.Errorf("account_lockout configuration not found")
+		return nil, fmt.Errorf("account_lockout configuration not found")
 	}
 	if mainConfig.Data == nil {
-		return fmt.Errorf("account_lockout configuration data is nil")
+		return nil, fmt.Errorf("account_lockout configuration data is nil")
 	}
 
 	// Load core configuration
 	coreData, err := utils.GetJSONFromKV(*mainConfig.Data, "core")
 	if err != nil {
-		return fmt.Errorf("account_lockout.core not found: %w", err)
+		return nil, fmt.Errorf("account_lockout.core not found: %w", err)
 	}
 	cfg.Enabled, _ = utils.GetBoolFromKV(coreData, "enabled")
 	cfg.MaxFailedAttempts, _ = utils.GetIntFromKV(coreData, "max_failed_attempts")
@@ -37,6 +56,10 @@ func (al *DXMAccountLockout) LoadConfig() error {
 		cfg.CircuitBreakerEnabled, _ = utils.GetBoolFromKV(failureData, "circuit_breaker_enabled")
 		cfg.CircuitBreakerThreshold, _ = utils.GetIntFromKV(failureData, "circuit_breaker_threshold")
 		cfg.CircuitBreakerTimeout, _ = utils.GetIntFromKV(failureData, "circuit_breaker_timeout_seconds")
+		cfg.CircuitBreakerHalfOpenProbes, _ = utils.GetIntFromKV(failureData, "circuit_breaker_half_open_max_probes")
+	}
+	if cfg.CircuitBreakerHalfOpenProbes == 0 {
+		cfg.CircuitBreakerHalfOpenProbes = defaultCircuitBreakerHalfOpenProbes
 	}
 
 	// Load audit logging
@@ -47,6 +70,28 @@ func (al *DXMAccountLockout) LoadConfig() error {
 		cfg.AsyncDBWrites, _ = utils.GetBoolFromKV(auditData, "async_db_writes")
 		cfg.BatchSize, _ = utils.GetIntFromKV(auditData, "batch_size")
 		cfg.FlushIntervalSeconds, _ = utils.GetIntFromKV(auditData, "flush_interval_seconds")
+		cfg.AsyncQueueSize, _ = utils.GetIntFromKV(auditData, "queue_size")
+		cfg.AsyncQueueFullPolicy, _ = utils.GetStringFromKV(auditData, "queue_full_policy")
+		cfg.AsyncBlockTimeoutMs, _ = utils.GetIntFromKV(auditData, "block_timeout_ms")
+		cfg.AsyncSpillMaxLength, _ = utils.GetIntFromKV(auditData, "spill_max_length")
+	}
+	if cfg.BatchSize == 0 {
+		cfg.BatchSize = defaultAuditBatchSize
+	}
+	if cfg.FlushIntervalSeconds == 0 {
+		cfg.FlushIntervalSeconds = defaultAuditFlushIntervalSeconds
+	}
+	if cfg.AsyncQueueSize == 0 {
+		cfg.AsyncQueueSize = cfg.BatchSize * 2
+	}
+	if cfg.AsyncQueueFullPolicy == "" {
+		cfg.AsyncQueueFullPolicy = QueueFullPolicySpill
+	}
+	if cfg.AsyncBlockTimeoutMs == 0 {
+		cfg.AsyncBlockTimeoutMs = defaultAuditBlockTimeoutMs
+	}
+	if cfg.AsyncSpillMaxLength == 0 {
+		cfg.AsyncSpillMaxLength = defaultAuditSpillMaxLength
 	}
 
 	// Load tracking
@@ -56,6 +101,10 @@ func (al *DXMAccountLockout) LoadConfig() error {
 		cfg.TrackPasswordFailures, _ = utils.GetBoolFromKV(trackingData, "track_password_failures")
 		cfg.ResetCounterOnSuccess, _ = utils.GetBoolFromKV(trackingData, "reset_counter_on_success")
 		cfg.GracePeriodMinutes, _ = utils.GetIntFromKV(trackingData, "grace_period_minutes")
+		cfg.FailedAttemptWindowMinutes, _ = utils.GetIntFromKV(trackingData, "window_minutes")
+	}
+	if cfg.FailedAttemptWindowMinutes == 0 {
+		cfg.FailedAttemptWindowMinutes = defaultFailedAttemptWindowMinutes
 	}
 
 	// Load progressive
@@ -64,35 +113,137 @@ func (al *DXMAccountLockout) LoadConfig() error {
 		cfg.ProgressiveEnabled, _ = utils.GetBoolFromKV(progressiveData, "enabled")
 		cfg.ProgressiveMultiplier, _ = utils.GetIntFromKV(progressiveData, "multiplier")
 		cfg.ProgressiveMaxDuration, _ = utils.GetIntFromKV(progressiveData, "max_duration_minutes")
+		cfg.ProgressiveWindowMinutes, _ = utils.GetIntFromKV(progressiveData, "window_minutes")
+		cfg.ProgressiveResetAfterMinutes, _ = utils.GetIntFromKV(progressiveData, "reset_after_minutes")
+	}
+	if cfg.ProgressiveMultiplier == 0 {
+		cfg.ProgressiveMultiplier = defaultProgressiveMultiplier
+	}
+	if cfg.ProgressiveWindowMinutes == 0 {
+		cfg.ProgressiveWindowMinutes = defaultProgressiveWindowMinutes
+	}
+
+	// Load IP / source tracking
+	ipData, err := utils.GetJSONFromKV(*mainConfig.Data, "ip_tracking")
+	if err == nil {
+		cfg.IPTrackingEnabled, _ = utils.GetBoolFromKV(ipData, "enabled")
+		cfg.IPMaxFailedAttempts, _ = utils.GetIntFromKV(ipData, "max_failed_attempts")
+		cfg.IPWindowMinutes, _ = utils.GetIntFromKV(ipData, "window_minutes")
+		cfg.IPBlockDurationMinutes, _ = utils.GetIntFromKV(ipData, "block_duration_minutes")
+		cfg.IPFingerprintEnabled, _ = utils.GetBoolFromKV(ipData, "fingerprint_enabled")
+		cfg.IPFingerprintMaxFailedAttempts, _ = utils.GetIntFromKV(ipData, "fingerprint_max_failed_attempts")
+		cfg.CredentialStuffingDistinctLoginIDs, _ = utils.GetIntFromKV(ipData, "credential_stuffing_distinct_loginids")
+		cfg.CredentialStuffingWindowMinutes, _ = utils.GetIntFromKV(ipData, "credential_stuffing_window_minutes")
+	}
+	if cfg.IPMaxFailedAttempts == 0 {
+		cfg.IPMaxFailedAttempts = defaultIPMaxFailedAttempts
+	}
+	if cfg.IPWindowMinutes == 0 {
+		cfg.IPWindowMinutes = defaultIPWindowMinutes
+	}
+	if cfg.IPBlockDurationMinutes == 0 {
+		cfg.IPBlockDurationMinutes = defaultIPBlockDurationMinutes
+	}
+	if cfg.IPFingerprintMaxFailedAttempts == 0 {
+		cfg.IPFingerprintMaxFailedAttempts = cfg.IPMaxFailedAttempts
+	}
+	if cfg.CredentialStuffingDistinctLoginIDs == 0 {
+		cfg.CredentialStuffingDistinctLoginIDs = defaultCredentialStuffingDistinctLoginIDs
+	}
+	if cfg.CredentialStuffingWindowMinutes == 0 {
+		cfg.CredentialStuffingWindowMinutes = cfg.IPWindowMinutes
+	}
+
+	// Load daily statistics, enabled unless explicitly turned off
+	cfg.StatisticsEnabled = true
+	statisticsData, err := utils.GetJSONFromKV(*mainConfig.Data, "statistics")
+	if err == nil {
+		if enabled, err := utils.GetBoolFromKV(statisticsData, "enabled"); err == nil {
+			cfg.StatisticsEnabled = enabled
+		}
+		cfg.StatisticsRetentionDays, _ = utils.GetIntFromKV(statisticsData, "retention_days")
+	}
+	if cfg.StatisticsRetentionDays == 0 {
+		cfg.StatisticsRetentionDays = defaultStatisticsRetentionDays
+	}
+
+	// Load hot reload
+	reloadData, err := utils.GetJSONFromKV(*mainConfig.Data, "hot_reload")
+	if err == nil {
+		cfg.ReloadIntervalSeconds, _ = utils.GetIntFromKV(reloadData, "interval_seconds")
 	}
 
 	// Validate configuration
 	if err := al.validateConfig(cfg); err != nil {
-		return err
+		return nil, err
 	}
 
-	al.Config = cfg
-	log.Log.Infof("Account Lockout configuration loaded: enabled=%v, max_attempts=%d, duration=%d min",
-		cfg.Enabled, cfg.MaxFailedAttempts, cfg.LockoutDurationMinutes)
-
-	return nil
+	return cfg, nil
 }
 
 func (al *DXMAccountLockout) validateConfig(cfg *AccountLockoutConfig) error {
-	// Validate max attempts
-	if cfg.MaxFailedAttempts < 1 || cfg.MaxFailedAttempts > 100 {
-		return fmt.Errorf("max_failed_attempts must be between 1 and 100, got %d", cfg.MaxFailedAttempts)
+	// Validate max attempts, duration and lockout type
+	if err := validatePolicyValues(cfg.MaxFailedAttempts, cfg.LockoutDurationMinutes, cfg.LockoutType); err != nil {
+		return err
+	}
+
+	// Validate tracking window
+	if cfg.FailedAttemptWindowMinutes < 1 || cfg.FailedAttemptWindowMinutes > 10080 {
+		return fmt.Errorf("tracking.window_minutes must be between 1 and 10080 (7 days), got %d", cfg.FailedAttemptWindowMinutes)
+	}
+	if cfg.GracePeriodMinutes < 0 {
+		return fmt.Errorf("tracking.grace_period_minutes must not be negative, got %d", cfg.GracePeriodMinutes)
+	}
+
+	// Validate progressive escalation
+	if cfg.LockoutType == LockoutTypeProgressive || cfg.ProgressiveEnabled {
+		if cfg.ProgressiveMultiplier < 1 {
+			return fmt.Errorf("progressive.multiplier must be at least 1, got %d", cfg.ProgressiveMultiplier)
+		}
+		if cfg.ProgressiveMaxDuration != 0 && cfg.ProgressiveMaxDuration < cfg.LockoutDurationMinutes {
+			return fmt.Errorf("progressive.max_duration_minutes (%d) must not be less than lockout_duration_minutes (%d)", cfg.ProgressiveMaxDuration, cfg.LockoutDurationMinutes)
+		}
+		if cfg.ProgressiveWindowMinutes < 1 {
+			return fmt.Errorf("progressive.window_minutes must be at least 1, got %d", cfg.ProgressiveWindowMinutes)
+		}
+	}
+
+	// Validate IP / source tracking
+	if cfg.IPTrackingEnabled {
+		if cfg.IPMaxFailedAttempts < 1 || cfg.IPFingerprintMaxFailedAttempts < 1 {
+			return fmt.Errorf("ip_tracking max_failed_attempts must be at least 1")
+		}
+		if cfg.IPWindowMinutes < 1 || cfg.IPBlockDurationMinutes < 1 || cfg.CredentialStuffingWindowMinutes < 1 {
+			return fmt.Errorf("ip_tracking window and block durations must be at least 1 minute")
+		}
+	}
+
+	// Validate async audit writer
+	if cfg.AsyncDBWrites {
+		if cfg.BatchSize < 1 || cfg.FlushIntervalSeconds < 1 || cfg.AsyncQueueSize < 1 || cfg.AsyncBlockTimeoutMs < 1 || cfg.AsyncSpillMaxLength < 1 {
+			return fmt.Errorf("audit_logging batch_size, flush_interval_seconds, queue_size, block_timeout_ms and spill_max_length must be at least 1")
+		}
+		validPolicies := []string{QueueFullPolicyBlock, QueueFullPolicyDrop, QueueFullPolicySpill}
+		if !contains(validPolicies, cfg.AsyncQueueFullPolicy) {
+			return fmt.Errorf("invalid audit_logging.queue_full_policy: %s", cfg.AsyncQueueFullPolicy)
+		}
+	}
+
+	// Validate circuit breaker
+	if cfg.CircuitBreakerEnabled {
+		if cfg.CircuitBreakerThreshold < 1 || cfg.CircuitBreakerTimeout < 1 || cfg.CircuitBreakerHalfOpenProbes < 1 {
+			return fmt.Errorf("failure_handling circuit_breaker_threshold, circuit_breaker_timeout_seconds and circuit_breaker_half_open_max_probes must be at least 1")
+		}
 	}
 
-	// Validate duration
-	if cfg.LockoutDurationMinutes < 1 || cfg.LockoutDurationMinutes > 10080 {
-		return fmt.Errorf("lockout_duration_minutes must be between 1 and 10080 (7 days), got %d", cfg.LockoutDurationMinutes)
+	// Validate hot reload
+	if cfg.ReloadIntervalSeconds < 0 {
+		return fmt.Errorf("hot_reload.interval_seconds must not be negative, got %d", cfg.ReloadIntervalSeconds)
 	}
 
-	// Validate lockout type
-	validTypes := []string{LockoutTypeAutoUnlock, LockoutTypeAdminUnlock, LockoutTypeProgressive}
-	if !contains(validTypes, cfg.LockoutType) {
-		return fmt.Errorf("invalid lockout_type: %s", cfg.LockoutType)
+	// Validate statistics retention
+	if cfg.StatisticsRetentionDays < 1 {
+		return fmt.Errorf("statistics.retention_days must be at least 1, got %d", cfg.StatisticsRetentionDays)
 	}
 
 	// Validate fail mode
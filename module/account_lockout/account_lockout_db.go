@@ -2,53 +2,57 @@ package account_lockout
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
 
+	"github.com/donnyhardyanto/dxlib/base"
 	"github.com/donnyhardyanto/dxlib/databases/db"
 	"github.com/donnyhardyanto/dxlib/log"
 	"github.com/donnyhardyanto/dxlib/utils"
 )
 
+// emitEvent feeds the daily statistics and, when persist is set, the audit table
+func (al *DXMAccountLockout) emitEvent(ctx context.Context, cfg *AccountLockoutConfig, event *LockoutEvent, persist bool) {
+	al.recordDailyStatistics(cfg, event)
+	if persist {
+		al.writeAuditLog(ctx, cfg, event)
+	}
+}
+
 // writeAuditLog writes lockout event to audit database
-func (al *DXMAccountLockout) writeAuditLog(ctx context.Context, event *LockoutEvent) {
-	if al.Config.AsyncDBWrites {
-		// Send to async queue
-		select {
-		case al.asyncWriteQueue <- event:
-			// Queued successfully
-		default:
-			// Queue full, write synchronously
-			log.Log.Warnf("Audit write queue full, writing synchronously")
-			al.writeAuditLogSync(ctx, event)
-		}
-	} else {
-		// Write synchronously
-		al.writeAuditLogSync(ctx, event)
+func (al *DXMAccountLockout) writeAuditLog(ctx context.Context, cfg *AccountLockoutConfig, event *LockoutEvent) {
+	if w := al.asyncWriter.Load(); w != nil && al.enqueueAuditEvent(ctx, cfg, w, event) {
+		return
+	}
+	// Write synchronously
+	al.writeAuditLogSync(ctx, event)
+}
+
+// auditEventColumns is the column order shared by the single and the batch insert
+var auditEventColumns = []string{
+	"event_type", "event_timestamp", "user_id", "user_uid", "user_loginid", "organization_id", "organization_uid",
+	"lockout_reason", "failed_attempts_count", "lockout_duration_seconds", "lockout_tier", "locked_at", "unlock_at",
+	"unlocked_by_user_id", "unlocked_by_user_uid", "unlock_reason",
+	"attempt_type", "attempt_ip_address", "attempt_user_agent", "attempt_auth_source", "metadata",
+}
+
+// auditEventValues returns the event values in auditEventColumns order
+func auditEventValues(event *LockoutEvent) []any {
+	return []any{
+		event.EventType, event.EventTimestamp, event.UserID, event.UserUID, event.UserLoginID, event.OrganizationID, event.OrganizationUID,
+		event.LockoutReason, event.FailedAttemptsCount, event.LockoutDurationSeconds, event.LockoutTier, event.LockedAt, event.UnlockAt,
+		event.UnlockedByUserID, event.UnlockedByUserUID, event.UnlockReason,
+		event.AttemptType, event.AttemptIPAddress, event.AttemptUserAgent, event.AttemptAuthSource, event.Metadata,
 	}
 }
 
 // writeAuditLogSync writes to database synchronously
 func (al *DXMAccountLockout) writeAuditLogSync(ctx context.Context, event *LockoutEvent) {
-	data := utils.JSON{
-		"event_type":               event.EventType,
-		"event_timestamp":          event.EventTimestamp,
-		"user_id":                  event.UserID,
-		"user_uid":                 event.UserUID,
-		"user_loginid":             event.UserLoginID,
-		"organization_id":          event.OrganizationID,
-		"organization_uid":         event.OrganizationUID,
-		"lockout_reason":           event.LockoutReason,
-		"failed_attempts_count":    event.FailedAttemptsCount,
-		"lockout_duration_seconds": event.LockoutDurationSeconds,
-		"locked_at":                event.LockedAt,
-		"unlock_at":                event.UnlockAt,
-		"unlocked_by_user_id":      event.UnlockedByUserID,
-		"unlocked_by_user_uid":     event.UnlockedByUserUID,
-		"unlock_reason":            event.UnlockReason,
-		"attempt_type":             event.AttemptType,
-		"attempt_ip_address":       event.AttemptIPAddress,
-		"attempt_user_agent":       event.AttemptUserAgent,
-		"attempt_auth_source":      event.AttemptAuthSource,
-		"metadata":                 event.Metadata,
+	data := utils.JSON{}
+	for i, v := range auditEventValues(event) {
+		data[auditEventColumns[i]] = v
 	}
 
 	_, _, err := al.AccountLockoutEvents.Insert(ctx, &log.Log, data, nil)
@@ -57,6 +61,99 @@ func (al *DXMAccountLockout) writeAuditLogSync(ctx context.Context, event *Locko
 	}
 }
 
+// maxAuditInsertParameters keeps a batch insert under the SQL Server limit of 2100 parameters
+const maxAuditInsertParameters = 2000
+
+// insertAuditEvents writes events with multi-row INSERT statements, one statement per chunk
+func (al *DXMAccountLockout) insertAuditEvents(ctx context.Context, events []*LockoutEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	err := al.AccountLockoutEvents.EnsureDatabase()
+	if err != nil {
+		return fmt.Errorf("failed to ensure databases connection: %w", err)
+	}
+	databaseType := al.AccountLockoutEvents.Database.DatabaseType
+
+	rowsPerStatement := maxAuditInsertParameters / len(auditEventColumns)
+	for start := 0; start < len(events); start += rowsPerStatement {
+		end := start + rowsPerStatement
+		if end > len(events) {
+			end = len(events)
+		}
+
+		query, args, err := buildAuditEventsInsert(databaseType, events[start:end])
+		if err != nil {
+			return err
+		}
+		_, err = al.AccountLockoutEvents.Database.Connection.ExecContext(ctx, query, args...)
+		if err != nil {
+			return fmt.Errorf("failed to insert %d audit events: %w", end-start, err)
+		}
+	}
+	return nil
+}
+
+// buildAuditEventsInsert builds one multi-row INSERT for the database dialect.
+// Oracle has no multi-row VALUES, it uses INSERT ... SELECT ... FROM DUAL UNION ALL so identity columns still get one value per row.
+func buildAuditEventsInsert(databaseType base.DXDatabaseType, events []*LockoutEvent) (string, []any, error) {
+	placeholder := func(n int) string { return "?" }
+	switch databaseType {
+	case base.DXDatabaseTypePostgreSQL:
+		placeholder = func(n int) string { return "$" + strconv.Itoa(n) }
+	case base.DXDatabaseTypeOracle:
+		placeholder = func(n int) string { return ":" + strconv.Itoa(n) }
+	case base.DXDatabaseTypeMariaDB, base.DXDatabaseTypeSQLServer:
+	default:
+		return "", nil, fmt.Errorf("unsupported databases type: %s", databaseType)
+	}
+
+	args := make([]any, 0, len(events)*len(auditEventColumns))
+	rows := make([]string, 0, len(events))
+	for _, event := range events {
+		values := auditEventValues(event)
+		placeholders := make([]string, len(values))
+		for i, v := range values {
+			args = append(args, auditEventArg(auditEventColumns[i], v))
+			placeholders[i] = placeholder(len(args))
+		}
+		if databaseType == base.DXDatabaseTypeOracle {
+			rows = append(rows, "SELECT "+strings.Join(placeholders, ", ")+" FROM DUAL")
+		} else {
+			rows = append(rows, "("+strings.Join(placeholders, ", ")+")")
+		}
+	}
+
+	query := "INSERT INTO account_lockout.account_lockout_events (" + strings.Join(auditEventColumns, ", ") + ")"
+	if databaseType == base.DXDatabaseTypeOracle {
+		query += " " + strings.Join(rows, " UNION ALL ")
+	} else {
+		query += " VALUES " + strings.Join(rows, ", ")
+	}
+	return query, args, nil
+}
+
+// auditEventArg converts a value for the raw batch insert: metadata goes in as JSON text, empty timestamps as NULL
+func auditEventArg(column string, v any) any {
+	switch column {
+	case "metadata":
+		metadata, _ := v.(utils.JSON)
+		if metadata == nil {
+			return nil
+		}
+		b, err := json.Marshal(metadata)
+		if err != nil {
+			return nil
+		}
+		return string(b)
+	case "locked_at", "unlock_at":
+		if v == "" {
+			return nil
+		}
+	}
+	return v
+}
+
 // GetLockoutHistory retrieves lockout history for a user
 func (al *DXMAccountLockout) GetLockoutHistory(ctx context.Context, userID int64, limit int) ([]utils.JSON, error) {
 	where := utils.JSON{"user_id": userID}
@@ -0,0 +1,287 @@
+package account_lockout
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/donnyhardyanto/dxlib/api"
+	"github.com/donnyhardyanto/dxlib/log"
+	goredis "github.com/go-redis/redis/v8"
+)
+
+const (
+	defaultIPMaxFailedAttempts                = 50
+	defaultIPWindowMinutes                    = 15
+	defaultIPBlockDurationMinutes             = 30
+	defaultCredentialStuffingDistinctLoginIDs = 20
+
+	BlockReasonIPFailedAttempts          = "EXCEEDED_FAILED_ATTEMPTS_FROM_IP"
+	BlockReasonFingerprintFailedAttempts = "EXCEEDED_FAILED_ATTEMPTS_FROM_FINGERPRINT"
+	BlockReasonCredentialStuffing        = "CREDENTIAL_STUFFING"
+)
+
+// Redis key patterns (source dimension):
+// pgn:partner:lockout:ip:counter:{ip}         -> failed attempts from one IP, TTL = ip window
+// pgn:partner:lockout:ip:loginids:{ip}        -> set of distinct loginids tried from one IP, TTL = stuffing window
+// pgn:partner:lockout:ip:blocked:{ip}         -> block record, TTL = block duration
+// pgn:partner:lockout:fp:counter:{fingerprint} -> failed attempts from one IP+user-agent
+// pgn:partner:lockout:fp:blocked:{fingerprint} -> block record, TTL = block duration
+
+func (al *DXMAccountLockout) redisKeyIP(kind string, ip string) string {
+	return fmt.Sprintf("%s:ip:%s:%s", redisKeyPrefix, kind, ip)
+}
+
+func (al *DXMAccountLockout) redisKeyFingerprint(kind string, fingerprint string) string {
+	return fmt.Sprintf("%s:fp:%s:%s", redisKeyPrefix, kind, fingerprint)
+}
+
+// sourceFingerprint identifies one client (IP + user agent) without storing the raw user agent in the key
+func sourceFingerprint(attemptIP string, attemptUserAgent string) string {
+	h := sha256.Sum256([]byte(attemptIP + "|" + attemptUserAgent))
+	return hex.EncodeToString(h[:16])
+}
+
+// CheckSourceLockStatus checks whether the source IP (or IP+user-agent fingerprint) is blocked
+// Returns: isBlocked, remainingTimeSeconds, error
+func (al *DXMAccountLockout) CheckSourceLockStatus(attemptIP string, attemptUserAgent string) (bool, int64, error) {
+	cfg := al.GetConfig()
+	if !cfg.Enabled || !cfg.IPTrackingEnabled || attemptIP == "" {
+		return false, 0, nil
+	}
+
+	keys := []string{al.redisKeyIP("blocked", attemptIP)}
+	if cfg.IPFingerprintEnabled {
+		keys = append(keys, al.redisKeyFingerprint("blocked", sourceFingerprint(attemptIP, attemptUserAgent)))
+	}
+
+	for _, key := range keys {
+		ttl, err := al.Redis.Connection.TTL(al.Redis.Context, key).Result()
+		if err != nil {
+			log.Log.Errorf(err, "Redis error checking source block for ip %s", attemptIP)
+			return false, 0, err
+		}
+		// -2 = no key, -1 = key without expiry (not written by this module)
+		if ttl > 0 {
+			return true, int64(ttl.Seconds()), nil
+		}
+	}
+
+	return false, 0, nil
+}
+
+// CheckLockStatusForAttempt checks the source first, then the account (userID 0 = unknown user, source only)
+// Returns: isLocked, remainingTimeSeconds, lockScope ("SOURCE" or "ACCOUNT"), error
+func (al *DXMAccountLockout) CheckLockStatusForAttempt(userID int64, attemptIP string, attemptUserAgent string) (bool, int64, string, error) {
+	isBlocked, remainingSeconds, err := al.CheckSourceLockStatus(attemptIP, attemptUserAgent)
+	if err != nil {
+		log.Log.Warnf("Source lock check failed, continuing with account check: %v", err)
+	}
+	if isBlocked {
+		return true, remainingSeconds, LockScopeSource, nil
+	}
+
+	if userID == 0 {
+		return false, 0, "", nil
+	}
+
+	isLocked, remainingSeconds, err := al.CheckLockStatus(userID)
+	if isLocked {
+		return true, remainingSeconds, LockScopeAccount, err
+	}
+	return false, 0, "", err
+}
+
+// RecordFailedAttemptUnknownUser records a failure for a loginid that does not resolve to a user.
+// It only feeds the source dimension and the audit log, there is no account to lock.
+func (al *DXMAccountLockout) RecordFailedAttemptUnknownUser(
+	aepr *api.DXAPIEndPointRequest,
+	userLoginID string,
+	attemptType string,
+	attemptIP string,
+	attemptUserAgent string,
+	attemptAuthSource string,
+) error {
+	cfg := al.GetConfig()
+	if !cfg.Enabled {
+		return nil
+	}
+
+	event := &LockoutEvent{
+		EventType:         EventTypeFailedAttemptUnknownUser,
+		EventTimestamp:    time.Now().Format(time.RFC3339),
+		UserLoginID:       userLoginID,
+		AttemptType:       attemptType,
+		AttemptIPAddress:  attemptIP,
+		AttemptUserAgent:  attemptUserAgent,
+		AttemptAuthSource: attemptAuthSource,
+	}
+	al.emitEvent(aepr.Context, cfg, event, cfg.LogFailedAttempts)
+
+	al.recordSourceFailure(aepr.Context, cfg, userLoginID, attemptType, attemptIP, attemptUserAgent, attemptAuthSource)
+	return nil
+}
+
+// recordSourceFailure counts a failure against the source IP / fingerprint and blocks the source on threshold
+func (al *DXMAccountLockout) recordSourceFailure(ctx context.Context, cfg *AccountLockoutConfig, userLoginID string, attemptType string, attemptIP string, attemptUserAgent string, attemptAuthSource string) {
+	if !cfg.IPTrackingEnabled || attemptIP == "" {
+		return
+	}
+
+	window := time.Duration(cfg.IPWindowMinutes) * time.Minute
+
+	count, err := al.incrementWindowCounter(al.redisKeyIP("counter", attemptIP), window)
+	if err != nil {
+		log.Log.Errorf(err, "Redis error incrementing ip counter for %s", attemptIP)
+		return
+	}
+	if count >= int64(cfg.IPMaxFailedAttempts) {
+		al.blockSource(ctx, cfg, al.redisKeyIP("blocked", attemptIP), EventTypeIPBlocked, BlockReasonIPFailedAttempts,
+			userLoginID, attemptType, attemptIP, attemptUserAgent, attemptAuthSource, count)
+	}
+
+	if cfg.IPFingerprintEnabled {
+		fingerprint := sourceFingerprint(attemptIP, attemptUserAgent)
+		count, err := al.incrementWindowCounter(al.redisKeyFingerprint("counter", fingerprint), window)
+		if err != nil {
+			log.Log.Errorf(err, "Redis error incrementing fingerprint counter for %s", attemptIP)
+		} else if count >= int64(cfg.IPFingerprintMaxFailedAttempts) {
+			al.blockSource(ctx, cfg, al.redisKeyFingerprint("blocked", fingerprint), EventTypeFingerprintBlocked, BlockReasonFingerprintFailedAttempts,
+				userLoginID, attemptType, attemptIP, attemptUserAgent, attemptAuthSource, count)
+		}
+	}
+
+	// Credential stuffing: one source trying many different loginids
+	if cfg.CredentialStuffingDistinctLoginIDs > 0 && userLoginID != "" {
+		key := al.redisKeyIP("loginids", attemptIP)
+		redisCtx := al.Redis.Context
+		var addedCmd, distinctCmd *goredis.IntCmd
+		var ttlCmd *goredis.DurationCmd
+		_, err := al.Redis.Connection.TxPipelined(redisCtx, func(pipe goredis.Pipeliner) error {
+			addedCmd = pipe.SAdd(redisCtx, key, userLoginID)
+			distinctCmd = pipe.SCard(redisCtx, key)
+			ttlCmd = pipe.TTL(redisCtx, key)
+			return nil
+		})
+		if err != nil {
+			log.Log.Errorf(err, "Redis error tracking distinct loginids for %s", attemptIP)
+			return
+		}
+		err = al.windowExpireIfPersistent(key, ttlCmd.Val(), time.Duration(cfg.CredentialStuffingWindowMinutes)*time.Minute)
+		if err != nil {
+			log.Log.Errorf(err, "Redis error setting distinct loginids expiry for %s", attemptIP)
+			return
+		}
+		if addedCmd.Val() == 0 {
+			return
+		}
+
+		distinct := distinctCmd.Val()
+		if distinct >= int64(cfg.CredentialStuffingDistinctLoginIDs) {
+			al.blockSource(ctx, cfg, al.redisKeyIP("blocked", attemptIP), EventTypeCredentialStuffingDetected, BlockReasonCredentialStuffing,
+				userLoginID, attemptType, attemptIP, attemptUserAgent, attemptAuthSource, distinct)
+		}
+	}
+}
+
+// incrementWindowCounter increments a counter whose window starts at the first failure
+func (al *DXMAccountLockout) incrementWindowCounter(key string, window time.Duration) (int64, error) {
+	ctx := al.Redis.Context
+	var countCmd *goredis.IntCmd
+	var ttlCmd *goredis.DurationCmd
+	_, err := al.Redis.Connection.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+		countCmd = pipe.Incr(ctx, key)
+		ttlCmd = pipe.TTL(ctx, key)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	err = al.windowExpireIfPersistent(key, ttlCmd.Val(), window)
+	if err != nil {
+		return 0, err
+	}
+	return countCmd.Val(), nil
+}
+
+// windowExpireIfPersistent sets the window TTL on a key that has none, not only after the first write, so a failed
+// EXPIRE is retried at the next failure instead of leaving the key (and a block) forever
+func (al *DXMAccountLockout) windowExpireIfPersistent(key string, ttl time.Duration, window time.Duration) error {
+	if ttl >= 0 {
+		return nil
+	}
+	return al.Redis.Connection.Expire(al.Redis.Context, key, window).Err()
+}
+
+// blockSource writes the block record; only the first writer of a block emits the event
+func (al *DXMAccountLockout) blockSource(
+	ctx context.Context,
+	cfg *AccountLockoutConfig,
+	key string,
+	eventType string,
+	reason string,
+	userLoginID string,
+	attemptType string,
+	attemptIP string,
+	attemptUserAgent string,
+	attemptAuthSource string,
+	count int64,
+) {
+	now := time.Now()
+	blockDuration := time.Duration(cfg.IPBlockDurationMinutes) * time.Minute
+
+	isNew, err := al.Redis.Connection.SetNX(al.Redis.Context, key, reason+":"+strconv.FormatInt(now.Unix(), 10), blockDuration).Result()
+	if err != nil {
+		log.Log.Errorf(err, "Redis error blocking source %s", attemptIP)
+		return
+	}
+	if !isNew {
+		return
+	}
+
+	event := &LockoutEvent{
+		EventType:              eventType,
+		EventTimestamp:         now.Format(time.RFC3339),
+		UserLoginID:            userLoginID,
+		LockoutReason:          reason,
+		FailedAttemptsCount:    int(count),
+		LockoutDurationSeconds: int(blockDuration.Seconds()),
+		LockedAt:               now.Format(time.RFC3339),
+		UnlockAt:               now.Add(blockDuration).Format(time.RFC3339),
+		AttemptType:            attemptType,
+		AttemptIPAddress:       attemptIP,
+		AttemptUserAgent:       attemptUserAgent,
+		AttemptAuthSource:      attemptAuthSource,
+	}
+	al.emitEvent(ctx, cfg, event, true)
+
+	log.Log.Warnf("LOGIN SOURCE BLOCKED: ip=%s, reason=%s, count=%d, duration=%v", attemptIP, reason, count, blockDuration)
+}
+
+// UnblockSource removes the IP block, its fingerprint blocks expire on their own
+func (al *DXMAccountLockout) UnblockSource(ctx context.Context, attemptIP string, unlockedByUserID int64, unlockedByUserUID string, reason string) error {
+	keys := []string{
+		al.redisKeyIP("blocked", attemptIP),
+		al.redisKeyIP("counter", attemptIP),
+		al.redisKeyIP("loginids", attemptIP),
+	}
+	if err := al.Redis.Connection.Del(al.Redis.Context, keys...).Err(); err != nil {
+		return fmt.Errorf("failed to unblock source in Redis: %w", err)
+	}
+
+	event := &LockoutEvent{
+		EventType:         EventTypeIPUnblockedAdmin,
+		EventTimestamp:    time.Now().Format(time.RFC3339),
+		AttemptIPAddress:  attemptIP,
+		UnlockedByUserID:  unlockedByUserID,
+		UnlockedByUserUID: unlockedByUserUID,
+		UnlockReason:      reason,
+	}
+	al.emitEvent(ctx, al.GetConfig(), event, true)
+
+	log.Log.Infof("Login source manually unblocked: ip=%s, unlocked_by=%d, reason=%s", attemptIP, unlockedByUserID, reason)
+	return nil
+}
@@ -11,9 +11,9 @@ import (
 )
 
 // CheckLockStatus checks if a user account is locked
-// Returns: isLocked, remainingTimeSeconds, error
+// Returns: isLocked, remainingTimeSeconds (-1 when the lock only clears via UnlockAccount), error
 func (al *DXMAccountLockout) CheckLockStatus(userID int64) (bool, int64, error) {
-	if !al.Config.Enabled {
+	if !al.GetConfig().Enabled {
 		return false, 0, nil
 	}
 
@@ -33,53 +33,68 @@ func (al *DXMAccountLockout) RecordFailedAttempt(
 	attemptUserAgent string,
 	attemptAuthSource string,
 ) error {
-	if !al.Config.Enabled {
+	// One snapshot for the whole attempt, a reload in the middle must not mix thresholds of two configs
+	cfg := al.GetConfig()
+	if !cfg.Enabled {
 		return nil
 	}
 
+	// The source dimension counts every failure, whatever the per-account tracking settings
+	al.recordSourceFailure(aepr.Context, cfg, userLoginID, attemptType, attemptIP, attemptUserAgent, attemptAuthSource)
+
 	// Check if we should track this attempt type
-	if attemptType == AttemptTypePassword && !al.Config.TrackPasswordFailures {
+	if attemptType == AttemptTypePassword && !cfg.TrackPasswordFailures {
 		return nil
 	}
-	if attemptType == AttemptTypeLDAP && !al.Config.TrackLDAPFailures {
+	if attemptType == AttemptTypeLDAP && !cfg.TrackLDAPFailures {
 		return nil
 	}
 
+	// Failures right after a successful login or an admin unlock are not counted against the account
+	inGracePeriod, err := al.IsInGracePeriodRedis(cfg, userID)
+	if err != nil {
+		log.Log.Warnf("Failed to read grace period for user %d: %v", userID, err)
+	}
+	if inGracePeriod {
+		log.Log.Infof("Failed login attempt inside grace period not counted: user_id=%d, type=%s, ip=%s", userID, attemptType, attemptIP)
+		return nil
+	}
+
+	policy := al.ResolvePolicy(aepr.Context, cfg, organizationID)
+
 	// Increment counter in Redis
-	count, err := al.IncrementFailedAttemptCounterRedis(userID, attemptIP, attemptType)
+	count, err := al.IncrementFailedAttemptCounterRedis(cfg, userID, attemptIP, attemptType)
 	if err != nil {
 		log.Log.Errorf(err, "Failed to increment counter for user %d", userID)
-		if al.Config.RedisFailMode == RedisFailModeFailThenLock {
+		if cfg.RedisFailMode == RedisFailModeFailThenLock {
 			return fmt.Errorf("failed to record attempt (fail-closed): %w", err)
 		}
 		return nil // REDIS_FAIL_KEEP_UNLOCK: don't fail auth
 	}
 
 	log.Log.Infof("Failed login attempt recorded: user_id=%d, count=%d/%d, type=%s, ip=%s",
-		userID, count, al.Config.MaxFailedAttempts, attemptType, attemptIP)
-
-	// Log to audit database
-	if al.Config.LogFailedAttempts {
-		event := &LockoutEvent{
-			EventType:           EventTypeFailedAttempt,
-			EventTimestamp:      time.Now().Format(time.RFC3339),
-			UserID:              userID,
-			UserUID:             userUID,
-			UserLoginID:         userLoginID,
-			OrganizationID:      organizationID,
-			OrganizationUID:     organizationUID,
-			FailedAttemptsCount: int(count),
-			AttemptType:         attemptType,
-			AttemptIPAddress:    attemptIP,
-			AttemptUserAgent:    attemptUserAgent,
-			AttemptAuthSource:   attemptAuthSource,
-		}
-		al.writeAuditLog(aepr.Context, event)
+		userID, count, policy.MaxFailedAttempts, attemptType, attemptIP)
+
+	// Count in daily statistics, persist to audit database if enabled
+	event := &LockoutEvent{
+		EventType:           EventTypeFailedAttempt,
+		EventTimestamp:      time.Now().Format(time.RFC3339),
+		UserID:              userID,
+		UserUID:             userUID,
+		UserLoginID:         userLoginID,
+		OrganizationID:      organizationID,
+		OrganizationUID:     organizationUID,
+		FailedAttemptsCount: int(count),
+		AttemptType:         attemptType,
+		AttemptIPAddress:    attemptIP,
+		AttemptUserAgent:    attemptUserAgent,
+		AttemptAuthSource:   attemptAuthSource,
 	}
+	al.emitEvent(aepr.Context, cfg, event, cfg.LogFailedAttempts)
 
 	// Check if threshold reached - lock account
-	if count >= int64(al.Config.MaxFailedAttempts) {
-		err := al.lockAccount(aepr.Context, userID, userUID, userLoginID, organizationID, organizationUID, count, "EXCEEDED_FAILED_ATTEMPTS")
+	if count >= int64(policy.MaxFailedAttempts) {
+		err := al.lockAccount(aepr.Context, cfg, policy, userID, userUID, userLoginID, organizationID, organizationUID, count, "EXCEEDED_FAILED_ATTEMPTS")
 		if err != nil {
 			log.Log.Errorf(err, "Failed to lock account for user %d", userID)
 		}
@@ -90,31 +105,37 @@ func (al *DXMAccountLockout) RecordFailedAttempt(
 
 // RecordSuccessfulLogin records successful login and resets counters
 func (al *DXMAccountLockout) RecordSuccessfulLogin(ctx context.Context, userID int64, userUID string, userLoginID string) error {
-	if !al.Config.Enabled {
+	cfg := al.GetConfig()
+	if !cfg.Enabled {
 		return nil
 	}
 
-	if !al.Config.ResetCounterOnSuccess {
+	al.resetLockTierIfClean(cfg, userID)
+
+	err := al.StartGracePeriodRedis(cfg, userID)
+	if err != nil {
+		log.Log.Warnf("Failed to start grace period for user %d: %v", userID, err)
+	}
+
+	if !cfg.ResetCounterOnSuccess {
 		return nil
 	}
 
 	// Reset counter in Redis
-	err := al.ResetCounterRedis(userID)
+	err = al.ResetCounterRedis(userID)
 	if err != nil {
 		log.Log.Warnf("Failed to reset counter for user %d: %v", userID, err)
 	}
 
-	// Log successful login if enabled
-	if al.Config.LogSuccessfulLogins {
-		event := &LockoutEvent{
-			EventType:      "SUCCESSFUL_LOGIN",
-			EventTimestamp: time.Now().Format(time.RFC3339),
-			UserID:         userID,
-			UserUID:        userUID,
-			UserLoginID:    userLoginID,
-		}
-		al.writeAuditLog(ctx, event)
+	// Count in daily statistics, persist to audit database if enabled
+	event := &LockoutEvent{
+		EventType:      EventTypeSuccessfulLogin,
+		EventTimestamp: time.Now().Format(time.RFC3339),
+		UserID:         userID,
+		UserUID:        userUID,
+		UserLoginID:    userLoginID,
 	}
+	al.emitEvent(ctx, cfg, event, cfg.LogSuccessfulLogins)
 
 	return nil
 }
@@ -129,7 +150,8 @@ func (al *DXMAccountLockout) UnlockAccount(
 	unlockedByUserUID string,
 	reason string,
 ) error {
-	if !al.Config.Enabled {
+	cfg := al.GetConfig()
+	if !cfg.Enabled {
 		return nil
 	}
 
@@ -139,6 +161,11 @@ func (al *DXMAccountLockout) UnlockAccount(
 		return fmt.Errorf("failed to unlock in Redis: %w", err)
 	}
 
+	err = al.StartGracePeriodRedis(cfg, userID)
+	if err != nil {
+		log.Log.Warnf("Failed to start grace period for user %d: %v", userID, err)
+	}
+
 	// Write audit log
 	event := &LockoutEvent{
 		EventType:         EventTypeAccountUnlockedAdmin,
@@ -150,7 +177,7 @@ func (al *DXMAccountLockout) UnlockAccount(
 		UnlockedByUserUID: unlockedByUserUID,
 		UnlockReason:      reason,
 	}
-	al.writeAuditLog(ctx, event)
+	al.emitEvent(ctx, cfg, event, true)
 
 	log.Log.Infof("Account manually unlocked: user_id=%d, unlocked_by=%d, reason=%s",
 		userID, unlockedByUserID, reason)
@@ -161,6 +188,8 @@ func (al *DXMAccountLockout) UnlockAccount(
 // lockAccount locks the account (internal helper)
 func (al *DXMAccountLockout) lockAccount(
 	ctx context.Context,
+	cfg *AccountLockoutConfig,
+	policy *AccountLockoutPolicy,
 	userID int64,
 	userUID string,
 	userLoginID string,
@@ -169,15 +198,33 @@ func (al *DXMAccountLockout) lockAccount(
 	failedCount int64,
 	reason string,
 ) error {
+	lockoutType := policy.LockoutType
+	lockoutTier := int64(1)
+	lockDuration := time.Duration(policy.LockoutDurationMinutes) * time.Minute
+	if al.isProgressive(cfg, lockoutType) {
+		tier, err := al.IncrementLockTierRedis(cfg, userID)
+		if err != nil {
+			log.Log.Warnf("Failed to increment lock tier for user %d, using base duration: %v", userID, err)
+		} else {
+			lockoutTier = tier
+		}
+		lockDuration = al.progressiveLockDuration(cfg, policy.LockoutDurationMinutes, lockoutTier)
+	}
+
 	// Lock in Redis
-	err := al.LockAccountRedis(userID, failedCount, reason)
+	err := al.LockAccountRedis(userID, userUID, userLoginID, organizationID, organizationUID, failedCount, reason, lockoutType, lockDuration, lockoutTier)
 	if err != nil {
 		return fmt.Errorf("failed to lock in Redis: %w", err)
 	}
 
 	// Write audit log
 	now := time.Now()
-	unlockAt := now.Add(time.Duration(al.Config.LockoutDurationMinutes) * time.Minute)
+	unlockAtAsString := ""
+	if lockoutTypeAutoUnlocks(lockoutType) {
+		unlockAtAsString = now.Add(lockDuration).Format(time.RFC3339)
+	} else {
+		lockDuration = 0
+	}
 
 	event := &LockoutEvent{
 		EventType:              EventTypeAccountLocked,
@@ -189,20 +236,22 @@ func (al *DXMAccountLockout) lockAccount(
 		OrganizationUID:        organizationUID,
 		LockoutReason:          reason,
 		FailedAttemptsCount:    int(failedCount),
-		LockoutDurationSeconds: al.Config.LockoutDurationMinutes * 60,
+		LockoutDurationSeconds: int(lockDuration.Seconds()),
+		LockoutTier:            int(lockoutTier),
 		LockedAt:               now.Format(time.RFC3339),
-		UnlockAt:               unlockAt.Format(time.RFC3339),
+		UnlockAt:               unlockAtAsString,
 	}
-	al.writeAuditLog(ctx, event)
+	al.emitEvent(ctx, cfg, event, true)
 
-	log.Log.Warnf("ACCOUNT LOCKED: user_id=%d, loginid=%s, failed_attempts=%d, duration=%d min",
-		userID, userLoginID, failedCount, al.Config.LockoutDurationMinutes)
+	log.Log.Warnf("ACCOUNT LOCKED: user_id=%d, loginid=%s, failed_attempts=%d, type=%s, duration=%v, tier=%d",
+		userID, userLoginID, failedCount, lockoutType, lockDuration, lockoutTier)
 
 	return nil
 }
 
 // GetLockoutStatus gets detailed lockout status for a user
 func (al *DXMAccountLockout) GetLockoutStatus(userID int64) (utils.JSON, error) {
+	cfg := al.GetConfig()
 	isLocked, remainingSeconds, err := al.CheckLockStatus(userID)
 	if err != nil {
 		return nil, err
@@ -214,14 +263,47 @@ func (al *DXMAccountLockout) GetLockoutStatus(userID int64) (utils.JSON, error)
 	}
 
 	if isLocked {
-		unlockAt := time.Now().Add(time.Duration(remainingSeconds) * time.Second)
-		status["unlock_at"] = unlockAt.Format(time.RFC3339)
-
-		// Get failed attempt count
-		count, _ := al.GetFailedAttemptCountRedis(userID)
-		status["failed_attempts"] = count
+		// remaining_seconds = -1 marks a lock that stays until an admin unlocks it
+		status["is_indefinite"] = remainingSeconds < 0
+		if remainingSeconds > 0 {
+			unlockAt := time.Now().Add(time.Duration(remainingSeconds) * time.Second)
+			status["unlock_at"] = unlockAt.Format(time.RFC3339)
+		}
 		status["lockout_reason"] = "EXCEEDED_FAILED_ATTEMPTS"
 	}
 
+	// Failed attempts inside the sliding window, with per-attempt details
+	count, _ := al.GetFailedAttemptCountRedis(cfg, userID)
+	status["failed_attempts"] = count
+	status["failed_attempt_window_minutes"] = cfg.FailedAttemptWindowMinutes
+	recentAttempts, err := al.GetRecentFailedAttemptsRedis(cfg, userID)
+	if err == nil {
+		status["recent_attempts"] = recentAttempts
+	}
+
+	inGracePeriod, err := al.IsInGracePeriodRedis(cfg, userID)
+	if err == nil {
+		status["in_grace_period"] = inGracePeriod
+	}
+
+	tier, _, err := al.GetLockTierRedis(userID)
+	if err == nil && tier > 0 {
+		status["lockout_tier"] = tier
+	}
+
+	// Redis health as seen by the lock check, an OPEN breaker means the fail mode is being applied
+	if circuitBreaker := al.GetCircuitBreaker(); circuitBreaker != nil {
+		snapshot := circuitBreaker.Snapshot()
+		circuitBreakerStatus := utils.JSON{
+			"enabled":       cfg.CircuitBreakerEnabled,
+			"state":         snapshot.State.String(),
+			"failure_count": snapshot.FailureCount,
+		}
+		if !snapshot.OpenedAt.IsZero() {
+			circuitBreakerStatus["opened_at"] = snapshot.OpenedAt.Format(time.RFC3339)
+		}
+		status["circuit_breaker"] = circuitBreakerStatus
+	}
+
 	return status, nil
 }
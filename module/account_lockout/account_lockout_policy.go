@@ -0,0 +1,135 @@
+package account_lockout
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/donnyhardyanto/dxlib/log"
+	"github.com/donnyhardyanto/dxlib/utils"
+)
+
+// policyCacheTTL bounds how long another instance's policy edit can stay unseen; local edits invalidate immediately
+const policyCacheTTL = 5 * time.Minute
+
+// AccountLockoutPolicy is the effective lockout policy for one organization
+type AccountLockoutPolicy struct {
+	OrganizationID         int64
+	MaxFailedAttempts      int
+	LockoutDurationMinutes int
+	LockoutType            string
+	IsOrganizationPolicy   bool
+}
+
+type policyCacheEntry struct {
+	policy    *AccountLockoutPolicy
+	expiresAt time.Time
+}
+
+// globalPolicy returns the policy defined by the global account_lockout configuration
+func (al *DXMAccountLockout) globalPolicy(cfg *AccountLockoutConfig) *AccountLockoutPolicy {
+	return &AccountLockoutPolicy{
+		MaxFailedAttempts:      cfg.MaxFailedAttempts,
+		LockoutDurationMinutes: cfg.LockoutDurationMinutes,
+		LockoutType:            cfg.LockoutType,
+	}
+}
+
+// ResolvePolicy returns the lockout policy of an organization, falling back to the global config
+// when the organization has no active row in account_lockout_config or the row cannot be read
+func (al *DXMAccountLockout) ResolvePolicy(ctx context.Context, cfg *AccountLockoutConfig, organizationID int64) *AccountLockoutPolicy {
+	if organizationID == 0 {
+		return al.globalPolicy(cfg)
+	}
+
+	al.policyCacheMutex.RLock()
+	entry, ok := al.policyCache[organizationID]
+	al.policyCacheMutex.RUnlock()
+	if ok && time.Now().Before(entry.expiresAt) {
+		return entry.policy
+	}
+
+	policy, err := al.loadOrganizationPolicy(ctx, cfg, organizationID)
+	if err != nil {
+		// Do not cache, the next attempt retries the lookup
+		log.Log.Warnf("Failed to load lockout policy for organization %d, using global policy: %v", organizationID, err)
+		return al.globalPolicy(cfg)
+	}
+
+	al.policyCacheMutex.Lock()
+	if al.policyCache == nil {
+		al.policyCache = map[int64]*policyCacheEntry{}
+	}
+	al.policyCache[organizationID] = &policyCacheEntry{
+		policy:    policy,
+		expiresAt: time.Now().Add(policyCacheTTL),
+	}
+	al.policyCacheMutex.Unlock()
+
+	return policy
+}
+
+func (al *DXMAccountLockout) loadOrganizationPolicy(ctx context.Context, cfg *AccountLockoutConfig, organizationID int64) (*AccountLockoutPolicy, error) {
+	policy := al.globalPolicy(cfg)
+	policy.OrganizationID = organizationID
+
+	_, row, err := al.AccountLockoutConfig.SelectOne(ctx, &log.Log, nil, utils.JSON{
+		"organization_id": organizationID,
+		"is_active":       true,
+	}, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if row == nil {
+		return policy, nil
+	}
+
+	// Columns left empty in the row inherit the global value
+	orgPolicy := *policy
+	orgPolicy.IsOrganizationPolicy = true
+	if v, err := utils.GetInt64FromKV(row, "max_failed_attempts"); err == nil && v > 0 {
+		orgPolicy.MaxFailedAttempts = int(v)
+	}
+	if v, err := utils.GetInt64FromKV(row, "lockout_duration_minutes"); err == nil && v > 0 {
+		orgPolicy.LockoutDurationMinutes = int(v)
+	}
+	if v, err := utils.GetStringFromKV(row, "lockout_type"); err == nil && v != "" {
+		orgPolicy.LockoutType = v
+	}
+
+	if err := validatePolicyValues(orgPolicy.MaxFailedAttempts, orgPolicy.LockoutDurationMinutes, orgPolicy.LockoutType); err != nil {
+		log.Log.Warnf("Invalid lockout policy for organization %d, using global policy: %v", organizationID, err)
+		return policy, nil
+	}
+
+	return &orgPolicy, nil
+}
+
+// InvalidatePolicyCache drops the cached policy of one organization
+func (al *DXMAccountLockout) InvalidatePolicyCache(organizationID int64) {
+	al.policyCacheMutex.Lock()
+	delete(al.policyCache, organizationID)
+	al.policyCacheMutex.Unlock()
+}
+
+// InvalidateAllPolicyCache drops every cached organization policy
+func (al *DXMAccountLockout) InvalidateAllPolicyCache() {
+	al.policyCacheMutex.Lock()
+	al.policyCache = map[int64]*policyCacheEntry{}
+	al.policyCacheMutex.Unlock()
+}
+
+// validatePolicyValues applies the same bounds as validateConfig to a single policy
+func validatePolicyValues(maxFailedAttempts int, lockoutDurationMinutes int, lockoutType string) error {
+	if maxFailedAttempts < 1 || maxFailedAttempts > 100 {
+		return fmt.Errorf("max_failed_attempts must be between 1 and 100, got %d", maxFailedAttempts)
+	}
+	if lockoutDurationMinutes < 1 || lockoutDurationMinutes > 10080 {
+		return fmt.Errorf("lockout_duration_minutes must be between 1 and 10080 (7 days), got %d", lockoutDurationMinutes)
+	}
+	validTypes := []string{LockoutTypeAutoUnlock, LockoutTypeAdminUnlock, LockoutTypeProgressive}
+	if !contains(validTypes, lockoutType) {
+		return fmt.Errorf("invalid lockout_type: %s", lockoutType)
+	}
+	return nil
+}
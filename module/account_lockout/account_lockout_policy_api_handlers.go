@@ -0,0 +1,171 @@
+package account_lockout
+
+import (
+	"net/http"
+
+	"github.com/donnyhardyanto/dxlib/api"
+	"github.com/donnyhardyanto/dxlib/utils"
+	"github.com/donnyhardyanto/dxlib_module/module/user_management"
+)
+
+// Admin handlers for per-organization lockout policies (account_lockout.account_lockout_config).
+// Every write invalidates the cached policy of the affected organization so it takes effect on the next attempt.
+
+// AccountLockoutPolicyListHandler - Complete handler in module (FIXED/HOW)
+func (al *DXMAccountLockout) AccountLockoutPolicyListHandler(aepr *api.DXAPIEndPointRequest) error {
+	return al.AccountLockoutConfig.RequestSearchPagingList(aepr)
+}
+
+// AccountLockoutPolicyReadHandler - Complete handler in module (FIXED/HOW)
+func (al *DXMAccountLockout) AccountLockoutPolicyReadHandler(aepr *api.DXAPIEndPointRequest) error {
+	return al.AccountLockoutConfig.RequestRead(aepr)
+}
+
+// AccountLockoutPolicyCreateHandler - Complete handler in module (FIXED/HOW)
+func (al *DXMAccountLockout) AccountLockoutPolicyCreateHandler(aepr *api.DXAPIEndPointRequest) error {
+	_, organizationUid, err := aepr.GetParameterValueAsString("organization_uid")
+	if err != nil {
+		return aepr.WriteResponseAndNewErrorf(http.StatusBadRequest, "PARAMETER_ORGANIZATION_UID_REQUIRED", "Parameter organization_uid is required")
+	}
+	_, maxFailedAttempts, err := aepr.GetParameterValueAsInt64("max_failed_attempts")
+	if err != nil {
+		return aepr.WriteResponseAndNewErrorf(http.StatusBadRequest, "PARAMETER_MAX_FAILED_ATTEMPTS_REQUIRED", "Parameter max_failed_attempts is required")
+	}
+	_, lockoutDurationMinutes, err := aepr.GetParameterValueAsInt64("lockout_duration_minutes")
+	if err != nil {
+		return aepr.WriteResponseAndNewErrorf(http.StatusBadRequest, "PARAMETER_LOCKOUT_DURATION_MINUTES_REQUIRED", "Parameter lockout_duration_minutes is required")
+	}
+	_, lockoutType, err := aepr.GetParameterValueAsString("lockout_type")
+	if err != nil {
+		return aepr.WriteResponseAndNewErrorf(http.StatusBadRequest, "PARAMETER_LOCKOUT_TYPE_REQUIRED", "Parameter lockout_type is required")
+	}
+	_, isActive, err := aepr.GetParameterValueAsBool("is_active", true)
+	if err != nil {
+		return err
+	}
+
+	err = validatePolicyValues(int(maxFailedAttempts), int(lockoutDurationMinutes), lockoutType)
+	if err != nil {
+		return aepr.WriteResponseAndNewErrorf(http.StatusBadRequest, "INVALID_LOCKOUT_POLICY", "NOT_ERROR:INVALID_LOCKOUT_POLICY:%s", err.Error())
+	}
+
+	_, organization, err := user_management.ModuleUserManagement.Organization.ShouldGetByUid(aepr.Context, &aepr.Log, organizationUid)
+	if err != nil {
+		return err
+	}
+	organizationId, err := utils.GetInt64FromKV(organization, "id")
+	if err != nil {
+		return err
+	}
+
+	_, err = al.AccountLockoutConfig.DoCreate(aepr, utils.JSON{
+		"organization_id":          organizationId,
+		"max_failed_attempts":      maxFailedAttempts,
+		"lockout_duration_minutes": lockoutDurationMinutes,
+		"lockout_type":             lockoutType,
+		"is_active":                isActive,
+	})
+	if err != nil {
+		return err
+	}
+
+	al.InvalidatePolicyCache(organizationId)
+	return nil
+}
+
+// AccountLockoutPolicyEditHandler - Complete handler in module (FIXED/HOW)
+func (al *DXMAccountLockout) AccountLockoutPolicyEditHandler(aepr *api.DXAPIEndPointRequest) error {
+	_, id, err := aepr.GetParameterValueAsInt64("id")
+	if err != nil {
+		return err
+	}
+	_, newFieldValues, err := aepr.GetParameterValueAsJSON("new")
+	if err != nil {
+		return err
+	}
+
+	_, row, err := al.AccountLockoutConfig.ShouldGetById(aepr.Context, &aepr.Log, id)
+	if err != nil {
+		return err
+	}
+	organizationId, err := utils.GetInt64FromKV(row, "organization_id")
+	if err != nil {
+		return err
+	}
+
+	p := utils.JSON{}
+
+	maxFailedAttempts, _ := utils.GetInt64FromKV(row, "max_failed_attempts")
+	if v, ok := jsonValueAsInt64(newFieldValues["max_failed_attempts"]); ok {
+		maxFailedAttempts = v
+		p["max_failed_attempts"] = v
+	}
+
+	lockoutDurationMinutes, _ := utils.GetInt64FromKV(row, "lockout_duration_minutes")
+	if v, ok := jsonValueAsInt64(newFieldValues["lockout_duration_minutes"]); ok {
+		lockoutDurationMinutes = v
+		p["lockout_duration_minutes"] = v
+	}
+
+	lockoutType, _ := utils.GetStringFromKV(row, "lockout_type")
+	if v, ok := newFieldValues["lockout_type"].(string); ok {
+		lockoutType = v
+		p["lockout_type"] = v
+	}
+
+	if v, ok := newFieldValues["is_active"].(bool); ok {
+		p["is_active"] = v
+	}
+
+	err = validatePolicyValues(int(maxFailedAttempts), int(lockoutDurationMinutes), lockoutType)
+	if err != nil {
+		return aepr.WriteResponseAndNewErrorf(http.StatusBadRequest, "INVALID_LOCKOUT_POLICY", "NOT_ERROR:INVALID_LOCKOUT_POLICY:%s", err.Error())
+	}
+
+	err = al.AccountLockoutConfig.DoUpdateWithValidation(aepr, id, p)
+	if err != nil {
+		return err
+	}
+
+	al.InvalidatePolicyCache(organizationId)
+	return nil
+}
+
+// AccountLockoutPolicyDeleteHandler - Complete handler in module (FIXED/HOW)
+func (al *DXMAccountLockout) AccountLockoutPolicyDeleteHandler(aepr *api.DXAPIEndPointRequest) error {
+	_, id, err := aepr.GetParameterValueAsInt64("id")
+	if err != nil {
+		return err
+	}
+
+	_, row, err := al.AccountLockoutConfig.ShouldGetById(aepr.Context, &aepr.Log, id)
+	if err != nil {
+		return err
+	}
+	organizationId, err := utils.GetInt64FromKV(row, "organization_id")
+	if err != nil {
+		return err
+	}
+
+	err = al.AccountLockoutConfig.RequestSoftDelete(aepr)
+	if err != nil {
+		return err
+	}
+
+	al.InvalidatePolicyCache(organizationId)
+	return nil
+}
+
+// jsonValueAsInt64 accepts the numeric shapes a decoded JSON parameter can take
+func jsonValueAsInt64(v any) (int64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return n, true
+	case int:
+		return int64(n), true
+	case float64:
+		return int64(n), true
+	default:
+		return 0, false
+	}
+}
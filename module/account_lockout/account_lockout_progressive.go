@@ -0,0 +1,109 @@
+package account_lockout
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/donnyhardyanto/dxlib/log"
+	goredis "github.com/go-redis/redis/v8"
+)
+
+const (
+	defaultProgressiveMultiplier    = 2
+	defaultProgressiveWindowMinutes = 1440
+)
+
+// Redis key pattern:
+// pgn:partner:lockout:tier:{user_id} -> hash {tier, last_locked_at}, TTL = progressive window (rolling from the last lock)
+
+func (al *DXMAccountLockout) redisKeyTierForUser(userID int64) string {
+	return fmt.Sprintf("%s:%s:%d", redisKeyPrefix, redisKeyTier, userID)
+}
+
+// isProgressive reports whether lock durations of the given lockout type escalate with each lock inside the window.
+// progressive.enabled extends escalation to AUTO_UNLOCK, an ADMIN_UNLOCK lock has no duration to escalate.
+func (al *DXMAccountLockout) isProgressive(cfg *AccountLockoutConfig, lockoutType string) bool {
+	if lockoutType == LockoutTypeProgressive {
+		return true
+	}
+	return cfg.ProgressiveEnabled && lockoutTypeAutoUnlocks(lockoutType)
+}
+
+// progressiveLockDuration returns base * multiplier^(tier-1), capped at ProgressiveMaxDuration
+func (al *DXMAccountLockout) progressiveLockDuration(cfg *AccountLockoutConfig, baseMinutes int, tier int64) time.Duration {
+	minutes := baseMinutes
+	for i := int64(1); i < tier && (cfg.ProgressiveMaxDuration <= 0 || minutes < cfg.ProgressiveMaxDuration); i++ {
+		minutes *= cfg.ProgressiveMultiplier
+	}
+	if cfg.ProgressiveMaxDuration > 0 && minutes > cfg.ProgressiveMaxDuration {
+		minutes = cfg.ProgressiveMaxDuration
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// IncrementLockTierRedis bumps the lock tier of a user and restarts the rolling window
+func (al *DXMAccountLockout) IncrementLockTierRedis(cfg *AccountLockoutConfig, userID int64) (tier int64, err error) {
+	key := al.redisKeyTierForUser(userID)
+
+	ctx := al.Redis.Context
+	var tierCmd *goredis.IntCmd
+	_, err = al.Redis.Connection.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+		tierCmd = pipe.HIncrBy(ctx, key, "tier", 1)
+		pipe.HSet(ctx, key, "last_locked_at", time.Now().Unix())
+		pipe.Expire(ctx, key, time.Duration(cfg.ProgressiveWindowMinutes)*time.Minute)
+		return nil
+	})
+	if err != nil {
+		log.Log.Errorf(err, "Redis error incrementing lock tier for user %d", userID)
+		return 0, err
+	}
+
+	return tierCmd.Val(), nil
+}
+
+// GetLockTierRedis returns the current lock tier (0 when the user has no lock inside the window)
+func (al *DXMAccountLockout) GetLockTierRedis(userID int64) (tier int64, lastLockedAt int64, err error) {
+	values, err := al.Redis.Connection.HGetAll(al.Redis.Context, al.redisKeyTierForUser(userID)).Result()
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(values) == 0 {
+		return 0, 0, nil
+	}
+
+	tier, _ = strconv.ParseInt(values["tier"], 10, 64)
+	lastLockedAt, _ = strconv.ParseInt(values["last_locked_at"], 10, 64)
+	return tier, lastLockedAt, nil
+}
+
+// ResetLockTierRedis drops the lock tier so the next lock starts from the base duration again
+func (al *DXMAccountLockout) ResetLockTierRedis(userID int64) error {
+	if err := al.Redis.Connection.Del(al.Redis.Context, al.redisKeyTierForUser(userID)).Err(); err != nil {
+		log.Log.Errorf(err, "Redis error resetting lock tier for user %d", userID)
+		return err
+	}
+	return nil
+}
+
+// resetLockTierIfClean resets the tier once the user has stayed clean (no lock) for ProgressiveResetAfterMinutes
+func (al *DXMAccountLockout) resetLockTierIfClean(cfg *AccountLockoutConfig, userID int64) {
+	if cfg.ProgressiveResetAfterMinutes <= 0 {
+		return
+	}
+
+	tier, lastLockedAt, err := al.GetLockTierRedis(userID)
+	if err != nil {
+		log.Log.Warnf("Failed to read lock tier for user %d: %v", userID, err)
+		return
+	}
+	if tier == 0 {
+		return
+	}
+
+	cleanFor := time.Since(time.Unix(lastLockedAt, 0))
+	if cleanFor >= time.Duration(cfg.ProgressiveResetAfterMinutes)*time.Minute {
+		_ = al.ResetLockTierRedis(userID)
+		log.Log.Infof("Lock tier reset after clean period: user_id=%d, previous_tier=%d", userID, tier)
+	}
+}
@@ -1,11 +1,16 @@
 package account_lockout
 
 import (
+	"encoding/json"
 	"fmt"
 	"strconv"
 	"time"
 
 	"github.com/donnyhardyanto/dxlib/log"
+	"github.com/donnyhardyanto/dxlib/utils"
+	"github.com/donnyhardyanto/dxlib_module/lib"
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
 )
 
 const (
@@ -14,13 +19,19 @@ const (
 	redisKeyLocked     = "locked"
 	redisKeyAttempts   = "attempts"
 	redisKeyStatistics = "stats:daily"
+	redisKeyTier       = "tier"
+	redisKeyGrace      = "grace"
+
+	lockRecordRetention = 24 * time.Hour
 )
 
 // Redis key patterns:
-// pgn:partner:lockout:counter:{user_id}
+// pgn:partner:lockout:counter:{user_id}  (legacy counter hash, only deleted)
 // pgn:partner:lockout:locked:{user_id}
-// pgn:partner:lockout:attempts:{user_id}
+// pgn:partner:lockout:attempts:{user_id} (sorted set, score = attempt time in ms)
 // pgn:partner:lockout:stats:daily:{YYYYMMDD}
+// pgn:partner:lockout:tier:{user_id}
+// pgn:partner:lockout:grace:{user_id}
 
 func (al *DXMAccountLockout) redisKeyCounterForUser(userID int64) string {
 	return fmt.Sprintf("%s:%s:%d", redisKeyPrefix, redisKeyCounter, userID)
@@ -34,20 +45,52 @@ func (al *DXMAccountLockout) redisKeyAttemptsForUser(userID int64) string {
 	return fmt.Sprintf("%s:%s:%d", redisKeyPrefix, redisKeyAttempts, userID)
 }
 
+func (al *DXMAccountLockout) redisKeyGraceForUser(userID int64) string {
+	return fmt.Sprintf("%s:%s:%d", redisKeyPrefix, redisKeyGrace, userID)
+}
+
+// failedAttemptRecord is the sorted set member stored per failed attempt
+type failedAttemptRecord struct {
+	ID          string `json:"id"`
+	AttemptedAt int64  `json:"at"`
+	IPAddress   string `json:"ip"`
+	AttemptType string `json:"type"`
+}
+
 func (al *DXMAccountLockout) redisKeyStatsDaily(date string) string {
 	return fmt.Sprintf("%s:%s:%s", redisKeyPrefix, redisKeyStatistics, date)
 }
 
-// CheckLockStatusRedis checks if user is locked (fast path)
+// newRedisCircuitBreaker builds the breaker guarding lock checks; transitions are logged so an outage is visible
+func (al *DXMAccountLockout) newRedisCircuitBreaker(cfg *AccountLockoutConfig) *lib.CircuitBreaker {
+	circuitBreaker := lib.NewCircuitBreaker(cfg.CircuitBreakerThreshold, time.Duration(cfg.CircuitBreakerTimeout)*time.Second, cfg.CircuitBreakerHalfOpenProbes)
+	circuitBreaker.OnStateChange(func(from lib.CircuitBreakerState, to lib.CircuitBreakerState) {
+		switch to {
+		case lib.CircuitBreakerStateOpen:
+			current := al.GetConfig()
+			log.Log.Warnf("ACCOUNT LOCKOUT REDIS CONSIDERED DOWN: circuit breaker %s -> %s, applying fail mode %s for %ds",
+				from, to, current.RedisFailMode, current.CircuitBreakerTimeout)
+		case lib.CircuitBreakerStateClosed:
+			log.Log.Infof("Account lockout Redis recovered: circuit breaker %s -> %s", from, to)
+		default:
+			log.Log.Infof("Account lockout Redis circuit breaker %s -> %s, probing", from, to)
+		}
+	})
+	return circuitBreaker
+}
+
+// CheckLockStatusRedis checks if user is locked (fast path), remainingSeconds = -1 for ADMIN_UNLOCK locks
 func (al *DXMAccountLockout) CheckLockStatusRedis(userID int64) (isLocked bool, remainingSeconds int64, err error) {
-	if !al.Config.Enabled {
+	cfg := al.GetConfig()
+	if !cfg.Enabled {
 		return false, 0, nil
 	}
+	circuitBreaker := al.GetCircuitBreaker()
 
 	// Circuit breaker check
-	if al.Config.CircuitBreakerEnabled && !al.CircuitBreaker.CanExecute() {
-		log.Log.Warnf("Account lockout circuit breaker open, applying fail mode: %s", al.Config.RedisFailMode)
-		if al.Config.RedisFailMode == RedisFailModeFailThenLock {
+	if cfg.CircuitBreakerEnabled && !circuitBreaker.CanExecute() {
+		log.Log.Warnf("Account lockout circuit breaker open, applying fail mode: %s", cfg.RedisFailMode)
+		if cfg.RedisFailMode == RedisFailModeFailThenLock {
 			return true, 0, fmt.Errorf("circuit breaker open - redis fail then lock")
 		}
 		return false, 0, nil // REDIS_FAIL_KEEP_UNLOCK
@@ -55,103 +98,222 @@ func (al *DXMAccountLockout) CheckLockStatusRedis(userID int64) (isLocked bool,
 
 	key := al.redisKeyLockedForUser(userID)
 
-	// Check if locked key exists
-	existsCount, err := al.Redis.Connection.Exists(al.Redis.Context, key).Result()
+	// Read the lock record
+	lockData, err := al.Redis.Connection.HGetAll(al.Redis.Context, key).Result()
 	if err != nil {
-		al.CircuitBreaker.RecordFailure()
+		circuitBreaker.RecordFailure()
 		log.Log.Errorf(err, "Redis error checking lock status for user %d", userID)
 
 		// Apply fail mode
-		if al.Config.RedisFailMode == RedisFailModeFailThenLock {
+		if cfg.RedisFailMode == RedisFailModeFailThenLock {
 			return true, 0, err
 		}
 		return false, 0, nil // REDIS_FAIL_KEEP_UNLOCK - allow login
 	}
 
-	al.CircuitBreaker.RecordSuccess()
+	circuitBreaker.RecordSuccess()
 
-	exists := existsCount > 0
-	if !exists {
+	if len(lockData) == 0 {
 		return false, 0, nil
 	}
 
-	// Get TTL (remaining time)
-	ttlDuration, err := al.Redis.Connection.TTL(al.Redis.Context, key).Result()
+	// Locks that only an admin can clear never expire
+	if !lockoutTypeAutoUnlocks(lockData["lockout_type"]) {
+		return true, -1, nil
+	}
+
+	unlockAt, err := strconv.ParseInt(lockData["unlock_at"], 10, 64)
 	if err != nil {
-		log.Log.Errorf(err, "Redis error getting TTL for user %d", userID)
+		log.Log.Errorf(err, "Invalid unlock_at in lock record for user %d", userID)
 		return true, 0, nil
 	}
 
-	ttl := int64(ttlDuration.Seconds())
-	if ttl <= 0 {
-		// Expired, clean up
-		al.Redis.Connection.Del(al.Redis.Context, key)
-		return false, 0, nil
+	remaining := unlockAt - time.Now().Unix()
+	if remaining > 0 {
+		return true, remaining, nil
 	}
 
-	return true, ttl, nil
+	// Lock period elapsed, clean up and record the automatic unlock
+	al.autoUnlockExpiredLock(cfg, userID, lockData)
+	return false, 0, nil
 }
 
-// IncrementFailedAttemptCounterRedis atomically increments the failure counter
-func (al *DXMAccountLockout) IncrementFailedAttemptCounterRedis(userID int64, attemptIP string, attemptType string) (count int64, err error) {
-	key := al.redisKeyCounterForUser(userID)
+// lockoutTypeAutoUnlocks reports whether locks of this type are released when their duration elapses.
+// Records written before the lockout type was stored carry an empty type and always had a TTL.
+func lockoutTypeAutoUnlocks(lockoutType string) bool {
+	return lockoutType != LockoutTypeAdminUnlock
+}
 
-	// Atomic increment
-	count, err = al.Redis.Connection.HIncrBy(al.Redis.Context, key, "count", 1).Result()
+// autoUnlockExpiredLock removes an elapsed lock record; only the caller that actually deletes it emits ACCOUNT_UNLOCKED_AUTO
+func (al *DXMAccountLockout) autoUnlockExpiredLock(cfg *AccountLockoutConfig, userID int64, lockData map[string]string) {
+	deleted, err := al.Redis.Connection.Del(al.Redis.Context, al.redisKeyLockedForUser(userID)).Result()
 	if err != nil {
-		log.Log.Errorf(err, "Redis error incrementing counter for user %d", userID)
+		log.Log.Errorf(err, "Redis error deleting expired lock for user %d", userID)
+		return
+	}
+	if deleted == 0 {
+		return
+	}
+
+	organizationID, _ := strconv.ParseInt(lockData["organization_id"], 10, 64)
+	lockedAt, _ := strconv.ParseInt(lockData["locked_at"], 10, 64)
+	unlockAt, _ := strconv.ParseInt(lockData["unlock_at"], 10, 64)
+
+	event := &LockoutEvent{
+		EventType:       EventTypeAccountUnlockedAuto,
+		EventTimestamp:  time.Now().Format(time.RFC3339),
+		UserID:          userID,
+		UserUID:         lockData["user_uid"],
+		UserLoginID:     lockData["user_loginid"],
+		OrganizationID:  organizationID,
+		OrganizationUID: lockData["organization_uid"],
+		LockoutReason:   lockData["reason"],
+		LockedAt:        time.Unix(lockedAt, 0).Format(time.RFC3339),
+		UnlockAt:        time.Unix(unlockAt, 0).Format(time.RFC3339),
+		UnlockReason:    "LOCK_DURATION_ELAPSED",
+	}
+	al.emitEvent(al.Redis.Context, cfg, event, true)
+
+	log.Log.Infof("Account auto-unlocked: user_id=%d", userID)
+}
+
+// IncrementFailedAttemptCounterRedis records one failed attempt in the sliding window and returns
+// the number of failures within the last FailedAttemptWindowMinutes
+func (al *DXMAccountLockout) IncrementFailedAttemptCounterRedis(cfg *AccountLockoutConfig, userID int64, attemptIP string, attemptType string) (count int64, err error) {
+	key := al.redisKeyAttemptsForUser(userID)
+
+	now := time.Now()
+	window := time.Duration(cfg.FailedAttemptWindowMinutes) * time.Minute
+
+	// Each member carries the attempt details; the id keeps simultaneous attempts distinct
+	member, err := json.Marshal(failedAttemptRecord{
+		ID:          uuid.NewString(),
+		AttemptedAt: now.Unix(),
+		IPAddress:   attemptIP,
+		AttemptType: attemptType,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	err = al.Redis.Connection.ZAdd(al.Redis.Context, key, &goredis.Z{
+		Score:  float64(now.UnixMilli()),
+		Member: string(member),
+	}).Err()
+	if err != nil {
+		log.Log.Errorf(err, "Redis error recording failed attempt for user %d", userID)
 		return 0, err
 	}
 
-	// Set metadata
-	now := time.Now().Unix()
-	al.Redis.Connection.HSet(al.Redis.Context, key, "last_attempt_at", fmt.Sprintf("%d", now))
-	al.Redis.Connection.HSet(al.Redis.Context, key, "last_attempt_ip", attemptIP)
-	al.Redis.Connection.HSet(al.Redis.Context, key, "last_attempt_type", attemptType)
+	// Drop attempts that slid out of the window, then count what is left
+	windowStart := strconv.FormatInt(now.Add(-window).UnixMilli(), 10)
+	al.Redis.Connection.ZRemRangeByScore(al.Redis.Context, key, "-inf", "("+windowStart)
 
-	// Set first attempt timestamp if this is first attempt
-	if count == 1 {
-		al.Redis.Connection.HSet(al.Redis.Context, key, "first_attempt_at", fmt.Sprintf("%d", now))
+	count, err = al.Redis.Connection.ZCard(al.Redis.Context, key).Result()
+	if err != nil {
+		log.Log.Errorf(err, "Redis error counting failed attempts for user %d", userID)
+		return 0, err
 	}
 
-	// Set expiry (sliding window - 1 hour)
-	al.Redis.Connection.Expire(al.Redis.Context, key, time.Duration(3600)*time.Second)
+	// The whole set is stale once the newest attempt leaves the window
+	al.Redis.Connection.Expire(al.Redis.Context, key, window)
 
 	return count, nil
 }
 
-// LockAccountRedis locks the account in Redis
-func (al *DXMAccountLockout) LockAccountRedis(userID int64, failedCount int64, reason string) error {
+// GetRecentFailedAttemptsRedis lists the failed attempts still inside the window, newest first
+func (al *DXMAccountLockout) GetRecentFailedAttemptsRedis(cfg *AccountLockoutConfig, userID int64) ([]utils.JSON, error) {
+	key := al.redisKeyAttemptsForUser(userID)
+	window := time.Duration(cfg.FailedAttemptWindowMinutes) * time.Minute
+	windowStart := strconv.FormatInt(time.Now().Add(-window).UnixMilli(), 10)
+
+	members, err := al.Redis.Connection.ZRevRangeByScore(al.Redis.Context, key, &goredis.ZRangeBy{
+		Min: windowStart,
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	attempts := make([]utils.JSON, 0, len(members))
+	for _, member := range members {
+		var record failedAttemptRecord
+		if err := json.Unmarshal([]byte(member), &record); err != nil {
+			continue
+		}
+		attempts = append(attempts, utils.JSON{
+			"attempted_at":       time.Unix(record.AttemptedAt, 0).Format(time.RFC3339),
+			"attempt_ip_address": record.IPAddress,
+			"attempt_type":       record.AttemptType,
+		})
+	}
+	return attempts, nil
+}
+
+// LockAccountRedis locks the account in Redis. ADMIN_UNLOCK locks are written without expiry and ignore lockDuration.
+func (al *DXMAccountLockout) LockAccountRedis(
+	userID int64,
+	userUID string,
+	userLoginID string,
+	organizationID int64,
+	organizationUID string,
+	failedCount int64,
+	reason string,
+	lockoutType string,
+	lockDuration time.Duration,
+	lockoutTier int64,
+) error {
 	key := al.redisKeyLockedForUser(userID)
 
 	now := time.Now()
-	unlockAt := now.Add(time.Duration(al.Config.LockoutDurationMinutes) * time.Minute)
+	unlockAt := now.Add(lockDuration)
+	autoUnlocks := lockoutTypeAutoUnlocks(lockoutType)
+	if !autoUnlocks {
+		lockDuration = 0
+	}
 
 	lockData := map[string]interface{}{
-		"locked":        "true",
-		"locked_at":     now.Unix(),
-		"unlock_at":     unlockAt.Unix(),
-		"reason":        reason,
-		"failed_count":  failedCount,
-		"lock_duration": al.Config.LockoutDurationMinutes * 60,
-	}
-
-	for field, value := range lockData {
-		if err := al.Redis.Connection.HSet(al.Redis.Context, key, field, fmt.Sprintf("%v", value)).Err(); err != nil {
-			log.Log.Errorf(err, "Redis error setting lock field %s for user %d", field, userID)
-			return err
-		}
+		"locked":           "true",
+		"locked_at":        now.Unix(),
+		"reason":           reason,
+		"failed_count":     failedCount,
+		"lock_duration":    int64(lockDuration.Seconds()),
+		"lockout_tier":     lockoutTier,
+		"lockout_type":     lockoutType,
+		"user_uid":         userUID,
+		"user_loginid":     userLoginID,
+		"organization_id":  organizationID,
+		"organization_uid": organizationUID,
+	}
+	if autoUnlocks {
+		lockData["unlock_at"] = unlockAt.Unix()
 	}
 
-	// Set TTL for auto-expiry
-	ttlSeconds := time.Duration(al.Config.LockoutDurationMinutes) * time.Minute
-	if err := al.Redis.Connection.Expire(al.Redis.Context, key, ttlSeconds).Err(); err != nil {
-		log.Log.Errorf(err, "Redis error setting lock expiry for user %d", userID)
+	// One transaction, so a concurrent CheckLockStatusRedis never sees a missing or half written record, and a
+	// re-lock that changes the lock type drops any leftover expiry data
+	ctx := al.Redis.Context
+	_, err := al.Redis.Connection.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+		pipe.Del(ctx, key)
+		pipe.HSet(ctx, key, lockData)
+		if autoUnlocks {
+			// Keep the record past unlock_at so the first status check after expiry can emit ACCOUNT_UNLOCKED_AUTO;
+			// the TTL only garbage-collects records of users that never come back
+			pipe.Expire(ctx, key, lockDuration+lockRecordRetention)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Log.Errorf(err, "Redis error writing lock for user %d", userID)
 		return err
 	}
 
-	log.Log.Infof("Account locked in Redis: user_id=%d, duration=%d min, unlock_at=%v",
-		userID, al.Config.LockoutDurationMinutes, unlockAt)
+	if !autoUnlocks {
+		log.Log.Infof("Account locked in Redis until admin unlock: user_id=%d, tier=%d", userID, lockoutTier)
+		return nil
+	}
+
+	log.Log.Infof("Account locked in Redis: user_id=%d, duration=%v, tier=%d, unlock_at=%v",
+		userID, lockDuration, lockoutTier, unlockAt)
 
 	return nil
 }
@@ -197,27 +359,32 @@ func (al *DXMAccountLockout) ResetCounterRedis(userID int64) error {
 	return nil
 }
 
-// GetFailedAttemptCountRedis gets current failed attempt count
-func (al *DXMAccountLockout) GetFailedAttemptCountRedis(userID int64) (int64, error) {
-	key := al.redisKeyCounterForUser(userID)
+// GetFailedAttemptCountRedis gets the number of failed attempts inside the sliding window
+func (al *DXMAccountLockout) GetFailedAttemptCountRedis(cfg *AccountLockoutConfig, userID int64) (int64, error) {
+	key := al.redisKeyAttemptsForUser(userID)
+	window := time.Duration(cfg.FailedAttemptWindowMinutes) * time.Minute
+	windowStart := strconv.FormatInt(time.Now().Add(-window).UnixMilli(), 10)
 
-	countStr, err := al.Redis.Connection.HGet(al.Redis.Context, key, "count").Result()
-	if err != nil {
-		// Key doesn't exist or error
-		if err.Error() == "redis: nil" {
-			return 0, nil
-		}
-		return 0, err
-	}
+	return al.Redis.Connection.ZCount(al.Redis.Context, key, windowStart, "+inf").Result()
+}
 
-	if countStr == "" {
-		return 0, nil
+// StartGracePeriodRedis starts a period during which failed attempts are not counted against the account
+func (al *DXMAccountLockout) StartGracePeriodRedis(cfg *AccountLockoutConfig, userID int64) error {
+	if cfg.GracePeriodMinutes <= 0 {
+		return nil
 	}
+	grace := time.Duration(cfg.GracePeriodMinutes) * time.Minute
+	return al.Redis.Connection.Set(al.Redis.Context, al.redisKeyGraceForUser(userID), time.Now().Unix(), grace).Err()
+}
 
-	count, err := strconv.ParseInt(countStr, 10, 64)
+// IsInGracePeriodRedis reports whether the account is inside a grace period
+func (al *DXMAccountLockout) IsInGracePeriodRedis(cfg *AccountLockoutConfig, userID int64) (bool, error) {
+	if cfg.GracePeriodMinutes <= 0 {
+		return false, nil
+	}
+	existsCount, err := al.Redis.Connection.Exists(al.Redis.Context, al.redisKeyGraceForUser(userID)).Result()
 	if err != nil {
-		return 0, err
+		return false, err
 	}
-
-	return count, nil
+	return existsCount > 0, nil
 }
@@ -0,0 +1,144 @@
+package account_lockout
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/donnyhardyanto/dxlib/log"
+)
+
+// asyncWriterShutdownTimeout bounds the final flush of a writer replaced by a reload
+const asyncWriterShutdownTimeout = 30 * time.Second
+
+// ReloadConfig re-reads the account_lockout configuration and swaps it in as a new snapshot.
+// An invalid configuration is rejected and the current one stays in effect.
+// Returns the list of changed settings as "name: old -> new".
+func (al *DXMAccountLockout) ReloadConfig() ([]string, error) {
+	al.reloadMutex.Lock()
+	defer al.reloadMutex.Unlock()
+
+	newConfig, err := al.readConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	oldConfig := al.config.Swap(newConfig)
+	if oldConfig == nil {
+		log.Log.Infof("Account Lockout configuration loaded: enabled=%v, max_attempts=%d, duration=%d min",
+			newConfig.Enabled, newConfig.MaxFailedAttempts, newConfig.LockoutDurationMinutes)
+		return nil, nil
+	}
+
+	changes := configDiff(oldConfig, newConfig)
+	if len(changes) == 0 {
+		return nil, nil
+	}
+	for _, change := range changes {
+		log.Log.Infof("Account Lockout configuration changed: %s", change)
+	}
+
+	al.applyConfigChange(oldConfig, newConfig)
+	return changes, nil
+}
+
+// applyConfigChange rebuilds the components whose parameters are fixed at construction
+func (al *DXMAccountLockout) applyConfigChange(oldConfig *AccountLockoutConfig, newConfig *AccountLockoutConfig) {
+	if oldConfig.CircuitBreakerEnabled != newConfig.CircuitBreakerEnabled ||
+		oldConfig.CircuitBreakerThreshold != newConfig.CircuitBreakerThreshold ||
+		oldConfig.CircuitBreakerTimeout != newConfig.CircuitBreakerTimeout ||
+		oldConfig.CircuitBreakerHalfOpenProbes != newConfig.CircuitBreakerHalfOpenProbes {
+		al.circuitBreaker.Store(al.newRedisCircuitBreaker(newConfig))
+	}
+
+	asyncWriterChanged := oldConfig.AsyncDBWrites != newConfig.AsyncDBWrites ||
+		oldConfig.AsyncQueueSize != newConfig.AsyncQueueSize ||
+		oldConfig.BatchSize != newConfig.BatchSize ||
+		oldConfig.FlushIntervalSeconds != newConfig.FlushIntervalSeconds
+	if asyncWriterChanged {
+		// Start the new writer first so producers never fall back to synchronous writes while the old one drains
+		var oldWriter *asyncWriter
+		if newConfig.AsyncDBWrites {
+			oldWriter = al.startAsyncWriter(newConfig)
+		} else {
+			oldWriter = al.asyncWriter.Swap(nil)
+		}
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), asyncWriterShutdownTimeout)
+			defer cancel()
+			if err := al.stopAsyncWriter(ctx, oldWriter); err != nil {
+				log.Log.Warnf("Replaced audit writer did not finish its final flush: %v", err)
+			}
+		}()
+	}
+
+	// Organization policies inherit empty columns from the global values
+	if oldConfig.MaxFailedAttempts != newConfig.MaxFailedAttempts ||
+		oldConfig.LockoutDurationMinutes != newConfig.LockoutDurationMinutes ||
+		oldConfig.LockoutType != newConfig.LockoutType {
+		al.InvalidateAllPolicyCache()
+	}
+
+	if oldConfig.ReloadIntervalSeconds != newConfig.ReloadIntervalSeconds {
+		// Called from the watcher itself, so restart it from a separate goroutine
+		go func() {
+			al.stopConfigWatcher()
+			if newConfig.ReloadIntervalSeconds > 0 {
+				al.startConfigWatcher(newConfig.ReloadIntervalSeconds)
+			}
+		}()
+	}
+}
+
+// configDiff lists the settings that differ between two snapshots
+func configDiff(oldConfig *AccountLockoutConfig, newConfig *AccountLockoutConfig) []string {
+	changes := []string{}
+	oldValue := reflect.ValueOf(*oldConfig)
+	newValue := reflect.ValueOf(*newConfig)
+	for i := 0; i < oldValue.NumField(); i++ {
+		o := oldValue.Field(i).Interface()
+		n := newValue.Field(i).Interface()
+		if o != n {
+			changes = append(changes, fmt.Sprintf("%s: %v -> %v", oldValue.Type().Field(i).Name, o, n))
+		}
+	}
+	return changes
+}
+
+// startConfigWatcher reloads the configuration every intervalSeconds until stopConfigWatcher is called
+func (al *DXMAccountLockout) startConfigWatcher(intervalSeconds int) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	al.reloadMutex.Lock()
+	if al.configWatcherCancel != nil {
+		al.configWatcherCancel()
+	}
+	al.configWatcherCancel = cancel
+	al.reloadMutex.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(time.Duration(intervalSeconds) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				_, err := al.ReloadConfig()
+				if err != nil {
+					log.Log.Warnf("Account Lockout configuration reload rejected, keeping current configuration: %v", err)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (al *DXMAccountLockout) stopConfigWatcher() {
+	al.reloadMutex.Lock()
+	defer al.reloadMutex.Unlock()
+	if al.configWatcherCancel != nil {
+		al.configWatcherCancel()
+		al.configWatcherCancel = nil
+	}
+}
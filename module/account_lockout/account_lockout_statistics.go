@@ -0,0 +1,383 @@
+package account_lockout
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/donnyhardyanto/dxlib/base"
+	"github.com/donnyhardyanto/dxlib/databases/db"
+	"github.com/donnyhardyanto/dxlib/log"
+	"github.com/donnyhardyanto/dxlib/utils"
+	goredis "github.com/go-redis/redis/v8"
+)
+
+const (
+	defaultStatisticsRetentionDays = 30
+	statisticsDateFormat           = "20060102"
+	statisticsDateLayoutAPI        = "2006-01-02"
+
+	StatisticsSourceRedis    = "redis"
+	StatisticsSourceDatabase = "database"
+)
+
+// Redis key patterns (per day, TTL = retention):
+// pgn:partner:lockout:stats:daily:{YYYYMMDD}              -> hash of counters (see statisticsCounterField)
+// pgn:partner:lockout:stats:daily:{YYYYMMDD}:locked_users -> sorted set "{user_id}|{loginid}" -> account locks
+// pgn:partner:lockout:stats:daily:{YYYYMMDD}:locked_ips   -> sorted set "{ip}" -> source blocks
+
+// statisticsCounterField maps an event type to its daily counter field
+var statisticsCounterField = map[string]string{
+	EventTypeFailedAttempt:              "failed_attempts",
+	EventTypeFailedAttemptUnknownUser:   "failed_attempts_unknown_user",
+	EventTypeAccountLocked:              "account_locks",
+	EventTypeAccountUnlockedAuto:        "unlocks_auto",
+	EventTypeAccountUnlockedAdmin:       "unlocks_admin",
+	EventTypeIPBlocked:                  "source_blocks",
+	EventTypeFingerprintBlocked:         "source_blocks",
+	EventTypeCredentialStuffingDetected: "credential_stuffing",
+	EventTypeIPUnblockedAdmin:           "source_unblocks_admin",
+	EventTypeSuccessfulLogin:            "successful_logins",
+}
+
+var statisticsSourceBlockEventTypes = []string{EventTypeIPBlocked, EventTypeFingerprintBlocked, EventTypeCredentialStuffingDetected}
+
+// recordDailyStatistics increments the counters of the event's day; failures only log, statistics never block a login
+func (al *DXMAccountLockout) recordDailyStatistics(cfg *AccountLockoutConfig, event *LockoutEvent) {
+	if !cfg.StatisticsEnabled {
+		return
+	}
+	field, ok := statisticsCounterField[event.EventType]
+	if !ok {
+		return
+	}
+
+	date := time.Now().Format(statisticsDateFormat)
+	key := al.redisKeyStatsDaily(date)
+	retention := time.Duration(cfg.StatisticsRetentionDays+1) * 24 * time.Hour
+	ctx := al.Redis.Context
+
+	_, err := al.Redis.Connection.Pipelined(ctx, func(pipe goredis.Pipeliner) error {
+		pipe.HIncrBy(ctx, key, field, 1)
+		if event.AttemptType != "" && (event.EventType == EventTypeFailedAttempt || event.EventType == EventTypeFailedAttemptUnknownUser) {
+			pipe.HIncrBy(ctx, key, "attempt_type:"+event.AttemptType, 1)
+		}
+		if event.OrganizationID != 0 {
+			pipe.HIncrBy(ctx, key, fmt.Sprintf("org:%d:%s", event.OrganizationID, field), 1)
+		}
+		pipe.Expire(ctx, key, retention)
+
+		if event.EventType == EventTypeAccountLocked {
+			usersKey := key + ":locked_users"
+			pipe.ZIncrBy(ctx, usersKey, 1, fmt.Sprintf("%d|%s", event.UserID, event.UserLoginID))
+			pipe.Expire(ctx, usersKey, retention)
+		}
+		if contains(statisticsSourceBlockEventTypes, event.EventType) && event.AttemptIPAddress != "" {
+			ipsKey := key + ":locked_ips"
+			pipe.ZIncrBy(ctx, ipsKey, 1, event.AttemptIPAddress)
+			pipe.Expire(ctx, ipsKey, retention)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Log.Warnf("Failed to record lockout statistics for %s: %v", event.EventType, err)
+	}
+}
+
+// statisticsRedisCutoff is the first day still held in Redis; older days are read from account_lockout_events
+func (al *DXMAccountLockout) statisticsRedisCutoff() time.Time {
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	return today.AddDate(0, 0, -al.GetConfig().StatisticsRetentionDays)
+}
+
+// GetStatisticsTimeSeries returns one entry per day between startDate and endDate (inclusive)
+func (al *DXMAccountLockout) GetStatisticsTimeSeries(ctx context.Context, startDate time.Time, endDate time.Time) ([]utils.JSON, error) {
+	cutoff := al.statisticsRedisCutoff()
+
+	var databaseDays map[string]utils.JSON
+	if startDate.Before(cutoff) {
+		databaseEnd := endDate.AddDate(0, 0, 1)
+		if databaseEnd.After(cutoff) {
+			databaseEnd = cutoff
+		}
+		var err error
+		databaseDays, err = al.statisticsTimeSeriesFromDatabase(ctx, startDate, databaseEnd)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	days := []utils.JSON{}
+	for day := startDate; !day.After(endDate); day = day.AddDate(0, 0, 1) {
+		dateAPI := day.Format(statisticsDateLayoutAPI)
+		if day.Before(cutoff) {
+			entry, ok := databaseDays[dateAPI]
+			if !ok {
+				entry = newStatisticsDay(dateAPI, StatisticsSourceDatabase)
+			}
+			days = append(days, entry)
+			continue
+		}
+
+		entry, err := al.statisticsDayFromRedis(day)
+		if err != nil {
+			return nil, err
+		}
+		days = append(days, entry)
+	}
+	return days, nil
+}
+
+func newStatisticsDay(date string, source string) utils.JSON {
+	entry := utils.JSON{
+		"date":            date,
+		"source":          source,
+		"by_attempt_type": utils.JSON{},
+		"by_organization": utils.JSON{},
+	}
+	for _, field := range statisticsCounterField {
+		entry[field] = int64(0)
+	}
+	return entry
+}
+
+func (al *DXMAccountLockout) statisticsDayFromRedis(day time.Time) (utils.JSON, error) {
+	entry := newStatisticsDay(day.Format(statisticsDateLayoutAPI), StatisticsSourceRedis)
+
+	values, err := al.Redis.Connection.HGetAll(al.Redis.Context, al.redisKeyStatsDaily(day.Format(statisticsDateFormat))).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	byAttemptType := entry["by_attempt_type"].(utils.JSON)
+	byOrganization := entry["by_organization"].(utils.JSON)
+	for field, valueAsString := range values {
+		value, err := strconv.ParseInt(valueAsString, 10, 64)
+		if err != nil {
+			continue
+		}
+		switch {
+		case strings.HasPrefix(field, "attempt_type:"):
+			byAttemptType[strings.TrimPrefix(field, "attempt_type:")] = value
+		case strings.HasPrefix(field, "org:"):
+			// org:{organization_id}:{counter}
+			parts := strings.SplitN(field, ":", 3)
+			if len(parts) != 3 {
+				continue
+			}
+			organization, ok := byOrganization[parts[1]].(utils.JSON)
+			if !ok {
+				organization = utils.JSON{}
+				byOrganization[parts[1]] = organization
+			}
+			organization[parts[2]] = value
+		default:
+			entry[field] = value
+		}
+	}
+	return entry, nil
+}
+
+// statisticsTimeSeriesFromDatabase aggregates account_lockout_events per day for [startDate, endDate)
+func (al *DXMAccountLockout) statisticsTimeSeriesFromDatabase(ctx context.Context, startDate time.Time, endDate time.Time) (map[string]utils.JSON, error) {
+	rows, err := al.statisticsQuery(ctx, statisticsQueryTimeSeries, startDate, endDate)
+	if err != nil {
+		return nil, err
+	}
+
+	days := map[string]utils.JSON{}
+	for _, row := range rows {
+		eventDate := statisticsRowDate(row["event_date"])
+		eventType, _ := row["event_type"].(string)
+		attemptType, _ := row["attempt_type"].(string)
+		// Oracle has no empty string, NVL pads missing attempt types with a blank
+		attemptType = strings.TrimSpace(attemptType)
+		organizationID := statisticsRowInt64(row["organization_id"])
+		total := statisticsRowInt64(row["total"])
+
+		field, ok := statisticsCounterField[eventType]
+		if !ok {
+			continue
+		}
+		entry, ok := days[eventDate]
+		if !ok {
+			entry = newStatisticsDay(eventDate, StatisticsSourceDatabase)
+			days[eventDate] = entry
+		}
+
+		entry[field] = entry[field].(int64) + total
+		if attemptType != "" && (eventType == EventTypeFailedAttempt || eventType == EventTypeFailedAttemptUnknownUser) {
+			byAttemptType := entry["by_attempt_type"].(utils.JSON)
+			current, _ := byAttemptType[attemptType].(int64)
+			byAttemptType[attemptType] = current + total
+		}
+		if organizationID != 0 {
+			byOrganization := entry["by_organization"].(utils.JSON)
+			organizationKey := strconv.FormatInt(organizationID, 10)
+			organization, ok := byOrganization[organizationKey].(utils.JSON)
+			if !ok {
+				organization = utils.JSON{}
+				byOrganization[organizationKey] = organization
+			}
+			current, _ := organization[field].(int64)
+			organization[field] = current + total
+		}
+	}
+	return days, nil
+}
+
+// GetStatisticsTopLocked returns the top-N most locked users (kind "users") or blocked source IPs (kind "ips")
+func (al *DXMAccountLockout) GetStatisticsTopLocked(ctx context.Context, kind string, startDate time.Time, endDate time.Time, limit int) ([]utils.JSON, error) {
+	cutoff := al.statisticsRedisCutoff()
+	totals := map[string]int64{}
+
+	if startDate.Before(cutoff) {
+		databaseEnd := endDate.AddDate(0, 0, 1)
+		if databaseEnd.After(cutoff) {
+			databaseEnd = cutoff
+		}
+		queryKind := statisticsQueryTopUsers
+		if kind == "ips" {
+			queryKind = statisticsQueryTopIPs
+		}
+		rows, err := al.statisticsQuery(ctx, queryKind, startDate, databaseEnd)
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			member := ""
+			if kind == "ips" {
+				member, _ = row["attempt_ip_address"].(string)
+			} else {
+				userLoginID, _ := row["user_loginid"].(string)
+				member = fmt.Sprintf("%d|%s", statisticsRowInt64(row["user_id"]), userLoginID)
+			}
+			totals[member] += statisticsRowInt64(row["total"])
+		}
+	}
+
+	suffix := ":locked_users"
+	if kind == "ips" {
+		suffix = ":locked_ips"
+	}
+	first := startDate
+	if first.Before(cutoff) {
+		first = cutoff
+	}
+	for day := first; !day.After(endDate); day = day.AddDate(0, 0, 1) {
+		key := al.redisKeyStatsDaily(day.Format(statisticsDateFormat)) + suffix
+		members, err := al.Redis.Connection.ZRangeWithScores(al.Redis.Context, key, 0, -1).Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, z := range members {
+			member, _ := z.Member.(string)
+			totals[member] += int64(z.Score)
+		}
+	}
+
+	result := make([]utils.JSON, 0, len(totals))
+	for member, total := range totals {
+		if kind == "ips" {
+			result = append(result, utils.JSON{"ip": member, "count": total})
+			continue
+		}
+		parts := strings.SplitN(member, "|", 2)
+		userID, _ := strconv.ParseInt(parts[0], 10, 64)
+		userLoginID := ""
+		if len(parts) == 2 {
+			userLoginID = parts[1]
+		}
+		result = append(result, utils.JSON{"user_id": userID, "user_loginid": userLoginID, "count": total})
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i]["count"].(int64) > result[j]["count"].(int64)
+	})
+	if limit > 0 && len(result) > limit {
+		result = result[:limit]
+	}
+	return result, nil
+}
+
+const (
+	statisticsQueryTimeSeries = iota
+	statisticsQueryTopUsers
+	statisticsQueryTopIPs
+)
+
+// statisticsQuery runs one of the per-database aggregation queries over [startDate, endDate)
+func (al *DXMAccountLockout) statisticsQuery(ctx context.Context, queryKind int, startDate time.Time, endDate time.Time) ([]utils.JSON, error) {
+	err := al.AccountLockoutEvents.EnsureDatabase()
+	if err != nil {
+		return nil, fmt.Errorf("failed to ensure databases connection: %w", err)
+	}
+
+	var queries [3]string
+	switch al.AccountLockoutEvents.Database.DatabaseType {
+	case base.DXDatabaseTypePostgreSQL:
+		queries = al.getPostgreSQLStatisticsQueries()
+	case base.DXDatabaseTypeMariaDB:
+		queries = al.getMariaDBStatisticsQueries()
+	case base.DXDatabaseTypeOracle:
+		queries = al.getOracleStatisticsQueries()
+	case base.DXDatabaseTypeSQLServer:
+		queries = al.getSQLServerStatisticsQueries()
+	default:
+		return nil, fmt.Errorf("unsupported databases type: %s", al.AccountLockoutEvents.Database.DatabaseType)
+	}
+
+	args := []interface{}{startDate, endDate}
+	if queryKind == statisticsQueryTopIPs {
+		args = append(args, EventTypeIPBlocked, EventTypeFingerprintBlocked, EventTypeCredentialStuffingDetected)
+	}
+	if queryKind == statisticsQueryTopUsers {
+		args = append(args, EventTypeAccountLocked)
+	}
+
+	_, rows, err := db.RawQueryRows(ctx, al.AccountLockoutEvents.Database.Connection, nil, queries[queryKind], args)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query lockout statistics: %w", err)
+	}
+	return rows, nil
+}
+
+func statisticsRowInt64(v any) int64 {
+	switch n := v.(type) {
+	case int64:
+		return n
+	case int32:
+		return int64(n)
+	case int:
+		return int64(n)
+	case float64:
+		return int64(n)
+	case string:
+		i, _ := strconv.ParseInt(n, 10, 64)
+		return i
+	case []byte:
+		i, _ := strconv.ParseInt(string(n), 10, 64)
+		return i
+	default:
+		return 0
+	}
+}
+
+func statisticsRowDate(v any) string {
+	switch d := v.(type) {
+	case time.Time:
+		return d.Format(statisticsDateLayoutAPI)
+	case string:
+		if len(d) >= 10 {
+			return d[:10]
+		}
+		return d
+	case []byte:
+		return statisticsRowDate(string(d))
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
@@ -0,0 +1,27 @@
+package account_lockout
+
+// getPostgreSQLStatisticsQueries returns the time series, top users and top IPs aggregation queries
+func (al *DXMAccountLockout) getPostgreSQLStatisticsQueries() [3]string {
+	return [3]string{
+		`
+        SELECT CAST(event_timestamp AS DATE) AS event_date, event_type,
+               COALESCE(attempt_type, '') AS attempt_type, COALESCE(organization_id, 0) AS organization_id,
+               COUNT(*) AS total
+        FROM account_lockout.account_lockout_events
+        WHERE event_timestamp >= $1 AND event_timestamp < $2
+        GROUP BY CAST(event_timestamp AS DATE), event_type, COALESCE(attempt_type, ''), COALESCE(organization_id, 0)
+    `,
+		`
+        SELECT user_id, user_loginid, COUNT(*) AS total
+        FROM account_lockout.account_lockout_events
+        WHERE event_timestamp >= $1 AND event_timestamp < $2 AND event_type = $3
+        GROUP BY user_id, user_loginid
+    `,
+		`
+        SELECT attempt_ip_address, COUNT(*) AS total
+        FROM account_lockout.account_lockout_events
+        WHERE event_timestamp >= $1 AND event_timestamp < $2 AND event_type IN ($3, $4, $5)
+        GROUP BY attempt_ip_address
+    `,
+	}
+}
@@ -3,7 +3,6 @@ package self
 import (
 	"context"
 	"database/sql"
-	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
@@ -14,6 +13,8 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/donnyhardyanto/dxlib"
@@ -36,6 +37,7 @@ import (
 	"github.com/donnyhardyanto/dxlib/utils/lv"
 	"github.com/donnyhardyanto/dxlib_module/base"
 	"github.com/donnyhardyanto/dxlib_module/lib"
+	"github.com/donnyhardyanto/dxlib_module/module/account_lockout"
 	"github.com/donnyhardyanto/dxlib_module/module/user_management"
 	"github.com/google/uuid"
 	"golang.org/x/crypto/ed25519"
@@ -60,6 +62,12 @@ type DxmSelf struct {
 	OnInitialize                          func(s *DxmSelf) (err error)
 	OnAuthenticateUser                    func(aepr *api.DXAPIEndPointRequest, loginId string, password string, organizationUid string) (isSuccess bool, user utils.JSON, organization utils.JSON, err error)
 	OnCreateSessionObject                 func(aepr *api.DXAPIEndPointRequest, user utils.JSON, organization utils.JSON, originalSessionObject utils.JSON) (newSessionObject utils.JSON, err error)
+	OnPasswordResetDeliver                func(ctx context.Context, channel string, user utils.JSON, subject string, contentType string, body string) (err error)
+	AccountLockout                        *account_lockout.DXMAccountLockout
+
+	// JWT access tokens, see self_jwt.go
+	jwtKeySet      atomic.Pointer[lib.JWTKeySet]
+	jwtKeySetMutex sync.Mutex
 }
 
 func (s *DxmSelf) Init(databaseNameId string) {
@@ -544,1186 +552,180 @@ func (s *DxmSelf) SelfConfiguration(aepr *api.DXAPIEndPointRequest) (err error)
 }
 
 func (s *DxmSelf) SelfLogin(aepr *api.DXAPIEndPointRequest) (err error) {
-	_, preKeyIndex, err := aepr.GetParameterValueAsString("i")
-	if err != nil {
-		return err
-	}
-	_, dataAsHexString, err := aepr.GetParameterValueAsString("d")
-	if err != nil {
-		return err
-	}
-
-	if api.OnE2EEPrekeyUnPack == nil {
-		return aepr.WriteResponseAndNewErrorf(http.StatusUnprocessableEntity, "NOT_IMPLEMENTED", "NOT_IMPLEMENTED:OnE2EEPrekeyUnPack_IS_NIL:%v", aepr.EndPoint.EndPointType)
-	}
-
-	lvPayloadElements, sharedKey2AsBytes, edB0PrivateKeyAsBytes, _, err := api.OnE2EEPrekeyUnPack(aepr, preKeyIndex, dataAsHexString)
-	if err != nil {
-		return aepr.WriteResponseAndNewErrorf(http.StatusUnprocessableEntity, "INVALID_PREKEY", "NOT_ERROR:UNPACK_ERROR:%v", err.Error())
-	}
-
-	lvPayloadLoginId := lvPayloadElements[0]
-	lvPayloadPassword := lvPayloadElements[1]
-
-	organizationUId := ""
-	userLoginId := string(lvPayloadLoginId.Value)
-	userPassword := string(lvPayloadPassword.Value)
-	if len(lvPayloadElements) > 2 {
-		lvPayloadOrganizationUId := lvPayloadElements[2]
-		organizationUId = string(lvPayloadOrganizationUId.Value)
-	}
-
-	var user utils.JSON
-	var userOrganizationMemberships []utils.JSON
-	var userLoggedOrganizationId int64
-	var userLoggedOrganizationUid string
-	var userLoggedOrganization utils.JSON
-	var verificationResult bool
-	if s.OnAuthenticateUser != nil {
-		verificationResult, user, userLoggedOrganization, err = s.OnAuthenticateUser(aepr, userLoginId, userPassword, organizationUId)
-		if err != nil {
-			return err
-		}
-		if !verificationResult {
-			return aepr.WriteResponseAndNewErrorf(http.StatusUnauthorized, base.MsgInvalidCredential, base.LogMsgNotErrorInvalidCredential)
-		}
-
-		userId, ok := user["id"].(int64)
-		if !ok {
-			return aepr.WriteResponseAndNewErrorf(http.StatusUnauthorized, "INTERNAL_ERROR", "SHOULD_NOT_HAPPEN:USER_ID_NOT_INT64")
-		}
-
-		us := utils.JSON{
-			"user_id": userId,
-		}
-
-		if organizationUId != "" {
-			us["organization_uid"] = organizationUId
-		}
-
-		_, userOrganizationMemberships, err = user_management.ModuleUserManagement.UserOrganizationMembership.Select(aepr.Context, &aepr.Log, nil, us, nil,
-			db.DXDatabaseTableFieldsOrderBy{"order_index": "asc"}, nil, nil)
-		if err != nil {
-			return err
-		}
-
-		if len(userOrganizationMemberships) == 0 {
-			return aepr.WriteResponseAndNewErrorf(http.StatusUnauthorized, base.MsgInvalidCredential, base.LogMsgNotErrorInvalidCredential)
-		}
-
-		userLoggedOrganizationId, ok = userLoggedOrganization["id"].(int64)
-		if !ok {
-			return aepr.WriteResponseAndNewErrorf(http.StatusUnauthorized, "INTERNAL_ERROR", "SHOULD_NOT_HAPPEN:ORGANIZATION_ID_NOT_INT64")
-		}
-		userLoggedOrganizationUid, ok = userLoggedOrganization["uid"].(string)
-		if !ok {
-			return aepr.WriteResponseAndNewErrorf(http.StatusUnauthorized, "INTERNAL_ERROR", "SHOULD_NOT_HAPPEN:ORGANIZATION_UID_NOT_STRING")
-		}
-	} else {
-		_, user, err := user_management.ModuleUserManagement.User.SelectOne(aepr.Context, &aepr.Log, nil, utils.JSON{
-			"loginid": userLoginId,
-		}, nil, nil)
-		if err != nil {
-			return err
-		}
-		if user == nil {
-			return aepr.WriteResponseAndNewErrorf(http.StatusUnauthorized, base.MsgInvalidCredential, base.LogMsgNotErrorInvalidCredential)
-		}
-
-		userId, ok := user["id"].(int64)
-		if !ok {
-			return aepr.WriteResponseAndNewErrorf(http.StatusUnauthorized, "INTERNAL_ERROR", "SHOULD_NOT_HAPPEN:USER_ID_NOT_INT64")
-		}
-
-		us := utils.JSON{
-			"user_id": userId,
-		}
-
-		if organizationUId != "" {
-			us["organization_uid"] = organizationUId
-		}
-
-		_, userOrganizationMemberships, err = user_management.ModuleUserManagement.UserOrganizationMembership.Select(aepr.Context, &aepr.Log, nil, us, nil,
-			db.DXDatabaseTableFieldsOrderBy{"order_index": "asc"}, nil, nil)
-		if err != nil {
-			return err
-		}
-
-		if len(userOrganizationMemberships) == 0 {
-			return aepr.WriteResponseAndNewErrorf(http.StatusUnauthorized, base.MsgInvalidCredential, base.LogMsgNotErrorInvalidCredential)
-		}
-
-		userLoggedOrganizationId, ok = userOrganizationMemberships[0]["organization_id"].(int64)
-		if !ok {
-			return aepr.WriteResponseAndNewErrorf(http.StatusUnauthorized, "INTERNAL_ERROR", "SHOULD_NOT_HAPPEN:ORGANIZATION_ID_NOT_INT64")
-		}
-		userLoggedOrganizationUid, ok = userOrganizationMemberships[0]["organization_uid"].(string)
-		if !ok {
-			return aepr.WriteResponseAndNewErrorf(http.StatusUnauthorized, "INTERNAL_ERROR", "SHOULD_NOT_HAPPEN:ORGANIZATION_UID_NOT_STRING")
-		}
-
-		_, userLoggedOrganization, err = user_management.ModuleUserManagement.Organization.ShouldGetById(aepr.Context, &aepr.Log, userLoggedOrganizationId)
-		if err != nil {
-			return err
-		}
-
-		verificationResult, err = user_management.ModuleUserManagement.UserPasswordVerify(aepr.Context, &aepr.Log, userId, userPassword)
-		if err != nil {
-			return err
-		}
-
-		if !verificationResult {
-			return aepr.WriteResponseAndNewErrorf(http.StatusUnauthorized, base.MsgInvalidCredential, base.LogMsgNotErrorInvalidCredential)
-		}
-	}
-
-	sessionKey, err := GenerateSessionKey()
-	if err != nil {
-		return err
-	}
-
-	userId, ok := user["id"].(int64)
-	if !ok {
-		return aepr.WriteResponseAndNewErrorf(500, "INTERNAL_ERROR", "SHOULD_NOT_HAPPEN:USER_ID_NOT_FOUND_IN_USER")
-	}
-	a := []any{userOrganizationMemberships}
-	sessionObject, allowed, err2 := s.RegenerateSessionObject(aepr, userId, sessionKey, user, userLoggedOrganizationId, userLoggedOrganizationUid, userLoggedOrganization, a)
-	if err2 != nil {
-		return aepr.WriteResponseAndNewErrorf(http.StatusUnauthorized, "SESSION_KEY_EXPIRED", "NOT_ERROR:SESSION_KEY_EXPIRED_%s", err2.Error())
-	}
-
-	if !allowed {
-		return aepr.WriteResponseAndNewErrorf(http.StatusForbidden, "USER_ROLE_PRIVILEGE_FORBIDDEN", "NOT_ERROR:USER_ROLE_PRIVILEGE_FORBIDDEN")
-	}
-
-	// Block login during maintenance mode for users without GLOBAL.SET_MAINTENANCE_MODE privilege
-	if s.SystemModeIsMaintenance(aepr.Context) {
-		userEffectivePrivilegeIds, ok := sessionObject["user_effective_privilege_ids"].(map[string]int64)
-		if ok {
-			_, hasMaintenancePrivilege := userEffectivePrivilegeIds[base.PrivilegeNameIdSetMaintenance]
-			if !hasMaintenancePrivilege {
-				aepr.WriteResponseAsErrorMessageNotLogged(http.StatusServiceUnavailable, "SYSTEM_UNDER_MAINTENANCE", ErrorSystemUnderMaintenance)
-				return nil
-			}
-		} else {
-			aepr.WriteResponseAsErrorMessageNotLogged(http.StatusServiceUnavailable, "SYSTEM_UNDER_MAINTENANCE", ErrorSystemUnderMaintenance)
-			return nil
-		}
-	}
-
-	configSystem := *configuration.Manager.Configurations["system"].Data
-	configSystemSession, ok := configSystem["sessions"].(utils.JSON)
-	if !ok {
-		return errors.New(ErrorConfigSystemSessionsNotFound)
-	}
-	sessionKeyTTLAsInt, ok := configSystemSession["session_ttl_in_seconds"].(int)
-	if !ok {
-		return errors.New("SHOULD_NOT_HAPPEN:SESSIONS_TTL_SECOND_NOT_FOUND_OR_NOT_INT")
-	}
-
-	sessionKeyTTLAsDuration := time.Duration(sessionKeyTTLAsInt) * time.Second
-
-	err = user_management.ModuleUserManagement.SessionRedis.Set(aepr.Context, sessionKey, sessionObject, sessionKeyTTLAsDuration)
-	if err != nil {
-		return err
-	}
-
-	sessionObjectJSON, err := json.Marshal(sessionObject)
-	if err != nil {
-		return err
-	}
-
-	sessionObjectJSONString := string(sessionObjectJSON)
-
-	lvSessionObject, err := lv.NewLV([]byte(sessionObjectJSONString))
-	if err != nil {
-		return err
-	}
-
-	if api.OnE2EEPrekeyPack == nil {
-		return aepr.WriteResponseAndNewErrorf(http.StatusUnprocessableEntity, "NOT_IMPLEMENTED", "NOT_IMPLEMENTED:OnE2EEPrekeyPack_IS_NIL:%v", aepr.EndPoint.EndPointType)
-	}
-
-	dataBlockEnvelopeAsHexString, err := api.OnE2EEPrekeyPack(aepr, preKeyIndex, edB0PrivateKeyAsBytes, sharedKey2AsBytes, lvSessionObject)
-	if err != nil {
-		return err
-	}
-
-	aepr.WriteResponseAsJSON(http.StatusOK, nil, utils.JSON{
-		"d": dataBlockEnvelopeAsHexString,
+	return s.LoginPipelineRun(aepr, LoginPipeline{
+		Credentials: s.LoginCredentialsE2EE,
 	})
-	return err
 }
 
 func (s *DxmSelf) SelfLoginV2(aepr *api.DXAPIEndPointRequest) (err error) {
-	_, userLoginId, err := aepr.GetParameterValueAsString("user_login_id")
-	if err != nil {
-		return err
-	}
-	_, userPassword, err := aepr.GetParameterValueAsString("user_login_password")
-	if err != nil {
-		return err
-	}
-	_, organizationUId, err := aepr.GetParameterValueAsString("organization_uid", "")
-	if err != nil {
-		return err
-	}
-
-	var user utils.JSON
-	var userOrganizationMemberships []utils.JSON
-	var userLoggedOrganizationId int64
-	var userLoggedOrganizationUid string
-	var userLoggedOrganization utils.JSON
-	var verificationResult bool
-	if s.OnAuthenticateUser != nil {
-		verificationResult, user, userLoggedOrganization, err = s.OnAuthenticateUser(aepr, userLoginId, userPassword, organizationUId)
-		if err != nil {
-			return err
-		}
-		if !verificationResult {
-			return aepr.WriteResponseAndNewErrorf(http.StatusUnauthorized, base.MsgInvalidCredential, base.LogMsgNotErrorInvalidCredential)
-		}
-
-		userId, err := utils.GetInt64FromKV(user, "id")
-		if err != nil {
-			return err
-		}
-
-		us := utils.JSON{
-			"user_id": userId,
-		}
-
-		if organizationUId != "" {
-			us["organization_uid"] = organizationUId
-		}
-
-		_, userOrganizationMemberships, err = user_management.ModuleUserManagement.UserOrganizationMembership.Select(aepr.Context, &aepr.Log, nil, us, nil,
-			db.DXDatabaseTableFieldsOrderBy{"order_index": "asc"}, nil, nil)
-		if err != nil {
-			return err
-		}
-
-		if len(userOrganizationMemberships) == 0 {
-			return aepr.WriteResponseAndNewErrorf(http.StatusUnauthorized, base.MsgInvalidCredential, base.LogMsgNotErrorInvalidCredential)
-		}
-
-		userLoggedOrganizationId, err = utils.GetInt64FromKV(userLoggedOrganization, "id")
-		if err != nil {
-			return err
-		}
-		userLoggedOrganizationUid, err = utils.GetStringFromKV(userLoggedOrganization, "uid")
-		if err != nil {
-			return err
-		}
-	} else {
-		_, user, err := user_management.ModuleUserManagement.User.SelectOne(aepr.Context, &aepr.Log, nil, utils.JSON{
-			"loginid": userLoginId,
-		}, nil, nil)
-		if err != nil {
-			return err
-		}
-		if user == nil {
-			return aepr.WriteResponseAndNewErrorf(http.StatusUnauthorized, base.MsgInvalidCredential, base.LogMsgNotErrorInvalidCredential)
-		}
-
-		userId, err := utils.GetInt64FromKV(user, "id")
-		if err != nil {
-			return err
-		}
-
-		us := utils.JSON{
-			"user_id": userId,
-		}
-
-		if organizationUId != "" {
-			us["organization_uid"] = organizationUId
-		}
-
-		_, userOrganizationMemberships, err = user_management.ModuleUserManagement.UserOrganizationMembership.Select(aepr.Context, &aepr.Log, nil, us, nil,
-			db.DXDatabaseTableFieldsOrderBy{"order_index": "asc"}, nil, nil)
-		if err != nil {
-			return err
-		}
-
-		if len(userOrganizationMemberships) == 0 {
-			return aepr.WriteResponseAndNewErrorf(http.StatusUnauthorized, base.MsgInvalidCredential, base.LogMsgNotErrorInvalidCredential)
-		}
-
-		userLoggedOrganizationId, err = utils.GetInt64FromKV(userOrganizationMemberships[0], "organization_id")
-		if err != nil {
-			return err
-		}
-		userLoggedOrganizationUid, err = utils.GetStringFromKV(userOrganizationMemberships[0], "organization_uid")
-		if err != nil {
-			return err
-		}
-
-		_, userLoggedOrganization, err = user_management.ModuleUserManagement.Organization.ShouldGetById(aepr.Context, &aepr.Log, userLoggedOrganizationId)
-		if err != nil {
-			return err
-		}
-
-		verificationResult, err = user_management.ModuleUserManagement.UserPasswordVerify(aepr.Context, &aepr.Log, userId, userPassword)
-		if err != nil {
-			return err
-		}
-
-		if !verificationResult {
-			return aepr.WriteResponseAndNewErrorf(http.StatusUnauthorized, base.MsgInvalidCredential, base.LogMsgNotErrorInvalidCredential)
-		}
-	}
-
-	sessionKey, err := GenerateSessionKey()
-	if err != nil {
-		return err
-	}
-
-	userId, ok := user["id"].(int64)
-	if !ok {
-		return aepr.WriteResponseAndNewErrorf(500, "INTERNAL_ERROR", "SHOULD_NOT_HAPPEN:USER_ID_NOT_FOUND_IN_USER")
-	}
-	a := []any{userOrganizationMemberships}
-	sessionObject, allowed, err2 := s.RegenerateSessionObject(aepr, userId, sessionKey, user, userLoggedOrganizationId, userLoggedOrganizationUid, userLoggedOrganization, a)
-	if err2 != nil {
-		return aepr.WriteResponseAndNewErrorf(http.StatusUnauthorized, "SESSION_KEY_EXPIRED", "NOT_ERROR:SESSION_KEY_EXPIRED_%s", err2.Error())
-	}
-
-	if !allowed {
-		return aepr.WriteResponseAndNewErrorf(http.StatusForbidden, "USER_ROLE_PRIVILEGE_FORBIDDEN", "NOT_ERROR:USER_ROLE_PRIVILEGE_FORBIDDEN")
-	}
-
-	// Block login during maintenance mode for users without GLOBAL.SET_MAINTENANCE_MODE privilege
-	if s.SystemModeIsMaintenance(aepr.Context) {
-		userEffectivePrivilegeIds, ok := sessionObject["user_effective_privilege_ids"].(map[string]int64)
-		if ok {
-			_, hasMaintenancePrivilege := userEffectivePrivilegeIds[base.PrivilegeNameIdSetMaintenance]
-			if !hasMaintenancePrivilege {
-				aepr.WriteResponseAsErrorMessageNotLogged(http.StatusServiceUnavailable, "SYSTEM_UNDER_MAINTENANCE", ErrorSystemUnderMaintenance)
-				return nil
-			}
-		} else {
-			aepr.WriteResponseAsErrorMessageNotLogged(http.StatusServiceUnavailable, "SYSTEM_UNDER_MAINTENANCE", ErrorSystemUnderMaintenance)
-			return nil
-		}
-	}
-
-	configSystem := *configuration.Manager.Configurations["system"].Data
-	configSystemSession, ok := configSystem["sessions"].(utils.JSON)
-	if !ok {
-		return errors.New(ErrorConfigSystemSessionsNotFound)
-	}
-	sessionKeyTTLAsInt, ok := configSystemSession["session_ttl_in_seconds"].(int)
-	if !ok {
-		return errors.New("SHOULD_NOT_HAPPEN:SESSIONS_TTL_SECOND_NOT_FOUND_OR_NOT_INT")
-	}
-
-	sessionKeyTTLAsDuration := time.Duration(sessionKeyTTLAsInt) * time.Second
-
-	err = user_management.ModuleUserManagement.SessionRedis.Set(aepr.Context, sessionKey, sessionObject, sessionKeyTTLAsDuration)
-	if err != nil {
-		return err
-	}
-
-	aepr.WriteResponseAsJSON(http.StatusOK, nil, utils.JSON{
-		"session_object": sessionObject,
+	return s.LoginPipelineRun(aepr, LoginPipeline{
+		Credentials: s.LoginCredentialsPlain,
 	})
-	return err
-}
-
-func (s *DxmSelf) SelfLoginCaptchaV3(aepr *api.DXAPIEndPointRequest) (err error) {
-	_, userLoginId, err := aepr.GetParameterValueAsString("user_login_id")
-	if err != nil {
-		return err
-	}
-	_, userPassword, err := aepr.GetParameterValueAsString("user_login_password")
-	if err != nil {
-		return err
-	}
-	_, organizationUId, err := aepr.GetParameterValueAsString("organization_uid", "")
-	if err != nil {
-		return err
-	}
-	_, captchaId, err := aepr.GetParameterValueAsString("captcha_id", "")
-	if err != nil {
-		return err
-	}
-	_, captchaText, err := aepr.GetParameterValueAsString("captcha_text", "")
-	if err != nil {
-		return err
-	}
-
-	preKeyData := aepr.EncryptionParameters["PRE_KEY_DATA"].(utils.JSON)
-
-	storedCaptchaId, err := utils.GetStringFromKV(preKeyData, "captcha_id")
-	if err != nil {
-		return err
-	}
-	storedCaptchaText, err := utils.GetStringFromKV(preKeyData, "captcha_text")
-	if err != nil {
-		return err
-	}
-
-	if captchaId != storedCaptchaId {
-		aepr.WriteResponseAsErrorMessageNotLogged(http.StatusUnprocessableEntity, "INVALID_CAPTCHA", "NOT_ERROR:INVALID_CAPTCHA")
-		return
-	}
-	if captchaText != storedCaptchaText {
-		aepr.WriteResponseAsErrorMessageNotLogged(http.StatusUnprocessableEntity, "INVALID_CAPTCHA", "NOT_ERROR:INVALID_CAPTCHA")
-		return
-	}
-
-	var user utils.JSON
-	var userOrganizationMemberships []utils.JSON
-	var userLoggedOrganizationId int64
-	var userLoggedOrganizationUid string
-	var userLoggedOrganization utils.JSON
-	var verificationResult bool
-	if s.OnAuthenticateUser != nil {
-		verificationResult, user, userLoggedOrganization, err = s.OnAuthenticateUser(aepr, userLoginId, userPassword, organizationUId)
-		if err != nil {
-			return err
-		}
-		if !verificationResult {
-			return aepr.WriteResponseAndNewErrorf(http.StatusUnauthorized, base.MsgInvalidCredential, base.LogMsgNotErrorInvalidCredential)
-		}
-
-		userId, err := utils.GetInt64FromKV(user, "id")
-		if err != nil {
-			return err
-		}
-
-		us := utils.JSON{
-			"user_id": userId,
-		}
-
-		if organizationUId != "" {
-			us["organization_uid"] = organizationUId
-		}
-
-		_, userOrganizationMemberships, err = user_management.ModuleUserManagement.UserOrganizationMembership.Select(aepr.Context, &aepr.Log, nil, us, nil,
-			db.DXDatabaseTableFieldsOrderBy{"order_index": "asc"}, nil, nil)
-		if err != nil {
-			return err
-		}
-
-		if len(userOrganizationMemberships) == 0 {
-			return aepr.WriteResponseAndNewErrorf(http.StatusUnauthorized, base.MsgInvalidCredential, base.LogMsgNotErrorInvalidCredential)
-		}
-
-		userLoggedOrganizationId, err = utils.GetInt64FromKV(userLoggedOrganization, "id")
-		if err != nil {
-			return err
-		}
-		userLoggedOrganizationUid, err = utils.GetStringFromKV(userLoggedOrganization, "uid")
-		if err != nil {
-			return err
-		}
-	} else {
-		_, user, err := user_management.ModuleUserManagement.User.SelectOne(aepr.Context, &aepr.Log, nil, utils.JSON{
-			"loginid": userLoginId,
-		}, nil, nil)
-		if err != nil {
-			return err
-		}
-		if user == nil {
-			return aepr.WriteResponseAndNewErrorf(http.StatusUnauthorized, base.MsgInvalidCredential, base.LogMsgNotErrorInvalidCredential)
-		}
-
-		userId, err := utils.GetInt64FromKV(user, "id")
-		if err != nil {
-			return err
-		}
-
-		us := utils.JSON{
-			"user_id": userId,
-		}
-
-		if organizationUId != "" {
-			us["organization_uid"] = organizationUId
-		}
-
-		_, userOrganizationMemberships, err = user_management.ModuleUserManagement.UserOrganizationMembership.Select(aepr.Context, &aepr.Log, nil, us, nil,
-			db.DXDatabaseTableFieldsOrderBy{"order_index": "asc"}, nil, nil)
-		if err != nil {
-			return err
-		}
-
-		if len(userOrganizationMemberships) == 0 {
-			return aepr.WriteResponseAndNewErrorf(http.StatusUnauthorized, base.MsgInvalidCredential, base.LogMsgNotErrorInvalidCredential)
-		}
-
-		userLoggedOrganizationId, err = utils.GetInt64FromKV(userOrganizationMemberships[0], "organization_id")
-		if err != nil {
-			return err
-		}
-		userLoggedOrganizationUid, err = utils.GetStringFromKV(userOrganizationMemberships[0], "organization_uid")
-		if err != nil {
-			return err
-		}
-
-		_, userLoggedOrganization, err = user_management.ModuleUserManagement.Organization.ShouldGetById(aepr.Context, &aepr.Log, userLoggedOrganizationId)
-		if err != nil {
-			return err
-		}
-
-		verificationResult, err = user_management.ModuleUserManagement.UserPasswordVerify(aepr.Context, &aepr.Log, userId, userPassword)
-		if err != nil {
-			return err
-		}
-
-		if !verificationResult {
-			return aepr.WriteResponseAndNewErrorf(http.StatusUnauthorized, base.MsgInvalidCredential, base.LogMsgNotErrorInvalidCredential)
-		}
-	}
-
-	sessionKey, err := GenerateSessionKey()
-	if err != nil {
-		return err
-	}
-
-	userId, ok := user["id"].(int64)
-	if !ok {
-		return aepr.WriteResponseAndNewErrorf(500, "INTERNAL_ERROR", "SHOULD_NOT_HAPPEN:USER_ID_NOT_FOUND_IN_USER")
-	}
-	a := []any{userOrganizationMemberships}
-	sessionObject, allowed, err2 := s.RegenerateSessionObject(aepr, userId, sessionKey, user, userLoggedOrganizationId, userLoggedOrganizationUid, userLoggedOrganization, a)
-	if err2 != nil {
-		return aepr.WriteResponseAndNewErrorf(http.StatusUnauthorized, "SESSION_KEY_EXPIRED", "NOT_ERROR:SESSION_KEY_EXPIRED_%s", err2.Error())
-	}
-
-	if !allowed {
-		return aepr.WriteResponseAndNewErrorf(http.StatusForbidden, "USER_ROLE_PRIVILEGE_FORBIDDEN", "NOT_ERROR:USER_ROLE_PRIVILEGE_FORBIDDEN")
-	}
-
-	// Block login during maintenance mode for users without GLOBAL.SET_MAINTENANCE_MODE privilege
-	if s.SystemModeIsMaintenance(aepr.Context) {
-		userEffectivePrivilegeIds, ok := sessionObject["user_effective_privilege_ids"].(map[string]int64)
-		if ok {
-			_, hasMaintenancePrivilege := userEffectivePrivilegeIds[base.PrivilegeNameIdSetMaintenance]
-			if !hasMaintenancePrivilege {
-				aepr.WriteResponseAsErrorMessageNotLogged(http.StatusServiceUnavailable, "SYSTEM_UNDER_MAINTENANCE", ErrorSystemUnderMaintenance)
-				return nil
-			}
-		} else {
-			aepr.WriteResponseAsErrorMessageNotLogged(http.StatusServiceUnavailable, "SYSTEM_UNDER_MAINTENANCE", ErrorSystemUnderMaintenance)
-			return nil
-		}
-	}
-
-	configSystem := *configuration.Manager.Configurations["system"].Data
-	configSystemSession, ok := configSystem["sessions"].(utils.JSON)
-	if !ok {
-		return errors.New(ErrorConfigSystemSessionsNotFound)
-	}
-	sessionKeyTTLAsInt, ok := configSystemSession["session_ttl_in_seconds"].(int)
-	if !ok {
-		return errors.New("SHOULD_NOT_HAPPEN:SESSIONS_TTL_SECOND_NOT_FOUND_OR_NOT_INT")
-	}
-
-	sessionKeyTTLAsDuration := time.Duration(sessionKeyTTLAsInt) * time.Second
-
-	err = user_management.ModuleUserManagement.SessionRedis.Set(aepr.Context, sessionKey, sessionObject, sessionKeyTTLAsDuration)
-	if err != nil {
-		return err
-	}
-
-	aepr.WriteResponseAsJSON(http.StatusOK, nil, utils.JSON{
-		"session_object": sessionObject,
-	})
-	return err
-}
-
-func (s *DxmSelf) RegenerateSessionObject(aepr *api.DXAPIEndPointRequest, userId int64, sessionKey string, user utils.JSON, userLoggedOrganizationId int64,
-	userLoggedOrganizationUid string, userLoggedOrganization utils.JSON, userOrganizationMemberships []any) (sessionObject utils.JSON, allowed bool, err error) {
-	var userEffectivePrivilegeIds map[string]int64
-
-	_, userRoleMemberships, err := user_management.ModuleUserManagement.UserRoleMembership.Select(aepr.Context, &aepr.Log, nil, utils.JSON{
-		"user_id": userId,
-	}, nil, db.DXDatabaseTableFieldsOrderBy{"id": "ASC"}, nil, nil)
-	if err != nil {
-		return nil, false, err
-	}
-
-	userEffectivePrivilegeIds = map[string]int64{}
-	for _, roleMembership := range userRoleMemberships {
-		_, rolePrivileges, err := user_management.ModuleUserManagement.RolePrivilege.Select(aepr.Context, &aepr.Log, nil, utils.JSON{
-			"role_id": roleMembership["role_id"],
-		}, nil, nil, nil, nil)
-		if err != nil {
-			return nil, false, err
-		}
-		for _, v1 := range rolePrivileges {
-			privilegeNameId, err := utils.GetStringFromKV(v1, "privilege_nameid")
-			if err != nil {
-				return nil, false, err
-			}
-
-			privilegeId, err := utils.GetInt64FromKV(v1, "privilege_id")
-			if err != nil {
-				return nil, false, err
-			}
-			if privilegeNameId == "EVERYTHING" {
-				// Preserve the EVERYTHING marker in the effective-privilege map so
-				// privilege-based bypass checks (CallerHasEverythingPrivilege) can
-				// detect full-access roles. The inner expansion still feeds every
-				// concrete privilege into the map for endpoint-level authorization.
-				if _, exists := userEffectivePrivilegeIds["EVERYTHING"]; !exists {
-					userEffectivePrivilegeIds["EVERYTHING"] = privilegeId
-				}
-				_, rolePrivileges, err := user_management.ModuleUserManagement.Privilege.Select(aepr.Context, &aepr.Log, nil, nil, nil, nil, nil, nil)
-				if err != nil {
-					return nil, false, err
-				}
-				for _, v2 := range rolePrivileges {
-					privilegeNameId, err := utils.GetStringFromKV(v2, "nameid")
-					if err != nil {
-						return nil, false, err
-					}
-					privilegeId, err := utils.GetInt64FromKV(v2, "id")
-					if err != nil {
-						return nil, false, err
-					}
-					if privilegeNameId != "EVERYTHING" {
-						_, exists := userEffectivePrivilegeIds[privilegeNameId]
-						if !exists {
-							userEffectivePrivilegeIds[privilegeNameId] = privilegeId
-						}
-					}
-
-				}
-			} else {
-				_, exists := userEffectivePrivilegeIds[privilegeNameId]
-				if !exists {
-					userEffectivePrivilegeIds[privilegeNameId] = privilegeId
-				}
-			}
-		}
-	}
-
-	menuTreeRoot, err := s.fetchMenuTree(aepr.Context, &aepr.Log, userEffectivePrivilegeIds)
-	if err != nil {
-		return nil, false, err
-	}
-
-	// Extract user language preference (default to 'id' if not set)
-	userLanguage, err := utils.GetStringFromKV(user, "language")
-	if err != nil || userLanguage == "" {
-		userLanguage = "id" // Default to Indonesian
-	}
-
-	sessionObject = utils.JSON{
-		"session_key":                   sessionKey,
-		"user_id":                       userId,
-		"user":                          user,
-		"language":                      userLanguage,
-		"organization_id":               userLoggedOrganizationId,
-		"organization_uid":              userLoggedOrganizationUid,
-		"organization":                  userLoggedOrganization,
-		"user_organization_memberships": userOrganizationMemberships,
-		"user_role_memberships":         userRoleMemberships,
-		"user_effective_privilege_ids":  userEffectivePrivilegeIds,
-		"menu_tree_root":                menuTreeRoot,
-		"privilege_version":             user_management.ModuleUserManagement.GetOrInitUserPrivilegeVersion(aepr.Context, userId),
-	}
-
-	if len(aepr.EndPoint.Privileges) > 0 {
-		allowed = false
-		for k := range userEffectivePrivilegeIds {
-			if slices.Contains(aepr.EndPoint.Privileges, k) {
-				allowed = true
-			}
-		}
-	} else {
-		allowed = true
-	}
-	if !allowed {
-		return sessionObject, false, err
-	}
-
-	if s.OnCreateSessionObject != nil {
-		sessionObject, err = s.OnCreateSessionObject(aepr, user, userLoggedOrganization, sessionObject)
-		if err != nil {
-			return sessionObject, true, err
-		}
-	}
-
-	return sessionObject, true, nil
-}
-
-func GenerateSessionKey() (string, error) {
-	a, err := uuid.NewV7()
-	if err != nil {
-		return "", err
-	}
-	b, err := uuid.NewRandom()
-	if err != nil {
-		return "", err
-	}
-
-	c, err := uuid.NewV7()
-	if err != nil {
-		return "", err
-	}
-	d, err := uuid.NewRandom()
-	if err != nil {
-		return "", err
-	}
-
-	z := a.String() + b.String() + c.String() + d.String()
-
-	sessionKey := strings.ReplaceAll(z, "-", "")
-	return sessionKey, nil
-}
-
-func (s *DxmSelf) SelfLoginCaptcha(aepr *api.DXAPIEndPointRequest) (err error) {
-
-	_, preKeyIndex, err := aepr.GetParameterValueAsString("i")
-	if err != nil {
-		return err
-	}
-	_, dataAsHexString, err := aepr.GetParameterValueAsString("d")
-	if err != nil {
-		return err
-	}
-
-	if api.OnE2EEPrekeyUnPack == nil {
-		return aepr.WriteResponseAndNewErrorf(http.StatusUnprocessableEntity, "NOT_IMPLEMENTED", "NOT_IMPLEMENTED:OnE2EEPrekeyUnPack_IS_NIL:%v", aepr.EndPoint.EndPointType)
-	}
-
-	lvPayloadElements, sharedKey2AsBytes, edB0PrivateKeyAsBytes, preKeyData, err := api.OnE2EEPrekeyUnPack(aepr, preKeyIndex, dataAsHexString)
-	if err != nil {
-		return aepr.WriteResponseAndNewErrorf(http.StatusUnprocessableEntity, "REFRESH_CAPTCHA", "NOT_ERROR:UNPACK_ERROR:%v", err.Error())
-	}
-
-	if preKeyData == nil {
-		return aepr.WriteResponseAndNewErrorf(http.StatusUnprocessableEntity, "REFRESH_CAPTCHA", "NOT_ERROR:UNPACK_ERROR:PREKEY_NOT_FOUND")
-	}
-
-	storedCaptchaId, err := utils.GetStringFromKV(preKeyData, "captcha_id")
-	if err != nil {
-		return err
-	}
-	storedCaptchaText, err := utils.GetStringFromKV(preKeyData, "captcha_text")
-	if err != nil {
-		return err
-	}
-
-	lvPayloadLoginId := lvPayloadElements[0]
-	lvPayloadPassword := lvPayloadElements[1]
-	lvPayloadOrganizationUId := lvPayloadElements[2]
-	lvPayloadCaptchaId := lvPayloadElements[3]
-	lvPayloadCaptchaText := lvPayloadElements[4]
-
-	userLoginId := string(lvPayloadLoginId.Value)
-	userPassword := string(lvPayloadPassword.Value)
-	organizationUId := string(lvPayloadOrganizationUId.Value)
-	captchaId := string(lvPayloadCaptchaId.Value)
-	captchaText := string(lvPayloadCaptchaText.Value)
-
-	if captchaId != storedCaptchaId {
-		aepr.WriteResponseAsErrorMessageNotLogged(http.StatusUnprocessableEntity, "INVALID_CAPTCHA", "NOT_ERROR:INVALID_CAPTCHA")
-		return
-	}
-	if captchaText != storedCaptchaText {
-		aepr.WriteResponseAsErrorMessageNotLogged(http.StatusUnprocessableEntity, "INVALID_CAPTCHA", "NOT_ERROR:INVALID_CAPTCHA")
-		return
-	}
-
-	var user utils.JSON
-	var userOrganizationMemberships []utils.JSON
-	var userLoggedOrganizationId int64
-	var userLoggedOrganizationUid string
-	var userLoggedOrganization utils.JSON
-	var verificationResult bool
-	if s.OnAuthenticateUser != nil {
-		verificationResult, user, userLoggedOrganization, err = s.OnAuthenticateUser(aepr, userLoginId, userPassword, organizationUId)
-		if err != nil {
-			return err
-		}
-		if !verificationResult {
-			return aepr.WriteResponseAndNewErrorf(http.StatusUnauthorized, base.MsgInvalidCredential, base.LogMsgNotErrorInvalidCredential)
-		}
-	} else {
-		_, user, err = user_management.ModuleUserManagement.User.SelectOne(aepr.Context, &aepr.Log, nil, utils.JSON{
-			"loginid": userLoginId,
-		}, nil, nil)
-		if err != nil {
-			return err
-		}
-		if user == nil {
-			return aepr.WriteResponseAndNewErrorf(http.StatusUnauthorized, base.MsgInvalidCredential, base.LogMsgNotErrorInvalidCredential)
-		}
-
-		userId, err := utils.GetInt64FromKV(user, "id")
-		if err != nil {
-			return err
-		}
-
-		us := utils.JSON{
-			"user_id": userId,
-		}
-
-		if organizationUId != "" {
-			us["organization_uid"] = organizationUId
-		}
-
-		_, userOrganizationMemberships, err = user_management.ModuleUserManagement.UserOrganizationMembership.Select(aepr.Context, &aepr.Log, nil, us, nil,
-			db.DXDatabaseTableFieldsOrderBy{"order_index": "asc"}, nil, nil)
-		if err != nil {
-			return err
-		}
-
-		if len(userOrganizationMemberships) == 0 {
-			return aepr.WriteResponseAndNewErrorf(http.StatusUnauthorized, base.MsgInvalidCredential, base.LogMsgNotErrorInvalidCredential)
-		}
-
-		userLoggedOrganizationId, err = utils.GetInt64FromKV(userOrganizationMemberships[0], "organization_id")
-		if err != nil {
-			return err
-		}
-		userLoggedOrganizationUid, err = utils.GetStringFromKV(userOrganizationMemberships[0], "organization_uid")
-		if err != nil {
-			return err
-		}
-
-		_, userLoggedOrganization, err = user_management.ModuleUserManagement.Organization.ShouldGetById(aepr.Context, &aepr.Log, userLoggedOrganizationId)
-		if err != nil {
-			return err
-		}
-
-		verificationResult, err = user_management.ModuleUserManagement.UserPasswordVerify(aepr.Context, &aepr.Log, userId, userPassword)
-		if err != nil {
-			return err
-		}
-
-		if !verificationResult {
-			return aepr.WriteResponseAndNewErrorf(http.StatusUnauthorized, base.MsgInvalidCredential, base.LogMsgNotErrorInvalidCredential)
-		}
-	}
-
-	sessionKey, err := GenerateSessionKey()
-	if err != nil {
-		return err
-	}
-
-	userId, err := utils.GetInt64FromKV(user, "id")
-	if err != nil {
-		return aepr.WriteResponseAndNewErrorf(500, "INTERNAL_ERROR", "SHOULD_NOT_HAPPEN:USER_ID_NOT_FOUND_IN_USER")
-	}
-	a := []any{userOrganizationMemberships}
-	sessionObject, allowed, err2 := s.RegenerateSessionObject(aepr, userId, sessionKey, user, userLoggedOrganizationId, userLoggedOrganizationUid, userLoggedOrganization, a)
-	if err2 != nil {
-		return aepr.WriteResponseAndNewErrorf(http.StatusUnauthorized, "SESSION_KEY_EXPIRED", "NOT_ERROR:SESSION_KEY_EXPIRED_%s", err2.Error())
-	}
-
-	if !allowed {
-		return aepr.WriteResponseAndNewErrorf(http.StatusForbidden, "USER_ROLE_PRIVILEGE_FORBIDDEN", "NOT_ERROR:USER_ROLE_PRIVILEGE_FORBIDDEN")
-	}
-
-	// Block login during maintenance mode for users without GLOBAL.SET_MAINTENANCE_MODE privilege
-	if s.SystemModeIsMaintenance(aepr.Context) {
-		userEffectivePrivilegeIds, ok := sessionObject["user_effective_privilege_ids"].(map[string]int64)
-		if ok {
-			_, hasMaintenancePrivilege := userEffectivePrivilegeIds[base.PrivilegeNameIdSetMaintenance]
-			if !hasMaintenancePrivilege {
-				aepr.WriteResponseAsErrorMessageNotLogged(http.StatusServiceUnavailable, "SYSTEM_UNDER_MAINTENANCE", ErrorSystemUnderMaintenance)
-				return nil
-			}
-		} else {
-			aepr.WriteResponseAsErrorMessageNotLogged(http.StatusServiceUnavailable, "SYSTEM_UNDER_MAINTENANCE", ErrorSystemUnderMaintenance)
-			return nil
-		}
-	}
-
-	configSystem := *configuration.Manager.Configurations["system"].Data
-	configSystemSession, ok := configSystem["sessions"].(utils.JSON)
-	if !ok {
-		return errors.New(ErrorConfigSystemSessionsNotFound)
-	}
-	sessionKeyTTLAsInt, ok := configSystemSession["session_ttl_in_seconds"].(int)
-	if !ok {
-		return errors.New("SHOULD_NOT_HAPPEN:SESSIONS_TTL_SECOND_NOT_FOUND_OR_NOT_INT")
-	}
-
-	sessionKeyTTLAsDuration := time.Duration(sessionKeyTTLAsInt) * time.Second
-
-	err = user_management.ModuleUserManagement.SessionRedis.Set(aepr.Context, sessionKey, sessionObject, sessionKeyTTLAsDuration)
-	if err != nil {
-		return err
-	}
-
-	sessionObjectJSON, err := json.Marshal(sessionObject)
-	if err != nil {
-		return err
-	}
-
-	sessionObjectJSONString := string(sessionObjectJSON)
-
-	lvSessionObject, err := lv.NewLV([]byte(sessionObjectJSONString))
-	if err != nil {
-		return err
-	}
-
-	if api.OnE2EEPrekeyPack == nil {
-		return aepr.WriteResponseAndNewErrorf(http.StatusUnprocessableEntity, "NOT_IMPLEMENTED", "NOT_IMPLEMENTED:OnE2EEPrekeyPack_IS_NIL:%v", aepr.EndPoint.EndPointType)
-	}
-
-	dataBlockEnvelopeAsHexString, err := api.OnE2EEPrekeyPack(aepr, preKeyIndex, edB0PrivateKeyAsBytes, sharedKey2AsBytes, lvSessionObject)
-	if err != nil {
-		return err
-	}
-
-	aepr.WriteResponseAsJSON(http.StatusOK, nil, utils.JSON{
-		"d": dataBlockEnvelopeAsHexString,
-	})
-	return err
-}
-
-func (s *DxmSelf) SelfLoginCaptchaV2(aepr *api.DXAPIEndPointRequest) (err error) {
-
-	_, preKeyIndex, err := aepr.GetParameterValueAsString("i")
-	if err != nil {
-		return err
-	}
-	_, dataAsHexString, err := aepr.GetParameterValueAsString("d")
-	if err != nil {
-		return err
-	}
-
-	if api.OnE2EEPrekeyUnPack == nil {
-		return aepr.WriteResponseAndNewErrorf(http.StatusUnprocessableEntity, "NOT_IMPLEMENTED", "NOT_IMPLEMENTED:OnE2EEPrekeyUnPack_IS_NIL:%v", aepr.EndPoint.EndPointType)
-	}
-
-	lvPayloadElements, sharedKey2AsBytes, edB0PrivateKeyAsBytes, preKeyData, err := api.OnE2EEPrekeyUnPack(aepr, preKeyIndex, dataAsHexString)
-	if err != nil {
-		return aepr.WriteResponseAndNewErrorf(http.StatusUnprocessableEntity, "INVALID_PREKEY", "NOT_ERROR:UNPACK_ERROR:%v", err.Error())
-	}
-
-	lvPayloadHeader := lvPayloadElements[0]
-	lvPayloadBody := lvPayloadElements[1]
-
-	payLoadHeaderAsBase64 := lvPayloadHeader.Value
-	payLoadHeaderAsBytes, err := base64.StdEncoding.DecodeString(string(payLoadHeaderAsBase64))
-	if err != nil {
-		return aepr.WriteResponseAndNewErrorf(http.StatusUnprocessableEntity, "DATA_CORRUPT", "DATA_CORRUPT:INVALID_DECODED_PAYLOAD_HEADER_FROM_BASE64")
-	}
-	payloadHeader := map[string]string{}
-	err = json.Unmarshal(payLoadHeaderAsBytes, &payloadHeader)
-	if err != nil {
-		return aepr.WriteResponseAndNewErrorf(http.StatusUnprocessableEntity, "DATA_CORRUPT", "DATA_CORRUPT:INVALID_UNMARSHAL_PAYLOAD_HEADER_BYTES")
-	}
-
-	aepr.EncryptionParameters = utils.JSON{
-		"PRE_KEY_INDEX":              preKeyIndex,
-		"SHARED_KEY_2_AS_BYTES":      sharedKey2AsBytes,
-		"ED_B0_PRIVATE_KEY_AS_BYTES": edB0PrivateKeyAsBytes,
-		"PRE_KEY_DATA":               preKeyData,
-	}
-	aepr.EffectiveRequestHeader = payloadHeader
-
-	payLoadBodyAsBase64 := lvPayloadBody.Value
-	payLoadBodyAsBytes, err := base64.StdEncoding.DecodeString(string(payLoadBodyAsBase64))
-	if err != nil {
-		return aepr.WriteResponseAndNewErrorf(http.StatusUnprocessableEntity, "DATA_CORRUPT", "DATA_CORRUPT:INVALID_DECODED_PAYLOAD_BODY_FROM_BASE64")
-	}
-	payloadBodyAsJSON := utils.JSON{}
-	err = json.Unmarshal(payLoadBodyAsBytes, &payloadBodyAsJSON)
-	if err != nil {
-		return aepr.WriteResponseAndNewErrorf(http.StatusUnprocessableEntity, "DATA_CORRUPT", "DATA_CORRUPT:INVALID_UNMARSHAL_PAYLOAD_BODY_BYTES")
-	}
-
-	userLoginId, err := utils.GetStringFromKV(payloadBodyAsJSON, "user_login_id")
-	if err != nil {
-		return aepr.WriteResponseAndNewErrorf(http.StatusUnprocessableEntity, "DATA_CORRUPT", "DATA_CORRUPT:INVALID_UNMARSHAL_PAYLOAD_BODY_BYTES")
-	}
-	userPassword, err := utils.GetStringFromKV(payloadBodyAsJSON, "user_login_password")
-	if err != nil {
-		return aepr.WriteResponseAndNewErrorf(http.StatusUnprocessableEntity, "DATA_CORRUPT", "DATA_CORRUPT:INVALID_UNMARSHAL_PAYLOAD_BODY_BYTES")
-	}
-	organizationUId, err := utils.GetStringFromKV(payloadBodyAsJSON, "organization_uid")
-	if err != nil {
-		return aepr.WriteResponseAndNewErrorf(http.StatusUnprocessableEntity, "DATA_CORRUPT", "DATA_CORRUPT:INVALID_UNMARSHAL_PAYLOAD_BODY_BYTES")
-	}
-	captchaId, err := utils.GetStringFromKV(payloadBodyAsJSON, "captcha_id")
-	if err != nil {
-		return aepr.WriteResponseAndNewErrorf(http.StatusUnprocessableEntity, "DATA_CORRUPT", "DATA_CORRUPT:INVALID_UNMARSHAL_PAYLOAD_BODY_BYTES")
-	}
-	captchaText, err := utils.GetStringFromKV(payloadBodyAsJSON, "captcha_text")
-	if err != nil {
-		return aepr.WriteResponseAndNewErrorf(http.StatusUnprocessableEntity, "DATA_CORRUPT", "DATA_CORRUPT:INVALID_UNMARSHAL_PAYLOAD_BODY_BYTES")
-	}
-
-	if preKeyData == nil {
-		return aepr.WriteResponseAndNewErrorf(http.StatusUnprocessableEntity, "INVALID_PREKEY", "NOT_ERROR:UNPACK_ERROR:PREKEY_NOT_FOUND")
-	}
-
-	storedCaptchaId, err := utils.GetStringFromKV(preKeyData, "captcha_id")
-	if err != nil {
-		return err
-	}
-	storedCaptchaText, err := utils.GetStringFromKV(preKeyData, "captcha_text")
-	if err != nil {
-		return err
-	}
-
-	if captchaId != storedCaptchaId {
-		aepr.WriteResponseAsErrorMessageNotLogged(http.StatusUnprocessableEntity, "INVALID_CAPTCHA", "NOT_ERROR:INVALID_CAPTCHA")
-		return
-	}
-	if captchaText != storedCaptchaText {
-		aepr.WriteResponseAsErrorMessageNotLogged(http.StatusUnprocessableEntity, "INVALID_CAPTCHA", "NOT_ERROR:INVALID_CAPTCHA")
-		return
-	}
-
-	var user utils.JSON
-	var userOrganizationMemberships []utils.JSON
-	var userLoggedOrganizationId int64
-	var userLoggedOrganizationUid string
-	var userLoggedOrganization utils.JSON
-	var verificationResult bool
-	if s.OnAuthenticateUser != nil {
-		verificationResult, user, userLoggedOrganization, err = s.OnAuthenticateUser(aepr, userLoginId, userPassword, organizationUId)
-		if err != nil {
-			return err
-		}
-		if !verificationResult {
-			return aepr.WriteResponseAndNewErrorf(http.StatusUnauthorized, base.MsgInvalidCredential, base.LogMsgNotErrorInvalidCredential)
-		}
-	} else {
-		_, user, err := user_management.ModuleUserManagement.User.SelectOne(aepr.Context, &aepr.Log, nil, utils.JSON{
-			"loginid": userLoginId,
-		}, nil, nil)
-		if err != nil {
-			return err
-		}
-		if user == nil {
-			return aepr.WriteResponseAndNewErrorf(http.StatusUnauthorized, base.MsgInvalidCredential, base.LogMsgNotErrorInvalidCredential)
-		}
-
-		userId, err := utils.GetInt64FromKV(user, "id")
-		if err != nil {
-			return err
-		}
-
-		us := utils.JSON{
-			"user_id": userId,
-		}
+}
 
-		if organizationUId != "" {
-			us["organization_uid"] = organizationUId
-		}
+func (s *DxmSelf) SelfLoginCaptchaV3(aepr *api.DXAPIEndPointRequest) (err error) {
+	return s.LoginPipelineRun(aepr, LoginPipeline{
+		Credentials: s.LoginCredentialsPlainCaptcha,
+		PreChecks:   []LoginStage{s.LoginCheckCaptcha},
+	})
+}
 
-		_, userOrganizationMemberships, err = user_management.ModuleUserManagement.UserOrganizationMembership.Select(aepr.Context, &aepr.Log, nil, us, nil,
-			db.DXDatabaseTableFieldsOrderBy{"order_index": "asc"}, nil, nil)
-		if err != nil {
-			return err
-		}
+func (s *DxmSelf) RegenerateSessionObject(aepr *api.DXAPIEndPointRequest, userId int64, sessionKey string, user utils.JSON, userLoggedOrganizationId int64,
+	userLoggedOrganizationUid string, userLoggedOrganization utils.JSON, userOrganizationMemberships []any) (sessionObject utils.JSON, allowed bool, err error) {
+	var userEffectivePrivilegeIds map[string]int64
 
-		if len(userOrganizationMemberships) == 0 {
-			return aepr.WriteResponseAndNewErrorf(http.StatusUnauthorized, base.MsgInvalidCredential, base.LogMsgNotErrorInvalidCredential)
-		}
+	_, userRoleMemberships, err := user_management.ModuleUserManagement.UserRoleMembership.Select(aepr.Context, &aepr.Log, nil, utils.JSON{
+		"user_id": userId,
+	}, nil, db.DXDatabaseTableFieldsOrderBy{"id": "ASC"}, nil, nil)
+	if err != nil {
+		return nil, false, err
+	}
 
-		userLoggedOrganizationId, err = utils.GetInt64FromKV(userOrganizationMemberships[0], "organization_id")
-		if err != nil {
-			return err
-		}
-		userLoggedOrganizationUid, err = utils.GetStringFromKV(userOrganizationMemberships[0], "organization_uid")
-		if err != nil {
-			return err
-		}
+	userRolePrivileges, err := user_management.ModuleUserManagement.UserRolePrivilegesEffective(aepr.Context, &aepr.Log, userId, userRoleMemberships)
+	if err != nil {
+		return nil, false, err
+	}
 
-		_, userLoggedOrganization, err = user_management.ModuleUserManagement.Organization.ShouldGetById(aepr.Context, &aepr.Log, userLoggedOrganizationId)
+	userEffectivePrivilegeIds = map[string]int64{}
+	for _, v1 := range userRolePrivileges {
+		privilegeNameId, err := utils.GetStringFromKV(v1, "privilege_nameid")
 		if err != nil {
-			return err
+			return nil, false, err
 		}
 
-		verificationResult, err = user_management.ModuleUserManagement.UserPasswordVerify(aepr.Context, &aepr.Log, userId, userPassword)
+		privilegeId, err := utils.GetInt64FromKV(v1, "privilege_id")
 		if err != nil {
-			return err
+			return nil, false, err
 		}
+		if privilegeNameId == "EVERYTHING" {
+			// Preserve the EVERYTHING marker in the effective-privilege map so
+			// privilege-based bypass checks (CallerHasEverythingPrivilege) can
+			// detect full-access roles. The inner expansion still feeds every
+			// concrete privilege into the map for endpoint-level authorization.
+			if _, exists := userEffectivePrivilegeIds["EVERYTHING"]; !exists {
+				userEffectivePrivilegeIds["EVERYTHING"] = privilegeId
+			}
+			_, rolePrivileges, err := user_management.ModuleUserManagement.Privilege.Select(aepr.Context, &aepr.Log, nil, nil, nil, nil, nil, nil)
+			if err != nil {
+				return nil, false, err
+			}
+			for _, v2 := range rolePrivileges {
+				privilegeNameId, err := utils.GetStringFromKV(v2, "nameid")
+				if err != nil {
+					return nil, false, err
+				}
+				privilegeId, err := utils.GetInt64FromKV(v2, "id")
+				if err != nil {
+					return nil, false, err
+				}
+				if privilegeNameId != "EVERYTHING" {
+					_, exists := userEffectivePrivilegeIds[privilegeNameId]
+					if !exists {
+						userEffectivePrivilegeIds[privilegeNameId] = privilegeId
+					}
+				}
 
-		if !verificationResult {
-			return aepr.WriteResponseAndNewErrorf(http.StatusUnauthorized, base.MsgInvalidCredential, base.LogMsgNotErrorInvalidCredential)
+			}
+		} else {
+			_, exists := userEffectivePrivilegeIds[privilegeNameId]
+			if !exists {
+				userEffectivePrivilegeIds[privilegeNameId] = privilegeId
+			}
 		}
 	}
 
-	sessionKey, err := GenerateSessionKey()
+	menuTreeRoot, err := s.fetchMenuTree(aepr.Context, &aepr.Log, userEffectivePrivilegeIds)
 	if err != nil {
-		return err
+		return nil, false, err
 	}
 
-	userId, ok := user["id"].(int64)
-	if !ok {
-		return aepr.WriteResponseAndNewErrorf(500, "INTERNAL_ERROR", "SHOULD_NOT_HAPPEN:USER_ID_NOT_FOUND_IN_USER")
-	}
-	a := []any{userOrganizationMemberships}
-	sessionObject, allowed, err2 := s.RegenerateSessionObject(aepr, userId, sessionKey, user, userLoggedOrganizationId, userLoggedOrganizationUid, userLoggedOrganization, a)
-	if err2 != nil {
-		return aepr.WriteResponseAndNewErrorf(http.StatusUnauthorized, "SESSION_KEY_EXPIRED", "NOT_ERROR:SESSION_KEY_EXPIRED_%s", err2.Error())
+	// Extract user language preference (default to 'id' if not set)
+	userLanguage, err := utils.GetStringFromKV(user, "language")
+	if err != nil || userLanguage == "" {
+		userLanguage = "id" // Default to Indonesian
 	}
 
-	if !allowed {
-		return aepr.WriteResponseAndNewErrorf(http.StatusForbidden, "USER_ROLE_PRIVILEGE_FORBIDDEN", "NOT_ERROR:USER_ROLE_PRIVILEGE_FORBIDDEN")
+	sessionObject = utils.JSON{
+		"session_key":                   sessionKey,
+		"user_id":                       userId,
+		"user":                          user,
+		"language":                      userLanguage,
+		"organization_id":               userLoggedOrganizationId,
+		"organization_uid":              userLoggedOrganizationUid,
+		"organization":                  userLoggedOrganization,
+		"user_organization_memberships": userOrganizationMemberships,
+		"user_role_memberships":         userRoleMemberships,
+		"user_effective_privilege_ids":  userEffectivePrivilegeIds,
+		"menu_tree_root":                menuTreeRoot,
+		"privilege_version":             user_management.ModuleUserManagement.GetOrInitUserPrivilegeVersion(aepr.Context, userId),
 	}
 
-	// Block login during maintenance mode for users without GLOBAL.SET_MAINTENANCE_MODE privilege
-	if s.SystemModeIsMaintenance(aepr.Context) {
-		userEffectivePrivilegeIds, ok := sessionObject["user_effective_privilege_ids"].(map[string]int64)
-		if ok {
-			_, hasMaintenancePrivilege := userEffectivePrivilegeIds[base.PrivilegeNameIdSetMaintenance]
-			if !hasMaintenancePrivilege {
-				aepr.WriteResponseAsErrorMessageNotLogged(http.StatusServiceUnavailable, "SYSTEM_UNDER_MAINTENANCE", ErrorSystemUnderMaintenance)
-				return nil
+	if len(aepr.EndPoint.Privileges) > 0 {
+		allowed = false
+		for k := range userEffectivePrivilegeIds {
+			if slices.Contains(aepr.EndPoint.Privileges, k) {
+				allowed = true
 			}
-		} else {
-			aepr.WriteResponseAsErrorMessageNotLogged(http.StatusServiceUnavailable, "SYSTEM_UNDER_MAINTENANCE", ErrorSystemUnderMaintenance)
-			return nil
 		}
+	} else {
+		allowed = true
 	}
-
-	configSystem := *configuration.Manager.Configurations["system"].Data
-	configSystemSession, ok := configSystem["sessions"].(utils.JSON)
-	if !ok {
-		return errors.New(ErrorConfigSystemSessionsNotFound)
-	}
-	sessionKeyTTLAsInt, ok := configSystemSession["session_ttl_in_seconds"].(int)
-	if !ok {
-		return errors.New("SHOULD_NOT_HAPPEN:SESSIONS_TTL_SECOND_NOT_FOUND_OR_NOT_INT")
+	if !allowed {
+		return sessionObject, false, err
 	}
 
-	sessionKeyTTLAsDuration := time.Duration(sessionKeyTTLAsInt) * time.Second
-
-	err = user_management.ModuleUserManagement.SessionRedis.Set(aepr.Context, sessionKey, sessionObject, sessionKeyTTLAsDuration)
+	err = sessionLifetimeStamp(aepr, sessionKey, sessionObject, userLoggedOrganization)
 	if err != nil {
-		return err
+		return sessionObject, true, err
 	}
 
-	sessionObjectJSON, err := json.Marshal(sessionObject)
-	if err != nil {
-		return err
+	if s.OnCreateSessionObject != nil {
+		sessionObject, err = s.OnCreateSessionObject(aepr, user, userLoggedOrganization, sessionObject)
+		if err != nil {
+			return sessionObject, true, err
+		}
 	}
 
-	sessionObjectJSONString := string(sessionObjectJSON)
+	return sessionObject, true, nil
+}
 
-	lvSessionObject, err := lv.NewLV([]byte(sessionObjectJSONString))
+func GenerateSessionKey() (string, error) {
+	a, err := uuid.NewV7()
 	if err != nil {
-		return err
+		return "", err
 	}
-
-	if api.OnE2EEPrekeyPack == nil {
-		return aepr.WriteResponseAndNewErrorf(http.StatusUnprocessableEntity, "NOT_IMPLEMENTED", "NOT_IMPLEMENTED:OnE2EEPrekeyPack_IS_NIL:%v", aepr.EndPoint.EndPointType)
+	b, err := uuid.NewRandom()
+	if err != nil {
+		return "", err
 	}
 
-	dataBlockEnvelopeAsHexString, err := api.OnE2EEPrekeyPack(aepr, preKeyIndex, edB0PrivateKeyAsBytes, sharedKey2AsBytes, lvSessionObject)
+	c, err := uuid.NewV7()
 	if err != nil {
-		return err
+		return "", err
+	}
+	d, err := uuid.NewRandom()
+	if err != nil {
+		return "", err
 	}
 
-	aepr.WriteResponseAsJSON(http.StatusOK, nil, utils.JSON{
-		"d": dataBlockEnvelopeAsHexString,
+	z := a.String() + b.String() + c.String() + d.String()
+
+	sessionKey := strings.ReplaceAll(z, "-", "")
+	return sessionKey, nil
+}
+
+func (s *DxmSelf) SelfLoginCaptcha(aepr *api.DXAPIEndPointRequest) (err error) {
+	return s.LoginPipelineRun(aepr, LoginPipeline{
+		Credentials: s.LoginCredentialsE2EECaptcha,
+		PreChecks:   []LoginStage{s.LoginCheckCaptcha},
 	})
+}
 
-	return err
+func (s *DxmSelf) SelfLoginCaptchaV2(aepr *api.DXAPIEndPointRequest) (err error) {
+	return s.LoginPipelineRun(aepr, LoginPipeline{
+		Credentials: s.LoginCredentialsE2EEHeaderBody,
+		PreChecks:   []LoginStage{s.LoginCheckCaptcha},
+	})
 }
 
 func (s *DxmSelf) SelfLoginToken(aepr *api.DXAPIEndPointRequest) (err error) {
@@ -1769,19 +771,8 @@ func (s *DxmSelf) SelfLoginToken(aepr *api.DXAPIEndPointRequest) (err error) {
 		return aepr.WriteResponseAndNewErrorf(http.StatusForbidden, "USER_ROLE_PRIVILEGE_FORBIDDEN", "NOT_ERROR:USER_ROLE_PRIVILEGE_FORBIDDEN")
 	}
 
-	// Block login during maintenance mode for users without GLOBAL.SET_MAINTENANCE_MODE privilege
-	if s.SystemModeIsMaintenance(aepr.Context) {
-		userEffectivePrivilegeIds, ok := sessionObject["user_effective_privilege_ids"].(map[string]int64)
-		if ok {
-			_, hasMaintenancePrivilege := userEffectivePrivilegeIds[base.PrivilegeNameIdSetMaintenance]
-			if !hasMaintenancePrivilege {
-				aepr.WriteResponseAsErrorMessageNotLogged(http.StatusServiceUnavailable, "SYSTEM_UNDER_MAINTENANCE", ErrorSystemUnderMaintenance)
-				return nil
-			}
-		} else {
-			aepr.WriteResponseAsErrorMessageNotLogged(http.StatusServiceUnavailable, "SYSTEM_UNDER_MAINTENANCE", ErrorSystemUnderMaintenance)
-			return nil
-		}
+	if s.sessionMaintenanceModeRefuse(aepr, sessionObject) {
+		return nil
 	}
 
 	configSystem := *configuration.Manager.Configurations["system"].Data
@@ -1796,7 +787,11 @@ func (s *DxmSelf) SelfLoginToken(aepr *api.DXAPIEndPointRequest) (err error) {
 
 	sessionKeyTTLAsDuration := time.Duration(sessionKeyTTLAsInt) * time.Second
 
-	err = user_management.ModuleUserManagement.SessionRedis.Set(aepr.Context, sessionKey, sessionObject, sessionKeyTTLAsDuration)
+	err = sessionStore(aepr.Context, sessionKey, sessionObject, sessionKeyTTLAsDuration)
+	if err != nil {
+		return err
+	}
+	err = s.jwtAccessTokenAttach(aepr, sessionObject)
 	if err != nil {
 		return err
 	}
@@ -1825,51 +820,83 @@ func SessionKeyToSessionObject(aepr *api.DXAPIEndPointRequest, sessionKey string
 		return nil, err
 	}
 	if sessionObject == nil {
+		if reason := user_management.ModuleUserManagement.SessionEndedReason(aepr.Context, sessionKey); reason != "" {
+			return nil, aepr.WriteResponseAndNewErrorf(http.StatusUnauthorized, reason, "NOT_ERROR:%s", reason)
+		}
 		return nil, aepr.WriteResponseAndNewErrorf(http.StatusUnauthorized, "SESSION_NOT_FOUND", "NOT_ERROR:SESSION_NOT_FOUND")
 	}
-	userId, err := utilsJSON.GetInt64(sessionObject, "user_id")
+
+	// GetEx slides the session by the default idle timeout, narrow it to the session's own idle timeout and absolute deadline
+	sessionTTLAsDuration, isLifetimeExceeded := sessionTTL(sessionObject, sessionKeyTTLAsDuration)
+	if isLifetimeExceeded {
+		err = user_management.ModuleUserManagement.SessionEnd(aepr.Context, sessionKey, user_management.SessionEndedReasonLifetimeExceeded, sessionKeyTTLAsDuration)
+		if err != nil {
+			return nil, err
+		}
+		return nil, aepr.WriteResponseAndNewErrorf(http.StatusUnauthorized, user_management.SessionEndedReasonLifetimeExceeded, "NOT_ERROR:%s", user_management.SessionEndedReasonLifetimeExceeded)
+	}
+	if sessionTTLAsDuration != sessionKeyTTLAsDuration {
+		err = user_management.ModuleUserManagement.SessionRedis.Connection.Expire(aepr.Context, sessionKey, sessionTTLAsDuration).Err()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = sessionLocalDataSet(aepr, sessionKey, sessionObject)
 	if err != nil {
 		return nil, err
 	}
+	userId, _ := aepr.LocalData["user_id"].(int64)
+	user_management.ModuleUserManagement.SessionIndexTouch(aepr.Context, userId, sessionKey, sessionTTLAsDuration)
+
+	return sessionObject, nil
+}
+
+// sessionLocalDataSet exposes the session to the endpoint through aepr.LocalData and aepr.CurrentUser
+func sessionLocalDataSet(aepr *api.DXAPIEndPointRequest, sessionKey string, sessionObject utils.JSON) (err error) {
+	userId, err := utilsJSON.GetInt64(sessionObject, "user_id")
+	if err != nil {
+		return err
+	}
 	user, err := utils.GetVFromKV[utils.JSON](sessionObject, "user")
 	if err != nil {
-		return nil, err
+		return err
 	}
 	userUid, err := utilsJSON.GetString(user, "uid")
 	if err != nil {
-		return nil, err
+		return err
 	}
 	userLoginId, err := utilsJSON.GetString(user, "loginid")
 	if err != nil {
-		return nil, err
+		return err
 	}
 	userFullName, err := utilsJSON.GetString(user, "fullname")
 	if err != nil {
-		return nil, err
+		return err
 	}
 	organization, err := utils.GetVFromKV[utils.JSON](sessionObject, "organization")
 	if err != nil {
-		return nil, err
+		return err
 	}
 	organizationId, err := utilsJSON.GetInt64(organization, "id")
 	if err != nil {
-		return nil, err
+		return err
 	}
 	organizationUid, err := utilsJSON.GetString(organization, "uid")
 	if err != nil {
-		return nil, err
+		return err
 	}
 	organizationName, err := utilsJSON.GetString(organization, "name")
 	if err != nil {
-		return nil, err
+		return err
 	}
 	userOrganizationMemberships, ok := sessionObject["user_organization_memberships"].([]interface{})
 	if !ok {
-		return nil, aepr.WriteResponseAndNewErrorf(http.StatusUnauthorized, "", "NOT_ERROR:USER_ORGANIZATION_MEMBERSHIPS_NOT_FOUND")
+		return aepr.WriteResponseAndNewErrorf(http.StatusUnauthorized, "", "NOT_ERROR:USER_ORGANIZATION_MEMBERSHIPS_NOT_FOUND")
 	}
 
 	if user == nil {
-		return nil, aepr.WriteResponseAndNewErrorf(http.StatusUnauthorized, "", "USER_NOT_FOUND")
+		return aepr.WriteResponseAndNewErrorf(http.StatusUnauthorized, "", "USER_NOT_FOUND")
 	}
 
 	// Extract user language from session (default to 'id' if not set)
@@ -1898,7 +925,16 @@ func SessionKeyToSessionObject(aepr *api.DXAPIEndPointRequest, sessionKey string
 	aepr.CurrentUser.OrganizationUid = organizationUid
 	aepr.CurrentUser.OrganizationName = organizationName
 
-	return sessionObject, nil
+	// An impersonated session acts as the target user; the impersonator is kept next to it and in the display name,
+	// so activity logged from CurrentUser is told apart from the user's own
+	if impersonatorUserId, err := utilsJSON.GetInt64(sessionObject, "impersonator_user_id"); err == nil && impersonatorUserId > 0 {
+		impersonatorUserLoginId, _ := utilsJSON.GetString(sessionObject, "impersonator_user_loginid")
+		aepr.LocalData["impersonator_user_id"] = impersonatorUserId
+		aepr.LocalData["impersonator_user_loginid"] = impersonatorUserLoginId
+		aepr.CurrentUser.FullName = fmt.Sprintf("%s (impersonated by %s)", userFullName, impersonatorUserLoginId)
+	}
+
+	return nil
 }
 
 func CheckUserPrivilegeForEndPoint(aepr *api.DXAPIEndPointRequest, userEffectivePrivilegeIds utils.JSON) (err error) {
@@ -1964,8 +1000,22 @@ func (s *DxmSelf) MiddlewareUserLoggedAndPrivilegeCheck(aepr *api.DXAPIEndPointR
 
 	sessionKey := authHeader[len(bearerSchema):]
 
+	if lib.JWTIsCompact(sessionKey) {
+		keySet, err := s.jwtKeySetGet()
+		if err != nil {
+			return err
+		}
+		if keySet != nil {
+			return s.jwtMiddlewareUserLoggedAndPrivilegeCheck(aepr, keySet, sessionKey)
+		}
+	}
+
 	sessionObject, err := SessionKeyToSessionObject(aepr, sessionKey)
 	if err != nil {
+		// SESSION_NOT_FOUND or the reason the session was ended has already been written
+		if aepr.ResponseHeaderSent {
+			return nil
+		}
 		aepr.WriteResponseAsErrorMessageNotLogged(http.StatusUnauthorized, "SESSION_EXPIRED", "NOT_ERROR:SESSION_EXPIRED")
 		return nil
 	}
@@ -1975,6 +1025,22 @@ func (s *DxmSelf) MiddlewareUserLoggedAndPrivilegeCheck(aepr *api.DXAPIEndPointR
 		return nil
 	}
 
+	// Opaque session keys and access tokens share the session store, an access token also needs its refresh token family alive
+	err = refreshTokenFamilyCheck(aepr, sessionKey, sessionObject)
+	if err != nil {
+		return nil
+	}
+
+	err = impersonationEndPointCheck(aepr)
+	if err != nil {
+		return nil
+	}
+
+	err = passwordChangeRequiredCheck(aepr, sessionMustChangePassword(sessionObject))
+	if err != nil {
+		return nil
+	}
+
 	// Check privilege version — refresh session if stale
 	var sessionPrivilegeVersion int64
 	if v, ok := sessionObject["privilege_version"]; ok {
@@ -2032,7 +1098,7 @@ func (s *DxmSelf) MiddlewareUserLoggedAndPrivilegeCheck(aepr *api.DXAPIEndPointR
 			if configSystemSession, ok := configSystem["sessions"].(utils.JSON); ok {
 				if sessionKeyTTLAsInt, ok := configSystemSession["session_ttl_in_seconds"].(int); ok {
 					sessionKeyTTLAsDuration := time.Duration(sessionKeyTTLAsInt) * time.Second
-					_ = user_management.ModuleUserManagement.SessionRedis.Set(aepr.Context, sessionKey, newSessionObject, sessionKeyTTLAsDuration)
+					_ = sessionStore(aepr.Context, sessionKey, newSessionObject, sessionKeyTTLAsDuration)
 				}
 			}
 			sessionObject = newSessionObject
@@ -2047,22 +1113,9 @@ func (s *DxmSelf) MiddlewareUserLoggedAndPrivilegeCheck(aepr *api.DXAPIEndPointR
 	// Enforce read-only for SUSPENDED organizations
 	if sessionOrganization, ok := sessionObject["organization"].(utils.JSON); ok {
 		sessionOrgStatus, _ := utils.GetStringFromKV(sessionOrganization, "status")
-		if sessionOrgStatus == user_management.OrganizationStatusSuspended {
-			isReadOnly := false
-			for _, priv := range aepr.EndPoint.Privileges {
-				action := priv
-				if idx := strings.LastIndex(priv, "."); idx >= 0 {
-					action = priv[idx+1:]
-				}
-				if strings.HasSuffix(action, "LIST") || strings.HasSuffix(action, "READ") || strings.HasPrefix(action, "READ_BY_") || strings.HasSuffix(action, "DOWNLOAD") {
-					isReadOnly = true
-					break
-				}
-			}
-			if len(aepr.EndPoint.Privileges) > 0 && !isReadOnly {
-				aepr.WriteResponseAsErrorMessageNotLogged(http.StatusForbidden, "ORGANIZATION_SUSPENDED", "ORGANIZATION_IS_SUSPENDED_READ_ONLY")
-				return nil
-			}
+		if sessionOrgStatus == user_management.OrganizationStatusSuspended && !endPointIsReadOnly(aepr) {
+			aepr.WriteResponseAsErrorMessageNotLogged(http.StatusForbidden, "ORGANIZATION_SUSPENDED", "ORGANIZATION_IS_SUSPENDED_READ_ONLY")
+			return nil
 		}
 	}
 
@@ -2086,6 +1139,24 @@ func (s *DxmSelf) MiddlewareUserLoggedAndPrivilegeCheck(aepr *api.DXAPIEndPointR
 	return nil
 }
 
+// endPointIsReadOnly reports whether the endpoint is allowed for a SUSPENDED organization: no privilege required,
+// or a list, read or download privilege
+func endPointIsReadOnly(aepr *api.DXAPIEndPointRequest) bool {
+	if len(aepr.EndPoint.Privileges) == 0 {
+		return true
+	}
+	for _, priv := range aepr.EndPoint.Privileges {
+		action := priv
+		if idx := strings.LastIndex(priv, "."); idx >= 0 {
+			action = priv[idx+1:]
+		}
+		if strings.HasSuffix(action, "LIST") || strings.HasSuffix(action, "READ") || strings.HasPrefix(action, "READ_BY_") || strings.HasSuffix(action, "DOWNLOAD") {
+			return true
+		}
+	}
+	return false
+}
+
 func (s *DxmSelf) MiddlewareRequestRateLimitCheck(aepr *api.DXAPIEndPointRequest) (err error) {
 	rateLimitGroupNameId := aepr.EndPoint.RateLimitGroupNameId
 	// Bypass when ""
@@ -2126,6 +1197,19 @@ func (s *DxmSelf) MiddlewareRequestRateLimitCheck(aepr *api.DXAPIEndPointRequest
 }
 
 func (s *DxmSelf) SelfLogout(aepr *api.DXAPIEndPointRequest) (err error) {
+	// Logged in with a JWT access token: deny the token and end the session it was issued for
+	if claims, ok := aepr.LocalData["access_token_claims"].(map[string]any); ok {
+		jti, _ := claims["jti"].(string)
+		err = user_management.ModuleUserManagement.JWTDenyToken(aepr.Context, jti)
+		if err != nil {
+			return err
+		}
+		userId, _ := aepr.LocalData["user_id"].(int64)
+		sessionId, _ := aepr.LocalData["session_id"].(string)
+		_, err = user_management.ModuleUserManagement.SessionRevoke(aepr.Context, userId, sessionId)
+		return err
+	}
+
 	sessionKey, ok := aepr.LocalData["session_key"].(string)
 	if !ok {
 		return aepr.WriteResponseAndNewErrorf(http.StatusUnauthorized, "", "SESSION_KEY_IS_NOT_IN_REQUEST_PARAMETER")
@@ -2133,10 +1217,30 @@ func (s *DxmSelf) SelfLogout(aepr *api.DXAPIEndPointRequest) (err error) {
 	if sessionKey == "" {
 		return aepr.WriteResponseAndNewErrorf(http.StatusNotFound, "", "SESSION_KEY_IS_EMPTY")
 	}
+	if sessionObject, ok := aepr.LocalData["session_object"].(utils.JSON); ok {
+		familyId, _ := sessionObject["refresh_token_family_id"].(string)
+		family, err := user_management.ModuleUserManagement.RefreshTokenFamilyGet(aepr.Context, familyId)
+		if err != nil {
+			return err
+		}
+		if family != nil {
+			err = user_management.ModuleUserManagement.RefreshTokenFamilyRevoke(aepr.Context, family, user_management.SessionEndedReasonRefreshTokenRevoked)
+			if err != nil {
+				return err
+			}
+		}
+	}
 	err = user_management.ModuleUserManagement.SessionRedis.Delete(aepr.Context, sessionKey)
 	if err != nil {
 		return err
 	}
+	err = user_management.ModuleUserManagement.JWTDenySession(aepr.Context, sessionKey)
+	if err != nil {
+		return err
+	}
+	if userId, ok := aepr.LocalData["user_id"].(int64); ok {
+		_ = user_management.ModuleUserManagement.SessionIndexRemove(aepr.Context, userId, sessionKey)
+	}
 	return nil
 }
 
@@ -2196,7 +1300,7 @@ func (s *DxmSelf) SelfUpdateLanguage(aepr *api.DXAPIEndPointRequest) (err error)
 	sessionObject["language"] = language
 
 	// Save updated session back to Redis
-	err = user_management.ModuleUserManagement.SessionRedis.Set(aepr.Context, sessionKey, sessionObject, sessionKeyTTLAsDuration)
+	err = sessionStore(aepr.Context, sessionKey, sessionObject, sessionKeyTTLAsDuration)
 	if err != nil {
 		return err
 	}
@@ -2215,6 +1319,10 @@ func (s *DxmSelf) SelfUpdateLanguage(aepr *api.DXAPIEndPointRequest) (err error)
 }
 
 func (s *DxmSelf) SelfPasswordChange(aepr *api.DXAPIEndPointRequest) (err error) {
+	err = user_management.SessionImpersonationForbid(aepr)
+	if err != nil {
+		return err
+	}
 	_, preKeyIndex, err := aepr.GetParameterValueAsString("i")
 	if err != nil {
 		return err
@@ -2235,19 +1343,15 @@ func (s *DxmSelf) SelfPasswordChange(aepr *api.DXAPIEndPointRequest) (err error)
 	userPasswordNew := string(lvPayloadNewPassword.Value)
 	userPasswordOld := string(lvPayloadOldPassword.Value)
 
-	if user_management.ModuleUserManagement.OnUserFormatPasswordValidation != nil {
-		err = user_management.ModuleUserManagement.OnUserFormatPasswordValidation(userPasswordNew)
-		if err != nil {
-			return aepr.WriteResponseAndNewErrorf(http.StatusUnprocessableEntity,
-				"INVALID_PASSWORD_FORMAT",
-				"NOT_ERROR:INVALID_PASSWORD_FORMAT:DETAIL=%s", err.Error())
-		}
-	}
-
 	userId, err := utils.GetInt64FromKV(aepr.LocalData, "user_id")
 	if err != nil {
 		return err
 	}
+	user, _ := utils.GetVFromKV[utils.JSON](aepr.LocalData, "user")
+	err = s.passwordNewValidate(aepr, userId, user, userPasswordNew)
+	if err != nil {
+		return err
+	}
 	var verificationResult bool
 
 	err = databases.Manager.GetOrCreate(user_management.ModuleUserManagement.DatabaseNameId).Tx(aepr.Context, &aepr.Log, sql.LevelReadCommitted, func(tx *databases.DXDatabaseTx) (err error) {
@@ -2288,10 +1392,17 @@ func (s *DxmSelf) SelfPasswordChange(aepr *api.DXAPIEndPointRequest) (err error)
 	if err != nil {
 		return err
 	}
+
+	s.sessionRevokeOthersAfterPasswordChange(aepr, userId)
+	s.sessionMustChangePasswordClear(aepr)
 	return nil
 }
 
 func (s *DxmSelf) SelfPasswordChangeV2(aepr *api.DXAPIEndPointRequest) (err error) {
+	err = user_management.SessionImpersonationForbid(aepr)
+	if err != nil {
+		return err
+	}
 	_, userPasswordNew, err := aepr.GetParameterValueAsString("user_login_password_new")
 	if err != nil {
 		return err
@@ -2301,18 +1412,15 @@ func (s *DxmSelf) SelfPasswordChangeV2(aepr *api.DXAPIEndPointRequest) (err erro
 		return err
 	}
 
-	if user_management.ModuleUserManagement.OnUserFormatPasswordValidation != nil {
-		err = user_management.ModuleUserManagement.OnUserFormatPasswordValidation(userPasswordNew)
-		if err != nil {
-			return aepr.WriteResponseAndNewErrorf(http.StatusUnprocessableEntity,
-				"INVALID_PASSWORD_FORMAT",
-				"NOT_ERROR:INVALID_PASSWORD_FORMAT:DETAIL=%s", err.Error())
-		}
-	}
 	userId, err := utils.GetInt64FromKV(aepr.LocalData, "user_id")
 	if err != nil {
 		return err
 	}
+	user, _ := utils.GetVFromKV[utils.JSON](aepr.LocalData, "user")
+	err = s.passwordNewValidate(aepr, userId, user, userPasswordNew)
+	if err != nil {
+		return err
+	}
 	var verificationResult bool
 
 	err = databases.Manager.GetOrCreate(user_management.ModuleUserManagement.DatabaseNameId).Tx(aepr.Context, &aepr.Log, sql.LevelReadCommitted, func(tx *databases.DXDatabaseTx) (err error) {
@@ -2353,6 +1461,9 @@ func (s *DxmSelf) SelfPasswordChangeV2(aepr *api.DXAPIEndPointRequest) (err erro
 	if err != nil {
 		return err
 	}
+
+	s.sessionRevokeOthersAfterPasswordChange(aepr, userId)
+	s.sessionMustChangePasswordClear(aepr)
 	return nil
 }
 
@@ -0,0 +1,177 @@
+package self
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/donnyhardyanto/dxlib/api"
+	"github.com/donnyhardyanto/dxlib/configuration"
+	"github.com/donnyhardyanto/dxlib/utils"
+	"github.com/donnyhardyanto/dxlib_module/module/account_lockout"
+	"github.com/donnyhardyanto/dxlib_module/module/user_management"
+)
+
+const (
+	AuthSourceLocal    = "LOCAL"
+	AuthSourceExternal = "EXTERNAL"
+)
+
+// requestTrustedProxies returns system.trusted_proxies, the addresses (IP or CIDR) of the proxies allowed to set
+// X-Forwarded-For
+func requestTrustedProxies() (trustedProxies []netip.Prefix) {
+	configSystem, ok := configuration.Manager.Configurations["system"]
+	if !ok || configSystem.Data == nil {
+		return nil
+	}
+	for _, v := range configStringList((*configSystem.Data)["trusted_proxies"]) {
+		prefix, err := netip.ParsePrefix(v)
+		if err != nil {
+			addr, err := netip.ParseAddr(v)
+			if err != nil {
+				continue
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		trustedProxies = append(trustedProxies, prefix.Masked())
+	}
+	return trustedProxies
+}
+
+func requestIsTrustedProxy(trustedProxies []netip.Prefix, ipAddress string) bool {
+	addr, err := netip.ParseAddr(ipAddress)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestClientIPAddress returns the client address without port. X-Forwarded-For is only read from the transport
+// header of a connection coming from a trusted proxy, never from the E2EE payload, and its hops are taken from the
+// right up to the first one that is not a trusted proxy itself.
+func requestClientIPAddress(aepr *api.DXAPIEndPointRequest) string {
+	ipAddress, _, err := net.SplitHostPort(aepr.Request.RemoteAddr)
+	if err != nil {
+		ipAddress = aepr.Request.RemoteAddr
+	}
+	trustedProxies := requestTrustedProxies()
+	if !requestIsTrustedProxy(trustedProxies, ipAddress) {
+		return ipAddress
+	}
+	hops := strings.Split(strings.Join(aepr.Request.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		ipAddress = hop
+		if !requestIsTrustedProxy(trustedProxies, hop) {
+			break
+		}
+	}
+	return ipAddress
+}
+
+func requestUserAgent(aepr *api.DXAPIEndPointRequest) string {
+	userAgent := utils.GetStringFromMapStringStringDefault(aepr.EffectiveRequestHeader, "User-Agent", "")
+	if userAgent != "" {
+		return userAgent
+	}
+	return aepr.Request.UserAgent()
+}
+
+func (s *DxmSelf) accountLockoutIsActive() bool {
+	return s.AccountLockout != nil && s.AccountLockout.GetConfig() != nil && s.AccountLockout.GetConfig().Enabled
+}
+
+// accountLockoutCheck refuses the login when the request source is blocked or the account is locked.
+// user may be nil (unknown loginid), then only the source is checked. A non-nil error means the response has been written.
+func (s *DxmSelf) accountLockoutCheck(aepr *api.DXAPIEndPointRequest, user utils.JSON) (err error) {
+	if !s.accountLockoutIsActive() {
+		return nil
+	}
+	userId, _ := user["id"].(int64)
+
+	isLocked, remainingSeconds, lockScope, err := s.AccountLockout.CheckLockStatusForAttempt(userId, requestClientIPAddress(aepr), requestUserAgent(aepr))
+	if err != nil {
+		aepr.Log.Warnf("ACCOUNT_LOCKOUT_CHECK_ERROR:user_id=%d:%v", userId, err)
+	}
+	if !isLocked {
+		return nil
+	}
+
+	if remainingSeconds > 0 {
+		w := *aepr.ResponseWriter
+		w.Header().Set("Retry-After", fmt.Sprintf("%d", remainingSeconds))
+	}
+	if lockScope == account_lockout.LockScopeSource {
+		return aepr.WriteResponseAndNewErrorf(http.StatusTooManyRequests, "LOGIN_SOURCE_BLOCKED", "NOT_ERROR:LOGIN_SOURCE_BLOCKED")
+	}
+	return aepr.WriteResponseAndNewErrorf(http.StatusLocked, "ACCOUNT_LOCKED", "NOT_ERROR:ACCOUNT_LOCKED:USER_ID=%d", userId)
+}
+
+// accountLockoutCheckByLoginId is used on the OnAuthenticateUser path, where the local user row is not loaded before authentication
+func (s *DxmSelf) accountLockoutCheckByLoginId(aepr *api.DXAPIEndPointRequest, userLoginId string) (user utils.JSON, err error) {
+	if !s.accountLockoutIsActive() {
+		return nil, nil
+	}
+	_, user, err = user_management.ModuleUserManagement.User.SelectOne(aepr.Context, &aepr.Log, nil, utils.JSON{
+		"loginid": userLoginId,
+	}, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	err = s.accountLockoutCheck(aepr, user)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// accountLockoutRecordFailedAttempt records the failure against the account, or only against the source when user is nil
+func (s *DxmSelf) accountLockoutRecordFailedAttempt(aepr *api.DXAPIEndPointRequest, user utils.JSON, attemptLoginId string, organizationId int64, organizationUid string, attemptType string, attemptAuthSource string) {
+	if !s.accountLockoutIsActive() {
+		return
+	}
+	userId, ok := user["id"].(int64)
+	if !ok {
+		err := s.AccountLockout.RecordFailedAttemptUnknownUser(aepr, attemptLoginId, attemptType, requestClientIPAddress(aepr), requestUserAgent(aepr), attemptAuthSource)
+		if err != nil {
+			aepr.Log.Warnf("ACCOUNT_LOCKOUT_RECORD_FAILED_ATTEMPT_ERROR:loginid=%s:%v", attemptLoginId, err)
+		}
+		return
+	}
+	userUid, _ := user["uid"].(string)
+	userLoginId, _ := user["loginid"].(string)
+
+	err := s.AccountLockout.RecordFailedAttempt(aepr, userId, userUid, userLoginId, organizationId, organizationUid,
+		attemptType, requestClientIPAddress(aepr), requestUserAgent(aepr), attemptAuthSource)
+	if err != nil {
+		aepr.Log.Warnf("ACCOUNT_LOCKOUT_RECORD_FAILED_ATTEMPT_ERROR:user_id=%d:%v", userId, err)
+	}
+}
+
+func (s *DxmSelf) accountLockoutRecordSuccessfulLogin(aepr *api.DXAPIEndPointRequest, user utils.JSON) {
+	if !s.accountLockoutIsActive() || user == nil {
+		return
+	}
+	userId, ok := user["id"].(int64)
+	if !ok {
+		return
+	}
+	userUid, _ := user["uid"].(string)
+	userLoginId, _ := user["loginid"].(string)
+
+	err := s.AccountLockout.RecordSuccessfulLogin(aepr.Context, userId, userUid, userLoginId)
+	if err != nil {
+		aepr.Log.Warnf("ACCOUNT_LOCKOUT_RECORD_SUCCESSFUL_LOGIN_ERROR:user_id=%d:%v", userId, err)
+	}
+}
@@ -0,0 +1,41 @@
+package self
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/donnyhardyanto/dxlib/api"
+	"github.com/donnyhardyanto/dxlib/utils"
+	"github.com/donnyhardyanto/dxlib_module/module/audit_log"
+)
+
+const (
+	ActivityResultStatusSuccess = "SUCCESS"
+)
+
+// userActivityAudit records a self service activity of the logged user in audit_log.user_activity_log.
+// Best-effort: skipped when the audit module is not initialized, a failed insert is only logged.
+func (s *DxmSelf) userActivityAudit(aepr *api.DXAPIEndPointRequest, activityName string, activityResultStatus string) {
+	if audit_log.ModuleAuditLog.UserActivityLog == nil {
+		return
+	}
+	userId, _ := aepr.LocalData["user_id"].(int64)
+	now := time.Now()
+	_, _, err := audit_log.ModuleAuditLog.UserActivityLog.Insert(aepr.Context, &aepr.Log, utils.JSON{
+		"user_id":                userId,
+		"user_uid":               aepr.CurrentUser.Uid,
+		"user_loginid":           aepr.CurrentUser.LoginId,
+		"user_fullname":          aepr.CurrentUser.FullName,
+		"ip_address":             requestClientIPAddress(aepr),
+		"api_url":                aepr.EndPoint.Uri,
+		"method":                 aepr.Request.Method,
+		"status_code":            http.StatusOK,
+		"start_time":             now,
+		"end_time":               now,
+		"activity_name":          activityName,
+		"activity_result_status": activityResultStatus,
+	}, nil)
+	if err != nil {
+		aepr.Log.Warnf("USER_ACTIVITY_AUDIT_ERROR:%s:user_id=%d:%v", activityName, userId, err)
+	}
+}
@@ -0,0 +1,278 @@
+package self
+
+import (
+	"net/http"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/donnyhardyanto/dxlib/api"
+	"github.com/donnyhardyanto/dxlib/configuration"
+	"github.com/donnyhardyanto/dxlib/databases/db"
+	"github.com/donnyhardyanto/dxlib/utils"
+	"github.com/donnyhardyanto/dxlib_module/base"
+	"github.com/donnyhardyanto/dxlib_module/module/user_management"
+)
+
+const (
+	defaultImpersonationTTLInSeconds = 900
+
+	ActivityNameImpersonationStart = "IMPERSONATION_START"
+	ActivityNameImpersonationEnd   = "IMPERSONATION_END"
+)
+
+/*
+  - Impersonation ("login as")
+    SelfImpersonationStart, for holders of USER.IMPERSONATE, opens a new session as the target user next to the caller's
+    own session, which stays valid. The session object records impersonator_user_id, impersonator_user_uid,
+    impersonator_user_loginid and impersonator_session_key, and ends at impersonation_expires_at however active it is.
+    SelfImpersonationEnd ends it and returns the impersonator's session object, null when that session is gone.
+    - The target's effective privileges must be a subset of the caller's, so impersonation never widens access
+    - Not nested, and no JWT or refresh token is issued for an impersonated session
+    - Password change, 2FA changes, revoking other sessions, refresh token issue and organization switch are refused,
+      see user_management.SessionImpersonationForbid
+    - The session is listed with the target user's sessions (device IMPERSONATION:{impersonator loginid}), so the user
+      sees it and can revoke it; it is not counted against the session limit
+    - Start and end are written to audit_log.user_activity_log, and while impersonating CurrentUser.FullName reads
+      "{full name} (impersonated by {impersonator loginid})"
+
+  - Configuration, system.sessions.impersonation (optional)
+    ttl_in_seconds                : lifetime of an impersonated session, default 900
+    blocked_endpoint_uris         : further endpoints refused in an impersonated session
+*/
+
+type impersonationConfig struct {
+	TTLInSeconds        int
+	BlockedEndPointUris []string
+}
+
+func impersonationConfigGet() impersonationConfig {
+	cfg := impersonationConfig{
+		TTLInSeconds: defaultImpersonationTTLInSeconds,
+	}
+	configSystem, ok := configuration.Manager.Configurations["system"]
+	if !ok || configSystem.Data == nil {
+		return cfg
+	}
+	configSystemSession, err := utils.GetJSONFromKV(*configSystem.Data, "sessions")
+	if err != nil {
+		return cfg
+	}
+	configImpersonation, err := utils.GetJSONFromKV(configSystemSession, "impersonation")
+	if err != nil {
+		return cfg
+	}
+	if v, err := utils.GetIntFromKV(configImpersonation, "ttl_in_seconds"); err == nil && v > 0 {
+		cfg.TTLInSeconds = v
+	}
+	cfg.BlockedEndPointUris = configStringList(configImpersonation["blocked_endpoint_uris"])
+	return cfg
+}
+
+// sessionPrivilegeNameIds returns the effective privilege names of a session object, sorted
+func sessionPrivilegeNameIds(sessionObject utils.JSON) []string {
+	privilegeNameIds := []string{}
+	switch v := sessionObject["user_effective_privilege_ids"].(type) {
+	case map[string]int64:
+		for k := range v {
+			privilegeNameIds = append(privilegeNameIds, k)
+		}
+	case utils.JSON:
+		for k := range v {
+			privilegeNameIds = append(privilegeNameIds, k)
+		}
+	}
+	sort.Strings(privilegeNameIds)
+	return privilegeNameIds
+}
+
+// impersonationEndPointCheck refuses the configured blocked_endpoint_uris in an impersonated session. Used by the middleware,
+// a non-nil error means the response has been written.
+func impersonationEndPointCheck(aepr *api.DXAPIEndPointRequest) (err error) {
+	if !user_management.SessionIsImpersonated(aepr) {
+		return nil
+	}
+	if !slices.Contains(impersonationConfigGet().BlockedEndPointUris, aepr.EndPoint.Uri) {
+		return nil
+	}
+	return aepr.WriteResponseAndNewErrorf(http.StatusForbidden, "IMPERSONATION_FORBIDDEN", "NOT_ERROR:ENDPOINT_NOT_ALLOWED_IN_IMPERSONATED_SESSION:%s", aepr.EndPoint.Uri)
+}
+
+func (s *DxmSelf) SelfImpersonationStart(aepr *api.DXAPIEndPointRequest) (err error) {
+	err = user_management.SessionImpersonationForbid(aepr)
+	if err != nil {
+		return err
+	}
+	_, userUid, err := aepr.GetParameterValueAsString("user_uid")
+	if err != nil {
+		return err
+	}
+	_, organizationUidFilter, err := aepr.GetParameterValueAsString("organization_uid", "")
+	if err != nil {
+		return err
+	}
+
+	impersonatorSessionObject, ok := aepr.LocalData["session_object"].(utils.JSON)
+	if !ok {
+		return aepr.WriteResponseAndNewErrorf(http.StatusUnauthorized, "SESSION_KEY_EXPIRED", "NOT_ERROR:NO_SESSION_OBJECT")
+	}
+	impersonatorSessionKey, err := utils.GetStringFromKV(aepr.LocalData, "session_key")
+	if err != nil {
+		return err
+	}
+	impersonatorUserId, err := utils.GetInt64FromKV(aepr.LocalData, "user_id")
+	if err != nil {
+		return err
+	}
+	impersonatorPrivilegeNameIds := sessionPrivilegeNameIds(impersonatorSessionObject)
+	if !slices.Contains(impersonatorPrivilegeNameIds, base.PrivilegeNameIdImpersonateUser) {
+		return aepr.WriteResponseAndNewErrorf(http.StatusForbidden, "USER_ROLE_PRIVILEGE_FORBIDDEN", "NOT_ERROR:USER_ROLE_PRIVILEGE_FORBIDDEN")
+	}
+
+	_, user, err := user_management.ModuleUserManagement.User.GetByUid(aepr.Context, &aepr.Log, userUid)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return aepr.WriteResponseAndNewErrorf(http.StatusNotFound, "USER_NOT_FOUND", "NOT_ERROR:USER_NOT_FOUND:%s", userUid)
+	}
+	userId, err := utils.GetInt64FromKV(user, "id")
+	if err != nil {
+		return err
+	}
+	if userId == impersonatorUserId {
+		return aepr.WriteResponseAndNewErrorf(http.StatusUnprocessableEntity, "IMPERSONATION_OF_SELF", "NOT_ERROR:IMPERSONATION_OF_SELF")
+	}
+	userStatus, _ := utils.GetStringFromKV(user, "status")
+	if userStatus != user_management.UserStatusActive {
+		return aepr.WriteResponseAndNewErrorf(http.StatusUnprocessableEntity, "USER_NOT_ACTIVE", "NOT_ERROR:USER_IS_NOT_ACTIVE:%s", userUid)
+	}
+	userLoginId, _ := utils.GetStringFromKV(user, "loginid")
+
+	us := utils.JSON{
+		"user_id": userId,
+	}
+	if organizationUidFilter != "" {
+		us["organization_uid"] = organizationUidFilter
+	}
+	_, userOrganizationMemberships, err := user_management.ModuleUserManagement.UserOrganizationMembership.Select(aepr.Context, &aepr.Log, nil, us, nil,
+		db.DXDatabaseTableFieldsOrderBy{"order_index": "asc"}, nil, nil)
+	if err != nil {
+		return err
+	}
+	if len(userOrganizationMemberships) == 0 {
+		return aepr.WriteResponseAndNewErrorf(http.StatusUnprocessableEntity, "ORGANIZATION_MEMBERSHIP_NOT_FOUND", "NOT_ERROR:USER_HAS_NO_ORGANIZATION_MEMBERSHIP:%s", userUid)
+	}
+	organizationId, err := utils.GetInt64FromKV(userOrganizationMemberships[0], "organization_id")
+	if err != nil {
+		return err
+	}
+	organizationUid, err := utils.GetStringFromKV(userOrganizationMemberships[0], "organization_uid")
+	if err != nil {
+		return err
+	}
+	_, organization, err := user_management.ModuleUserManagement.Organization.ShouldGetById(aepr.Context, &aepr.Log, organizationId)
+	if err != nil {
+		return err
+	}
+
+	sessionKey, err := GenerateSessionKey()
+	if err != nil {
+		return err
+	}
+	a := []any{userOrganizationMemberships}
+	sessionObject, allowed, err := s.RegenerateSessionObject(aepr, userId, sessionKey, user, organizationId, organizationUid, organization, a)
+	if err != nil {
+		return aepr.WriteResponseAndNewErrorf(http.StatusUnauthorized, "SESSION_KEY_EXPIRED", "NOT_ERROR:SESSION_KEY_EXPIRED_%s", err.Error())
+	}
+	if !allowed {
+		return aepr.WriteResponseAndNewErrorf(http.StatusForbidden, "USER_ROLE_PRIVILEGE_FORBIDDEN", "NOT_ERROR:USER_ROLE_PRIVILEGE_FORBIDDEN")
+	}
+	for _, privilegeNameId := range sessionPrivilegeNameIds(sessionObject) {
+		if !slices.Contains(impersonatorPrivilegeNameIds, privilegeNameId) {
+			return aepr.WriteResponseAndNewErrorf(http.StatusForbidden, "IMPERSONATION_PRIVILEGE_ESCALATION", "NOT_ERROR:TARGET_USER_HAS_PRIVILEGE_NOT_HELD:%s", privilegeNameId)
+		}
+	}
+	if s.sessionMaintenanceModeRefuse(aepr, sessionObject) {
+		return nil
+	}
+
+	cfg := impersonationConfigGet()
+	impersonationTTL := time.Duration(cfg.TTLInSeconds) * time.Second
+	sessionObject["impersonator_user_id"] = impersonatorUserId
+	sessionObject["impersonator_user_uid"] = aepr.CurrentUser.Uid
+	sessionObject["impersonator_user_loginid"] = aepr.CurrentUser.LoginId
+	sessionObject["impersonator_session_key"] = impersonatorSessionKey
+	sessionObject["impersonation_expires_at"] = time.Now().Add(impersonationTTL).Unix()
+	sessionLifetimeImpersonationCap(sessionObject)
+
+	sessionKeyTTLAsDuration, err := sessionDefaultIdleTimeout()
+	if err != nil {
+		return err
+	}
+	err = sessionStore(aepr.Context, sessionKey, sessionObject, sessionKeyTTLAsDuration)
+	if err != nil {
+		return err
+	}
+	err = user_management.ModuleUserManagement.SessionIndexAdd(aepr.Context, userId, sessionKey, user_management.SessionDeviceImpersonationPrefix+aepr.CurrentUser.LoginId,
+		requestClientIPAddress(aepr), requestUserAgent(aepr), impersonationTTL)
+	if err != nil {
+		aepr.Log.Warnf("SESSION_INDEX_ADD_ERROR:user_id=%d:%v", userId, err)
+	}
+
+	aepr.Log.Infof("User %d started impersonating user %d", impersonatorUserId, userId)
+	s.userActivityAudit(aepr, ActivityNameImpersonationStart+":"+userLoginId, ActivityResultStatusSuccess)
+
+	aepr.WriteResponseAsJSON(http.StatusOK, nil, utils.JSON{
+		"session_object": sessionObject,
+	})
+	return nil
+}
+
+// SelfImpersonationEnd is called with the impersonated session, returns the impersonator's own session object
+func (s *DxmSelf) SelfImpersonationEnd(aepr *api.DXAPIEndPointRequest) (err error) {
+	if !user_management.SessionIsImpersonated(aepr) {
+		return aepr.WriteResponseAndNewErrorf(http.StatusConflict, "NOT_IMPERSONATING", "NOT_ERROR:SESSION_IS_NOT_IMPERSONATED")
+	}
+	sessionObject, ok := aepr.LocalData["session_object"].(utils.JSON)
+	if !ok {
+		return aepr.WriteResponseAndNewErrorf(http.StatusUnauthorized, "SESSION_KEY_EXPIRED", "NOT_ERROR:NO_SESSION_OBJECT")
+	}
+	sessionKey, err := utils.GetStringFromKV(aepr.LocalData, "session_key")
+	if err != nil {
+		return err
+	}
+	userId, err := utils.GetInt64FromKV(aepr.LocalData, "user_id")
+	if err != nil {
+		return err
+	}
+	impersonatorSessionKey, _ := utils.GetStringFromKV(sessionObject, "impersonator_session_key")
+
+	sessionKeyTTLAsDuration, err := sessionDefaultIdleTimeout()
+	if err != nil {
+		return err
+	}
+	err = user_management.ModuleUserManagement.SessionEnd(aepr.Context, sessionKey, user_management.SessionEndedReasonImpersonationEnded, sessionKeyTTLAsDuration)
+	if err != nil {
+		return err
+	}
+	err = user_management.ModuleUserManagement.SessionIndexRemove(aepr.Context, userId, sessionKey)
+	if err != nil {
+		aepr.Log.Warnf("SESSION_INDEX_REMOVE_ERROR:user_id=%d:%v", userId, err)
+	}
+
+	aepr.Log.Infof("User %v ended impersonating user %d", aepr.LocalData["impersonator_user_id"], userId)
+	s.userActivityAudit(aepr, ActivityNameImpersonationEnd, ActivityResultStatusSuccess)
+
+	var impersonatorSessionObject utils.JSON
+	if impersonatorSessionKey != "" {
+		impersonatorSessionObject, err = user_management.ModuleUserManagement.SessionRedis.Get(aepr.Context, impersonatorSessionKey)
+		if err != nil {
+			return err
+		}
+	}
+	aepr.WriteResponseAsJSON(http.StatusOK, nil, utils.JSON{
+		"session_object": impersonatorSessionObject,
+	})
+	return nil
+}
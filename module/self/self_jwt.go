@@ -0,0 +1,314 @@
+package self
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/donnyhardyanto/dxlib/api"
+	"github.com/donnyhardyanto/dxlib/configuration"
+	"github.com/donnyhardyanto/dxlib/errors"
+	"github.com/donnyhardyanto/dxlib/utils"
+	"github.com/donnyhardyanto/dxlib/utils/crypto/rand"
+	utilsJSON "github.com/donnyhardyanto/dxlib/utils/json"
+	"github.com/donnyhardyanto/dxlib_module/lib"
+	"github.com/donnyhardyanto/dxlib_module/module/user_management"
+)
+
+/*
+  - JWT access tokens, system.sessions.jwt (optional, off when absent or enabled is false)
+    enabled                      : true to return a "jwt" access token from the logins next to the session key
+    issuer                       : "iss" claim, checked on verification when set
+    access_token_ttl_in_seconds  : token lifetime, default 300; also the staleness bound for privileges, see below
+    signing_kid                  : kid of the key new tokens are signed with
+    keys                         : {kid: {"private_key": base64 Ed25519 seed or private key, "public_key": base64}}
+    Rotation: add the new key, make it signing_kid, keep the old kid with only its public_key for one token lifetime,
+    then drop it, and call JWTKeySetReload (or restart).
+
+  - Claims: iss, sub (user uid), jti, iat, exp, uid (user id), oid/ouid (organization id/uid), ost (organization status),
+    sid (session id of the login), pv (privilege version), prv (effective privilege names) and mcp (must change
+    password, only when true).
+    The middleware verifies a JWT bearer locally and checks only the denylist (one Redis MGET); it does not look up
+    the privilege version, so a privilege change reaches JWT clients when they next get a token from SelfLoginToken.
+    Suspending, deleting, revoking or logging out a session writes the denylist, see user_management_jwt_denylist.go.
+*/
+
+type jwtConfig struct {
+	Enabled                 bool
+	Issuer                  string
+	AccessTokenTTLInSeconds int
+}
+
+func jwtConfigGet() (cfg jwtConfig, configJWT utils.JSON) {
+	cfg = jwtConfig{
+		AccessTokenTTLInSeconds: user_management.DefaultJWTAccessTokenTTLInSeconds,
+	}
+	configSystem, ok := configuration.Manager.Configurations["system"]
+	if !ok || configSystem.Data == nil {
+		return cfg, nil
+	}
+	configSystemSession, err := utils.GetJSONFromKV(*configSystem.Data, "sessions")
+	if err != nil {
+		return cfg, nil
+	}
+	configJWT, err = utils.GetJSONFromKV(configSystemSession, "jwt")
+	if err != nil {
+		return cfg, nil
+	}
+	cfg.Enabled, _ = utils.GetBoolFromKV(configJWT, "enabled")
+	cfg.Issuer, _ = utils.GetStringFromKV(configJWT, "issuer")
+	if n, err := utils.GetIntFromKV(configJWT, "access_token_ttl_in_seconds"); err == nil && n > 0 {
+		cfg.AccessTokenTTLInSeconds = n
+	}
+	return cfg, configJWT
+}
+
+func jwtKeySetFromConfig(configJWT utils.JSON) (keySet *lib.JWTKeySet, err error) {
+	keySet = &lib.JWTKeySet{
+		PrivateKeys: map[string]ed25519.PrivateKey{},
+		PublicKeys:  map[string]ed25519.PublicKey{},
+	}
+	keySet.SigningKid, err = utils.GetStringFromKV(configJWT, "signing_kid")
+	if err != nil {
+		return nil, errors.New("CONFIG_SYSTEM_SESSIONS_JWT_SIGNING_KID_NOT_FOUND")
+	}
+	configKeys, err := utils.GetJSONFromKV(configJWT, "keys")
+	if err != nil {
+		return nil, errors.New("CONFIG_SYSTEM_SESSIONS_JWT_KEYS_NOT_FOUND")
+	}
+	for kid := range configKeys {
+		configKey, err := utils.GetJSONFromKV(configKeys, kid)
+		if err != nil {
+			return nil, errors.Errorf("CONFIG_SYSTEM_SESSIONS_JWT_KEY_INVALID:%s", kid)
+		}
+		if privateKeyAsBase64, _ := utils.GetStringFromKV(configKey, "private_key"); privateKeyAsBase64 != "" {
+			privateKeyAsBytes, err := base64.StdEncoding.DecodeString(privateKeyAsBase64)
+			if err != nil {
+				return nil, errors.Errorf("CONFIG_SYSTEM_SESSIONS_JWT_PRIVATE_KEY_INVALID:%s", kid)
+			}
+			var privateKey ed25519.PrivateKey
+			switch len(privateKeyAsBytes) {
+			case ed25519.SeedSize:
+				privateKey = ed25519.NewKeyFromSeed(privateKeyAsBytes)
+			case ed25519.PrivateKeySize:
+				privateKey = privateKeyAsBytes
+			default:
+				return nil, errors.Errorf("CONFIG_SYSTEM_SESSIONS_JWT_PRIVATE_KEY_INVALID_SIZE:%s", kid)
+			}
+			keySet.PrivateKeys[kid] = privateKey
+			keySet.PublicKeys[kid] = privateKey.Public().(ed25519.PublicKey)
+		}
+		if publicKeyAsBase64, _ := utils.GetStringFromKV(configKey, "public_key"); publicKeyAsBase64 != "" {
+			publicKeyAsBytes, err := base64.StdEncoding.DecodeString(publicKeyAsBase64)
+			if err != nil || len(publicKeyAsBytes) != ed25519.PublicKeySize {
+				return nil, errors.Errorf("CONFIG_SYSTEM_SESSIONS_JWT_PUBLIC_KEY_INVALID:%s", kid)
+			}
+			keySet.PublicKeys[kid] = publicKeyAsBytes
+		}
+	}
+	if _, ok := keySet.PrivateKeys[keySet.SigningKid]; !ok {
+		return nil, errors.Errorf("CONFIG_SYSTEM_SESSIONS_JWT_SIGNING_KEY_HAS_NO_PRIVATE_KEY:%s", keySet.SigningKid)
+	}
+	return keySet, nil
+}
+
+// JWTKeySetReload rebuilds the JWT key set from the configuration, call it after rotating keys.
+// With JWT access tokens off, the key set is cleared.
+func (s *DxmSelf) JWTKeySetReload() (err error) {
+	cfg, configJWT := jwtConfigGet()
+	if !cfg.Enabled {
+		s.jwtKeySet.Store(nil)
+		return nil
+	}
+	keySet, err := jwtKeySetFromConfig(configJWT)
+	if err != nil {
+		return err
+	}
+	s.jwtKeySet.Store(keySet)
+	return nil
+}
+
+// jwtKeySetGet returns the key set, loading it on first use; nil when JWT access tokens are off
+func (s *DxmSelf) jwtKeySetGet() (keySet *lib.JWTKeySet, err error) {
+	keySet = s.jwtKeySet.Load()
+	if keySet != nil {
+		return keySet, nil
+	}
+	s.jwtKeySetMutex.Lock()
+	defer s.jwtKeySetMutex.Unlock()
+	keySet = s.jwtKeySet.Load()
+	if keySet != nil {
+		return keySet, nil
+	}
+	err = s.JWTKeySetReload()
+	if err != nil {
+		return nil, err
+	}
+	return s.jwtKeySet.Load(), nil
+}
+
+// jwtAccessTokenAttach adds the "jwt" access token and "jwt_expires_at" to a login response built from sessionObject, when JWT access tokens are on.
+// Called after the session object has been stored, so the token is not kept in Redis.
+func (s *DxmSelf) jwtAccessTokenAttach(aepr *api.DXAPIEndPointRequest, sessionObject utils.JSON) (err error) {
+	keySet, err := s.jwtKeySetGet()
+	if err != nil {
+		return err
+	}
+	if keySet == nil {
+		return nil
+	}
+	// The JWT middleware does not read the session object, an impersonated session stays on its session key
+	if _, ok := sessionObject["impersonator_user_id"]; ok {
+		return nil
+	}
+	cfg, _ := jwtConfigGet()
+
+	sessionKey, err := utils.GetStringFromKV(sessionObject, "session_key")
+	if err != nil {
+		return err
+	}
+	userId, err := utilsJSON.GetInt64(sessionObject, "user_id")
+	if err != nil {
+		return err
+	}
+	user, err := utils.GetVFromKV[utils.JSON](sessionObject, "user")
+	if err != nil {
+		return err
+	}
+	organization, err := utils.GetVFromKV[utils.JSON](sessionObject, "organization")
+	if err != nil {
+		return err
+	}
+	userUid, _ := utilsJSON.GetString(user, "uid")
+	organizationId, _ := utilsJSON.GetInt64(sessionObject, "organization_id")
+	organizationUid, _ := utilsJSON.GetString(sessionObject, "organization_uid")
+	organizationStatus, _ := utilsJSON.GetString(organization, "status")
+	privilegeVersion, _ := utilsJSON.GetInt64(sessionObject, "privilege_version")
+
+	privilegeNameIds := sessionPrivilegeNameIds(sessionObject)
+
+	now := time.Now()
+	expiresAt := now.Add(time.Duration(cfg.AccessTokenTTLInSeconds) * time.Second).Unix()
+	// Never outlive the login the token was issued for
+	if absoluteExpiresAt, err := utilsJSON.GetInt64(sessionObject, "absolute_expires_at"); err == nil && absoluteExpiresAt > 0 && absoluteExpiresAt < expiresAt {
+		expiresAt = absoluteExpiresAt
+	}
+	claims := map[string]any{
+		"sub":  userUid,
+		"jti":  hex.EncodeToString(rand.RandomData(16)),
+		"iat":  now.Unix(),
+		"exp":  expiresAt,
+		"uid":  userId,
+		"oid":  organizationId,
+		"ouid": organizationUid,
+		"ost":  organizationStatus,
+		"sid":  user_management.SessionIdFromSessionKey(sessionKey),
+		"pv":   privilegeVersion,
+		"prv":  privilegeNameIds,
+	}
+	if cfg.Issuer != "" {
+		claims["iss"] = cfg.Issuer
+	}
+	if sessionMustChangePassword(sessionObject) {
+		claims["mcp"] = true
+	}
+	accessToken, err := keySet.Sign(claims)
+	if err != nil {
+		return err
+	}
+	sessionObject["jwt"] = accessToken
+	sessionObject["jwt_expires_at"] = expiresAt
+	return nil
+}
+
+func jwtClaimInt64(claims map[string]any, name string) (int64, error) {
+	n, ok := claims[name].(json.Number)
+	if !ok {
+		return 0, errors.Errorf("JWT_CLAIM_NOT_FOUND:%s", name)
+	}
+	return n.Int64()
+}
+
+// jwtMiddlewareUserLoggedAndPrivilegeCheck is MiddlewareUserLoggedAndPrivilegeCheck for a JWT bearer: the token is
+// verified locally and aepr.LocalData is filled from its claims instead of from the session object.
+// "session_object" and "session_key" are not set, endpoints that need them only work with a session key.
+func (s *DxmSelf) jwtMiddlewareUserLoggedAndPrivilegeCheck(aepr *api.DXAPIEndPointRequest, keySet *lib.JWTKeySet, token string) (err error) {
+	claims, err := keySet.Verify(token, time.Now())
+	if err != nil {
+		if err == lib.ErrJWTExpired {
+			aepr.WriteResponseAsErrorMessageNotLogged(http.StatusUnauthorized, "ACCESS_TOKEN_EXPIRED", "NOT_ERROR:ACCESS_TOKEN_EXPIRED")
+			return nil
+		}
+		aepr.WriteResponseAsErrorMessageNotLogged(http.StatusUnauthorized, "ACCESS_TOKEN_INVALID", "NOT_ERROR:ACCESS_TOKEN_INVALID:"+err.Error())
+		return nil
+	}
+	cfg, _ := jwtConfigGet()
+	if issuer, _ := claims["iss"].(string); cfg.Issuer != "" && issuer != cfg.Issuer {
+		aepr.WriteResponseAsErrorMessageNotLogged(http.StatusUnauthorized, "ACCESS_TOKEN_INVALID", "NOT_ERROR:ACCESS_TOKEN_INVALID:ISSUER")
+		return nil
+	}
+	userId, err := jwtClaimInt64(claims, "uid")
+	if err != nil {
+		return aepr.WriteResponseAndNewErrorf(http.StatusUnauthorized, "ACCESS_TOKEN_INVALID", "NOT_ERROR:ACCESS_TOKEN_INVALID:%s", err.Error())
+	}
+	organizationId, err := jwtClaimInt64(claims, "oid")
+	if err != nil {
+		return aepr.WriteResponseAndNewErrorf(http.StatusUnauthorized, "ACCESS_TOKEN_INVALID", "NOT_ERROR:ACCESS_TOKEN_INVALID:%s", err.Error())
+	}
+	issuedAt, err := jwtClaimInt64(claims, "iat")
+	if err != nil {
+		return aepr.WriteResponseAndNewErrorf(http.StatusUnauthorized, "ACCESS_TOKEN_INVALID", "NOT_ERROR:ACCESS_TOKEN_INVALID:%s", err.Error())
+	}
+	jti, _ := claims["jti"].(string)
+	sessionId, _ := claims["sid"].(string)
+	userUid, _ := claims["sub"].(string)
+	organizationUid, _ := claims["ouid"].(string)
+	organizationStatus, _ := claims["ost"].(string)
+	mustChangePassword, _ := claims["mcp"].(bool)
+
+	isDenied, err := user_management.ModuleUserManagement.JWTIsDenied(aepr.Context, jti, sessionId, userId, issuedAt)
+	if err != nil {
+		return err
+	}
+	if isDenied {
+		aepr.WriteResponseAsErrorMessageNotLogged(http.StatusUnauthorized, "ACCESS_TOKEN_REVOKED", "NOT_ERROR:ACCESS_TOKEN_REVOKED")
+		return nil
+	}
+
+	userEffectivePrivilegeIds := utils.JSON{}
+	if privilegeNameIds, ok := claims["prv"].([]any); ok {
+		for _, v := range privilegeNameIds {
+			if privilegeNameId, ok := v.(string); ok {
+				userEffectivePrivilegeIds[privilegeNameId] = true
+			}
+		}
+	}
+
+	aepr.LocalData["access_token_claims"] = claims
+	aepr.LocalData["session_id"] = sessionId
+	aepr.LocalData["user_id"] = userId
+	aepr.LocalData["user_uid"] = userUid
+	aepr.LocalData["organization_id"] = organizationId
+	aepr.LocalData["organization_uid"] = organizationUid
+	aepr.LocalData["user_effective_privilege_ids"] = userEffectivePrivilegeIds
+
+	aepr.CurrentUser.Id = utils.Int64ToString(userId)
+	aepr.CurrentUser.Uid = userUid
+	aepr.CurrentUser.OrganizationId = utils.Int64ToString(organizationId)
+	aepr.CurrentUser.OrganizationUid = organizationUid
+
+	if organizationStatus == user_management.OrganizationStatusSuspended && !endPointIsReadOnly(aepr) {
+		aepr.WriteResponseAsErrorMessageNotLogged(http.StatusForbidden, "ORGANIZATION_SUSPENDED", "ORGANIZATION_IS_SUSPENDED_READ_ONLY")
+		return nil
+	}
+
+	err = passwordChangeRequiredCheck(aepr, mustChangePassword)
+	if err != nil {
+		return nil
+	}
+
+	return s.CheckMaintenanceMode(aepr, userEffectivePrivilegeIds)
+}
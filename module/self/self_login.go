@@ -0,0 +1,527 @@
+package self
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+
+	"github.com/donnyhardyanto/dxlib/api"
+	"github.com/donnyhardyanto/dxlib/databases/db"
+	"github.com/donnyhardyanto/dxlib/utils"
+	"github.com/donnyhardyanto/dxlib/utils/lv"
+	"github.com/donnyhardyanto/dxlib_module/base"
+	"github.com/donnyhardyanto/dxlib_module/module/account_lockout"
+	"github.com/donnyhardyanto/dxlib_module/module/user_management"
+)
+
+/*
+  - Login pipeline
+    Every SelfLogin* endpoint is a LoginPipeline run by LoginPipelineRun, in this order:
+    1. Credentials    reads login id, password and organization uid filter, and whether the response is E2EE
+    2. PreChecks      run before any credential is verified (captcha)
+    3. Authenticator  verifies the credentials with the account lockout bookkeeping, and resolves the user,
+                      the organization logged into and the organization memberships
+    4. PostChecks     run on the authenticated user before a session exists (session limit, password age, 2FA challenge)
+    5. loginSessionIssue builds the session object, refuses it without privileges or in maintenance mode,
+                      stores and registers it, and only then records the successful login for the account lockout
+    A stage that refuses the login writes the response and returns an error. A stage that answers the request itself,
+    like a 2FA challenge or an invalid captcha, returns isDone and the login ends there without a session.
+    A new login type composes the existing stages or adds one; the SelfLogin* endpoints are thin wrappers.
+*/
+
+// LoginE2EEEnvelope is what the E2EE credential stages keep to encrypt the response with
+type LoginE2EEEnvelope struct {
+	PreKeyIndex           string
+	EdB0PrivateKeyAsBytes []byte
+	SharedKey2AsBytes     []byte
+}
+
+// LoginAttempt is the state passed along the stages of a LoginPipeline
+type LoginAttempt struct {
+	// Set by the credential stage
+	UserLoginId           string
+	UserPassword          string
+	OrganizationUidFilter string
+	CaptchaId             string
+	CaptchaText           string
+	PreKeyData            utils.JSON
+	E2EE                  *LoginE2EEEnvelope // nil for a plain JSON login
+
+	// Set by the authenticator
+	User                        utils.JSON
+	UserId                      int64
+	UserOrganizationMemberships []utils.JSON
+	OrganizationId              int64
+	OrganizationUid             string
+	Organization                utils.JSON
+}
+
+type LoginStage func(aepr *api.DXAPIEndPointRequest, attempt *LoginAttempt) (isDone bool, err error)
+
+type LoginPipeline struct {
+	Credentials   LoginStage
+	PreChecks     []LoginStage
+	Authenticator LoginStage   // nil: LoginAuthenticate
+	PostChecks    []LoginStage // nil: LoginPostChecksDefault
+}
+
+func (s *DxmSelf) LoginPostChecksDefault() []LoginStage {
+	return []LoginStage{s.LoginCheckSessionLimit, s.LoginCheckPasswordAge, s.LoginCheckTwoFactor}
+}
+
+func (s *DxmSelf) LoginPipelineRun(aepr *api.DXAPIEndPointRequest, pipeline LoginPipeline) (err error) {
+	authenticator := pipeline.Authenticator
+	if authenticator == nil {
+		authenticator = s.LoginAuthenticate
+	}
+	postChecks := pipeline.PostChecks
+	if postChecks == nil {
+		postChecks = s.LoginPostChecksDefault()
+	}
+
+	stages := []LoginStage{pipeline.Credentials}
+	stages = append(stages, pipeline.PreChecks...)
+	stages = append(stages, authenticator)
+	stages = append(stages, postChecks...)
+
+	attempt := &LoginAttempt{}
+	for _, stage := range stages {
+		isDone, err := stage(aepr, attempt)
+		if err != nil {
+			return err
+		}
+		if isDone {
+			return nil
+		}
+	}
+
+	sessionObject, err := s.loginSessionIssue(aepr, attempt)
+	if err != nil {
+		return err
+	}
+	if sessionObject == nil {
+		return nil
+	}
+	return loginWriteResponse(aepr, attempt.E2EE, sessionObject, "session_object")
+}
+
+// loginWriteResponse writes payload E2EE encrypted when the login came in E2EE, otherwise as JSON,
+// under plainKey when it is not empty
+func loginWriteResponse(aepr *api.DXAPIEndPointRequest, e2ee *LoginE2EEEnvelope, payload utils.JSON, plainKey string) (err error) {
+	if e2ee == nil {
+		if plainKey != "" {
+			payload = utils.JSON{
+				plainKey: payload,
+			}
+		}
+		aepr.WriteResponseAsJSON(http.StatusOK, nil, payload)
+		return nil
+	}
+
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	lvPayload, err := lv.NewLV(payloadJSON)
+	if err != nil {
+		return err
+	}
+
+	if api.OnE2EEPrekeyPack == nil {
+		return aepr.WriteResponseAndNewErrorf(http.StatusUnprocessableEntity, "NOT_IMPLEMENTED", "NOT_IMPLEMENTED:OnE2EEPrekeyPack_IS_NIL:%v", aepr.EndPoint.EndPointType)
+	}
+
+	dataBlockEnvelopeAsHexString, err := api.OnE2EEPrekeyPack(aepr, e2ee.PreKeyIndex, e2ee.EdB0PrivateKeyAsBytes, e2ee.SharedKey2AsBytes, lvPayload)
+	if err != nil {
+		return err
+	}
+
+	aepr.WriteResponseAsJSON(http.StatusOK, nil, utils.JSON{
+		"d": dataBlockEnvelopeAsHexString,
+	})
+	return nil
+}
+
+/* Credential stages */
+
+// loginE2EEUnpack reads the "i" and "d" parameters and decrypts the payload; unpackErrorCode is the code the client
+// gets when decryption fails, so it knows whether to redo the prelogin or refresh the captcha
+func loginE2EEUnpack(aepr *api.DXAPIEndPointRequest, attempt *LoginAttempt, unpackErrorCode string) (lvPayloadElements []*lv.LV, err error) {
+	_, preKeyIndex, err := aepr.GetParameterValueAsString("i")
+	if err != nil {
+		return nil, err
+	}
+	_, dataAsHexString, err := aepr.GetParameterValueAsString("d")
+	if err != nil {
+		return nil, err
+	}
+
+	if api.OnE2EEPrekeyUnPack == nil {
+		return nil, aepr.WriteResponseAndNewErrorf(http.StatusUnprocessableEntity, "NOT_IMPLEMENTED", "NOT_IMPLEMENTED:OnE2EEPrekeyUnPack_IS_NIL:%v", aepr.EndPoint.EndPointType)
+	}
+
+	lvPayloadElements, sharedKey2AsBytes, edB0PrivateKeyAsBytes, preKeyData, err := api.OnE2EEPrekeyUnPack(aepr, preKeyIndex, dataAsHexString)
+	if err != nil {
+		return nil, aepr.WriteResponseAndNewErrorf(http.StatusUnprocessableEntity, unpackErrorCode, "NOT_ERROR:UNPACK_ERROR:%v", err.Error())
+	}
+
+	attempt.PreKeyData = preKeyData
+	attempt.E2EE = &LoginE2EEEnvelope{
+		PreKeyIndex:           preKeyIndex,
+		EdB0PrivateKeyAsBytes: edB0PrivateKeyAsBytes,
+		SharedKey2AsBytes:     sharedKey2AsBytes,
+	}
+	return lvPayloadElements, nil
+}
+
+// LoginCredentialsE2EE reads LV(login id),LV(password)[,LV(organization uid)] from an E2EE payload
+func (s *DxmSelf) LoginCredentialsE2EE(aepr *api.DXAPIEndPointRequest, attempt *LoginAttempt) (isDone bool, err error) {
+	lvPayloadElements, err := loginE2EEUnpack(aepr, attempt, "INVALID_PREKEY")
+	if err != nil {
+		return false, err
+	}
+	if len(lvPayloadElements) < 2 {
+		return false, aepr.WriteResponseAndNewErrorf(http.StatusUnprocessableEntity, "INVALID_PAYLOAD", "NOT_ERROR:PAYLOAD_ELEMENTS_LESS_THAN_2")
+	}
+
+	attempt.UserLoginId = string(lvPayloadElements[0].Value)
+	attempt.UserPassword = string(lvPayloadElements[1].Value)
+	if len(lvPayloadElements) > 2 {
+		attempt.OrganizationUidFilter = string(lvPayloadElements[2].Value)
+	}
+	return false, nil
+}
+
+// LoginCredentialsE2EECaptcha reads LV(login id),LV(password),LV(organization uid),LV(captcha id),LV(captcha text)
+// from an E2EE payload whose prekey was issued together with a captcha
+func (s *DxmSelf) LoginCredentialsE2EECaptcha(aepr *api.DXAPIEndPointRequest, attempt *LoginAttempt) (isDone bool, err error) {
+	lvPayloadElements, err := loginE2EEUnpack(aepr, attempt, "REFRESH_CAPTCHA")
+	if err != nil {
+		return false, err
+	}
+	if attempt.PreKeyData == nil {
+		return false, aepr.WriteResponseAndNewErrorf(http.StatusUnprocessableEntity, "REFRESH_CAPTCHA", "NOT_ERROR:UNPACK_ERROR:PREKEY_NOT_FOUND")
+	}
+	if len(lvPayloadElements) < 5 {
+		return false, aepr.WriteResponseAndNewErrorf(http.StatusUnprocessableEntity, "INVALID_PAYLOAD", "NOT_ERROR:PAYLOAD_ELEMENTS_LESS_THAN_5")
+	}
+
+	attempt.UserLoginId = string(lvPayloadElements[0].Value)
+	attempt.UserPassword = string(lvPayloadElements[1].Value)
+	attempt.OrganizationUidFilter = string(lvPayloadElements[2].Value)
+	attempt.CaptchaId = string(lvPayloadElements[3].Value)
+	attempt.CaptchaText = string(lvPayloadElements[4].Value)
+	return false, nil
+}
+
+// LoginCredentialsE2EEHeaderBody reads an E2EE payload of LV(base64 JSON request header),LV(base64 JSON body);
+// the body carries user_login_id, user_login_password, organization_uid, captcha_id and captcha_text
+func (s *DxmSelf) LoginCredentialsE2EEHeaderBody(aepr *api.DXAPIEndPointRequest, attempt *LoginAttempt) (isDone bool, err error) {
+	lvPayloadElements, err := loginE2EEUnpack(aepr, attempt, "INVALID_PREKEY")
+	if err != nil {
+		return false, err
+	}
+	if len(lvPayloadElements) < 2 {
+		return false, aepr.WriteResponseAndNewErrorf(http.StatusUnprocessableEntity, "INVALID_PAYLOAD", "NOT_ERROR:PAYLOAD_ELEMENTS_LESS_THAN_2")
+	}
+	lvPayloadHeader := lvPayloadElements[0]
+	lvPayloadBody := lvPayloadElements[1]
+
+	payLoadHeaderAsBytes, err := base64.StdEncoding.DecodeString(string(lvPayloadHeader.Value))
+	if err != nil {
+		return false, aepr.WriteResponseAndNewErrorf(http.StatusUnprocessableEntity, "DATA_CORRUPT", "DATA_CORRUPT:INVALID_DECODED_PAYLOAD_HEADER_FROM_BASE64")
+	}
+	payloadHeader := map[string]string{}
+	err = json.Unmarshal(payLoadHeaderAsBytes, &payloadHeader)
+	if err != nil {
+		return false, aepr.WriteResponseAndNewErrorf(http.StatusUnprocessableEntity, "DATA_CORRUPT", "DATA_CORRUPT:INVALID_UNMARSHAL_PAYLOAD_HEADER_BYTES")
+	}
+
+	aepr.EncryptionParameters = utils.JSON{
+		"PRE_KEY_INDEX":              attempt.E2EE.PreKeyIndex,
+		"SHARED_KEY_2_AS_BYTES":      attempt.E2EE.SharedKey2AsBytes,
+		"ED_B0_PRIVATE_KEY_AS_BYTES": attempt.E2EE.EdB0PrivateKeyAsBytes,
+		"PRE_KEY_DATA":               attempt.PreKeyData,
+	}
+	aepr.EffectiveRequestHeader = payloadHeader
+
+	payLoadBodyAsBytes, err := base64.StdEncoding.DecodeString(string(lvPayloadBody.Value))
+	if err != nil {
+		return false, aepr.WriteResponseAndNewErrorf(http.StatusUnprocessableEntity, "DATA_CORRUPT", "DATA_CORRUPT:INVALID_DECODED_PAYLOAD_BODY_FROM_BASE64")
+	}
+	payloadBodyAsJSON := utils.JSON{}
+	err = json.Unmarshal(payLoadBodyAsBytes, &payloadBodyAsJSON)
+	if err != nil {
+		return false, aepr.WriteResponseAndNewErrorf(http.StatusUnprocessableEntity, "DATA_CORRUPT", "DATA_CORRUPT:INVALID_UNMARSHAL_PAYLOAD_BODY_BYTES")
+	}
+
+	for k, v := range map[string]*string{
+		"user_login_id":       &attempt.UserLoginId,
+		"user_login_password": &attempt.UserPassword,
+		"organization_uid":    &attempt.OrganizationUidFilter,
+		"captcha_id":          &attempt.CaptchaId,
+		"captcha_text":        &attempt.CaptchaText,
+	} {
+		*v, err = utils.GetStringFromKV(payloadBodyAsJSON, k)
+		if err != nil {
+			return false, aepr.WriteResponseAndNewErrorf(http.StatusUnprocessableEntity, "DATA_CORRUPT", "DATA_CORRUPT:INVALID_UNMARSHAL_PAYLOAD_BODY_BYTES")
+		}
+	}
+
+	if attempt.PreKeyData == nil {
+		return false, aepr.WriteResponseAndNewErrorf(http.StatusUnprocessableEntity, "INVALID_PREKEY", "NOT_ERROR:UNPACK_ERROR:PREKEY_NOT_FOUND")
+	}
+	return false, nil
+}
+
+// LoginCredentialsPlain reads the user_login_id, user_login_password and organization_uid parameters
+func (s *DxmSelf) LoginCredentialsPlain(aepr *api.DXAPIEndPointRequest, attempt *LoginAttempt) (isDone bool, err error) {
+	_, attempt.UserLoginId, err = aepr.GetParameterValueAsString("user_login_id")
+	if err != nil {
+		return false, err
+	}
+	_, attempt.UserPassword, err = aepr.GetParameterValueAsString("user_login_password")
+	if err != nil {
+		return false, err
+	}
+	_, attempt.OrganizationUidFilter, err = aepr.GetParameterValueAsString("organization_uid", "")
+	if err != nil {
+		return false, err
+	}
+	return false, nil
+}
+
+// LoginCredentialsPlainCaptcha is LoginCredentialsPlain plus captcha_id and captcha_text, on an endpoint whose
+// request envelope was decrypted with a captcha prekey
+func (s *DxmSelf) LoginCredentialsPlainCaptcha(aepr *api.DXAPIEndPointRequest, attempt *LoginAttempt) (isDone bool, err error) {
+	isDone, err = s.LoginCredentialsPlain(aepr, attempt)
+	if err != nil || isDone {
+		return isDone, err
+	}
+	_, attempt.CaptchaId, err = aepr.GetParameterValueAsString("captcha_id", "")
+	if err != nil {
+		return false, err
+	}
+	_, attempt.CaptchaText, err = aepr.GetParameterValueAsString("captcha_text", "")
+	if err != nil {
+		return false, err
+	}
+	attempt.PreKeyData, _ = aepr.EncryptionParameters["PRE_KEY_DATA"].(utils.JSON)
+	return false, nil
+}
+
+/* Pre-checks */
+
+// LoginCheckCaptcha compares the captcha answer with the one stored with the prekey
+func (s *DxmSelf) LoginCheckCaptcha(aepr *api.DXAPIEndPointRequest, attempt *LoginAttempt) (isDone bool, err error) {
+	if attempt.PreKeyData == nil {
+		return false, aepr.WriteResponseAndNewErrorf(http.StatusUnprocessableEntity, "REFRESH_CAPTCHA", "NOT_ERROR:CAPTCHA_PREKEY_DATA_NOT_FOUND")
+	}
+	storedCaptchaId, err := utils.GetStringFromKV(attempt.PreKeyData, "captcha_id")
+	if err != nil {
+		return false, err
+	}
+	storedCaptchaText, err := utils.GetStringFromKV(attempt.PreKeyData, "captcha_text")
+	if err != nil {
+		return false, err
+	}
+
+	if attempt.CaptchaId != storedCaptchaId || attempt.CaptchaText != storedCaptchaText {
+		aepr.WriteResponseAsErrorMessageNotLogged(http.StatusUnprocessableEntity, "INVALID_CAPTCHA", "NOT_ERROR:INVALID_CAPTCHA")
+		return true, nil
+	}
+	return false, nil
+}
+
+/* Authenticators */
+
+// LoginAuthenticate uses OnAuthenticateUser when the application sets it, the local password otherwise
+func (s *DxmSelf) LoginAuthenticate(aepr *api.DXAPIEndPointRequest, attempt *LoginAttempt) (isDone bool, err error) {
+	if s.OnAuthenticateUser != nil {
+		return s.LoginAuthenticateExternal(aepr, attempt)
+	}
+	return s.LoginAuthenticateLocal(aepr, attempt)
+}
+
+// LoginAuthenticateExternal verifies the credentials with OnAuthenticateUser (LDAP, SSO, ...), which also picks the organization
+func (s *DxmSelf) LoginAuthenticateExternal(aepr *api.DXAPIEndPointRequest, attempt *LoginAttempt) (isDone bool, err error) {
+	lockoutUser, err := s.accountLockoutCheckByLoginId(aepr, attempt.UserLoginId)
+	if err != nil {
+		return false, err
+	}
+	verificationResult, user, organization, err := s.OnAuthenticateUser(aepr, attempt.UserLoginId, attempt.UserPassword, attempt.OrganizationUidFilter)
+	if err != nil {
+		return false, err
+	}
+	if !verificationResult {
+		s.accountLockoutRecordFailedAttempt(aepr, lockoutUser, attempt.UserLoginId, 0, "", account_lockout.AttemptTypeLDAP, AuthSourceExternal)
+		return false, aepr.WriteResponseAndNewErrorf(http.StatusUnauthorized, base.MsgInvalidCredential, base.LogMsgNotErrorInvalidCredential)
+	}
+
+	attempt.User = user
+	attempt.UserId, err = utils.GetInt64FromKV(user, "id")
+	if err != nil {
+		return false, err
+	}
+	attempt.UserOrganizationMemberships, err = loginUserOrganizationMembershipsGet(aepr, attempt.UserId, attempt.OrganizationUidFilter)
+	if err != nil {
+		return false, err
+	}
+	attempt.Organization = organization
+	attempt.OrganizationId, err = utils.GetInt64FromKV(organization, "id")
+	if err != nil {
+		return false, err
+	}
+	attempt.OrganizationUid, err = utils.GetStringFromKV(organization, "uid")
+	if err != nil {
+		return false, err
+	}
+	return false, nil
+}
+
+// LoginAuthenticateLocal verifies the password stored in user_management and logs into the first organization membership
+func (s *DxmSelf) LoginAuthenticateLocal(aepr *api.DXAPIEndPointRequest, attempt *LoginAttempt) (isDone bool, err error) {
+	_, user, err := user_management.ModuleUserManagement.User.SelectOne(aepr.Context, &aepr.Log, nil, utils.JSON{
+		"loginid": attempt.UserLoginId,
+	}, nil, nil)
+	if err != nil {
+		return false, err
+	}
+	err = s.accountLockoutCheck(aepr, user)
+	if err != nil {
+		return false, err
+	}
+	if user == nil {
+		s.accountLockoutRecordFailedAttempt(aepr, nil, attempt.UserLoginId, 0, "", account_lockout.AttemptTypePassword, AuthSourceLocal)
+		return false, aepr.WriteResponseAndNewErrorf(http.StatusUnauthorized, base.MsgInvalidCredential, base.LogMsgNotErrorInvalidCredential)
+	}
+
+	attempt.User = user
+	attempt.UserId, err = utils.GetInt64FromKV(user, "id")
+	if err != nil {
+		return false, err
+	}
+	attempt.UserOrganizationMemberships, err = loginUserOrganizationMembershipsGet(aepr, attempt.UserId, attempt.OrganizationUidFilter)
+	if err != nil {
+		return false, err
+	}
+	attempt.OrganizationId, err = utils.GetInt64FromKV(attempt.UserOrganizationMemberships[0], "organization_id")
+	if err != nil {
+		return false, err
+	}
+	attempt.OrganizationUid, err = utils.GetStringFromKV(attempt.UserOrganizationMemberships[0], "organization_uid")
+	if err != nil {
+		return false, err
+	}
+	_, attempt.Organization, err = user_management.ModuleUserManagement.Organization.ShouldGetById(aepr.Context, &aepr.Log, attempt.OrganizationId)
+	if err != nil {
+		return false, err
+	}
+
+	verificationResult, err := user_management.ModuleUserManagement.UserPasswordVerify(aepr.Context, &aepr.Log, attempt.UserId, attempt.UserPassword)
+	if err != nil {
+		return false, err
+	}
+	if !verificationResult {
+		s.accountLockoutRecordFailedAttempt(aepr, user, attempt.UserLoginId, attempt.OrganizationId, attempt.OrganizationUid, account_lockout.AttemptTypePassword, AuthSourceLocal)
+		return false, aepr.WriteResponseAndNewErrorf(http.StatusUnauthorized, base.MsgInvalidCredential, base.LogMsgNotErrorInvalidCredential)
+	}
+	return false, nil
+}
+
+// loginUserOrganizationMembershipsGet returns the memberships of the user, only the one of organizationUidFilter when it is set.
+// A user without any is refused as an invalid credential.
+func loginUserOrganizationMembershipsGet(aepr *api.DXAPIEndPointRequest, userId int64, organizationUidFilter string) (userOrganizationMemberships []utils.JSON, err error) {
+	us := utils.JSON{
+		"user_id": userId,
+	}
+	if organizationUidFilter != "" {
+		us["organization_uid"] = organizationUidFilter
+	}
+
+	_, userOrganizationMemberships, err = user_management.ModuleUserManagement.UserOrganizationMembership.Select(aepr.Context, &aepr.Log, nil, us, nil,
+		db.DXDatabaseTableFieldsOrderBy{"order_index": "asc"}, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(userOrganizationMemberships) == 0 {
+		return nil, aepr.WriteResponseAndNewErrorf(http.StatusUnauthorized, base.MsgInvalidCredential, base.LogMsgNotErrorInvalidCredential)
+	}
+	return userOrganizationMemberships, nil
+}
+
+/* Post-checks */
+
+func (s *DxmSelf) LoginCheckSessionLimit(aepr *api.DXAPIEndPointRequest, attempt *LoginAttempt) (isDone bool, err error) {
+	return false, s.sessionLimitCheck(aepr, attempt.UserId, attempt.OrganizationId, attempt.Organization)
+}
+
+// LoginCheckTwoFactor answers with a 2FA challenge instead of a session when the user has or must have 2FA
+func (s *DxmSelf) LoginCheckTwoFactor(aepr *api.DXAPIEndPointRequest, attempt *LoginAttempt) (isDone bool, err error) {
+	twoFactorChallenge, err := s.twoFactorLoginChallengeCreate(aepr, attempt.UserId, attempt.OrganizationUidFilter, attempt.OrganizationId, attempt.OrganizationUid, attempt.Organization)
+	if err != nil {
+		return false, err
+	}
+	if twoFactorChallenge == nil {
+		return false, nil
+	}
+	return true, loginWriteResponse(aepr, attempt.E2EE, twoFactorChallenge, "")
+}
+
+// loginSessionIssue creates the session of an authenticated attempt. A nil session object without error means
+// the response has been written (maintenance mode).
+func (s *DxmSelf) loginSessionIssue(aepr *api.DXAPIEndPointRequest, attempt *LoginAttempt) (sessionObject utils.JSON, err error) {
+	sessionKey, err := GenerateSessionKey()
+	if err != nil {
+		return nil, err
+	}
+	a := []any{attempt.UserOrganizationMemberships}
+	sessionObject, allowed, err := s.RegenerateSessionObject(aepr, attempt.UserId, sessionKey, attempt.User, attempt.OrganizationId, attempt.OrganizationUid, attempt.Organization, a)
+	if err != nil {
+		return nil, aepr.WriteResponseAndNewErrorf(http.StatusUnauthorized, "SESSION_KEY_EXPIRED", "NOT_ERROR:SESSION_KEY_EXPIRED_%s", err.Error())
+	}
+	if !allowed {
+		return nil, aepr.WriteResponseAndNewErrorf(http.StatusForbidden, "USER_ROLE_PRIVILEGE_FORBIDDEN", "NOT_ERROR:USER_ROLE_PRIVILEGE_FORBIDDEN")
+	}
+
+	if s.sessionMaintenanceModeRefuse(aepr, sessionObject) {
+		return nil, nil
+	}
+
+	sessionKeyTTLAsDuration, err := sessionDefaultIdleTimeout()
+	if err != nil {
+		return nil, err
+	}
+	err = sessionStore(aepr.Context, sessionKey, sessionObject, sessionKeyTTLAsDuration)
+	if err != nil {
+		return nil, err
+	}
+	err = s.sessionRegister(aepr, attempt.UserId, attempt.OrganizationId, attempt.Organization, sessionKey, sessionKeyTTLAsDuration)
+	if err != nil {
+		_ = user_management.ModuleUserManagement.SessionRedis.Delete(aepr.Context, sessionKey)
+		return nil, err
+	}
+	err = s.jwtAccessTokenAttach(aepr, sessionObject)
+	if err != nil {
+		return nil, err
+	}
+	// Only now the login succeeded, a password accepted before a failed second factor must not reset the counter
+	s.accountLockoutRecordSuccessfulLogin(aepr, attempt.User)
+	return sessionObject, nil
+}
+
+// sessionMaintenanceModeRefuse blocks login during maintenance mode for users without GLOBAL.SET_MAINTENANCE_MODE privilege.
+// It returns true when SYSTEM_UNDER_MAINTENANCE has been written.
+func (s *DxmSelf) sessionMaintenanceModeRefuse(aepr *api.DXAPIEndPointRequest, sessionObject utils.JSON) (isRefused bool) {
+	if !s.SystemModeIsMaintenance(aepr.Context) {
+		return false
+	}
+	userEffectivePrivilegeIds, ok := sessionObject["user_effective_privilege_ids"].(map[string]int64)
+	if ok {
+		if _, hasMaintenancePrivilege := userEffectivePrivilegeIds[base.PrivilegeNameIdSetMaintenance]; hasMaintenancePrivilege {
+			return false
+		}
+	}
+	aepr.WriteResponseAsErrorMessageNotLogged(http.StatusServiceUnavailable, "SYSTEM_UNDER_MAINTENANCE", ErrorSystemUnderMaintenance)
+	return true
+}
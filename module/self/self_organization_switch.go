@@ -0,0 +1,160 @@
+package self
+
+import (
+	"net/http"
+
+	"github.com/donnyhardyanto/dxlib/api"
+	"github.com/donnyhardyanto/dxlib/databases/db"
+	"github.com/donnyhardyanto/dxlib/utils"
+	"github.com/donnyhardyanto/dxlib_module/base"
+	"github.com/donnyhardyanto/dxlib_module/module/user_management"
+)
+
+const (
+	ActivityNameOrganizationSwitch = "ORGANIZATION_SWITCH"
+)
+
+/*
+  - Organization switch
+    SelfOrganizationSwitch moves the current session to another organization the user is a member of, without a new login.
+    The session keeps its key, created_at and refresh token family. The session object is rebuilt for the new organization,
+    so the privileges, the lifetime policy and the 2FA enforcement of that organization apply.
+    The concurrent session limit is not checked again, the number of sessions does not change. As with privilege changes,
+    a JWT access token issued before the switch keeps the previous organization until it expires.
+    Only with UserOrganizationMembershipTypeMultipleOrganizationPerUser.
+*/
+
+func (s *DxmSelf) SelfOrganizationSwitch(aepr *api.DXAPIEndPointRequest) (err error) {
+	err = user_management.SessionImpersonationForbid(aepr)
+	if err != nil {
+		return err
+	}
+	_, organizationUid, err := aepr.GetParameterValueAsString("organization_uid")
+	if err != nil {
+		return err
+	}
+	if s.UserOrganizationMembershipType != user_management.UserOrganizationMembershipTypeMultipleOrganizationPerUser {
+		return aepr.WriteResponseAndNewErrorf(http.StatusUnprocessableEntity, "ORGANIZATION_SWITCH_NOT_SUPPORTED", "NOT_ERROR:USER_ORGANIZATION_MEMBERSHIP_TYPE_IS_%s", s.UserOrganizationMembershipType)
+	}
+
+	sessionObject, ok := aepr.LocalData["session_object"].(utils.JSON)
+	if !ok {
+		return aepr.WriteResponseAndNewErrorf(http.StatusUnauthorized, "SESSION_KEY_EXPIRED", "NOT_ERROR:NO_SESSION_OBJECT")
+	}
+	sessionKey, err := utils.GetStringFromKV(aepr.LocalData, "session_key")
+	if err != nil {
+		return err
+	}
+	userId, err := utils.GetInt64FromKV(aepr.LocalData, "user_id")
+	if err != nil {
+		return err
+	}
+	previousOrganizationUid, err := utils.GetStringFromKV(aepr.LocalData, "organization_uid")
+	if err != nil {
+		return err
+	}
+	if organizationUid == previousOrganizationUid {
+		aepr.WriteResponseAsJSON(http.StatusOK, nil, utils.JSON{
+			"session_object": sessionObject,
+		})
+		return nil
+	}
+
+	_, user, err := user_management.ModuleUserManagement.User.GetById(aepr.Context, &aepr.Log, userId)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return aepr.WriteResponseAndNewErrorf(http.StatusUnauthorized, base.MsgInvalidCredential, base.LogMsgNotErrorInvalidCredential)
+	}
+	err = s.accountLockoutCheck(aepr, user)
+	if err != nil {
+		return err
+	}
+
+	_, userOrganizationMemberships, err := user_management.ModuleUserManagement.UserOrganizationMembership.Select(aepr.Context, &aepr.Log, nil, utils.JSON{
+		"user_id": userId,
+	}, nil, db.DXDatabaseTableFieldsOrderBy{"order_index": "asc"}, nil, nil)
+	if err != nil {
+		return err
+	}
+	var organizationId int64
+	isMember := false
+	for _, userOrganizationMembership := range userOrganizationMemberships {
+		membershipOrganizationUid, _ := utils.GetStringFromKV(userOrganizationMembership, "organization_uid")
+		if membershipOrganizationUid != organizationUid {
+			continue
+		}
+		organizationId, err = utils.GetInt64FromKV(userOrganizationMembership, "organization_id")
+		if err != nil {
+			return err
+		}
+		isMember = true
+		break
+	}
+	if !isMember {
+		return aepr.WriteResponseAndNewErrorf(http.StatusForbidden, "ORGANIZATION_MEMBERSHIP_NOT_FOUND", "NOT_ERROR:USER_IS_NOT_MEMBER_OF_ORGANIZATION:%s", organizationUid)
+	}
+	_, organization, err := user_management.ModuleUserManagement.Organization.ShouldGetById(aepr.Context, &aepr.Log, organizationId)
+	if err != nil {
+		return err
+	}
+
+	// A user with 2FA enabled has passed it at login, one without cannot enter an organization that enforces it
+	isTwoFactorRequired, err := s.twoFactorIsRequired(aepr, twoFactorConfigGet(), userId, organizationId, organization)
+	if err != nil {
+		return err
+	}
+	if isTwoFactorRequired {
+		isTwoFactorEnabled, err := user_management.ModuleUserManagement.UserTotpIsEnabled(aepr.Context, &aepr.Log, userId)
+		if err != nil {
+			return err
+		}
+		if !isTwoFactorEnabled {
+			return aepr.WriteResponseAndNewErrorf(http.StatusForbidden, "TWO_FACTOR_ENROLLMENT_REQUIRED", "NOT_ERROR:TWO_FACTOR_ENFORCED_FOR_ORGANIZATION:%s", organizationUid)
+		}
+	}
+
+	a := []any{userOrganizationMemberships}
+	sessionObject, allowed, err := s.RegenerateSessionObject(aepr, userId, sessionKey, user, organizationId, organizationUid, organization, a)
+	if err != nil {
+		return aepr.WriteResponseAndNewErrorf(http.StatusUnauthorized, "SESSION_KEY_EXPIRED", "NOT_ERROR:SESSION_KEY_EXPIRED_%s", err.Error())
+	}
+	if !allowed {
+		return aepr.WriteResponseAndNewErrorf(http.StatusForbidden, "USER_ROLE_PRIVILEGE_FORBIDDEN", "NOT_ERROR:USER_ROLE_PRIVILEGE_FORBIDDEN")
+	}
+	if s.sessionMaintenanceModeRefuse(aepr, sessionObject) {
+		return nil
+	}
+
+	sessionKeyTTLAsDuration, err := sessionDefaultIdleTimeout()
+	if err != nil {
+		return err
+	}
+	err = sessionStore(aepr.Context, sessionKey, sessionObject, sessionKeyTTLAsDuration)
+	if err != nil {
+		return err
+	}
+	if familyId, _ := sessionObject["refresh_token_family_id"].(string); familyId != "" {
+		err = user_management.ModuleUserManagement.RefreshTokenFamilyOrganizationSet(aepr.Context, familyId, organizationId)
+		if err != nil {
+			return err
+		}
+	}
+	err = sessionLocalDataSet(aepr, sessionKey, sessionObject)
+	if err != nil {
+		return err
+	}
+
+	aepr.Log.Infof("User %d switched organization from %s to %s", userId, previousOrganizationUid, organizationUid)
+	s.userActivityAudit(aepr, ActivityNameOrganizationSwitch, ActivityResultStatusSuccess)
+
+	err = s.jwtAccessTokenAttach(aepr, sessionObject)
+	if err != nil {
+		return err
+	}
+	aepr.WriteResponseAsJSON(http.StatusOK, nil, utils.JSON{
+		"session_object": sessionObject,
+	})
+	return nil
+}
@@ -0,0 +1,119 @@
+package self
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/donnyhardyanto/dxlib/api"
+	"github.com/donnyhardyanto/dxlib/utils"
+	"github.com/donnyhardyanto/dxlib_module/module/user_management"
+)
+
+/*
+  - Password policy, self side (see user_management.PasswordPolicy)
+    LoginCheckPasswordAge, a default login post-check, sets must_change_password when the password is older than
+    max_age_in_days. A session whose user must change the password can then only call the endpoints listed in
+    system.password_policy.must_change_password_allowed_endpoint_uris (the password change, logout, ...), both with
+    the session key and with a JWT access token (claim "mcp"). Without that list must_change_password stays a hint
+    for the client, as before. A password change clears the flag in the current session too; a JWT access token
+    issued before keeps the claim until the next refresh.
+*/
+
+// LoginCheckPasswordAge sets must_change_password on the user when the password has expired under the policy
+func (s *DxmSelf) LoginCheckPasswordAge(aepr *api.DXAPIEndPointRequest, attempt *LoginAttempt) (isDone bool, err error) {
+	if mustChangePassword, _ := attempt.User["must_change_password"].(bool); mustChangePassword {
+		return false, nil
+	}
+	policy, err := user_management.ModuleUserManagement.PasswordPolicyForUser(aepr.Context, &aepr.Log, attempt.UserId)
+	if err != nil {
+		return false, err
+	}
+	isExpired, err := user_management.ModuleUserManagement.UserPasswordIsExpired(aepr.Context, &aepr.Log, attempt.UserId, policy)
+	if err != nil {
+		return false, err
+	}
+	if !isExpired {
+		return false, nil
+	}
+	_, err = user_management.ModuleUserManagement.User.UpdateSimple(aepr.Context, utils.JSON{
+		"must_change_password": true,
+	}, utils.JSON{
+		"id": attempt.UserId,
+	})
+	if err != nil {
+		return false, err
+	}
+	attempt.User["must_change_password"] = true
+	aepr.Log.Infof("Password of user %d is older than %d days, must_change_password set", attempt.UserId, policy.MaxAgeInDays)
+	return false, nil
+}
+
+func sessionMustChangePassword(sessionObject utils.JSON) bool {
+	user, err := utils.GetVFromKV[utils.JSON](sessionObject, "user")
+	if err != nil {
+		return false
+	}
+	mustChangePassword, _ := user["must_change_password"].(bool)
+	return mustChangePassword
+}
+
+// passwordChangeRequiredCheck refuses an endpoint not allowed while the user must change the password
+func passwordChangeRequiredCheck(aepr *api.DXAPIEndPointRequest, mustChangePassword bool) (err error) {
+	// The impersonator cannot change the target's password, see user_management.SessionImpersonationForbid
+	if !mustChangePassword || user_management.SessionIsImpersonated(aepr) {
+		return nil
+	}
+	allowedEndPointUris := configStringList(user_management.PasswordPolicyConfigGet()["must_change_password_allowed_endpoint_uris"])
+	if len(allowedEndPointUris) == 0 || slices.Contains(allowedEndPointUris, aepr.EndPoint.Uri) {
+		return nil
+	}
+	return aepr.WriteResponseAndNewErrorf(http.StatusForbidden, "PASSWORD_CHANGE_REQUIRED", "NOT_ERROR:PASSWORD_CHANGE_REQUIRED:%s", aepr.EndPoint.Uri)
+}
+
+// passwordNewValidate checks a new password of the logged or resetting user against the callback and the policy
+func (s *DxmSelf) passwordNewValidate(aepr *api.DXAPIEndPointRequest, userId int64, user utils.JSON, userPasswordNew string) (err error) {
+	if user == nil {
+		// The JWT path does not put the user in aepr.LocalData
+		_, user, err = user_management.ModuleUserManagement.User.ShouldGetById(aepr.Context, &aepr.Log, userId)
+		if err != nil {
+			return err
+		}
+	}
+	policy, err := user_management.ModuleUserManagement.PasswordPolicyForUser(aepr.Context, &aepr.Log, userId)
+	if err != nil {
+		return err
+	}
+	passwordViolation, err := user_management.ModuleUserManagement.UserPasswordValidate(aepr.Context, &aepr.Log, policy, userId, userPasswordNew,
+		user_management.PasswordPolicyUserInputs(user)...)
+	if err != nil {
+		return err
+	}
+	if passwordViolation != "" {
+		return aepr.WriteResponseAndNewErrorf(http.StatusUnprocessableEntity,
+			"INVALID_PASSWORD_FORMAT",
+			"NOT_ERROR:INVALID_PASSWORD_FORMAT:DETAIL=%s", passwordViolation)
+	}
+	return nil
+}
+
+// sessionMustChangePasswordClear lets the current session through passwordChangeRequiredCheck after a password change
+func (s *DxmSelf) sessionMustChangePasswordClear(aepr *api.DXAPIEndPointRequest) {
+	sessionObject, ok := aepr.LocalData["session_object"].(utils.JSON)
+	if !ok || !sessionMustChangePassword(sessionObject) {
+		return
+	}
+	sessionKey, _ := aepr.LocalData["session_key"].(string)
+	user, err := utils.GetVFromKV[utils.JSON](sessionObject, "user")
+	if err != nil {
+		return
+	}
+	user["must_change_password"] = false
+	sessionObject["user"] = user
+	sessionKeyTTLAsDuration, err := sessionDefaultIdleTimeout()
+	if err == nil {
+		err = sessionStore(aepr.Context, sessionKey, sessionObject, sessionKeyTTLAsDuration)
+	}
+	if err != nil {
+		aepr.Log.Warnf("SESSION_MUST_CHANGE_PASSWORD_CLEAR_ERROR:user_id=%v:%v", aepr.LocalData["user_id"], err)
+	}
+}
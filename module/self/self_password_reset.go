@@ -0,0 +1,284 @@
+package self
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/donnyhardyanto/dxlib/api"
+	"github.com/donnyhardyanto/dxlib/configuration"
+	"github.com/donnyhardyanto/dxlib/databases"
+	"github.com/donnyhardyanto/dxlib/errors"
+	"github.com/donnyhardyanto/dxlib/log"
+	"github.com/donnyhardyanto/dxlib/utils"
+	"github.com/donnyhardyanto/dxlib_module/module/general"
+	"github.com/donnyhardyanto/dxlib_module/module/user_management"
+)
+
+const (
+	PasswordResetChannelEmail = "EMAIL"
+	PasswordResetChannelSMS   = "SMS"
+
+	defaultPasswordResetTokenTTLInSeconds      = 1800
+	defaultPasswordResetMaxRequestsPerWindow   = 3
+	defaultPasswordResetRequestWindowInSeconds = 3600
+
+	passwordResetRequestProcessTimeout = time.Minute
+)
+
+/*
+  - Self-service password reset ("forgot password")
+    SelfPasswordResetRequest takes user_login_id and channel (EMAIL or SMS, default EMAIL), issues a single-use reset
+    token and delivers it with the general.template of the channel through OnPasswordResetDeliver. The response is the
+    same whether or not the user exists, is active, is over its request limit or the delivery failed, and is written
+    before the user is looked up, so neither its content nor its timing can be used to enumerate accounts; those cases
+    are only logged. Per IP limiting is the endpoint rate limit group.
+    SelfPasswordResetConfirm takes reset_token and user_login_password_new, checks the password against the password
+    policy before the token is used up, redeems the token, writes a new user_password row, clears
+    must_change_password and revokes all sessions and refresh token families of the user.
+    Tokens are stored hashed, see user_management.PasswordResetTokenCreate.
+
+  - Template placeholders: <token>, <user_loginid>, <user_fullname>, <expires_in_minutes>
+
+  - Configuration, system.password_reset (optional)
+    token_ttl_in_seconds          : lifetime of a reset token, default 1800
+    max_requests_per_window       : reset requests per user in a window, default 3
+    request_window_in_seconds     : default 3600
+    template_nameids              : {"EMAIL": ..., "SMS": ...}, default PASSWORD_RESET_EMAIL and PASSWORD_RESET_SMS
+*/
+
+type passwordResetConfig struct {
+	TokenTTLInSeconds      int
+	MaxRequestsPerWindow   int
+	RequestWindowInSeconds int
+	TemplateNameIds        map[string]string
+}
+
+func passwordResetConfigGet() passwordResetConfig {
+	cfg := passwordResetConfig{
+		TokenTTLInSeconds:      defaultPasswordResetTokenTTLInSeconds,
+		MaxRequestsPerWindow:   defaultPasswordResetMaxRequestsPerWindow,
+		RequestWindowInSeconds: defaultPasswordResetRequestWindowInSeconds,
+		TemplateNameIds: map[string]string{
+			PasswordResetChannelEmail: "PASSWORD_RESET_EMAIL",
+			PasswordResetChannelSMS:   "PASSWORD_RESET_SMS",
+		},
+	}
+	configSystem, ok := configuration.Manager.Configurations["system"]
+	if !ok || configSystem.Data == nil {
+		return cfg
+	}
+	configPasswordReset, err := utils.GetJSONFromKV(*configSystem.Data, "password_reset")
+	if err != nil {
+		return cfg
+	}
+	if v, err := utils.GetIntFromKV(configPasswordReset, "token_ttl_in_seconds"); err == nil && v > 0 {
+		cfg.TokenTTLInSeconds = v
+	}
+	if v, err := utils.GetIntFromKV(configPasswordReset, "max_requests_per_window"); err == nil && v > 0 {
+		cfg.MaxRequestsPerWindow = v
+	}
+	if v, err := utils.GetIntFromKV(configPasswordReset, "request_window_in_seconds"); err == nil && v > 0 {
+		cfg.RequestWindowInSeconds = v
+	}
+	if templateNameIds, err := utils.GetJSONFromKV(configPasswordReset, "template_nameids"); err == nil {
+		for channel, v := range templateNameIds {
+			if nameId, ok := v.(string); ok && nameId != "" {
+				cfg.TemplateNameIds[channel] = nameId
+			}
+		}
+	}
+	return cfg
+}
+
+func passwordResetTemplateRender(text string, data utils.JSON) string {
+	for key, value := range data {
+		text = strings.ReplaceAll(text, fmt.Sprintf("<%s>", key), fmt.Sprintf("%v", value))
+	}
+	return text
+}
+
+func (s *DxmSelf) SelfPasswordResetRequest(aepr *api.DXAPIEndPointRequest) (err error) {
+	_, userLoginId, err := aepr.GetParameterValueAsString("user_login_id")
+	if err != nil {
+		return err
+	}
+	channel := PasswordResetChannelEmail
+	if v, ok := aepr.ParameterValues["channel"].Value.(string); ok && v != "" {
+		channel = strings.ToUpper(v)
+	}
+
+	cfg := passwordResetConfigGet()
+	templateNameId, ok := cfg.TemplateNameIds[channel]
+	if !ok {
+		return aepr.WriteResponseAndNewErrorf(http.StatusUnprocessableEntity, "INVALID_CHANNEL", "NOT_ERROR:INVALID_PASSWORD_RESET_CHANNEL:%s", channel)
+	}
+
+	// Off the request path, so the response time does not tell an existing account from an unknown one
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(aepr.Context), passwordResetRequestProcessTimeout)
+	go func() {
+		defer cancel()
+		err := s.passwordResetRequestProcess(ctx, cfg, userLoginId, channel, templateNameId)
+		if err != nil {
+			log.Log.Warnf("PASSWORD_RESET_REQUEST_ERROR:user_login_id=%s:%v", userLoginId, err)
+		}
+	}()
+
+	aepr.WriteResponseAsJSON(http.StatusOK, nil, utils.JSON{
+		"message": aepr.TranslateMessage("If the account exists, password reset instructions have been sent"),
+	})
+	return nil
+}
+
+// passwordResetRequestProcess issues and delivers the token, run off the request path;
+// a nil error with nothing sent means the request was ignored
+func (s *DxmSelf) passwordResetRequestProcess(ctx context.Context, cfg passwordResetConfig, userLoginId string, channel string, templateNameId string) (err error) {
+	if s.OnPasswordResetDeliver == nil {
+		return errors.New("PASSWORD_RESET_DELIVER_NOT_CONFIGURED")
+	}
+	_, user, err := user_management.ModuleUserManagement.User.SelectOne(ctx, &log.Log, nil, utils.JSON{
+		"loginid": userLoginId,
+	}, nil, nil)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		log.Log.Infof("Password reset requested for unknown user_login_id %s", userLoginId)
+		return nil
+	}
+	userId, err := utils.GetInt64FromKV(user, "id")
+	if err != nil {
+		return err
+	}
+	userStatus, _ := utils.GetStringFromKV(user, "status")
+	if userStatus != user_management.UserStatusActive {
+		log.Log.Infof("Password reset requested for inactive user %d", userId)
+		return nil
+	}
+
+	requestCount, err := user_management.ModuleUserManagement.PasswordResetRequestCountIncr(ctx, userId, time.Duration(cfg.RequestWindowInSeconds)*time.Second)
+	if err != nil {
+		return err
+	}
+	if requestCount > int64(cfg.MaxRequestsPerWindow) {
+		log.Log.Warnf("PASSWORD_RESET_REQUEST_LIMIT_EXCEEDED:user_id=%d:count=%d", userId, requestCount)
+		return nil
+	}
+
+	_, templateTitle, templateContentType, templateBody, err := general.ModuleGeneral.TemplateGetByNameId(ctx, &log.Log, templateNameId)
+	if err != nil {
+		return err
+	}
+
+	token, err := user_management.ModuleUserManagement.PasswordResetTokenCreate(ctx, userId, time.Duration(cfg.TokenTTLInSeconds)*time.Second)
+	if err != nil {
+		return err
+	}
+	userFullName, _ := utils.GetStringFromKV(user, "fullname")
+	templateData := utils.JSON{
+		"token":              token,
+		"user_loginid":       userLoginId,
+		"user_fullname":      userFullName,
+		"expires_in_minutes": cfg.TokenTTLInSeconds / 60,
+	}
+	err = s.OnPasswordResetDeliver(ctx, channel, user,
+		passwordResetTemplateRender(templateTitle, templateData),
+		templateContentType,
+		passwordResetTemplateRender(templateBody, templateData))
+	if err != nil {
+		return err
+	}
+	log.Log.Infof("Password reset token sent to user %d via %s", userId, channel)
+	return nil
+}
+
+func (s *DxmSelf) SelfPasswordResetConfirm(aepr *api.DXAPIEndPointRequest) (err error) {
+	_, resetToken, err := aepr.GetParameterValueAsString("reset_token")
+	if err != nil {
+		return err
+	}
+	_, userPasswordNew, err := aepr.GetParameterValueAsString("user_login_password_new")
+	if err != nil {
+		return err
+	}
+
+	userId, isValid, err := user_management.ModuleUserManagement.PasswordResetTokenPeek(aepr.Context, resetToken)
+	if err != nil {
+		return err
+	}
+	if !isValid {
+		return aepr.WriteResponseAndNewErrorf(http.StatusUnprocessableEntity, "INVALID_RESET_TOKEN", "NOT_ERROR:INVALID_RESET_TOKEN")
+	}
+	_, user, err := user_management.ModuleUserManagement.User.ShouldGetById(aepr.Context, &aepr.Log, userId)
+	if err != nil {
+		return err
+	}
+	// Checked before the token is redeemed, so a rejected password does not use up the token
+	err = s.passwordNewValidate(aepr, userId, user, userPasswordNew)
+	if err != nil {
+		return err
+	}
+
+	// The token is redeemed last inside the transaction: a confirm that loses the race rolls its password back,
+	// and a transaction that fails after redeeming gives the token back
+	isTokenConsumed := false
+	var tokenRemainingTTL time.Duration
+	err = databases.Manager.GetOrCreate(user_management.ModuleUserManagement.DatabaseNameId).Tx(aepr.Context, &aepr.Log, sql.LevelReadCommitted, func(tx *databases.DXDatabaseTx) (err error) {
+		_, _, err = user_management.ModuleUserManagement.User.TxShouldSelectOne(tx, nil, utils.JSON{
+			"id": userId,
+		}, nil, nil, "FOR UPDATE")
+		if err != nil {
+			return err
+		}
+
+		err = user_management.ModuleUserManagement.TxUserPasswordCreate(tx, userId, userPasswordNew)
+		if err != nil {
+			return err
+		}
+		aepr.Log.Infof("User password reset")
+
+		_, err = user_management.ModuleUserManagement.User.TxUpdateSimple(tx, utils.JSON{
+			"must_change_password": false,
+		}, utils.JSON{
+			"id": userId,
+		})
+		if err != nil {
+			return err
+		}
+
+		consumedUserId, isValid, remainingTTL, err := user_management.ModuleUserManagement.PasswordResetTokenConsume(aepr.Context, resetToken)
+		if err != nil {
+			return err
+		}
+		if !isValid || consumedUserId != userId {
+			return aepr.WriteResponseAndNewErrorf(http.StatusUnprocessableEntity, "INVALID_RESET_TOKEN", "NOT_ERROR:INVALID_RESET_TOKEN")
+		}
+		isTokenConsumed = true
+		tokenRemainingTTL = remainingTTL
+		return nil
+	})
+	if err != nil {
+		if isTokenConsumed {
+			errRestore := user_management.ModuleUserManagement.PasswordResetTokenRestore(aepr.Context, resetToken, userId, tokenRemainingTTL)
+			if errRestore != nil {
+				aepr.Log.Warnf("PASSWORD_RESET_TOKEN_RESTORE_ERROR:user_id=%d:%v", userId, errRestore)
+			}
+		}
+		return err
+	}
+
+	revokedCount, err := user_management.ModuleUserManagement.SessionRevokeAllForUser(aepr.Context, userId, "")
+	if err != nil {
+		aepr.Log.Warnf("SESSION_REVOKE_ALL_ERROR:user_id=%d:%v", userId, err)
+	} else {
+		aepr.Log.Infof("Revoked %d sessions after password reset", revokedCount)
+	}
+
+	aepr.WriteResponseAsJSON(http.StatusOK, nil, utils.JSON{
+		"message": aepr.TranslateMessage("Password reset successfully"),
+	})
+	return nil
+}
@@ -0,0 +1,305 @@
+package self
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/donnyhardyanto/dxlib/api"
+	"github.com/donnyhardyanto/dxlib/configuration"
+	"github.com/donnyhardyanto/dxlib/databases/db"
+	"github.com/donnyhardyanto/dxlib/errors"
+	"github.com/donnyhardyanto/dxlib/utils"
+	utilsJSON "github.com/donnyhardyanto/dxlib/utils/json"
+	"github.com/donnyhardyanto/dxlib_module/module/user_management"
+)
+
+const SessionTypeAccessToken = "ACCESS_TOKEN"
+
+/*
+  - Refresh token session model, system.sessions.refresh_token (optional)
+    access_token_ttl_in_seconds   : lifetime of an access token, default 900
+    refresh_token_ttl_in_seconds  : lifetime of a refresh token family, default 2592000 (30 days), never past the absolute session lifetime
+    A logged-in client converts its opaque session key with SelfRefreshTokenIssue. The session key then becomes a short-lived
+    access token, still sent as "Authorization: Bearer", and SelfTokenRefresh trades the refresh token for a new access token
+    and a new refresh token. Clients that never call SelfRefreshTokenIssue keep the plain opaque session key flow.
+*/
+
+type refreshTokenConfig struct {
+	AccessTokenTTLInSeconds  int
+	RefreshTokenTTLInSeconds int
+}
+
+func refreshTokenConfigGet() (cfg refreshTokenConfig) {
+	cfg = refreshTokenConfig{
+		AccessTokenTTLInSeconds:  900,
+		RefreshTokenTTLInSeconds: 30 * 24 * 60 * 60,
+	}
+	configSystem, ok := configuration.Manager.Configurations["system"]
+	if !ok || configSystem.Data == nil {
+		return cfg
+	}
+	configSystemSession, err := utils.GetJSONFromKV(*configSystem.Data, "sessions")
+	if err != nil {
+		return cfg
+	}
+	configRefreshToken, err := utils.GetJSONFromKV(configSystemSession, "refresh_token")
+	if err != nil {
+		return cfg
+	}
+	if n, err := utils.GetIntFromKV(configRefreshToken, "access_token_ttl_in_seconds"); err == nil && n > 0 {
+		cfg.AccessTokenTTLInSeconds = n
+	}
+	if n, err := utils.GetIntFromKV(configRefreshToken, "refresh_token_ttl_in_seconds"); err == nil && n > 0 {
+		cfg.RefreshTokenTTLInSeconds = n
+	}
+	return cfg
+}
+
+func sessionDefaultIdleTimeout() (ttl time.Duration, err error) {
+	configSystem := *configuration.Manager.Configurations["system"].Data
+	configSystemSession, ok := configSystem["sessions"].(utils.JSON)
+	if !ok {
+		return 0, errors.New(ErrorConfigSystemSessionsNotFound)
+	}
+	sessionKeyTTLAsInt, ok := configSystemSession["session_ttl_in_seconds"].(int)
+	if !ok {
+		return 0, errors.New("SHOULD_NOT_HAPPEN:SESSIONS_TTL_SECOND_NOT_FOUND_OR_NOT_INT")
+	}
+	return time.Duration(sessionKeyTTLAsInt) * time.Second, nil
+}
+
+// refreshTokenAccessTokenStamp marks the session object as the access token of familyId
+func refreshTokenAccessTokenStamp(sessionObject utils.JSON, familyId string, cfg refreshTokenConfig) (accessTokenExpiresAt int64) {
+	accessTokenExpiresAt = time.Now().Add(time.Duration(cfg.AccessTokenTTLInSeconds) * time.Second).Unix()
+	sessionObject["session_type"] = SessionTypeAccessToken
+	sessionObject["refresh_token_family_id"] = familyId
+	sessionObject["access_token_expires_at"] = accessTokenExpiresAt
+	return accessTokenExpiresAt
+}
+
+// refreshTokenFamilyCheck rejects an access token whose refresh token family has been revoked. Used by the middleware,
+// a non-nil error means the response has been written.
+func refreshTokenFamilyCheck(aepr *api.DXAPIEndPointRequest, sessionKey string, sessionObject utils.JSON) (err error) {
+	familyId, _ := sessionObject["refresh_token_family_id"].(string)
+	if familyId == "" {
+		return nil
+	}
+	family, err := user_management.ModuleUserManagement.RefreshTokenFamilyGet(aepr.Context, familyId)
+	if err != nil {
+		return err
+	}
+	if family != nil && family["session_key"] == sessionKey {
+		return nil
+	}
+	_ = user_management.ModuleUserManagement.SessionRedis.Delete(aepr.Context, sessionKey)
+	return aepr.WriteResponseAndNewErrorf(http.StatusUnauthorized, user_management.SessionEndedReasonRefreshTokenRevoked, "NOT_ERROR:%s", user_management.SessionEndedReasonRefreshTokenRevoked)
+}
+
+// SelfRefreshTokenIssue turns the current opaque session into an access token and returns its first refresh token
+func (s *DxmSelf) SelfRefreshTokenIssue(aepr *api.DXAPIEndPointRequest) (err error) {
+	err = user_management.SessionImpersonationForbid(aepr)
+	if err != nil {
+		return err
+	}
+	sessionObject, ok := aepr.LocalData["session_object"].(utils.JSON)
+	if !ok {
+		return aepr.WriteResponseAndNewErrorf(http.StatusUnauthorized, "SESSION_KEY_EXPIRED", "NOT_ERROR:NO_SESSION_OBJECT")
+	}
+	sessionKey, err := utils.GetStringFromKV(aepr.LocalData, "session_key")
+	if err != nil {
+		return err
+	}
+	userId, err := utils.GetInt64FromKV(aepr.LocalData, "user_id")
+	if err != nil {
+		return err
+	}
+	organizationId, err := utils.GetInt64FromKV(aepr.LocalData, "organization_id")
+	if err != nil {
+		return err
+	}
+	if familyId, _ := sessionObject["refresh_token_family_id"].(string); familyId != "" {
+		return aepr.WriteResponseAndNewErrorf(http.StatusConflict, "REFRESH_TOKEN_ALREADY_ISSUED", "NOT_ERROR:REFRESH_TOKEN_ALREADY_ISSUED")
+	}
+	defaultIdleTimeout, err := sessionDefaultIdleTimeout()
+	if err != nil {
+		return err
+	}
+
+	cfg := refreshTokenConfigGet()
+	now := time.Now()
+	createdAt := now
+	if v, err := utilsJSON.GetInt64(sessionObject, "created_at"); err == nil && v > 0 {
+		createdAt = time.Unix(v, 0)
+	}
+	refreshTokenExpiresAt := now.Add(time.Duration(cfg.RefreshTokenTTLInSeconds) * time.Second)
+	if absoluteExpiresAt, err := utilsJSON.GetInt64(sessionObject, "absolute_expires_at"); err == nil && absoluteExpiresAt > 0 && absoluteExpiresAt < refreshTokenExpiresAt.Unix() {
+		refreshTokenExpiresAt = time.Unix(absoluteExpiresAt, 0)
+	}
+
+	familyId, refreshToken, err := user_management.ModuleUserManagement.RefreshTokenFamilyCreate(aepr.Context, userId, organizationId, sessionKey, createdAt, refreshTokenExpiresAt)
+	if err != nil {
+		return err
+	}
+	accessTokenExpiresAt := refreshTokenAccessTokenStamp(sessionObject, familyId, cfg)
+	err = sessionStore(aepr.Context, sessionKey, sessionObject, defaultIdleTimeout)
+	if err != nil {
+		return err
+	}
+
+	aepr.WriteResponseAsJSON(http.StatusOK, nil, utils.JSON{
+		"access_token":             sessionKey,
+		"access_token_expires_at":  accessTokenExpiresAt,
+		"refresh_token":            refreshToken,
+		"refresh_token_expires_at": refreshTokenExpiresAt.Unix(),
+	})
+	return nil
+}
+
+// SelfTokenRefresh trades a refresh token for a new access token and refresh token. Not behind the logged-in middleware,
+// the access token has usually expired by then.
+func (s *DxmSelf) SelfTokenRefresh(aepr *api.DXAPIEndPointRequest) (err error) {
+	_, refreshToken, err := aepr.GetParameterValueAsString("refresh_token")
+	if err != nil {
+		return err
+	}
+
+	family, isReused, err := user_management.ModuleUserManagement.RefreshTokenConsume(aepr.Context, refreshToken)
+	if err != nil {
+		return err
+	}
+	if family == nil {
+		return aepr.WriteResponseAndNewErrorf(http.StatusUnauthorized, "REFRESH_TOKEN_INVALID", "NOT_ERROR:REFRESH_TOKEN_INVALID")
+	}
+	userId, err := utilsJSON.GetInt64(family, "user_id")
+	if err != nil {
+		return err
+	}
+	if isReused {
+		aepr.Log.Warnf("REFRESH_TOKEN_REUSED:user_id=%d:family_id=%v, family revoked", userId, family["family_id"])
+		return aepr.WriteResponseAndNewErrorf(http.StatusUnauthorized, user_management.SessionEndedReasonRefreshTokenReused, "NOT_ERROR:%s", user_management.SessionEndedReasonRefreshTokenReused)
+	}
+	// Until the family is advanced any failure gives the token back, a transient error must not turn the client's retry
+	// into a reuse that revokes the family
+	isAdvanced := false
+	defer func() {
+		if err != nil && !isAdvanced {
+			_ = user_management.ModuleUserManagement.RefreshTokenConsumeRelease(aepr.Context, refreshToken)
+		}
+	}()
+	familyId, _ := family["family_id"].(string)
+	previousSessionKey, _ := family["session_key"].(string)
+	organizationId, err := utilsJSON.GetInt64(family, "organization_id")
+	if err != nil {
+		return err
+	}
+	createdAt, err := utilsJSON.GetInt64(family, "created_at")
+	if err != nil {
+		return err
+	}
+	revokeFamily := func() {
+		_ = user_management.ModuleUserManagement.RefreshTokenFamilyRevoke(aepr.Context, family, user_management.SessionEndedReasonRefreshTokenRevoked)
+	}
+
+	_, user, err := user_management.ModuleUserManagement.User.GetById(aepr.Context, &aepr.Log, userId)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		revokeFamily()
+		return aepr.WriteResponseAndNewErrorf(http.StatusUnauthorized, "REFRESH_TOKEN_INVALID", "NOT_ERROR:USER_NOT_FOUND")
+	}
+	userStatus, _ := utils.GetStringFromKV(user, "status")
+	if userStatus != user_management.UserStatusActive {
+		revokeFamily()
+		return aepr.WriteResponseAndNewErrorf(http.StatusForbidden, "USER_SUSPENDED", "NOT_ERROR:USER_IS_NOT_ACTIVE")
+	}
+	_, organization, err := user_management.ModuleUserManagement.Organization.GetById(aepr.Context, &aepr.Log, organizationId)
+	if err != nil {
+		return err
+	}
+	organizationIsDeleted, _ := organization["is_deleted"].(bool)
+	organizationStatus, _ := utils.GetStringFromKV(organization, "status")
+	if organization == nil || organizationIsDeleted || organizationStatus == user_management.OrganizationStatusDeleted {
+		revokeFamily()
+		return aepr.WriteResponseAndNewErrorf(http.StatusForbidden, "ORGANIZATION_DELETED", "NOT_ERROR:ORGANIZATION_NOT_FOUND_OR_DELETED")
+	}
+	organizationUid, err := utils.GetStringFromKV(organization, "uid")
+	if err != nil {
+		return err
+	}
+	_, userOrganizationMemberships, err := user_management.ModuleUserManagement.UserOrganizationMembership.Select(aepr.Context, &aepr.Log, nil, utils.JSON{
+		"user_id": userId,
+	}, nil, db.DXDatabaseTableFieldsOrderBy{"order_index": "asc"}, nil, nil)
+	if err != nil {
+		return err
+	}
+	isMember := false
+	for _, userOrganizationMembership := range userOrganizationMemberships {
+		if membershipOrganizationId, err := utils.GetInt64FromKV(userOrganizationMembership, "organization_id"); err == nil && membershipOrganizationId == organizationId {
+			isMember = true
+		}
+	}
+	if !isMember {
+		revokeFamily()
+		return aepr.WriteResponseAndNewErrorf(http.StatusForbidden, "USER_ROLE_PRIVILEGE_FORBIDDEN", "NOT_ERROR:USER_NO_LONGER_MEMBER_OF_ORGANIZATION")
+	}
+
+	sessionKey, err := GenerateSessionKey()
+	if err != nil {
+		return err
+	}
+	a := []any{userOrganizationMemberships}
+	sessionObject, allowed, err := s.RegenerateSessionObject(aepr, userId, sessionKey, user, organizationId, organizationUid, organization, a)
+	if err != nil {
+		return aepr.WriteResponseAndNewErrorf(http.StatusUnauthorized, "SESSION_KEY_EXPIRED", "NOT_ERROR:SESSION_KEY_EXPIRED_%s", err.Error())
+	}
+	if !allowed {
+		return aepr.WriteResponseAndNewErrorf(http.StatusForbidden, "USER_ROLE_PRIVILEGE_FORBIDDEN", "NOT_ERROR:USER_ROLE_PRIVILEGE_FORBIDDEN")
+	}
+
+	// The new access token belongs to the same login, so the absolute lifetime keeps counting from it
+	policy, err := sessionLifetimePolicyGet(organization)
+	if err != nil {
+		return err
+	}
+	sessionLifetimeStampAt(sessionObject, policy, createdAt)
+	cfg := refreshTokenConfigGet()
+	accessTokenExpiresAt := refreshTokenAccessTokenStamp(sessionObject, familyId, cfg)
+
+	defaultIdleTimeout, err := sessionDefaultIdleTimeout()
+	if err != nil {
+		return err
+	}
+	if _, isLifetimeExceeded := sessionTTL(sessionObject, defaultIdleTimeout); isLifetimeExceeded {
+		revokeFamily()
+		return aepr.WriteResponseAndNewErrorf(http.StatusUnauthorized, user_management.SessionEndedReasonLifetimeExceeded, "NOT_ERROR:%s", user_management.SessionEndedReasonLifetimeExceeded)
+	}
+	err = sessionStore(aepr.Context, sessionKey, sessionObject, defaultIdleTimeout)
+	if err != nil {
+		return err
+	}
+	newRefreshToken, err := user_management.ModuleUserManagement.RefreshTokenFamilyAdvance(aepr.Context, family, sessionKey)
+	if err != nil {
+		_ = user_management.ModuleUserManagement.SessionRedis.Delete(aepr.Context, sessionKey)
+		return err
+	}
+	isAdvanced = true
+
+	if previousSessionKey != "" {
+		_ = user_management.ModuleUserManagement.SessionRedis.Delete(aepr.Context, previousSessionKey)
+		_ = user_management.ModuleUserManagement.SessionIndexRemove(aepr.Context, userId, previousSessionKey)
+	}
+	err = user_management.ModuleUserManagement.SessionIndexAdd(aepr.Context, userId, sessionKey, requestDevice(aepr), requestClientIPAddress(aepr), requestUserAgent(aepr), defaultIdleTimeout)
+	if err != nil {
+		aepr.Log.Warnf("SESSION_INDEX_ADD_ERROR:user_id=%d:%v", userId, err)
+	}
+
+	aepr.WriteResponseAsJSON(http.StatusOK, nil, utils.JSON{
+		"access_token":             sessionKey,
+		"access_token_expires_at":  accessTokenExpiresAt,
+		"refresh_token":            newRefreshToken,
+		"refresh_token_expires_at": family["expires_at"],
+		"session_object":           sessionObject,
+	})
+	return nil
+}
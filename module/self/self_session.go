@@ -0,0 +1,324 @@
+package self
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/donnyhardyanto/dxlib/api"
+	"github.com/donnyhardyanto/dxlib/configuration"
+	"github.com/donnyhardyanto/dxlib/utils"
+	"github.com/donnyhardyanto/dxlib_module/module/user_management"
+)
+
+const (
+	SessionLimitActionReject      = "REJECT"
+	SessionLimitActionEvictOldest = "EVICT_OLDEST"
+)
+
+/*
+  - Concurrent session limit, system.sessions.concurrent_limit (optional, no section means unlimited)
+    action                          : REJECT refuses the new login, EVICT_OLDEST (default) ends the oldest sessions
+    default_max_sessions            : limit for users no other entry applies to, 0 = unlimited
+    role_max_sessions               : {role_nameid: limit} for the user roles in the organization logged into
+    organization_type_max_sessions  : {organization type: limit}
+    When several entries apply the most permissive wins, so an admin role keeps 0 (unlimited)
+    even when the user also holds a limited role.
+*/
+
+type sessionLimitConfig struct {
+	Action                      string
+	DefaultMaxSessions          int
+	RoleMaxSessions             map[string]int
+	OrganizationTypeMaxSessions map[string]int
+}
+
+func sessionLimitConfigGet() (cfg sessionLimitConfig, isConfigured bool) {
+	cfg = sessionLimitConfig{
+		Action: SessionLimitActionEvictOldest,
+	}
+	configSystem, ok := configuration.Manager.Configurations["system"]
+	if !ok || configSystem.Data == nil {
+		return cfg, false
+	}
+	configSystemSession, err := utils.GetJSONFromKV(*configSystem.Data, "sessions")
+	if err != nil {
+		return cfg, false
+	}
+	configLimit, err := utils.GetJSONFromKV(configSystemSession, "concurrent_limit")
+	if err != nil {
+		return cfg, false
+	}
+	if action, _ := utils.GetStringFromKV(configLimit, "action"); action == SessionLimitActionReject {
+		cfg.Action = action
+	}
+	cfg.DefaultMaxSessions, _ = utils.GetIntFromKV(configLimit, "default_max_sessions")
+	cfg.RoleMaxSessions = configIntMap(configLimit["role_max_sessions"])
+	cfg.OrganizationTypeMaxSessions = configIntMap(configLimit["organization_type_max_sessions"])
+	return cfg, true
+}
+
+func configIntMap(v any) map[string]int {
+	m, ok := v.(utils.JSON)
+	if !ok {
+		m, ok = v.(map[string]any)
+		if !ok {
+			return nil
+		}
+	}
+	r := map[string]int{}
+	for k := range m {
+		n, err := utils.GetIntFromKV(m, k)
+		if err == nil {
+			r[k] = n
+		}
+	}
+	return r
+}
+
+// sessionLimitGet returns the number of sessions the user may hold in the organization, 0 = unlimited
+func (s *DxmSelf) sessionLimitGet(aepr *api.DXAPIEndPointRequest, cfg sessionLimitConfig, userId int64, organizationId int64, organization utils.JSON) (maxSessions int, err error) {
+	limits := []int{}
+	organizationType, _ := utils.GetStringFromKV(organization, "type")
+	if n, ok := cfg.OrganizationTypeMaxSessions[organizationType]; ok {
+		limits = append(limits, n)
+	}
+	if len(cfg.RoleMaxSessions) > 0 {
+		_, userRoleMemberships, err := user_management.ModuleUserManagement.UserRoleMembership.Select(aepr.Context, &aepr.Log, nil, utils.JSON{
+			"user_id":         userId,
+			"organization_id": organizationId,
+		}, nil, nil, nil, nil)
+		if err != nil {
+			return 0, err
+		}
+		for _, userRoleMembership := range userRoleMemberships {
+			roleNameId, _ := utils.GetStringFromKV(userRoleMembership, "role_nameid")
+			if n, ok := cfg.RoleMaxSessions[roleNameId]; ok {
+				limits = append(limits, n)
+			}
+		}
+	}
+	if len(limits) == 0 {
+		return cfg.DefaultMaxSessions, nil
+	}
+
+	maxSessions = limits[0]
+	for _, n := range limits {
+		if n <= 0 {
+			return 0, nil
+		}
+		if n > maxSessions {
+			maxSessions = n
+		}
+	}
+	return maxSessions, nil
+}
+
+// sessionsCountedAgainstLimit returns the live sessions of the user except impersonated ones, which neither take a slot
+// nor get evicted
+func sessionsCountedAgainstLimit(aepr *api.DXAPIEndPointRequest, userId int64) (sessions []utils.JSON, err error) {
+	allSessions, err := user_management.ModuleUserManagement.SessionIndexList(aepr.Context, userId)
+	if err != nil {
+		return nil, err
+	}
+	sessions = []utils.JSON{}
+	for _, session := range allSessions {
+		if !user_management.SessionIndexEntryIsImpersonation(session) {
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions, nil
+}
+
+// sessionLimitCheck refuses a login that would exceed the session limit when the action is REJECT.
+// Called before a session or a 2FA challenge is created, so a user at the limit is not asked for the second factor;
+// sessionRegister takes the slot atomically. A non-nil error means the response has been written.
+func (s *DxmSelf) sessionLimitCheck(aepr *api.DXAPIEndPointRequest, userId int64, organizationId int64, organization utils.JSON) (err error) {
+	cfg, isConfigured := sessionLimitConfigGet()
+	if !isConfigured || cfg.Action != SessionLimitActionReject {
+		return nil
+	}
+	maxSessions, err := s.sessionLimitGet(aepr, cfg, userId, organizationId, organization)
+	if err != nil {
+		return err
+	}
+	if maxSessions <= 0 {
+		return nil
+	}
+	sessions, err := sessionsCountedAgainstLimit(aepr, userId)
+	if err != nil {
+		return err
+	}
+	if len(sessions) >= maxSessions {
+		return aepr.WriteResponseAndNewErrorf(http.StatusConflict, "SESSION_LIMIT_REACHED", "NOT_ERROR:SESSION_LIMIT_REACHED:USER_ID=%d:MAX_SESSIONS=%d", userId, maxSessions)
+	}
+	return nil
+}
+
+// sessionLimitEvict ends the oldest sessions of the user until the new one fits, when the action is EVICT_OLDEST
+func (s *DxmSelf) sessionLimitEvict(aepr *api.DXAPIEndPointRequest, userId int64, organizationId int64, organization utils.JSON, sessionKey string, ttl time.Duration) (err error) {
+	cfg, isConfigured := sessionLimitConfigGet()
+	if !isConfigured || cfg.Action != SessionLimitActionEvictOldest {
+		return nil
+	}
+	maxSessions, err := s.sessionLimitGet(aepr, cfg, userId, organizationId, organization)
+	if err != nil {
+		return err
+	}
+	if maxSessions <= 0 {
+		return nil
+	}
+	sessions, err := sessionsCountedAgainstLimit(aepr, userId)
+	if err != nil {
+		return err
+	}
+	if len(sessions) <= maxSessions {
+		return nil
+	}
+
+	currentSessionId := user_management.SessionIdFromSessionKey(sessionKey)
+	sort.Slice(sessions, func(i, j int) bool {
+		a, _ := sessions[i]["created_at"].(string)
+		b, _ := sessions[j]["created_at"].(string)
+		return a < b
+	})
+	evictCount := len(sessions) - maxSessions
+	evictedCount := 0
+	for _, session := range sessions {
+		if evictedCount == evictCount {
+			break
+		}
+		sessionId, _ := session["session_id"].(string)
+		if sessionId == currentSessionId {
+			continue
+		}
+		err = user_management.ModuleUserManagement.SessionEvict(aepr.Context, userId, sessionId, ttl)
+		if err != nil {
+			return err
+		}
+		evictedCount++
+	}
+	aepr.Log.Infof("Session limit %d reached for user_id=%d, evicted %d oldest sessions", maxSessions, userId, evictedCount)
+	return nil
+}
+
+// requestDevice returns the device name the client reports, empty when it does not send one
+func requestDevice(aepr *api.DXAPIEndPointRequest) string {
+	return utils.GetStringFromMapStringStringDefault(aepr.EffectiveRequestHeader, "X-Device-Name", "")
+}
+
+// sessionRegister records a new login session in the per-user index and applies the session limit.
+// With REJECT the slot is taken in one atomic step and a non-nil error means SESSION_LIMIT_REACHED has been written,
+// the caller must drop the stored session. Otherwise best-effort: the session is already usable.
+func (s *DxmSelf) sessionRegister(aepr *api.DXAPIEndPointRequest, userId int64, organizationId int64, organization utils.JSON, sessionKey string, ttl time.Duration) (err error) {
+	maxSessions := 0
+	cfg, isConfigured := sessionLimitConfigGet()
+	if isConfigured && cfg.Action == SessionLimitActionReject {
+		maxSessions, err = s.sessionLimitGet(aepr, cfg, userId, organizationId, organization)
+		if err != nil {
+			return err
+		}
+	}
+	isAdded, err := user_management.ModuleUserManagement.SessionIndexAddWithinLimit(aepr.Context, userId, sessionKey, requestDevice(aepr), requestClientIPAddress(aepr), requestUserAgent(aepr), ttl, maxSessions)
+	if err != nil {
+		aepr.Log.Warnf("SESSION_INDEX_ADD_ERROR:user_id=%d:%v", userId, err)
+		return nil
+	}
+	if !isAdded {
+		return aepr.WriteResponseAndNewErrorf(http.StatusConflict, "SESSION_LIMIT_REACHED", "NOT_ERROR:SESSION_LIMIT_REACHED:USER_ID=%d:MAX_SESSIONS=%d", userId, maxSessions)
+	}
+	err = s.sessionLimitEvict(aepr, userId, organizationId, organization, sessionKey, ttl)
+	if err != nil {
+		aepr.Log.Warnf("SESSION_LIMIT_EVICT_ERROR:user_id=%d:%v", userId, err)
+	}
+	return nil
+}
+
+// sessionRevokeOthersAfterPasswordChange ends every session of the user except the one that changed the password
+func (s *DxmSelf) sessionRevokeOthersAfterPasswordChange(aepr *api.DXAPIEndPointRequest, userId int64) {
+	sessionKey, _ := aepr.LocalData["session_key"].(string)
+	revokedCount, err := user_management.ModuleUserManagement.SessionRevokeAllForUser(aepr.Context, userId, sessionKey)
+	if err != nil {
+		aepr.Log.Warnf("SESSION_REVOKE_ALL_ERROR:user_id=%d:%v", userId, err)
+		return
+	}
+	aepr.Log.Infof("Revoked %d other sessions after password change", revokedCount)
+}
+
+func (s *DxmSelf) SelfSessionList(aepr *api.DXAPIEndPointRequest) (err error) {
+	userId, err := utils.GetInt64FromKV(aepr.LocalData, "user_id")
+	if err != nil {
+		return err
+	}
+	sessionKey, err := utils.GetStringFromKV(aepr.LocalData, "session_key")
+	if err != nil {
+		return err
+	}
+
+	sessions, err := user_management.ModuleUserManagement.SessionIndexList(aepr.Context, userId)
+	if err != nil {
+		return err
+	}
+	currentSessionId := user_management.SessionIdFromSessionKey(sessionKey)
+	sessions = user_management.SessionIndexEntriesForResponse(sessions)
+	for _, session := range sessions {
+		session["is_current"] = session["session_id"] == currentSessionId
+	}
+
+	aepr.WriteResponseAsJSON(http.StatusOK, nil, utils.JSON{
+		"sessions": sessions,
+	})
+	return nil
+}
+
+func (s *DxmSelf) SelfSessionRevoke(aepr *api.DXAPIEndPointRequest) (err error) {
+	err = user_management.SessionImpersonationForbid(aepr)
+	if err != nil {
+		return err
+	}
+	userId, err := utils.GetInt64FromKV(aepr.LocalData, "user_id")
+	if err != nil {
+		return err
+	}
+	_, sessionId, err := aepr.GetParameterValueAsString("session_id")
+	if err != nil {
+		return err
+	}
+
+	isFound, err := user_management.ModuleUserManagement.SessionRevoke(aepr.Context, userId, sessionId)
+	if err != nil {
+		return err
+	}
+	if !isFound {
+		return aepr.WriteResponseAndNewErrorf(http.StatusNotFound, "SESSION_NOT_FOUND", "NOT_ERROR:SESSION_NOT_FOUND")
+	}
+
+	aepr.WriteResponseAsJSON(http.StatusOK, nil, nil)
+	return nil
+}
+
+// SelfSessionRevokeAllOthers ends every session of the logged user except the current one
+func (s *DxmSelf) SelfSessionRevokeAllOthers(aepr *api.DXAPIEndPointRequest) (err error) {
+	err = user_management.SessionImpersonationForbid(aepr)
+	if err != nil {
+		return err
+	}
+	userId, err := utils.GetInt64FromKV(aepr.LocalData, "user_id")
+	if err != nil {
+		return err
+	}
+	sessionKey, err := utils.GetStringFromKV(aepr.LocalData, "session_key")
+	if err != nil {
+		return err
+	}
+
+	revokedCount, err := user_management.ModuleUserManagement.SessionRevokeAllForUser(aepr.Context, userId, sessionKey)
+	if err != nil {
+		return err
+	}
+
+	aepr.WriteResponseAsJSON(http.StatusOK, nil, utils.JSON{
+		"revoked_count": revokedCount,
+	})
+	return nil
+}
@@ -0,0 +1,168 @@
+package self
+
+import (
+	"context"
+	"time"
+
+	"github.com/donnyhardyanto/dxlib/api"
+	"github.com/donnyhardyanto/dxlib/configuration"
+	"github.com/donnyhardyanto/dxlib/errors"
+	"github.com/donnyhardyanto/dxlib/utils"
+	utilsJSON "github.com/donnyhardyanto/dxlib/utils/json"
+	"github.com/donnyhardyanto/dxlib_module/module/user_management"
+)
+
+/*
+  - Session lifetime, system.sessions
+    session_ttl_in_seconds        : idle timeout, the session ends after this long without a request
+    absolute_lifetime_in_seconds  : the session ends this long after login however active it is, 0 = no limit (default)
+    organization_type_lifetimes   : {organization type: {"idle_timeout_in_seconds", "absolute_lifetime_in_seconds"}}
+    organization_uid_lifetimes    : {organization uid: {...}}, wins over the organization type entry
+    A key missing from an override keeps the value from the level above.
+
+  - The session object records
+    created_at                    : unix seconds of the login, kept when the session object is regenerated
+    absolute_expires_at           : unix seconds the session ends at, 0 = no absolute limit
+    idle_timeout_in_seconds       : idle timeout of the organization the session is logged into
+    access_token_expires_at       : unix seconds an access token of a refresh token family expires at, see self_refresh_token.go
+    impersonation_expires_at      : unix seconds an impersonated session ends at, see self_impersonation.go
+*/
+
+// sessionObjectPreservedKeys are carried over when the session object of a live session is regenerated
+var sessionObjectPreservedKeys = []string{"session_type", "refresh_token_family_id", "access_token_expires_at",
+	"impersonator_user_id", "impersonator_user_uid", "impersonator_user_loginid", "impersonator_session_key", "impersonation_expires_at"}
+
+type sessionLifetimePolicy struct {
+	IdleTimeoutInSeconds      int
+	AbsoluteLifetimeInSeconds int
+}
+
+func sessionLifetimePolicyGet(organization utils.JSON) (policy sessionLifetimePolicy, err error) {
+	configSystem := *configuration.Manager.Configurations["system"].Data
+	configSystemSession, ok := configSystem["sessions"].(utils.JSON)
+	if !ok {
+		return policy, errors.New(ErrorConfigSystemSessionsNotFound)
+	}
+	policy.IdleTimeoutInSeconds, ok = configSystemSession["session_ttl_in_seconds"].(int)
+	if !ok {
+		return policy, errors.New("SHOULD_NOT_HAPPEN:SESSIONS_TTL_SECOND_NOT_FOUND_OR_NOT_INT")
+	}
+	policy.AbsoluteLifetimeInSeconds, _ = utils.GetIntFromKV(configSystemSession, "absolute_lifetime_in_seconds")
+
+	organizationType, _ := utils.GetStringFromKV(organization, "type")
+	organizationUid, _ := utils.GetStringFromKV(organization, "uid")
+	sessionLifetimePolicyOverride(&policy, configSystemSession, "organization_type_lifetimes", organizationType)
+	sessionLifetimePolicyOverride(&policy, configSystemSession, "organization_uid_lifetimes", organizationUid)
+	return policy, nil
+}
+
+func sessionLifetimePolicyOverride(policy *sessionLifetimePolicy, configSystemSession utils.JSON, section string, key string) {
+	if key == "" {
+		return
+	}
+	configSection, err := utils.GetJSONFromKV(configSystemSession, section)
+	if err != nil {
+		return
+	}
+	configOverride, err := utils.GetJSONFromKV(configSection, key)
+	if err != nil {
+		return
+	}
+	if n, err := utils.GetIntFromKV(configOverride, "idle_timeout_in_seconds"); err == nil && n > 0 {
+		policy.IdleTimeoutInSeconds = n
+	}
+	if n, err := utils.GetIntFromKV(configOverride, "absolute_lifetime_in_seconds"); err == nil {
+		policy.AbsoluteLifetimeInSeconds = n
+	}
+}
+
+// sessionLifetimeStamp writes the lifetime fields into a session object being (re)generated.
+// A regenerated session keeps its created_at, the deadline is recomputed so a changed policy or organization applies.
+func sessionLifetimeStamp(aepr *api.DXAPIEndPointRequest, sessionKey string, sessionObject utils.JSON, organization utils.JSON) (err error) {
+	policy, err := sessionLifetimePolicyGet(organization)
+	if err != nil {
+		return err
+	}
+
+	createdAt := time.Now().Unix()
+	if previousSessionObject, ok := aepr.LocalData["session_object"].(utils.JSON); ok && previousSessionObject["session_key"] == sessionKey {
+		if previousCreatedAt, err := utilsJSON.GetInt64(previousSessionObject, "created_at"); err == nil && previousCreatedAt > 0 {
+			createdAt = previousCreatedAt
+		}
+		for _, k := range sessionObjectPreservedKeys {
+			if v, ok := previousSessionObject[k]; ok {
+				sessionObject[k] = v
+			}
+		}
+	}
+	sessionLifetimeStampAt(sessionObject, policy, createdAt)
+	sessionLifetimeImpersonationCap(sessionObject)
+	return nil
+}
+
+// sessionLifetimeStampAt writes the lifetime fields for a session whose login happened at createdAt
+func sessionLifetimeStampAt(sessionObject utils.JSON, policy sessionLifetimePolicy, createdAt int64) {
+	absoluteExpiresAt := int64(0)
+	if policy.AbsoluteLifetimeInSeconds > 0 {
+		absoluteExpiresAt = createdAt + int64(policy.AbsoluteLifetimeInSeconds)
+	}
+
+	sessionObject["created_at"] = createdAt
+	sessionObject["absolute_expires_at"] = absoluteExpiresAt
+	sessionObject["idle_timeout_in_seconds"] = policy.IdleTimeoutInSeconds
+}
+
+// sessionLifetimeImpersonationCap shortens the deadline and the idle timeout of an impersonated session to impersonation_expires_at
+func sessionLifetimeImpersonationCap(sessionObject utils.JSON) {
+	impersonationExpiresAt, err := utilsJSON.GetInt64(sessionObject, "impersonation_expires_at")
+	if err != nil || impersonationExpiresAt <= 0 {
+		return
+	}
+	absoluteExpiresAt, _ := utilsJSON.GetInt64(sessionObject, "absolute_expires_at")
+	if absoluteExpiresAt <= 0 || impersonationExpiresAt < absoluteExpiresAt {
+		sessionObject["absolute_expires_at"] = impersonationExpiresAt
+	}
+	createdAt, _ := utilsJSON.GetInt64(sessionObject, "created_at")
+	idleTimeoutInSeconds, _ := utilsJSON.GetInt64(sessionObject, "idle_timeout_in_seconds")
+	if lifetimeInSeconds := impersonationExpiresAt - createdAt; lifetimeInSeconds > 0 && (idleTimeoutInSeconds <= 0 || lifetimeInSeconds < idleTimeoutInSeconds) {
+		sessionObject["idle_timeout_in_seconds"] = int(lifetimeInSeconds)
+	}
+}
+
+// sessionTTL returns how long the session may live from now: its idle timeout, cut short by its access token expiry and absolute deadline.
+// Sessions created before the lifetime fields existed fall back to defaultIdleTimeout with no deadline.
+func sessionTTL(sessionObject utils.JSON, defaultIdleTimeout time.Duration) (ttl time.Duration, isLifetimeExceeded bool) {
+	ttl = defaultIdleTimeout
+	if idleTimeoutInSeconds, err := utilsJSON.GetInt64(sessionObject, "idle_timeout_in_seconds"); err == nil && idleTimeoutInSeconds > 0 {
+		ttl = time.Duration(idleTimeoutInSeconds) * time.Second
+	}
+	// An access token simply expires, the client refreshes it; Redis takes at least a second
+	if accessTokenExpiresAt, err := utilsJSON.GetInt64(sessionObject, "access_token_expires_at"); err == nil && accessTokenExpiresAt > 0 {
+		ttl = min(ttl, max(time.Until(time.Unix(accessTokenExpiresAt, 0)), time.Second))
+	}
+	absoluteExpiresAt, err := utilsJSON.GetInt64(sessionObject, "absolute_expires_at")
+	if err != nil || absoluteExpiresAt <= 0 {
+		return ttl, false
+	}
+	remaining := time.Until(time.Unix(absoluteExpiresAt, 0))
+	if remaining <= 0 {
+		return 0, true
+	}
+	if remaining < ttl {
+		ttl = remaining
+	}
+	return ttl, false
+}
+
+// sessionStore saves a session object with a TTL that honours both its idle timeout and its absolute deadline
+func sessionStore(ctx context.Context, sessionKey string, sessionObject utils.JSON, defaultIdleTimeout time.Duration) (err error) {
+	ttl, isLifetimeExceeded := sessionTTL(sessionObject, defaultIdleTimeout)
+	if isLifetimeExceeded {
+		err = user_management.ModuleUserManagement.SessionEnd(ctx, sessionKey, user_management.SessionEndedReasonLifetimeExceeded, defaultIdleTimeout)
+		if err != nil {
+			return err
+		}
+		return errors.New("NOT_ERROR:SESSION_LIFETIME_EXCEEDED")
+	}
+	return user_management.ModuleUserManagement.SessionRedis.Set(ctx, sessionKey, sessionObject, ttl)
+}
@@ -0,0 +1,623 @@
+package self
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/donnyhardyanto/dxlib/api"
+	"github.com/donnyhardyanto/dxlib/configuration"
+	"github.com/donnyhardyanto/dxlib/utils"
+	utilsJSON "github.com/donnyhardyanto/dxlib/utils/json"
+	"github.com/donnyhardyanto/dxlib_module/base"
+	"github.com/donnyhardyanto/dxlib_module/lib"
+	"github.com/donnyhardyanto/dxlib_module/module/account_lockout"
+	"github.com/donnyhardyanto/dxlib_module/module/user_management"
+)
+
+const (
+	defaultTwoFactorChallengeTTLInSeconds = 300
+	defaultTwoFactorChallengeMaxAttempts  = 5
+)
+
+/*
+  - Two factor login
+    When the user has a confirmed TOTP secret, or 2FA is enforced for the organization or one of the user roles,
+    SelfLogin* does not issue a session after the password check. It parks the login in SessionRedis under
+    two_factor_challenge:{token} and answers {"two_factor_required":true,"challenge_token":...}.
+    SelfLoginTwoFactor / SelfLoginTwoFactorE2EE exchange the token and a TOTP or recovery code for the session.
+    A user that is required to use 2FA but has not enrolled yet gets enrollment_required=true, calls
+    SelfLoginTwoFactorEnrollBegin with the token and confirms the first code through SelfLoginTwoFactor.
+
+  - Configuration, system.two_factor (all optional)
+    issuer                       : issuer shown in the authenticator app
+    challenge_ttl_in_seconds     : lifetime of the challenge token, default 300
+    challenge_max_attempts       : wrong codes allowed per challenge token, default 5
+    enforced_organization_uids   : organizations whose members must use 2FA
+    enforced_organization_types  : organization types whose members must use 2FA
+    enforced_role_nameids        : roles whose members must use 2FA
+*/
+
+type twoFactorConfig struct {
+	Issuer                    string
+	ChallengeTTLInSeconds     int
+	ChallengeMaxAttempts      int
+	EnforcedOrganizationUids  []string
+	EnforcedOrganizationTypes []string
+	EnforcedRoleNameIds       []string
+}
+
+func twoFactorConfigGet() twoFactorConfig {
+	cfg := twoFactorConfig{
+		ChallengeTTLInSeconds: defaultTwoFactorChallengeTTLInSeconds,
+		ChallengeMaxAttempts:  defaultTwoFactorChallengeMaxAttempts,
+	}
+	configSystem, ok := configuration.Manager.Configurations["system"]
+	if !ok || configSystem.Data == nil {
+		return cfg
+	}
+	configTwoFactor, err := utils.GetJSONFromKV(*configSystem.Data, "two_factor")
+	if err != nil {
+		return cfg
+	}
+	cfg.Issuer, _ = utils.GetStringFromKV(configTwoFactor, "issuer")
+	if v, err := utils.GetIntFromKV(configTwoFactor, "challenge_ttl_in_seconds"); err == nil && v > 0 {
+		cfg.ChallengeTTLInSeconds = v
+	}
+	if v, err := utils.GetIntFromKV(configTwoFactor, "challenge_max_attempts"); err == nil && v > 0 {
+		cfg.ChallengeMaxAttempts = v
+	}
+	cfg.EnforcedOrganizationUids = configStringList(configTwoFactor["enforced_organization_uids"])
+	cfg.EnforcedOrganizationTypes = configStringList(configTwoFactor["enforced_organization_types"])
+	cfg.EnforcedRoleNameIds = configStringList(configTwoFactor["enforced_role_nameids"])
+	return cfg
+}
+
+func configStringList(v any) []string {
+	switch vt := v.(type) {
+	case []string:
+		return vt
+	case []any:
+		r := make([]string, 0, len(vt))
+		for _, e := range vt {
+			if s, ok := e.(string); ok {
+				r = append(r, s)
+			}
+		}
+		return r
+	default:
+		return nil
+	}
+}
+
+func twoFactorChallengeKey(challengeToken string) string {
+	return fmt.Sprintf("two_factor_challenge:%s", challengeToken)
+}
+
+func twoFactorChallengeAttemptsKey(challengeToken string) string {
+	return fmt.Sprintf("two_factor_challenge_attempts:%s", challengeToken)
+}
+
+func twoFactorVerifyAttemptsKey(userId int64) string {
+	return fmt.Sprintf("two_factor_verify_attempts:%d", userId)
+}
+
+// twoFactorIsRequired reports whether 2FA is enforced for the user in the organization being logged into
+func (s *DxmSelf) twoFactorIsRequired(aepr *api.DXAPIEndPointRequest, cfg twoFactorConfig, userId int64, organizationId int64, organization utils.JSON) (isRequired bool, err error) {
+	organizationUid, _ := utils.GetStringFromKV(organization, "uid")
+	if organizationUid != "" && slices.Contains(cfg.EnforcedOrganizationUids, organizationUid) {
+		return true, nil
+	}
+	organizationType, _ := utils.GetStringFromKV(organization, "type")
+	if organizationType != "" && slices.Contains(cfg.EnforcedOrganizationTypes, organizationType) {
+		return true, nil
+	}
+	if len(cfg.EnforcedRoleNameIds) == 0 {
+		return false, nil
+	}
+
+	_, userRoleMemberships, err := user_management.ModuleUserManagement.UserRoleMembership.Select(aepr.Context, &aepr.Log, nil, utils.JSON{
+		"user_id":         userId,
+		"organization_id": organizationId,
+	}, nil, nil, nil, nil)
+	if err != nil {
+		return false, err
+	}
+	for _, userRoleMembership := range userRoleMemberships {
+		roleNameId, _ := utils.GetStringFromKV(userRoleMembership, "role_nameid")
+		if slices.Contains(cfg.EnforcedRoleNameIds, roleNameId) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// twoFactorLoginChallengeCreate is called by SelfLogin* after the password check.
+// It returns nil when no second factor is needed, the caller then issues the session as before.
+// organizationUIdFilter is the organization_uid the client sent, kept to rebuild the same memberships on completion.
+func (s *DxmSelf) twoFactorLoginChallengeCreate(aepr *api.DXAPIEndPointRequest, userId int64, organizationUIdFilter string, userLoggedOrganizationId int64,
+	userLoggedOrganizationUid string, userLoggedOrganization utils.JSON) (challenge utils.JSON, err error) {
+	cfg := twoFactorConfigGet()
+
+	isEnabled, err := user_management.ModuleUserManagement.UserTotpIsEnabled(aepr.Context, &aepr.Log, userId)
+	if err != nil {
+		return nil, err
+	}
+	isRequired, err := s.twoFactorIsRequired(aepr, cfg, userId, userLoggedOrganizationId, userLoggedOrganization)
+	if err != nil {
+		return nil, err
+	}
+	if !isEnabled && !isRequired {
+		return nil, nil
+	}
+
+	challengeToken, err := GenerateSessionKey()
+	if err != nil {
+		return nil, err
+	}
+	challengeTTL := time.Duration(cfg.ChallengeTTLInSeconds) * time.Second
+	err = user_management.ModuleUserManagement.SessionRedis.Set(aepr.Context, twoFactorChallengeKey(challengeToken), utils.JSON{
+		"user_id":                 userId,
+		"organization_id":         userLoggedOrganizationId,
+		"organization_uid":        userLoggedOrganizationUid,
+		"organization_uid_filter": organizationUIdFilter,
+		"enrollment_required":     !isEnabled,
+	}, challengeTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	return utils.JSON{
+		"two_factor_required": true,
+		"challenge_token":     challengeToken,
+		"enrollment_required": !isEnabled,
+		"expires_in_seconds":  cfg.ChallengeTTLInSeconds,
+	}, nil
+}
+
+// twoFactorChallengeGet loads a live challenge. A non-nil error means the response has been written.
+func (s *DxmSelf) twoFactorChallengeGet(aepr *api.DXAPIEndPointRequest, challengeToken string) (challenge utils.JSON, userId int64, err error) {
+	if challengeToken == "" {
+		return nil, 0, aepr.WriteResponseAndNewErrorf(http.StatusUnauthorized, "TWO_FACTOR_CHALLENGE_EXPIRED", "NOT_ERROR:TWO_FACTOR_CHALLENGE_TOKEN_IS_EMPTY")
+	}
+	challenge, err = user_management.ModuleUserManagement.SessionRedis.Get(aepr.Context, twoFactorChallengeKey(challengeToken))
+	if err != nil {
+		return nil, 0, err
+	}
+	if challenge == nil {
+		return nil, 0, aepr.WriteResponseAndNewErrorf(http.StatusUnauthorized, "TWO_FACTOR_CHALLENGE_EXPIRED", "NOT_ERROR:TWO_FACTOR_CHALLENGE_NOT_FOUND")
+	}
+	userId, err = utilsJSON.GetInt64(challenge, "user_id")
+	if err != nil {
+		return nil, 0, err
+	}
+	return challenge, userId, nil
+}
+
+func (s *DxmSelf) twoFactorChallengeDelete(aepr *api.DXAPIEndPointRequest, challengeToken string) {
+	err := user_management.ModuleUserManagement.SessionRedis.Delete(aepr.Context, twoFactorChallengeKey(challengeToken))
+	if err != nil {
+		aepr.Log.Warnf("TWO_FACTOR_CHALLENGE_DELETE_ERROR:%v", err)
+	}
+	_ = user_management.ModuleUserManagement.SessionRedis.Connection.Del(aepr.Context, twoFactorChallengeAttemptsKey(challengeToken)).Err()
+}
+
+// twoFactorLoginComplete verifies the second factor for a challenge and creates the session.
+// sessionObject is nil without error when a response other than the session has already been written.
+func (s *DxmSelf) twoFactorLoginComplete(aepr *api.DXAPIEndPointRequest, challengeToken string, code string) (sessionObject utils.JSON, recoveryCodes []string, err error) {
+	challenge, userId, err := s.twoFactorChallengeGet(aepr, challengeToken)
+	if err != nil {
+		return nil, nil, err
+	}
+	enrollmentRequired, _ := challenge["enrollment_required"].(bool)
+	userLoggedOrganizationId, err := utilsJSON.GetInt64(challenge, "organization_id")
+	if err != nil {
+		return nil, nil, err
+	}
+	userLoggedOrganizationUid, err := utilsJSON.GetString(challenge, "organization_uid")
+	if err != nil {
+		return nil, nil, err
+	}
+	organizationUIdFilter, _ := utilsJSON.GetString(challenge, "organization_uid_filter")
+
+	_, user, err := user_management.ModuleUserManagement.User.GetById(aepr.Context, &aepr.Log, userId)
+	if err != nil {
+		return nil, nil, err
+	}
+	if user == nil {
+		s.twoFactorChallengeDelete(aepr, challengeToken)
+		return nil, nil, aepr.WriteResponseAndNewErrorf(http.StatusUnauthorized, base.MsgInvalidCredential, base.LogMsgNotErrorInvalidCredential)
+	}
+	err = s.accountLockoutCheck(aepr, user)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cfg := twoFactorConfigGet()
+	attempts, err := user_management.ModuleUserManagement.SessionRedis.Connection.Incr(aepr.Context, twoFactorChallengeAttemptsKey(challengeToken)).Result()
+	if err != nil {
+		return nil, nil, err
+	}
+	if attempts == 1 {
+		_ = user_management.ModuleUserManagement.SessionRedis.Connection.Expire(aepr.Context, twoFactorChallengeAttemptsKey(challengeToken), time.Duration(cfg.ChallengeTTLInSeconds)*time.Second).Err()
+	}
+	if attempts > int64(cfg.ChallengeMaxAttempts) {
+		s.twoFactorChallengeDelete(aepr, challengeToken)
+		return nil, nil, aepr.WriteResponseAndNewErrorf(http.StatusUnauthorized, "TWO_FACTOR_CHALLENGE_EXPIRED", "NOT_ERROR:TWO_FACTOR_CHALLENGE_MAX_ATTEMPTS_REACHED")
+	}
+
+	var isValid bool
+	if enrollmentRequired {
+		isValid, recoveryCodes, err = user_management.ModuleUserManagement.UserTotpEnrollConfirm(aepr.Context, &aepr.Log, userId, code)
+		if err != nil {
+			return nil, nil, aepr.WriteResponseAndNewErrorf(http.StatusConflict, "TWO_FACTOR_ENROLLMENT_NOT_FOUND", "NOT_ERROR:TWO_FACTOR_ENROLLMENT_NOT_STARTED:%s", err.Error())
+		}
+	} else {
+		isValid, err = user_management.ModuleUserManagement.UserTotpVerify(aepr.Context, &aepr.Log, userId, code)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !isValid {
+			isValid, err = user_management.ModuleUserManagement.UserRecoveryCodeVerify(aepr.Context, &aepr.Log, userId, code)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	if !isValid {
+		userLoginId, _ := utils.GetStringFromKV(user, "loginid")
+		s.accountLockoutRecordFailedAttempt(aepr, user, userLoginId, userLoggedOrganizationId, userLoggedOrganizationUid, account_lockout.AttemptTypeTOTP, AuthSourceLocal)
+		return nil, nil, aepr.WriteResponseAndNewErrorf(http.StatusUnauthorized, "INVALID_TWO_FACTOR_CODE", "NOT_ERROR:INVALID_TWO_FACTOR_CODE")
+	}
+	s.twoFactorChallengeDelete(aepr, challengeToken)
+
+	attempt := &LoginAttempt{
+		User:                  user,
+		UserId:                userId,
+		OrganizationUidFilter: organizationUIdFilter,
+		OrganizationId:        userLoggedOrganizationId,
+		OrganizationUid:       userLoggedOrganizationUid,
+	}
+	attempt.UserOrganizationMemberships, err = loginUserOrganizationMembershipsGet(aepr, userId, organizationUIdFilter)
+	if err != nil {
+		return nil, nil, err
+	}
+	_, attempt.Organization, err = user_management.ModuleUserManagement.Organization.ShouldGetById(aepr.Context, &aepr.Log, userLoggedOrganizationId)
+	if err != nil {
+		return nil, nil, err
+	}
+	_, err = s.LoginCheckSessionLimit(aepr, attempt)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sessionObject, err = s.loginSessionIssue(aepr, attempt)
+	if err != nil {
+		return nil, nil, err
+	}
+	if sessionObject == nil {
+		return nil, nil, nil
+	}
+	return sessionObject, recoveryCodes, nil
+}
+
+// SelfLoginTwoFactor completes a login that answered two_factor_required, returns the session object.
+// recovery_codes is only present when the login also finished a forced enrollment.
+func (s *DxmSelf) SelfLoginTwoFactor(aepr *api.DXAPIEndPointRequest) (err error) {
+	_, challengeToken, err := aepr.GetParameterValueAsString("challenge_token")
+	if err != nil {
+		return err
+	}
+	_, code, err := aepr.GetParameterValueAsString("code")
+	if err != nil {
+		return err
+	}
+
+	sessionObject, recoveryCodes, err := s.twoFactorLoginComplete(aepr, challengeToken, code)
+	if err != nil {
+		return err
+	}
+	if sessionObject == nil {
+		return nil
+	}
+
+	response := utils.JSON{
+		"session_object": sessionObject,
+	}
+	if recoveryCodes != nil {
+		response["recovery_codes"] = recoveryCodes
+	}
+	aepr.WriteResponseAsJSON(http.StatusOK, nil, response)
+	return nil
+}
+
+// SelfLoginTwoFactorE2EE is SelfLoginTwoFactor for the E2EE login variants, payload is LV(challenge_token),LV(code)
+func (s *DxmSelf) SelfLoginTwoFactorE2EE(aepr *api.DXAPIEndPointRequest) (err error) {
+	attempt := &LoginAttempt{}
+	lvPayloadElements, err := loginE2EEUnpack(aepr, attempt, "INVALID_PREKEY")
+	if err != nil {
+		return err
+	}
+	if len(lvPayloadElements) < 2 {
+		return aepr.WriteResponseAndNewErrorf(http.StatusUnprocessableEntity, "INVALID_PAYLOAD", "NOT_ERROR:PAYLOAD_ELEMENTS_LESS_THAN_2")
+	}
+	challengeToken := string(lvPayloadElements[0].Value)
+	code := string(lvPayloadElements[1].Value)
+
+	sessionObject, recoveryCodes, err := s.twoFactorLoginComplete(aepr, challengeToken, code)
+	if err != nil {
+		return err
+	}
+	if sessionObject == nil {
+		return nil
+	}
+
+	response := utils.JSON{
+		"session_object": sessionObject,
+	}
+	if recoveryCodes != nil {
+		response["recovery_codes"] = recoveryCodes
+	}
+	return loginWriteResponse(aepr, attempt.E2EE, response, "")
+}
+
+// SelfLoginTwoFactorEnrollBegin lets a user that must use 2FA but never enrolled create the secret during login
+func (s *DxmSelf) SelfLoginTwoFactorEnrollBegin(aepr *api.DXAPIEndPointRequest) (err error) {
+	_, challengeToken, err := aepr.GetParameterValueAsString("challenge_token")
+	if err != nil {
+		return err
+	}
+
+	challenge, userId, err := s.twoFactorChallengeGet(aepr, challengeToken)
+	if err != nil {
+		return err
+	}
+	enrollmentRequired, _ := challenge["enrollment_required"].(bool)
+	if !enrollmentRequired {
+		return aepr.WriteResponseAndNewErrorf(http.StatusConflict, "TWO_FACTOR_ALREADY_ENABLED", "NOT_ERROR:TWO_FACTOR_ALREADY_ENABLED")
+	}
+
+	return s.twoFactorEnrollBegin(aepr, userId)
+}
+
+func (s *DxmSelf) twoFactorEnrollBegin(aepr *api.DXAPIEndPointRequest, userId int64) (err error) {
+	isEnabled, err := user_management.ModuleUserManagement.UserTotpIsEnabled(aepr.Context, &aepr.Log, userId)
+	if err != nil {
+		return err
+	}
+	if isEnabled {
+		return aepr.WriteResponseAndNewErrorf(http.StatusConflict, "TWO_FACTOR_ALREADY_ENABLED", "NOT_ERROR:TWO_FACTOR_ALREADY_ENABLED")
+	}
+
+	_, user, err := user_management.ModuleUserManagement.User.ShouldGetById(aepr.Context, &aepr.Log, userId)
+	if err != nil {
+		return err
+	}
+	userLoginId, err := utils.GetStringFromKV(user, "loginid")
+	if err != nil {
+		return err
+	}
+
+	secret, err := user_management.ModuleUserManagement.UserTotpEnrollBegin(aepr.Context, &aepr.Log, userId)
+	if err != nil {
+		return err
+	}
+
+	aepr.WriteResponseAsJSON(http.StatusOK, nil, utils.JSON{
+		"secret":           secret,
+		"provisioning_uri": lib.TOTPProvisioningURI(twoFactorConfigGet().Issuer, userLoginId, secret),
+	})
+	return nil
+}
+
+// twoFactorVerifyCurrentUser checks a TOTP or recovery code of the logged user before a change to the second factor.
+// Like a login challenge it allows challenge_max_attempts wrong codes per challenge_ttl_in_seconds, and every wrong code
+// counts toward the account lockout, so a stolen session cannot guess its way to removing 2FA.
+func (s *DxmSelf) twoFactorVerifyCurrentUser(aepr *api.DXAPIEndPointRequest, userId int64, code string) (err error) {
+	sessionUser, _ := aepr.LocalData["user"].(utils.JSON)
+	userUid, _ := aepr.LocalData["user_uid"].(string)
+	userLoginId, _ := utils.GetStringFromKV(sessionUser, "loginid")
+	user := utils.JSON{
+		"id":      userId,
+		"uid":     userUid,
+		"loginid": userLoginId,
+	}
+	err = s.accountLockoutCheck(aepr, user)
+	if err != nil {
+		return err
+	}
+
+	cfg := twoFactorConfigGet()
+	attemptsKey := twoFactorVerifyAttemptsKey(userId)
+	attempts, err := user_management.ModuleUserManagement.SessionRedis.Connection.Incr(aepr.Context, attemptsKey).Result()
+	if err != nil {
+		return err
+	}
+	if attempts == 1 {
+		_ = user_management.ModuleUserManagement.SessionRedis.Connection.Expire(aepr.Context, attemptsKey, time.Duration(cfg.ChallengeTTLInSeconds)*time.Second).Err()
+	}
+	if attempts > int64(cfg.ChallengeMaxAttempts) {
+		return aepr.WriteResponseAndNewErrorf(http.StatusTooManyRequests, "TWO_FACTOR_TOO_MANY_ATTEMPTS", "NOT_ERROR:TWO_FACTOR_VERIFY_MAX_ATTEMPTS_REACHED")
+	}
+
+	isValid, err := user_management.ModuleUserManagement.UserTotpVerify(aepr.Context, &aepr.Log, userId, code)
+	if err != nil {
+		return err
+	}
+	if !isValid {
+		isValid, err = user_management.ModuleUserManagement.UserRecoveryCodeVerify(aepr.Context, &aepr.Log, userId, code)
+		if err != nil {
+			return err
+		}
+	}
+	if !isValid {
+		organizationId, _ := aepr.LocalData["organization_id"].(int64)
+		organizationUid, _ := aepr.LocalData["organization_uid"].(string)
+		s.accountLockoutRecordFailedAttempt(aepr, user, userLoginId, organizationId, organizationUid, account_lockout.AttemptTypeTOTP, AuthSourceLocal)
+		return aepr.WriteResponseAndNewErrorf(http.StatusUnauthorized, "INVALID_TWO_FACTOR_CODE", "NOT_ERROR:INVALID_TWO_FACTOR_CODE")
+	}
+	_ = user_management.ModuleUserManagement.SessionRedis.Connection.Del(aepr.Context, attemptsKey).Err()
+	return nil
+}
+
+func (s *DxmSelf) SelfTwoFactorStatus(aepr *api.DXAPIEndPointRequest) (err error) {
+	userId, err := utils.GetInt64FromKV(aepr.LocalData, "user_id")
+	if err != nil {
+		return err
+	}
+	organizationId, err := utils.GetInt64FromKV(aepr.LocalData, "organization_id")
+	if err != nil {
+		return err
+	}
+	organization, err := utils.GetVFromKV[utils.JSON](aepr.LocalData, "organization")
+	if err != nil {
+		return err
+	}
+
+	userTotp, err := user_management.ModuleUserManagement.UserTotpGet(aepr.Context, &aepr.Log, userId)
+	if err != nil {
+		return err
+	}
+	isRequired, err := s.twoFactorIsRequired(aepr, twoFactorConfigGet(), userId, organizationId, organization)
+	if err != nil {
+		return err
+	}
+	recoveryCodesRemaining, err := user_management.ModuleUserManagement.UserRecoveryCodeRemainingCount(aepr.Context, &aepr.Log, userId)
+	if err != nil {
+		return err
+	}
+
+	isEnabled := false
+	var confirmedAt any
+	if userTotp != nil {
+		isEnabled, _ = userTotp["is_confirmed"].(bool)
+		confirmedAt = userTotp["confirmed_at"]
+	}
+
+	aepr.WriteResponseAsJSON(http.StatusOK, nil, utils.JSON{
+		"is_enabled":               isEnabled,
+		"is_required":              isRequired,
+		"confirmed_at":             confirmedAt,
+		"recovery_codes_remaining": recoveryCodesRemaining,
+	})
+	return nil
+}
+
+func (s *DxmSelf) SelfTwoFactorEnrollBegin(aepr *api.DXAPIEndPointRequest) (err error) {
+	err = user_management.SessionImpersonationForbid(aepr)
+	if err != nil {
+		return err
+	}
+	userId, err := utils.GetInt64FromKV(aepr.LocalData, "user_id")
+	if err != nil {
+		return err
+	}
+	return s.twoFactorEnrollBegin(aepr, userId)
+}
+
+// SelfTwoFactorEnrollConfirm activates the pending secret with its first code and returns the recovery codes, shown only once
+func (s *DxmSelf) SelfTwoFactorEnrollConfirm(aepr *api.DXAPIEndPointRequest) (err error) {
+	err = user_management.SessionImpersonationForbid(aepr)
+	if err != nil {
+		return err
+	}
+	userId, err := utils.GetInt64FromKV(aepr.LocalData, "user_id")
+	if err != nil {
+		return err
+	}
+	_, code, err := aepr.GetParameterValueAsString("code")
+	if err != nil {
+		return err
+	}
+
+	isValid, recoveryCodes, err := user_management.ModuleUserManagement.UserTotpEnrollConfirm(aepr.Context, &aepr.Log, userId, code)
+	if err != nil {
+		return aepr.WriteResponseAndNewErrorf(http.StatusConflict, "TWO_FACTOR_ENROLLMENT_NOT_FOUND", "NOT_ERROR:TWO_FACTOR_ENROLLMENT_NOT_STARTED:%s", err.Error())
+	}
+	if !isValid {
+		return aepr.WriteResponseAndNewErrorf(http.StatusUnauthorized, "INVALID_TWO_FACTOR_CODE", "NOT_ERROR:INVALID_TWO_FACTOR_CODE")
+	}
+	aepr.Log.Infof("User two factor authentication enabled")
+
+	aepr.WriteResponseAsJSON(http.StatusOK, nil, utils.JSON{
+		"recovery_codes": recoveryCodes,
+	})
+	return nil
+}
+
+// SelfTwoFactorDisable removes the second factor, refused while 2FA is enforced for the user
+func (s *DxmSelf) SelfTwoFactorDisable(aepr *api.DXAPIEndPointRequest) (err error) {
+	err = user_management.SessionImpersonationForbid(aepr)
+	if err != nil {
+		return err
+	}
+	userId, err := utils.GetInt64FromKV(aepr.LocalData, "user_id")
+	if err != nil {
+		return err
+	}
+	organizationId, err := utils.GetInt64FromKV(aepr.LocalData, "organization_id")
+	if err != nil {
+		return err
+	}
+	organization, err := utils.GetVFromKV[utils.JSON](aepr.LocalData, "organization")
+	if err != nil {
+		return err
+	}
+	_, code, err := aepr.GetParameterValueAsString("code")
+	if err != nil {
+		return err
+	}
+
+	isRequired, err := s.twoFactorIsRequired(aepr, twoFactorConfigGet(), userId, organizationId, organization)
+	if err != nil {
+		return err
+	}
+	if isRequired {
+		return aepr.WriteResponseAndNewErrorf(http.StatusForbidden, "TWO_FACTOR_IS_REQUIRED", "NOT_ERROR:TWO_FACTOR_IS_REQUIRED")
+	}
+
+	err = s.twoFactorVerifyCurrentUser(aepr, userId, code)
+	if err != nil {
+		return err
+	}
+
+	err = user_management.ModuleUserManagement.UserTotpDisable(aepr.Context, &aepr.Log, userId)
+	if err != nil {
+		return err
+	}
+	aepr.Log.Infof("User two factor authentication disabled")
+
+	aepr.WriteResponseAsJSON(http.StatusOK, nil, nil)
+	return nil
+}
+
+func (s *DxmSelf) SelfTwoFactorRecoveryCodesRegenerate(aepr *api.DXAPIEndPointRequest) (err error) {
+	err = user_management.SessionImpersonationForbid(aepr)
+	if err != nil {
+		return err
+	}
+	userId, err := utils.GetInt64FromKV(aepr.LocalData, "user_id")
+	if err != nil {
+		return err
+	}
+	_, code, err := aepr.GetParameterValueAsString("code")
+	if err != nil {
+		return err
+	}
+
+	err = s.twoFactorVerifyCurrentUser(aepr, userId, code)
+	if err != nil {
+		return err
+	}
+
+	recoveryCodes, err := user_management.ModuleUserManagement.UserRecoveryCodesRegenerate(aepr.Context, &aepr.Log, userId)
+	if err != nil {
+		return err
+	}
+
+	aepr.WriteResponseAsJSON(http.StatusOK, nil, utils.JSON{
+		"recovery_codes": recoveryCodes,
+	})
+	return nil
+}
@@ -57,18 +57,30 @@ var (
 	DXMUserLoginIdSyncToEnumSetAll = []any{DXMUserLoginIdSyncToNone, DXMUserLoginIdSyncToEmail, DXMUserLoginIdSyncToPhoneNumber, DXMUserLoginIdSyncToLdapLoginId}
 )
 
-const MinPasswordHashMethod byte = 2 // bcrypt — hardcoded floor
+// Password hash methods, stored in each user_password value, see passwordHashCreate
+const (
+	PasswordHashMethodSHA512   byte = 1
+	PasswordHashMethodBcrypt   byte = 2
+	PasswordHashMethodArgon2id byte = 3
+	// PasswordHashMethodArgon2idV2 stores its Argon2id parameters in the hash, see PasswordHashArgon2idParameters
+	PasswordHashMethodArgon2idV2 byte = 4
+)
+
+const MinPasswordHashMethod = PasswordHashMethodBcrypt // hardcoded floor
 
 type OnUserPasswordValidationDef func(password string) (err error)
 type DxmUserManagement struct {
 	dxlibModule.DXModule
 	CurrentPasswordHashMethod            byte
+	PasswordHashArgon2idParameters       Argon2idParameters // see SetPasswordHashArgon2idParameters
 	UserPasswordEncryptionKeyDef         *databases.EncryptionKeyDef
 	UserOrganizationMembershipType       UserOrganizationMembershipType
 	SessionRedis                         *redis.DXRedis
 	PreKeyRedis                          *redis.DXRedis
 	User                                 *tables.DXTable
 	UserPassword                         *tables.DXTable
+	UserTotp                             *tables.DXTable
+	UserRecoveryCode                     *tables.DXTable
 	UserMessageChannelType               *tables.DXRawTable
 	UserMessageCategory                  *tables.DXRawTable
 	UserMessage                          *tables.DXTable
@@ -81,6 +93,7 @@ type DxmUserManagement struct {
 	UserRoleMembership                   *tables.DXTable
 	MenuItem                             *tables.DXTable
 	OnUserFormatPasswordValidation       OnUserPasswordValidationDef
+	BreachedPasswordChecker              BreachedPasswordChecker // nil: off, see user_management_breached_password.go
 	OnUserAfterCreate                    func(aepr *api.DXAPIEndPointRequest, dtx *databases.DXDatabaseTx, user utils.JSON, userPassword string) (err error)
 	OnUserResetPassword                  func(aepr *api.DXAPIEndPointRequest, dtx *databases.DXDatabaseTx, user utils.JSON, userPassword string) (err error)
 	OnUserRoleMembershipAfterCreate      func(aepr *api.DXAPIEndPointRequest, dtx *databases.DXDatabaseTx, userRoleMembership utils.JSON, organizationId int64) (err error)
@@ -101,6 +114,7 @@ func (um *DxmUserManagement) Init(databaseNameId string, userPasswordEncryptionK
 	um.DatabaseNameId = databaseNameId
 	um.UserPasswordEncryptionKeyDef = userPasswordEncryptionKeyDef
 	um.CurrentPasswordHashMethod = MinPasswordHashMethod
+	um.PasswordHashArgon2idParameters = DefaultArgon2idParameters
 	um.User = tables.NewDXTableSimple(databaseNameId,
 		"user_management.user", "user_management.user", "user_management.v_user",
 		"id", "uid", "loginid", "data",
@@ -132,6 +146,27 @@ func (um *DxmUserManagement) Init(databaseNameId string, userPasswordEncryptionK
 		[]string{"user_id", "created_at", "id", "uid"},
 		[]string{"id", "uid", "user_id", "created_at", "last_modified_at", "is_deleted"},
 	)
+	um.UserTotp = tables.NewDXTableWithEncryption(databaseNameId,
+		"user_management.user_totp", "user_management.user_totp", "user_management.v_user_totp",
+		"id", "uid", "", "data",
+		nil,
+		[]databases.EncryptionColumnDef{
+			{FieldName: "secret_encrypted", DataFieldName: "secret", AliasName: "secret", EncryptionKeyDef: um.UserPasswordEncryptionKeyDef, HashFieldName: "", ViewHasDecrypt: true},
+		},
+		[][]string{{"user_id"}},
+		nil,
+		[]string{"user_id", "confirmed_at", "created_at", "id", "uid"},
+		[]string{"id", "uid", "user_id", "is_confirmed", "confirmed_at", "last_used_time_step", "created_at", "last_modified_at", "is_deleted"},
+	)
+	um.UserRecoveryCode = tables.NewDXTableSimple(databaseNameId,
+		"user_management.user_recovery_code", "user_management.user_recovery_code", "user_management.user_recovery_code",
+		"id", "uid", "", "data",
+		nil,
+		nil,
+		nil,
+		[]string{"user_id", "created_at", "id", "uid"},
+		[]string{"id", "uid", "user_id", "is_used", "used_at", "created_at", "is_deleted"},
+	)
 	um.Role = tables.NewDXTableSimple(databaseNameId,
 		"user_management.role", "user_management.role", "user_management.v_role",
 		"id", "uid", "nameid", "data",
@@ -291,6 +326,21 @@ func RoleNameidsTextFragment(dbType dxlibBase.DXDatabaseType, userIdRef string)
 	}
 }
 
+func RolePrivilegesInheritedQuery(dbType dxlibBase.DXDatabaseType, userIdRef string, direction string) string {
+	switch dbType {
+	case dxlibBase.DXDatabaseTypePostgreSQL, dxlibBase.DXDatabaseTypePostgresSQLV2:
+		return getPostgreSQLRolePrivilegesInheritedQuery(userIdRef, direction)
+	case dxlibBase.DXDatabaseTypeSQLServer:
+		return getSQLServerRolePrivilegesInheritedQuery(userIdRef, direction)
+	case dxlibBase.DXDatabaseTypeOracle:
+		return getOracleRolePrivilegesInheritedQuery(userIdRef, direction)
+	case dxlibBase.DXDatabaseTypeMariaDB:
+		return getMariaDBRolePrivilegesInheritedQuery(userIdRef, direction)
+	default:
+		return getMariaDBRolePrivilegesInheritedQuery(userIdRef, direction)
+	}
+}
+
 func (um *DxmUserManagement) UserMessageCreateFCMAllApplication(ctx context.Context, l *log.DXLog, userId int64, userMessageCategoryId int64, templateTitle, templateBody string, templateData utils.JSON, attachedData map[string]string) (err error) {
 	for key, value := range templateData {
 		if nestedMap, ok := value.(map[string]any); ok {
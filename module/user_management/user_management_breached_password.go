@@ -0,0 +1,204 @@
+package user_management
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/donnyhardyanto/dxlib/errors"
+)
+
+/*
+  - Breached password check
+    UserPasswordValidate rejects a password found by um.BreachedPasswordChecker (violation BREACHED), nil turns it off.
+    Both checkers here work on local files, without network:
+    - BreachedPasswordRangeFiles, a directory of Have I Been Pwned range files: one file per 5 hex digit prefix of the
+      SHA-1 of the password, named by the prefix (optionally .txt), each line "SUFFIX:COUNT" with the 35 remaining
+      hex digits. A missing prefix file means no breached password has that prefix.
+    - BreachedPasswordBloomFilter, a bloom filter of the same SHA-1 hashes in one file, much smaller than the range
+      files at the cost of a small false positive rate; see NewBreachedPasswordBloomFilter to build one.
+    An HTTP range API client only has to implement BreachedPasswordChecker.
+*/
+
+type BreachedPasswordChecker interface {
+	IsBreached(ctx context.Context, password string) (isBreached bool, err error)
+}
+
+func breachedPasswordSHA1(password string) [sha1.Size]byte {
+	return sha1.Sum([]byte(password))
+}
+
+// BreachedPasswordRangeFiles looks up the SHA-1 suffix in the range file of its prefix
+type BreachedPasswordRangeFiles struct {
+	Dir      string
+	MinCount int64 // a suffix seen fewer times is not counted as breached, 0 counts all
+}
+
+func NewBreachedPasswordRangeFiles(dir string, minCount int64) (*BreachedPasswordRangeFiles, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, errors.New(fmt.Sprintf("BREACHED_PASSWORD_RANGE_FILES_NOT_A_DIRECTORY:%s", dir))
+	}
+	return &BreachedPasswordRangeFiles{Dir: dir, MinCount: minCount}, nil
+}
+
+func (b *BreachedPasswordRangeFiles) rangeFileOpen(prefix string) (f *os.File, err error) {
+	for _, name := range []string{prefix, prefix + ".txt", strings.ToLower(prefix), strings.ToLower(prefix) + ".txt"} {
+		f, err = os.Open(filepath.Join(b.Dir, name))
+		if err == nil {
+			return f, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+func (b *BreachedPasswordRangeFiles) IsBreached(ctx context.Context, password string) (isBreached bool, err error) {
+	h := breachedPasswordSHA1(password)
+	hashAsHexString := strings.ToUpper(hex.EncodeToString(h[:]))
+	prefix, suffix := hashAsHexString[:5], hashAsHexString[5:]
+
+	f, err := b.rangeFileOpen(prefix)
+	if err != nil {
+		return false, err
+	}
+	if f == nil {
+		return false, nil
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lineSuffix, countAsString, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if !strings.EqualFold(lineSuffix, suffix) {
+			continue
+		}
+		if b.MinCount <= 0 {
+			return true, nil
+		}
+		count, err := strconv.ParseInt(countAsString, 10, 64)
+		if err != nil {
+			return false, err
+		}
+		return count >= b.MinCount, nil
+	}
+	return false, scanner.Err()
+}
+
+/*
+  - Bloom filter file: "DXBF", k (uint32), m in bits (uint64), then the m bits rounded up to bytes, integers big
+    endian. Bit i of the filter is bit i%8 of byte i/8. The k positions of a SHA-1 hash are (h1 + i*h2) mod m with
+    h1 and h2 its first and second 8 bytes.
+*/
+
+const breachedPasswordBloomFilterMagic = "DXBF"
+
+type BreachedPasswordBloomFilter struct {
+	k    uint32
+	m    uint64
+	bits []byte
+}
+
+// NewBreachedPasswordBloomFilter returns an empty filter sized for n hashes at the false positive rate p, to be
+// filled with AddSHA1Hex and saved with WriteTo
+func NewBreachedPasswordBloomFilter(n uint64, p float64) *BreachedPasswordBloomFilter {
+	n = max(n, 1)
+	m := uint64(math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	m = max(m, 8)
+	k := uint32(max(math.Round(float64(m)/float64(n)*math.Ln2), 1))
+	return &BreachedPasswordBloomFilter{k: k, m: m, bits: make([]byte, (m+7)/8)}
+}
+
+// LoadBreachedPasswordBloomFilter reads a filter file written by WriteTo
+func LoadBreachedPasswordBloomFilter(path string) (*BreachedPasswordBloomFilter, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	header := make([]byte, len(breachedPasswordBloomFilterMagic)+4+8)
+	_, err = io.ReadFull(r, header)
+	if err != nil {
+		return nil, err
+	}
+	if string(header[:4]) != breachedPasswordBloomFilterMagic {
+		return nil, errors.New("BREACHED_PASSWORD_BLOOM_FILTER_INVALID_MAGIC")
+	}
+	b := &BreachedPasswordBloomFilter{
+		k: binary.BigEndian.Uint32(header[4:8]),
+		m: binary.BigEndian.Uint64(header[8:16]),
+	}
+	if b.k == 0 || b.m == 0 {
+		return nil, errors.New("BREACHED_PASSWORD_BLOOM_FILTER_INVALID_HEADER")
+	}
+	b.bits = make([]byte, (b.m+7)/8)
+	_, err = io.ReadFull(r, b.bits)
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (b *BreachedPasswordBloomFilter) positions(h [sha1.Size]byte, f func(bit uint64) bool) bool {
+	h1 := binary.BigEndian.Uint64(h[0:8])
+	h2 := binary.BigEndian.Uint64(h[8:16])
+	for i := uint64(0); i < uint64(b.k); i++ {
+		if !f((h1 + i*h2) % b.m) {
+			return false
+		}
+	}
+	return true
+}
+
+// AddSHA1Hex adds a hash given as 40 hex digits, as in the HIBP lists
+func (b *BreachedPasswordBloomFilter) AddSHA1Hex(hashAsHexString string) (err error) {
+	var h [sha1.Size]byte
+	n, err := hex.Decode(h[:], []byte(hashAsHexString))
+	if err != nil {
+		return err
+	}
+	if n != sha1.Size {
+		return errors.New(fmt.Sprintf("BREACHED_PASSWORD_INVALID_SHA1:%s", hashAsHexString))
+	}
+	b.positions(h, func(bit uint64) bool {
+		b.bits[bit/8] |= 1 << (bit % 8)
+		return true
+	})
+	return nil
+}
+
+func (b *BreachedPasswordBloomFilter) WriteTo(w io.Writer) (n int64, err error) {
+	header := []byte(breachedPasswordBloomFilterMagic)
+	header = binary.BigEndian.AppendUint32(header, b.k)
+	header = binary.BigEndian.AppendUint64(header, b.m)
+	headerCount, err := w.Write(header)
+	n += int64(headerCount)
+	if err != nil {
+		return n, err
+	}
+	bitsCount, err := w.Write(b.bits)
+	n += int64(bitsCount)
+	return n, err
+}
+
+func (b *BreachedPasswordBloomFilter) IsBreached(ctx context.Context, password string) (isBreached bool, err error) {
+	return b.positions(breachedPasswordSHA1(password), func(bit uint64) bool {
+		return b.bits[bit/8]&(1<<(bit%8)) != 0
+	}), nil
+}
@@ -0,0 +1,111 @@
+package user_management
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/donnyhardyanto/dxlib/configuration"
+	"github.com/donnyhardyanto/dxlib/utils"
+	goredis "github.com/go-redis/redis/v8"
+)
+
+/*
+  - JWT access token denylist, in SessionRedis
+    jwt_denylist_jti:{jti}                -> one token
+    jwt_denylist_session:{session_id}     -> every token issued for one session
+    jwt_denylist_user:{user_id}           -> unix seconds, every token of the user issued at or before it
+    Entries only need to outlive the tokens they deny, so they expire after the longest access token lifetime.
+    Nothing is written while JWT access tokens are off (TTL 0).
+*/
+
+func jwtDenylistJtiKey(jti string) string {
+	return fmt.Sprintf("jwt_denylist_jti:%s", jti)
+}
+
+func jwtDenylistSessionKey(sessionId string) string {
+	return fmt.Sprintf("jwt_denylist_session:%s", sessionId)
+}
+
+func jwtDenylistUserKey(userId int64) string {
+	return fmt.Sprintf("jwt_denylist_user:%d", userId)
+}
+
+// DefaultJWTAccessTokenTTLInSeconds is the access token lifetime when system.sessions.jwt does not set one
+const DefaultJWTAccessTokenTTLInSeconds = 300
+
+// JWTAccessTokenTTLGet returns the lifetime of JWT access tokens from system.sessions.jwt, 0 when they are off.
+// The denylist reads it here instead of being told by the issuer, so a process that has not issued a token yet
+// (just started, or serving only admin endpoints) still writes its entries.
+func JWTAccessTokenTTLGet() time.Duration {
+	configSystem, ok := configuration.Manager.Configurations["system"]
+	if !ok || configSystem.Data == nil {
+		return 0
+	}
+	configSystemSession, err := utils.GetJSONFromKV(*configSystem.Data, "sessions")
+	if err != nil {
+		return 0
+	}
+	configJWT, err := utils.GetJSONFromKV(configSystemSession, "jwt")
+	if err != nil {
+		return 0
+	}
+	if isEnabled, _ := utils.GetBoolFromKV(configJWT, "enabled"); !isEnabled {
+		return 0
+	}
+	ttlInSeconds := DefaultJWTAccessTokenTTLInSeconds
+	if n, err := utils.GetIntFromKV(configJWT, "access_token_ttl_in_seconds"); err == nil && n > 0 {
+		ttlInSeconds = n
+	}
+	return time.Duration(ttlInSeconds) * time.Second
+}
+
+// JWTDenyToken revokes a single access token
+func (um *DxmUserManagement) JWTDenyToken(ctx context.Context, jti string) (err error) {
+	ttl := JWTAccessTokenTTLGet()
+	if ttl <= 0 || jti == "" {
+		return nil
+	}
+	return um.SessionRedis.Connection.Set(ctx, jwtDenylistJtiKey(jti), 1, ttl).Err()
+}
+
+// JWTDenySession revokes the access tokens issued for the session with sessionKey
+func (um *DxmUserManagement) JWTDenySession(ctx context.Context, sessionKey string) (err error) {
+	ttl := JWTAccessTokenTTLGet()
+	if ttl <= 0 || sessionKey == "" {
+		return nil
+	}
+	return um.SessionRedis.Connection.Set(ctx, jwtDenylistSessionKey(SessionIdFromSessionKey(sessionKey)), 1, ttl).Err()
+}
+
+// JWTDenyUser revokes every access token of the user issued up to now
+func (um *DxmUserManagement) JWTDenyUser(ctx context.Context, userId int64) (err error) {
+	ttl := JWTAccessTokenTTLGet()
+	if ttl <= 0 {
+		return nil
+	}
+	return um.SessionRedis.Connection.Set(ctx, jwtDenylistUserKey(userId), time.Now().Unix(), ttl).Err()
+}
+
+// JWTIsDenied checks a verified token against the three denylists in one round trip
+func (um *DxmUserManagement) JWTIsDenied(ctx context.Context, jti string, sessionId string, userId int64, issuedAt int64) (isDenied bool, err error) {
+	values, err := um.SessionRedis.Connection.MGet(ctx, jwtDenylistJtiKey(jti), jwtDenylistSessionKey(sessionId), jwtDenylistUserKey(userId)).Result()
+	if err != nil && err != goredis.Nil {
+		return false, err
+	}
+	if len(values) != 3 {
+		return false, nil
+	}
+	if values[0] != nil || values[1] != nil {
+		return true, nil
+	}
+	if deniedUpTo, ok := values[2].(string); ok {
+		deniedUpToAsInt64, err := strconv.ParseInt(deniedUpTo, 10, 64)
+		if err != nil {
+			return true, nil
+		}
+		return issuedAt <= deniedUpToAsInt64, nil
+	}
+	return false, nil
+}
@@ -21,3 +21,19 @@ func getOracleRoleNameidsTextFragment(userIdRef string) string {
                  JOIN user_management.role r ON urm2.role_id = r.id
         WHERE urm2.user_id = ` + userIdRef + `)`
 }
+
+func getOracleRolePrivilegesInheritedQuery(userIdRef string, direction string) string {
+	return `WITH role_tree (role_id, depth) AS (
+        SELECT urm2.role_id, 0
+        FROM user_management.user_role_membership urm2
+                 JOIN user_management.role r2 ON r2.id = urm2.role_id AND r2.is_deleted = 0
+        WHERE urm2.user_id = ` + userIdRef + ` AND urm2.is_deleted = 0
+        UNION ALL
+        ` + roleInheritanceRecursiveStep(direction, "0") + `
+    )
+    SELECT DISTINCT p.id AS privilege_id, p.nameid AS privilege_nameid
+    FROM role_tree rt
+             JOIN user_management.role r ON r.id = rt.role_id AND r.is_deleted = 0
+             JOIN user_management.role_privilege rp ON rp.role_id = rt.role_id AND rp.is_deleted = 0
+             JOIN user_management.privilege p ON rp.privilege_id = p.id AND p.is_deleted = 0`
+}
@@ -0,0 +1,93 @@
+package user_management
+
+import (
+	"crypto/subtle"
+	"encoding/binary"
+
+	"github.com/donnyhardyanto/dxlib/errors"
+	"github.com/donnyhardyanto/dxlib/utils/lv"
+	"golang.org/x/crypto/argon2"
+)
+
+/*
+  - PasswordHashMethodArgon2idV2
+    The LV envelope of PasswordHashMethodArgon2id is salt, method, hash, so its cost is whatever
+    security.HashArgon2id used when the hash was made. Method 4 appends the parameters it was made with:
+    salt, method, hash, memory (KiB), iterations, parallelism, key length; the integers big endian.
+    Verification reads them from the stored hash, so changing PasswordHashArgon2idParameters only affects new hashes,
+    and TxUserPasswordVerify rehashes a password stored with other parameters at the next login.
+*/
+
+type Argon2idParameters struct {
+	MemoryInKiB uint32
+	Iterations  uint32
+	Parallelism uint8
+	KeyLength   uint32
+}
+
+// DefaultArgon2idParameters are the OWASP minimums for Argon2id: 19 MiB, 2 iterations, 1 lane
+var DefaultArgon2idParameters = Argon2idParameters{
+	MemoryInKiB: 19 * 1024,
+	Iterations:  2,
+	Parallelism: 1,
+	KeyLength:   32,
+}
+
+// SetPasswordHashArgon2idParameters sets the cost of new PasswordHashMethodArgon2idV2 hashes, call after Init
+func (um *DxmUserManagement) SetPasswordHashArgon2idParameters(p Argon2idParameters) {
+	p.Iterations = max(p.Iterations, 1)
+	p.Parallelism = max(p.Parallelism, 1)
+	p.MemoryInKiB = max(p.MemoryInKiB, 8*uint32(p.Parallelism))
+	p.KeyLength = max(p.KeyLength, 16)
+	um.PasswordHashArgon2idParameters = p
+}
+
+func hashBlockArgon2id(saltValue []byte, data []byte, p Argon2idParameters) []byte {
+	return argon2.IDKey(data, saltValue, p.Iterations, p.MemoryInKiB, p.Parallelism, p.KeyLength)
+}
+
+// argon2idParametersLVs returns the envelope elements that follow the hash
+func argon2idParametersLVs(p Argon2idParameters) (lvs []*lv.LV, err error) {
+	for _, v := range [][]byte{
+		binary.BigEndian.AppendUint32(nil, p.MemoryInKiB),
+		binary.BigEndian.AppendUint32(nil, p.Iterations),
+		{p.Parallelism},
+		binary.BigEndian.AppendUint32(nil, p.KeyLength),
+	} {
+		lvParameter, err := lv.NewLV(v)
+		if err != nil {
+			return nil, err
+		}
+		lvs = append(lvs, lvParameter)
+	}
+	return lvs, nil
+}
+
+// argon2idParametersFromLVs reads the parameters of an expanded envelope
+func argon2idParametersFromLVs(lvElements []*lv.LV) (p Argon2idParameters, err error) {
+	if len(lvElements) < 7 {
+		return p, errors.New("ARGON2ID_PARAMETERS_MISSING")
+	}
+	if len(lvElements[3].Value) != 4 || len(lvElements[4].Value) != 4 || len(lvElements[5].Value) != 1 || len(lvElements[6].Value) != 4 {
+		return p, errors.New("ARGON2ID_PARAMETERS_INVALID")
+	}
+	p = Argon2idParameters{
+		MemoryInKiB: binary.BigEndian.Uint32(lvElements[3].Value),
+		Iterations:  binary.BigEndian.Uint32(lvElements[4].Value),
+		Parallelism: lvElements[5].Value[0],
+		KeyLength:   binary.BigEndian.Uint32(lvElements[6].Value),
+	}
+	if p.Iterations == 0 || p.Parallelism == 0 || p.KeyLength == 0 {
+		return p, errors.New("ARGON2ID_PARAMETERS_INVALID")
+	}
+	return p, nil
+}
+
+func argon2idVerify(tryPasswordAsBytes []byte, lvElements []*lv.LV) (verificationResult bool, err error) {
+	p, err := argon2idParametersFromLVs(lvElements)
+	if err != nil {
+		return false, err
+	}
+	tryHashPasswordBlock := hashBlockArgon2id(lvElements[0].Value, tryPasswordAsBytes, p)
+	return subtle.ConstantTimeCompare(tryHashPasswordBlock, lvElements[2].Value) == 1, nil
+}
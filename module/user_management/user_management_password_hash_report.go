@@ -0,0 +1,109 @@
+package user_management
+
+import (
+	"context"
+	"net/http"
+	"sort"
+
+	"github.com/donnyhardyanto/dxlib/api"
+	"github.com/donnyhardyanto/dxlib/databases/db"
+	dxlibLog "github.com/donnyhardyanto/dxlib/log"
+	"github.com/donnyhardyanto/dxlib/utils"
+)
+
+var passwordHashMethodNames = map[byte]string{
+	PasswordHashMethodSHA512:     "SHA512",
+	PasswordHashMethodBcrypt:     "BCRYPT",
+	PasswordHashMethodArgon2id:   "ARGON2ID",
+	PasswordHashMethodArgon2idV2: "ARGON2ID_V2",
+}
+
+func PasswordHashMethodName(method byte) string {
+	name, ok := passwordHashMethodNames[method]
+	if !ok {
+		return "UNKNOWN"
+	}
+	return name
+}
+
+// UserPasswordHashMethodUserIds groups the users by the hash method of their current password.
+// unreadableUserIds are the users whose current password could not be decoded.
+func (um *DxmUserManagement) UserPasswordHashMethodUserIds(ctx context.Context, l *dxlibLog.DXLog) (userIdsByMethod map[byte][]int64, unreadableUserIds []int64, err error) {
+	_, userPasswordRows, err := um.UserPassword.Select(ctx, l, []string{"id", "user_id"}, nil, nil,
+		db.DXDatabaseTableFieldsOrderBy{"id": "DESC"}, nil, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	userIdsByMethod = map[byte][]int64{}
+	unreadableUserIds = []int64{}
+	isUserSeen := map[int64]bool{}
+	for _, userPasswordRow := range userPasswordRows {
+		userId, err := utils.GetInt64FromKV(userPasswordRow, "user_id")
+		if err != nil {
+			return nil, nil, err
+		}
+		// Rows come newest first, the first one of a user is the current password
+		if isUserSeen[userId] {
+			continue
+		}
+		isUserSeen[userId] = true
+
+		userPasswordId, err := utils.GetInt64FromKV(userPasswordRow, "id")
+		if err != nil {
+			return nil, nil, err
+		}
+		_, userPasswordRow, err = um.UserPassword.SelectOneAuto(ctx, l, []string{"id", "value"}, utils.JSON{
+			"id": userPasswordId,
+		}, nil, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		userPasswordValue, _ := utils.GetStringFromKV(userPasswordRow, "value")
+		method, err := um.extractSaltMethodFromHash(userPasswordValue)
+		if err != nil {
+			unreadableUserIds = append(unreadableUserIds, userId)
+			continue
+		}
+		userIdsByMethod[method] = append(userIdsByMethod[method], userId)
+	}
+	return userIdsByMethod, unreadableUserIds, nil
+}
+
+// UserPasswordHashMethodReport counts the users on each password hash method. The user ids are listed for the
+// methods older than CurrentPasswordHashMethod, the users who have not logged in since the upgrade, so their
+// passwords can be reset.
+func (um *DxmUserManagement) UserPasswordHashMethodReport(aepr *api.DXAPIEndPointRequest) (err error) {
+	userIdsByMethod, unreadableUserIds, err := um.UserPasswordHashMethodUserIds(aepr.Context, &aepr.Log)
+	if err != nil {
+		return err
+	}
+
+	methods := make([]byte, 0, len(userIdsByMethod))
+	for method := range userIdsByMethod {
+		methods = append(methods, method)
+	}
+	sort.Slice(methods, func(i, j int) bool { return methods[i] < methods[j] })
+
+	hashMethods := []utils.JSON{}
+	for _, method := range methods {
+		hashMethod := utils.JSON{
+			"hash_method":      method,
+			"hash_method_name": PasswordHashMethodName(method),
+			"user_count":       len(userIdsByMethod[method]),
+			"is_outdated":      method < um.CurrentPasswordHashMethod,
+		}
+		if method < um.CurrentPasswordHashMethod {
+			hashMethod["user_ids"] = userIdsByMethod[method]
+		}
+		hashMethods = append(hashMethods, hashMethod)
+	}
+
+	aepr.WriteResponseAsJSON(http.StatusOK, nil, utils.JSON{"data": utils.JSON{
+		"current_hash_method":      um.CurrentPasswordHashMethod,
+		"current_hash_method_name": PasswordHashMethodName(um.CurrentPasswordHashMethod),
+		"hash_methods":             hashMethods,
+		"unreadable_user_ids":      unreadableUserIds,
+	}})
+	return nil
+}
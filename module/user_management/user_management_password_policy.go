@@ -0,0 +1,363 @@
+package user_management
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/donnyhardyanto/dxlib/configuration"
+	"github.com/donnyhardyanto/dxlib/databases/db"
+	dxlibLog "github.com/donnyhardyanto/dxlib/log"
+	"github.com/donnyhardyanto/dxlib/utils"
+)
+
+/*
+  - Password policy
+    Applied on top of OnUserFormatPasswordValidation wherever a user chooses a password (user create, self password
+    change, self password reset). Without system.password_policy nothing beyond the callback is checked.
+    - min_length, min_character_classes (of lowercase, uppercase, digit and symbol)
+    - min_strength_score, 0 to 4, from PasswordStrengthScore: an estimate of the guesses needed in the manner of zxcvbn,
+      which discounts repeats, sequences, common passwords and the user's own login id, email and name
+    - history_count, the new password must differ from the last N stored in user_password
+    - max_age_in_days, at login an older password sets must_change_password, see self.LoginCheckPasswordAge
+    - not a known breached password, when um.BreachedPasswordChecker is set, see user_management_breached_password.go
+    A user in several organizations gets the strictest of their policies, so switching organization never relaxes it.
+
+  - Configuration, system.password_policy (optional)
+    min_length, min_character_classes, min_strength_score, history_count, max_age_in_days
+    organizations                              : {"<organization_uid>": {same keys}}, overrides per organization
+    must_change_password_allowed_endpoint_uris : see self.passwordChangeRequiredCheck
+*/
+
+// Password policy violations, the detail of an INVALID_PASSWORD_FORMAT response
+const (
+	PasswordPolicyViolationTooShort               = "TOO_SHORT"
+	PasswordPolicyViolationTooFewCharacterClasses = "TOO_FEW_CHARACTER_CLASSES"
+	PasswordPolicyViolationTooWeak                = "TOO_WEAK"
+	PasswordPolicyViolationReused                 = "REUSED"
+	PasswordPolicyViolationBreached               = "BREACHED"
+)
+
+type PasswordPolicy struct {
+	MinLength           int
+	MinCharacterClasses int
+	MinStrengthScore    int
+	HistoryCount        int
+	MaxAgeInDays        int
+}
+
+func passwordPolicyOverride(policy PasswordPolicy, config utils.JSON) PasswordPolicy {
+	if v, err := utils.GetIntFromKV(config, "min_length"); err == nil && v >= 0 {
+		policy.MinLength = v
+	}
+	if v, err := utils.GetIntFromKV(config, "min_character_classes"); err == nil && v >= 0 {
+		policy.MinCharacterClasses = v
+	}
+	if v, err := utils.GetIntFromKV(config, "min_strength_score"); err == nil && v >= 0 {
+		policy.MinStrengthScore = v
+	}
+	if v, err := utils.GetIntFromKV(config, "history_count"); err == nil && v >= 0 {
+		policy.HistoryCount = v
+	}
+	if v, err := utils.GetIntFromKV(config, "max_age_in_days"); err == nil && v >= 0 {
+		policy.MaxAgeInDays = v
+	}
+	return policy
+}
+
+// passwordPolicyStricter combines two policies into one that satisfies both, a zero MaxAgeInDays means no expiry
+func passwordPolicyStricter(a PasswordPolicy, b PasswordPolicy) PasswordPolicy {
+	r := PasswordPolicy{
+		MinLength:           max(a.MinLength, b.MinLength),
+		MinCharacterClasses: max(a.MinCharacterClasses, b.MinCharacterClasses),
+		MinStrengthScore:    max(a.MinStrengthScore, b.MinStrengthScore),
+		HistoryCount:        max(a.HistoryCount, b.HistoryCount),
+		MaxAgeInDays:        a.MaxAgeInDays,
+	}
+	if r.MaxAgeInDays == 0 || (b.MaxAgeInDays > 0 && b.MaxAgeInDays < r.MaxAgeInDays) {
+		r.MaxAgeInDays = b.MaxAgeInDays
+	}
+	return r
+}
+
+// PasswordPolicyConfigGet returns system.password_policy, nil when not configured
+func PasswordPolicyConfigGet() utils.JSON {
+	configSystem, ok := configuration.Manager.Configurations["system"]
+	if !ok || configSystem.Data == nil {
+		return nil
+	}
+	configPasswordPolicy, err := utils.GetJSONFromKV(*configSystem.Data, "password_policy")
+	if err != nil {
+		return nil
+	}
+	return configPasswordPolicy
+}
+
+// PasswordPolicyGet returns the strictest policy of the organizations, the global policy when none is given
+func (um *DxmUserManagement) PasswordPolicyGet(organizationUids ...string) PasswordPolicy {
+	config := PasswordPolicyConfigGet()
+	if config == nil {
+		return PasswordPolicy{}
+	}
+	policy := passwordPolicyOverride(PasswordPolicy{}, config)
+	configOrganizations, err := utils.GetJSONFromKV(config, "organizations")
+	if err != nil || len(organizationUids) == 0 {
+		return policy
+	}
+
+	var r *PasswordPolicy
+	for _, organizationUid := range organizationUids {
+		organizationPolicy := policy
+		if configOrganization, err := utils.GetJSONFromKV(configOrganizations, organizationUid); err == nil {
+			organizationPolicy = passwordPolicyOverride(policy, configOrganization)
+		}
+		if r == nil {
+			r = &organizationPolicy
+			continue
+		}
+		stricter := passwordPolicyStricter(*r, organizationPolicy)
+		r = &stricter
+	}
+	return *r
+}
+
+// PasswordPolicyForUser returns the policy over all organizations the user is a member of
+func (um *DxmUserManagement) PasswordPolicyForUser(ctx context.Context, l *dxlibLog.DXLog, userId int64) (policy PasswordPolicy, err error) {
+	_, userOrganizationMemberships, err := um.UserOrganizationMembership.Select(ctx, l, nil, utils.JSON{
+		"user_id": userId,
+	}, nil, nil, nil, nil)
+	if err != nil {
+		return PasswordPolicy{}, err
+	}
+	organizationUids := []string{}
+	for _, userOrganizationMembership := range userOrganizationMemberships {
+		if organizationUid, _ := utils.GetStringFromKV(userOrganizationMembership, "organization_uid"); organizationUid != "" {
+			organizationUids = append(organizationUids, organizationUid)
+		}
+	}
+	return um.PasswordPolicyGet(organizationUids...), nil
+}
+
+// PasswordPolicyUserInputs returns the user's own data a password should not be built on
+func PasswordPolicyUserInputs(user utils.JSON) []string {
+	r := []string{}
+	for _, k := range []string{"loginid", "email", "fullname", "phonenumber"} {
+		v, _ := utils.GetStringFromKV(user, k)
+		if k == "email" {
+			v, _, _ = strings.Cut(v, "@")
+		}
+		r = append(r, strings.Fields(v)...)
+	}
+	return r
+}
+
+// passwordCommonWords are among the first guesses of any attack, so a password containing one gains little from it
+var passwordCommonWords = []string{
+	"password", "passw0rd", "qwerty", "asdf", "zxcv", "letmein", "welcome", "admin", "login", "iloveyou", "monkey",
+	"dragon", "master", "sunshine", "princess", "football", "baseball", "shadow", "superman", "trustno1", "secret",
+	"123123", "111111", "000000", "654321",
+}
+
+// PasswordStrengthScore estimates how hard the password is to guess on the 0 to 4 scale of zxcvbn:
+// below 10^3 guesses 0, 10^6 1, 10^8 2, 10^10 3, above 4
+func PasswordStrengthScore(password string, userInputs ...string) int {
+	if password == "" {
+		return 0
+	}
+	charsetSize := 0
+	for _, characterClass := range passwordCharacterClasses(password) {
+		charsetSize += characterClass
+	}
+
+	// Dictionary words count as one guess among a small list, not as random characters
+	log10Guesses := 0.0
+	rest := strings.ToLower(password)
+	for _, word := range passwordCommonWords {
+		if strings.Contains(rest, word) {
+			rest = strings.ReplaceAll(rest, word, "\x00")
+			log10Guesses += 2
+		}
+	}
+	for _, userInput := range userInputs {
+		userInput = strings.ToLower(userInput)
+		if len(userInput) >= 3 && strings.Contains(rest, userInput) {
+			rest = strings.ReplaceAll(rest, userInput, "\x00")
+			log10Guesses += 1
+		}
+	}
+
+	// A character repeating or continuing a sequence of the previous one adds almost nothing
+	effectiveLength := 0.0
+	var previous rune = -1
+	for _, c := range rest {
+		if c == 0 {
+			previous = -1
+			continue
+		}
+		if previous >= 0 && (c == previous || c == previous+1 || c == previous-1) {
+			effectiveLength += 0.1
+		} else {
+			effectiveLength++
+		}
+		previous = c
+	}
+	log10Guesses += effectiveLength * math.Log10(float64(max(charsetSize, 10)))
+
+	switch {
+	case log10Guesses < 3:
+		return 0
+	case log10Guesses < 6:
+		return 1
+	case log10Guesses < 8:
+		return 2
+	case log10Guesses < 10:
+		return 3
+	default:
+		return 4
+	}
+}
+
+// passwordCharacterClasses returns the size of each character class present in the password
+func passwordCharacterClasses(password string) []int {
+	var hasLower, hasUpper, hasDigit, hasSymbol bool
+	for _, c := range password {
+		switch {
+		case unicode.IsLower(c):
+			hasLower = true
+		case unicode.IsUpper(c):
+			hasUpper = true
+		case unicode.IsDigit(c):
+			hasDigit = true
+		default:
+			hasSymbol = true
+		}
+	}
+	r := []int{}
+	if hasLower {
+		r = append(r, 26)
+	}
+	if hasUpper {
+		r = append(r, 26)
+	}
+	if hasDigit {
+		r = append(r, 10)
+	}
+	if hasSymbol {
+		r = append(r, 33)
+	}
+	return r
+}
+
+// PasswordPolicyViolation checks the rules that need no stored data, returns "" when the password satisfies them
+func PasswordPolicyViolation(policy PasswordPolicy, password string, userInputs ...string) string {
+	if len([]rune(password)) < policy.MinLength {
+		return fmt.Sprintf("%s:MIN_LENGTH=%d", PasswordPolicyViolationTooShort, policy.MinLength)
+	}
+	if len(passwordCharacterClasses(password)) < policy.MinCharacterClasses {
+		return fmt.Sprintf("%s:MIN_CHARACTER_CLASSES=%d", PasswordPolicyViolationTooFewCharacterClasses, policy.MinCharacterClasses)
+	}
+	if policy.MinStrengthScore > 0 {
+		if score := PasswordStrengthScore(password, userInputs...); score < policy.MinStrengthScore {
+			return fmt.Sprintf("%s:SCORE=%d:MIN_STRENGTH_SCORE=%d", PasswordPolicyViolationTooWeak, score, policy.MinStrengthScore)
+		}
+	}
+	return ""
+}
+
+// UserPasswordIsReused reports whether the password is one of the last historyCount passwords of the user
+func (um *DxmUserManagement) UserPasswordIsReused(ctx context.Context, l *dxlibLog.DXLog, userId int64, password string, historyCount int) (isReused bool, err error) {
+	if historyCount <= 0 || userId == 0 {
+		return false, nil
+	}
+	_, userPasswordRows, err := um.UserPassword.Select(ctx, l, []string{"id"}, utils.JSON{
+		"user_id": userId,
+	}, nil, db.DXDatabaseTableFieldsOrderBy{"id": "DESC"}, historyCount, nil)
+	if err != nil {
+		return false, err
+	}
+	for _, userPasswordRow := range userPasswordRows {
+		userPasswordId, err := utils.GetInt64FromKV(userPasswordRow, "id")
+		if err != nil {
+			return false, err
+		}
+		// The value is read one row at a time through the decrypting select, as UserPasswordVerify does
+		_, userPasswordRow, err = um.UserPassword.SelectOneAuto(ctx, l, []string{"id", "value"}, utils.JSON{
+			"id": userPasswordId,
+		}, nil, nil)
+		if err != nil {
+			return false, err
+		}
+		if userPasswordRow == nil {
+			continue
+		}
+		userPasswordValue, err := utils.GetStringFromKV(userPasswordRow, "value")
+		if err != nil {
+			return false, err
+		}
+		isMatch, err := um.passwordHashVerify(password, userPasswordValue)
+		if err != nil {
+			return false, err
+		}
+		if isMatch {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// UserPasswordValidate runs OnUserFormatPasswordValidation, the policy and the breached password check on a new
+// password of userId, 0 for a user not created yet. violation is "" when the password is accepted.
+func (um *DxmUserManagement) UserPasswordValidate(ctx context.Context, l *dxlibLog.DXLog, policy PasswordPolicy, userId int64, password string, userInputs ...string) (violation string, err error) {
+	if um.OnUserFormatPasswordValidation != nil {
+		err = um.OnUserFormatPasswordValidation(password)
+		if err != nil {
+			return err.Error(), nil
+		}
+	}
+	violation = PasswordPolicyViolation(policy, password, userInputs...)
+	if violation != "" {
+		return violation, nil
+	}
+	if um.BreachedPasswordChecker != nil {
+		isBreached, err := um.BreachedPasswordChecker.IsBreached(ctx, password)
+		if err != nil {
+			return "", err
+		}
+		if isBreached {
+			return PasswordPolicyViolationBreached, nil
+		}
+	}
+	isReused, err := um.UserPasswordIsReused(ctx, l, userId, password, policy.HistoryCount)
+	if err != nil {
+		return "", err
+	}
+	if isReused {
+		return fmt.Sprintf("%s:HISTORY_COUNT=%d", PasswordPolicyViolationReused, policy.HistoryCount), nil
+	}
+	return "", nil
+}
+
+// UserPasswordIsExpired reports whether the current password of the user is older than policy.MaxAgeInDays.
+// A user without a stored password, authenticated elsewhere, never expires here.
+func (um *DxmUserManagement) UserPasswordIsExpired(ctx context.Context, l *dxlibLog.DXLog, userId int64, policy PasswordPolicy) (isExpired bool, err error) {
+	if policy.MaxAgeInDays <= 0 {
+		return false, nil
+	}
+	_, userPasswordRow, err := um.UserPassword.SelectOneAuto(ctx, l, []string{"id", "created_at"}, utils.JSON{
+		"user_id": userId,
+	}, nil, db.DXDatabaseTableFieldsOrderBy{"id": "DESC"})
+	if err != nil {
+		return false, err
+	}
+	if userPasswordRow == nil {
+		return false, nil
+	}
+	createdAt, err := utils.GetTimeFromKV(userPasswordRow, "created_at")
+	if err != nil {
+		return false, err
+	}
+	return time.Since(createdAt) > time.Duration(policy.MaxAgeInDays)*24*time.Hour, nil
+}
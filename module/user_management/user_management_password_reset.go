@@ -0,0 +1,143 @@
+package user_management
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/donnyhardyanto/dxlib/utils"
+	"github.com/donnyhardyanto/dxlib/utils/crypto/rand"
+	utilsJSON "github.com/donnyhardyanto/dxlib/utils/json"
+)
+
+/*
+  - Password reset tokens, in SessionRedis
+    password_reset_token:{token_id}         -> JSON {"user_id"}, deleted by the first confirm that presents the token
+    password_reset_user:{user_id}           -> token_id of the user's latest token, requesting a new one voids the previous
+    password_reset_request_count:{user_id}  -> requests in the current window
+    token_id is the sha256 of the token, the token itself is only sent to the user. All keys expire on their own.
+*/
+
+func passwordResetTokenKey(tokenId string) string {
+	return fmt.Sprintf("password_reset_token:%s", tokenId)
+}
+
+func passwordResetUserKey(userId int64) string {
+	return fmt.Sprintf("password_reset_user:%d", userId)
+}
+
+func passwordResetRequestCountKey(userId int64) string {
+	return fmt.Sprintf("password_reset_request_count:%d", userId)
+}
+
+// PasswordResetTokenCreate issues a reset token for the user valid for ttl, voiding any earlier one
+func (um *DxmUserManagement) PasswordResetTokenCreate(ctx context.Context, userId int64, ttl time.Duration) (token string, err error) {
+	token = hex.EncodeToString(rand.RandomData(32))
+	tokenId := tokenHash(token)
+
+	previousTokenId, err := um.SessionRedis.Connection.GetSet(ctx, passwordResetUserKey(userId), tokenId).Result()
+	if err == nil && previousTokenId != "" {
+		_ = um.SessionRedis.Delete(ctx, passwordResetTokenKey(previousTokenId))
+	}
+	err = um.SessionRedis.Connection.Expire(ctx, passwordResetUserKey(userId), ttl).Err()
+	if err != nil {
+		return "", err
+	}
+	err = um.SessionRedis.Set(ctx, passwordResetTokenKey(tokenId), utils.JSON{
+		"user_id": userId,
+	}, ttl)
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// PasswordResetTokenPeek returns the user of a reset token without redeeming it
+func (um *DxmUserManagement) PasswordResetTokenPeek(ctx context.Context, token string) (userId int64, isValid bool, err error) {
+	if token == "" {
+		return 0, false, nil
+	}
+	entry, err := um.SessionRedis.Get(ctx, passwordResetTokenKey(tokenHash(token)))
+	if err != nil {
+		return 0, false, err
+	}
+	if entry == nil {
+		return 0, false, nil
+	}
+	userId, err = utilsJSON.GetInt64(entry, "user_id")
+	if err != nil {
+		return 0, false, err
+	}
+	return userId, true, nil
+}
+
+// PasswordResetTokenConsume redeems a reset token once; isValid is false when it is unknown, expired, voided or already used.
+// remainingTTL is how long the token was still valid, for PasswordResetTokenRestore.
+func (um *DxmUserManagement) PasswordResetTokenConsume(ctx context.Context, token string) (userId int64, isValid bool, remainingTTL time.Duration, err error) {
+	if token == "" {
+		return 0, false, 0, nil
+	}
+	tokenId := tokenHash(token)
+	entry, err := um.SessionRedis.Get(ctx, passwordResetTokenKey(tokenId))
+	if err != nil {
+		return 0, false, 0, err
+	}
+	if entry == nil {
+		return 0, false, 0, nil
+	}
+	remainingTTL, err = um.SessionRedis.Connection.PTTL(ctx, passwordResetTokenKey(tokenId)).Result()
+	if err != nil {
+		return 0, false, 0, err
+	}
+	// Only the request that deletes the key wins, a concurrent confirm with the same token gets isValid false
+	deletedCount, err := um.SessionRedis.Connection.Del(ctx, passwordResetTokenKey(tokenId)).Result()
+	if err != nil {
+		return 0, false, 0, err
+	}
+	if deletedCount == 0 {
+		return 0, false, 0, nil
+	}
+	userId, err = utilsJSON.GetInt64(entry, "user_id")
+	if err != nil {
+		return 0, false, 0, err
+	}
+	_ = um.SessionRedis.Connection.Del(ctx, passwordResetUserKey(userId)).Err()
+	return userId, true, remainingTTL, nil
+}
+
+// PasswordResetTokenRestore puts back a token consumed by a confirm that failed afterwards, for the remainingTTL it had
+// left, so a failed confirm never extends it. It is not restored when it had no time left or the user requested a newer
+// token in the meantime.
+func (um *DxmUserManagement) PasswordResetTokenRestore(ctx context.Context, token string, userId int64, remainingTTL time.Duration) (err error) {
+	if remainingTTL <= 0 {
+		return nil
+	}
+	tokenId := tokenHash(token)
+	isSet, err := um.SessionRedis.Connection.SetNX(ctx, passwordResetUserKey(userId), tokenId, remainingTTL).Result()
+	if err != nil {
+		return err
+	}
+	if !isSet {
+		return nil
+	}
+	return um.SessionRedis.Set(ctx, passwordResetTokenKey(tokenId), utils.JSON{
+		"user_id": userId,
+	}, remainingTTL)
+}
+
+// PasswordResetRequestCountIncr counts a reset request of the user in a fixed window, returns the count so far
+func (um *DxmUserManagement) PasswordResetRequestCountIncr(ctx context.Context, userId int64, window time.Duration) (count int64, err error) {
+	key := passwordResetRequestCountKey(userId)
+	count, err = um.SessionRedis.Connection.Incr(ctx, key).Result()
+	if err != nil {
+		return 0, err
+	}
+	if count == 1 {
+		err = um.SessionRedis.Connection.Expire(ctx, key, window).Err()
+		if err != nil {
+			return count, err
+		}
+	}
+	return count, nil
+}
@@ -0,0 +1,235 @@
+package user_management
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/donnyhardyanto/dxlib/utils"
+	"github.com/donnyhardyanto/dxlib/utils/crypto/rand"
+	utilsJSON "github.com/donnyhardyanto/dxlib/utils/json"
+)
+
+// Reasons for ending the access session of a refresh token family
+const (
+	SessionEndedReasonRefreshTokenReused  = "REFRESH_TOKEN_REUSED"
+	SessionEndedReasonRefreshTokenRevoked = "REFRESH_TOKEN_REVOKED"
+)
+
+/*
+  - Refresh token families, in SessionRedis
+    refresh_token_family:{family_id} -> JSON {"family_id","user_id","organization_id","session_key","current_token_id","created_at","expires_at"}
+    refresh_token:{token_id}          -> JSON {"family_id"}, one per token ever issued in the family
+    refresh_token_consumed:{token_id} -> set once when the token is exchanged
+    user_refresh_token_families:{user_id} -> set of family_id, so revoking the sessions of a user revokes their families
+    token_id is the sha256 of the refresh token, the token itself is never stored. Every refresh consumes the
+    presented token and issues a new one; presenting a consumed or superseded token revokes the whole family,
+    since either the client or an attacker holds a stolen copy. All keys expire with the family.
+*/
+
+func refreshTokenFamilyKey(familyId string) string {
+	return fmt.Sprintf("refresh_token_family:%s", familyId)
+}
+
+func refreshTokenKey(tokenId string) string {
+	return fmt.Sprintf("refresh_token:%s", tokenId)
+}
+
+func refreshTokenConsumedKey(tokenId string) string {
+	return fmt.Sprintf("refresh_token_consumed:%s", tokenId)
+}
+
+func userRefreshTokenFamiliesKey(userId int64) string {
+	return fmt.Sprintf("user_refresh_token_families:%d", userId)
+}
+
+// tokenHash is the id under which a bearer token is stored, so the token itself never is
+func tokenHash(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+// refreshTokenIssue stores a new token of the family and returns it
+func (um *DxmUserManagement) refreshTokenIssue(ctx context.Context, familyId string, ttl time.Duration) (refreshToken string, tokenId string, err error) {
+	refreshToken = hex.EncodeToString(rand.RandomData(32))
+	tokenId = tokenHash(refreshToken)
+	err = um.SessionRedis.Set(ctx, refreshTokenKey(tokenId), utils.JSON{
+		"family_id": familyId,
+	}, ttl)
+	if err != nil {
+		return "", "", err
+	}
+	return refreshToken, tokenId, nil
+}
+
+// RefreshTokenFamilyCreate starts a refresh token family for the access session sessionKey, ending at expiresAt
+func (um *DxmUserManagement) RefreshTokenFamilyCreate(ctx context.Context, userId int64, organizationId int64, sessionKey string, createdAt time.Time, expiresAt time.Time) (familyId string, refreshToken string, err error) {
+	familyId = hex.EncodeToString(rand.RandomData(16))
+	ttl := time.Until(expiresAt)
+	refreshToken, tokenId, err := um.refreshTokenIssue(ctx, familyId, ttl)
+	if err != nil {
+		return "", "", err
+	}
+	err = um.SessionRedis.Set(ctx, refreshTokenFamilyKey(familyId), utils.JSON{
+		"family_id":        familyId,
+		"user_id":          userId,
+		"organization_id":  organizationId,
+		"session_key":      sessionKey,
+		"current_token_id": tokenId,
+		"created_at":       createdAt.Unix(),
+		"expires_at":       expiresAt.Unix(),
+	}, ttl)
+	if err != nil {
+		return "", "", err
+	}
+
+	key := userRefreshTokenFamiliesKey(userId)
+	err = um.SessionRedis.Connection.SAdd(ctx, key, familyId).Err()
+	if err != nil {
+		return "", "", err
+	}
+	return familyId, refreshToken, um.sessionIndexExtend(ctx, key, ttl)
+}
+
+// RefreshTokenFamilyGet returns the family, nil when it was revoked or has expired
+func (um *DxmUserManagement) RefreshTokenFamilyGet(ctx context.Context, familyId string) (family utils.JSON, err error) {
+	if familyId == "" {
+		return nil, nil
+	}
+	return um.SessionRedis.Get(ctx, refreshTokenFamilyKey(familyId))
+}
+
+// RefreshTokenConsume exchanges a refresh token. family is nil when the token is unknown or its family is gone.
+// isReused is true when the token had already been exchanged or superseded, the family is then revoked.
+func (um *DxmUserManagement) RefreshTokenConsume(ctx context.Context, refreshToken string) (family utils.JSON, isReused bool, err error) {
+	tokenId := tokenHash(refreshToken)
+	token, err := um.SessionRedis.Get(ctx, refreshTokenKey(tokenId))
+	if err != nil {
+		return nil, false, err
+	}
+	if token == nil {
+		return nil, false, nil
+	}
+	familyId, _ := token["family_id"].(string)
+	family, err = um.RefreshTokenFamilyGet(ctx, familyId)
+	if err != nil {
+		return nil, false, err
+	}
+	if family == nil {
+		return nil, false, nil
+	}
+
+	currentTokenId, _ := family["current_token_id"].(string)
+	isFirstUse, err := um.SessionRedis.Connection.SetNX(ctx, refreshTokenConsumedKey(tokenId), 1, um.refreshTokenFamilyTTL(family)).Result()
+	if err != nil {
+		return nil, false, err
+	}
+	if !isFirstUse || currentTokenId != tokenId {
+		err = um.RefreshTokenFamilyRevoke(ctx, family, SessionEndedReasonRefreshTokenReused)
+		if err != nil {
+			return nil, true, err
+		}
+		return family, true, nil
+	}
+	return family, false, nil
+}
+
+// RefreshTokenConsumeRelease takes back the consumption of a token whose exchange failed before its family was advanced,
+// so the client retrying with the same token is not taken for a reuse
+func (um *DxmUserManagement) RefreshTokenConsumeRelease(ctx context.Context, refreshToken string) (err error) {
+	return um.SessionRedis.Connection.Del(ctx, refreshTokenConsumedKey(tokenHash(refreshToken))).Err()
+}
+
+// RefreshTokenFamilyAdvance moves the family to its new access session and issues the next refresh token
+func (um *DxmUserManagement) RefreshTokenFamilyAdvance(ctx context.Context, family utils.JSON, sessionKey string) (refreshToken string, err error) {
+	familyId, _ := family["family_id"].(string)
+	ttl := um.refreshTokenFamilyTTL(family)
+	refreshToken, tokenId, err := um.refreshTokenIssue(ctx, familyId, ttl)
+	if err != nil {
+		return "", err
+	}
+	family["session_key"] = sessionKey
+	family["current_token_id"] = tokenId
+	err = um.SessionRedis.Set(ctx, refreshTokenFamilyKey(familyId), family, ttl)
+	if err != nil {
+		return "", err
+	}
+	return refreshToken, nil
+}
+
+// RefreshTokenFamilyOrganizationSet makes the next refreshes of the family issue sessions for organizationId
+func (um *DxmUserManagement) RefreshTokenFamilyOrganizationSet(ctx context.Context, familyId string, organizationId int64) (err error) {
+	family, err := um.RefreshTokenFamilyGet(ctx, familyId)
+	if err != nil {
+		return err
+	}
+	if family == nil {
+		return nil
+	}
+	family["organization_id"] = organizationId
+	return um.SessionRedis.Set(ctx, refreshTokenFamilyKey(familyId), family, um.refreshTokenFamilyTTL(family))
+}
+
+func (um *DxmUserManagement) refreshTokenFamilyTTL(family utils.JSON) time.Duration {
+	expiresAt, err := utilsJSON.GetInt64(family, "expires_at")
+	if err != nil {
+		return time.Second
+	}
+	return max(time.Until(time.Unix(expiresAt, 0)), time.Second)
+}
+
+// RefreshTokenFamilyRevoke deletes the family and ends its current access session with reason
+func (um *DxmUserManagement) RefreshTokenFamilyRevoke(ctx context.Context, family utils.JSON, reason string) (err error) {
+	familyId, _ := family["family_id"].(string)
+	userId, _ := utilsJSON.GetInt64(family, "user_id")
+	sessionKey, _ := family["session_key"].(string)
+	ttl := um.refreshTokenFamilyTTL(family)
+
+	err = um.SessionRedis.Delete(ctx, refreshTokenFamilyKey(familyId))
+	if err != nil {
+		return err
+	}
+	_ = um.SessionRedis.Connection.SRem(ctx, userRefreshTokenFamiliesKey(userId), familyId).Err()
+	if sessionKey != "" {
+		err = um.SessionEnd(ctx, sessionKey, reason, ttl)
+		if err != nil {
+			return err
+		}
+		_ = um.SessionIndexRemove(ctx, userId, sessionKey)
+	}
+	return nil
+}
+
+// RefreshTokenFamilyRevokeForUser revokes the families of the user whose access session matches sessionId,
+// or all of them when sessionId is empty, except the family of exceptSessionKey
+func (um *DxmUserManagement) RefreshTokenFamilyRevokeForUser(ctx context.Context, userId int64, sessionId string, exceptSessionKey string) (err error) {
+	key := userRefreshTokenFamiliesKey(userId)
+	familyIds, err := um.SessionRedis.Connection.SMembers(ctx, key).Result()
+	if err != nil {
+		return err
+	}
+	for _, familyId := range familyIds {
+		family, err := um.RefreshTokenFamilyGet(ctx, familyId)
+		if err != nil {
+			return err
+		}
+		if family == nil {
+			_ = um.SessionRedis.Connection.SRem(ctx, key, familyId).Err()
+			continue
+		}
+		sessionKey, _ := family["session_key"].(string)
+		if exceptSessionKey != "" && sessionKey == exceptSessionKey {
+			continue
+		}
+		if sessionId != "" && SessionIdFromSessionKey(sessionKey) != sessionId {
+			continue
+		}
+		err = um.RefreshTokenFamilyRevoke(ctx, family, SessionEndedReasonRefreshTokenRevoked)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
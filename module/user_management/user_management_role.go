@@ -4,7 +4,6 @@ import (
 	"database/sql"
 	"encoding/json"
 	"net/http"
-	"strings"
 
 	"github.com/donnyhardyanto/dxlib/api"
 	"github.com/donnyhardyanto/dxlib/databases"
@@ -133,6 +132,18 @@ func (um *DxmUserManagement) RoleEdit(aepr *api.DXAPIEndPointRequest) (err error
 		p["organization_types"] = jsonString
 	}
 
+	parentRoleUid, ok := newFieldValues["parent_uid"].(string)
+	if ok && parentRoleUid != "" {
+		_, row, err := t.ShouldGetById(aepr.Context, &aepr.Log, id)
+		if err != nil {
+			return err
+		}
+		err = um.roleParentSet(aepr, id, row, parentRoleUid, p)
+		if err != nil {
+			return err
+		}
+	}
+
 	err = t.DoUpdateWithValidation(aepr, id, p)
 	if err != nil {
 		return err
@@ -140,6 +151,36 @@ func (um *DxmUserManagement) RoleEdit(aepr *api.DXAPIEndPointRequest) (err error
 	return nil
 }
 
+// roleParentSet puts the id of the new parent in p, refusing the superadmin role and a parent below the role itself
+func (um *DxmUserManagement) roleParentSet(aepr *api.DXAPIEndPointRequest, id int64, row utils.JSON, parentRoleUid string, p utils.JSON) (err error) {
+	t := um.Role
+	utag, _ := utils.GetStringFromKV(row, "utag")
+	if utag == "SUPER-ADMINISTRATOR" {
+		return aepr.WriteResponseAndNewErrorf(http.StatusForbidden,
+			"CANNOT_REPARENT_SUPERADMIN", "Cannot change parent of superadmin role")
+	}
+
+	_, parentRole, err := t.ShouldGetByUid(aepr.Context, &aepr.Log, parentRoleUid)
+	if err != nil {
+		return err
+	}
+	parentId, err := utils.GetInt64FromKV(parentRole, "id")
+	if err != nil {
+		return err
+	}
+
+	isCycle, err := um.RoleParentIsCycle(aepr.Context, &aepr.Log, id, parentId)
+	if err != nil {
+		return err
+	}
+	if isCycle {
+		return aepr.WriteResponseAndNewErrorf(http.StatusBadRequest,
+			"CIRCULAR_REFERENCE", "Cannot set parent: would create circular reference")
+	}
+	p["parent_id"] = parentId
+	return nil
+}
+
 func (um *DxmUserManagement) RoleEditByUid(aepr *api.DXAPIEndPointRequest) (err error) {
 	t := um.Role
 	_, uid, err := aepr.GetParameterValueAsString("uid")
@@ -189,29 +230,10 @@ func (um *DxmUserManagement) RoleEditByUid(aepr *api.DXAPIEndPointRequest) (err
 
 	parentRoleUid, ok := newFieldValues["parent_uid"].(string)
 	if ok && parentRoleUid != "" {
-		utag, _ := utils.GetStringFromKV(row, "utag")
-		if utag == "SUPER-ADMINISTRATOR" {
-			return aepr.WriteResponseAndNewErrorf(http.StatusForbidden,
-				"CANNOT_REPARENT_SUPERADMIN", "Cannot change parent of superadmin role")
-		}
-
-		_, parentRole, err := t.ShouldGetByUid(aepr.Context, &aepr.Log, parentRoleUid)
-		if err != nil {
-			return err
-		}
-
-		parentAbsPath, _ := utils.GetStringFromKV(parentRole, "absolute_path")
-		roleUid, _ := utils.GetStringFromKV(row, "uid")
-		if strings.Contains(parentAbsPath, "/"+roleUid) {
-			return aepr.WriteResponseAndNewErrorf(http.StatusBadRequest,
-				"CIRCULAR_REFERENCE", "Cannot set parent: would create circular reference")
-		}
-
-		parentId, err := utils.GetInt64FromKV(parentRole, "id")
+		err = um.roleParentSet(aepr, id, row, parentRoleUid, p)
 		if err != nil {
 			return err
 		}
-		p["parent_id"] = parentId
 	}
 
 	err = t.DoUpdateWithValidation(aepr, id, p)
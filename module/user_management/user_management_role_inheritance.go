@@ -0,0 +1,128 @@
+package user_management
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	dxlibBase "github.com/donnyhardyanto/dxlib/base"
+	"github.com/donnyhardyanto/dxlib/configuration"
+	"github.com/donnyhardyanto/dxlib/databases/db"
+	"github.com/donnyhardyanto/dxlib/errors"
+	dxlibLog "github.com/donnyhardyanto/dxlib/log"
+	"github.com/donnyhardyanto/dxlib/utils"
+)
+
+/*
+  - Role inheritance
+    system.role_inheritance decides which roles besides the user's own give privileges to the session:
+    - NONE (default), only the roles the user is a member of
+    - ANCESTORS, also every role above them up to the root, so a role has the privileges of its parents
+    - DESCENDANTS, also every role below them, so a role has the privileges of the roles under it
+    The roles are walked in one recursive query per database type (RolePrivilegesInheritedQuery), at most
+    roleInheritanceMaxDepth levels, so a parent_id cycle already in the table cannot loop it. Soft-deleted roles and
+    privileges are skipped, and a deleted role also cuts the walk at its place in the tree. NONE is the default
+    because the root of the tree is usually the super administrator role: with ANCESTORS every role would get
+    EVERYTHING unless the privileges sit on the leaves.
+    RoleEdit and RoleEditByUid refuse a parent_id that would put the role below itself (CIRCULAR_REFERENCE).
+*/
+
+const (
+	RoleInheritanceNone        = "NONE"
+	RoleInheritanceAncestors   = "ANCESTORS"
+	RoleInheritanceDescendants = "DESCENDANTS"
+)
+
+const roleInheritanceMaxDepth = 32
+
+// RoleInheritanceConfigGet returns system.role_inheritance, RoleInheritanceNone when missing or unknown
+func RoleInheritanceConfigGet() string {
+	configSystem, ok := configuration.Manager.Configurations["system"]
+	if !ok || configSystem.Data == nil {
+		return RoleInheritanceNone
+	}
+	direction, err := utils.GetStringFromKV(*configSystem.Data, "role_inheritance")
+	if err != nil {
+		return RoleInheritanceNone
+	}
+	switch direction {
+	case RoleInheritanceAncestors, RoleInheritanceDescendants:
+		return direction
+	default:
+		return RoleInheritanceNone
+	}
+}
+
+// roleInheritanceRecursiveStep is the recursive member of the role_tree CTE, the same SQL on every database type but
+// for falseLiteral, how the dialect writes is_deleted = false. A soft-deleted role ends the walk, it neither gives its
+// privileges nor passes on those of the roles beyond it.
+func roleInheritanceRecursiveStep(direction string, falseLiteral string) string {
+	if direction == RoleInheritanceDescendants {
+		return `SELECT r.id, rt.depth + 1
+        FROM role_tree rt
+                 JOIN user_management.role r ON r.parent_id = rt.role_id AND r.is_deleted = ` + falseLiteral + `
+        WHERE rt.depth < ` + strconv.Itoa(roleInheritanceMaxDepth)
+	}
+	return `SELECT r.parent_id, rt.depth + 1
+        FROM role_tree rt
+                 JOIN user_management.role r ON r.id = rt.role_id AND r.is_deleted = ` + falseLiteral + `
+        WHERE r.parent_id IS NOT NULL AND rt.depth < ` + strconv.Itoa(roleInheritanceMaxDepth)
+}
+
+// UserRolePrivilegesEffective returns the privilege_id and privilege_nameid of every privilege the user gets from the
+// roles, following system.role_inheritance; userRoleMemberships are the user's own, already selected by the caller
+func (um *DxmUserManagement) UserRolePrivilegesEffective(ctx context.Context, l *dxlibLog.DXLog, userId int64, userRoleMemberships []utils.JSON) (rolePrivileges []utils.JSON, err error) {
+	direction := RoleInheritanceConfigGet()
+	if direction == RoleInheritanceNone {
+		for _, roleMembership := range userRoleMemberships {
+			_, r, err := um.RolePrivilege.Select(ctx, l, nil, utils.JSON{
+				"role_id": roleMembership["role_id"],
+			}, nil, nil, nil, nil)
+			if err != nil {
+				return nil, err
+			}
+			rolePrivileges = append(rolePrivileges, r...)
+		}
+		return rolePrivileges, nil
+	}
+
+	err = um.Role.EnsureDatabase()
+	if err != nil {
+		return nil, err
+	}
+	userIdRef := "?"
+	switch um.Role.Database.DatabaseType {
+	case dxlibBase.DXDatabaseTypePostgreSQL, dxlibBase.DXDatabaseTypePostgresSQLV2:
+		userIdRef = "$1"
+	case dxlibBase.DXDatabaseTypeOracle:
+		userIdRef = ":1"
+	}
+	query := RolePrivilegesInheritedQuery(um.Role.Database.DatabaseType, userIdRef, direction)
+	_, rolePrivileges, err = db.RawQueryRows(ctx, um.Role.Database.Connection, nil, query, []any{userId})
+	if err != nil {
+		return nil, err
+	}
+	return rolePrivileges, nil
+}
+
+// RoleParentIsCycle reports whether making parentId the parent of roleId puts the role below itself
+func (um *DxmUserManagement) RoleParentIsCycle(ctx context.Context, l *dxlibLog.DXLog, roleId int64, parentId int64) (isCycle bool, err error) {
+	id := parentId
+	for range roleInheritanceMaxDepth {
+		if id == roleId {
+			return true, nil
+		}
+		_, role, err := um.Role.ShouldGetById(ctx, l, id)
+		if err != nil {
+			return false, err
+		}
+		if role["parent_id"] == nil {
+			return false, nil
+		}
+		id, err = utils.GetInt64FromKV(role, "parent_id")
+		if err != nil {
+			return false, err
+		}
+	}
+	return false, errors.New(fmt.Sprintf("ROLE_PARENT_CHAIN_TOO_DEEP:%d", parentId))
+}
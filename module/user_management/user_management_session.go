@@ -0,0 +1,446 @@
+package user_management
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/donnyhardyanto/dxlib/api"
+	"github.com/donnyhardyanto/dxlib/utils"
+	goredis "github.com/go-redis/redis/v8"
+)
+
+// sessionIndexTouchInterval limits how often last_seen_at is rewritten for a session in use
+const sessionIndexTouchInterval = time.Minute
+
+// Reasons kept for a session that was ended by the server, reported to the client instead of SESSION_EXPIRED
+const (
+	SessionEndedReasonReplaced           = "SESSION_REPLACED"
+	SessionEndedReasonLifetimeExceeded   = "SESSION_LIFETIME_EXCEEDED"
+	SessionEndedReasonImpersonationEnded = "IMPERSONATION_ENDED"
+)
+
+/*
+  - Per-user session index, in SessionRedis
+    user_sessions:{user_id} -> hash, field session_id, value JSON
+    {"session_id","session_key","device","ip_address","user_agent","created_at","last_seen_at"}
+    session_id is derived from the session key so it can be shown and used to revoke a session
+    without ever handing out the key itself. Entries whose session has expired are pruned when listed.
+  - Ended sessions
+    session_ended:{session_key} -> JSON {"reason","ended_at"}, kept for the session TTL after the server ends a session
+    (evicted by a newer login, absolute lifetime reached), so the client can be told why instead of SESSION_EXPIRED.
+*/
+
+func userSessionsKey(userId int64) string {
+	return fmt.Sprintf("user_sessions:%d", userId)
+}
+
+func sessionEndedKey(sessionKey string) string {
+	return fmt.Sprintf("session_ended:%s", sessionKey)
+}
+
+// SessionIdFromSessionKey returns the public identifier of a session
+func SessionIdFromSessionKey(sessionKey string) string {
+	h := sha256.Sum256([]byte(sessionKey))
+	return hex.EncodeToString(h[:16])
+}
+
+// SessionDeviceImpersonationPrefix starts the device of an impersonated session in the index, such a session does not
+// count against the session limit
+const SessionDeviceImpersonationPrefix = "IMPERSONATION:"
+
+// SessionIndexEntryIsImpersonation reports whether an index entry is a session a support user opened as the user
+func SessionIndexEntryIsImpersonation(entry utils.JSON) bool {
+	device, _ := entry["device"].(string)
+	return strings.HasPrefix(device, SessionDeviceImpersonationPrefix)
+}
+
+// sessionIndexAddWithinLimitScript sets the entry ARGV[1] = ARGV[2] unless the index already holds ARGV[3] sessions
+// whose device does not start with ARGV[4], counting and adding in one step so parallel logins cannot all pass the limit
+var sessionIndexAddWithinLimitScript = goredis.NewScript(`
+if redis.call('HEXISTS', KEYS[1], ARGV[1]) == 0 then
+	local count = 0
+	for _, value in ipairs(redis.call('HVALS', KEYS[1])) do
+		local ok, entry = pcall(cjson.decode, value)
+		if not ok or type(entry['device']) ~= 'string' or string.sub(entry['device'], 1, #ARGV[4]) ~= ARGV[4] then
+			count = count + 1
+		end
+	end
+	if count >= tonumber(ARGV[3]) then
+		return 0
+	end
+end
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[2])
+return 1
+`)
+
+func sessionIndexEntry(sessionKey string, device string, ipAddress string, userAgent string) (entry []byte, err error) {
+	now := time.Now().UTC().Format(time.RFC3339)
+	return json.Marshal(utils.JSON{
+		"session_id":   SessionIdFromSessionKey(sessionKey),
+		"session_key":  sessionKey,
+		"device":       device,
+		"ip_address":   ipAddress,
+		"user_agent":   userAgent,
+		"created_at":   now,
+		"last_seen_at": now,
+	})
+}
+
+// SessionIndexAdd records a newly issued session of the user; the index lives as long as its longest session
+func (um *DxmUserManagement) SessionIndexAdd(ctx context.Context, userId int64, sessionKey string, device string, ipAddress string, userAgent string, ttl time.Duration) (err error) {
+	entry, err := sessionIndexEntry(sessionKey, device, ipAddress, userAgent)
+	if err != nil {
+		return err
+	}
+
+	key := userSessionsKey(userId)
+	err = um.SessionRedis.Connection.HSet(ctx, key, SessionIdFromSessionKey(sessionKey), entry).Err()
+	if err != nil {
+		return err
+	}
+	return um.sessionIndexExtend(ctx, key, ttl)
+}
+
+// SessionIndexAddWithinLimit is SessionIndexAdd that takes the slot only while the user has less than maxSessions live
+// sessions besides impersonated ones, isAdded is false when the limit is reached. maxSessions <= 0 means unlimited.
+// The session must already be stored, so pruning by a concurrent SessionIndexList keeps its entry.
+func (um *DxmUserManagement) SessionIndexAddWithinLimit(ctx context.Context, userId int64, sessionKey string, device string, ipAddress string, userAgent string, ttl time.Duration, maxSessions int) (isAdded bool, err error) {
+	if maxSessions <= 0 {
+		return true, um.SessionIndexAdd(ctx, userId, sessionKey, device, ipAddress, userAgent, ttl)
+	}
+	// Prunes the entries of expired sessions, so they do not hold slots
+	_, err = um.SessionIndexList(ctx, userId)
+	if err != nil {
+		return false, err
+	}
+	entry, err := sessionIndexEntry(sessionKey, device, ipAddress, userAgent)
+	if err != nil {
+		return false, err
+	}
+
+	key := userSessionsKey(userId)
+	result, err := sessionIndexAddWithinLimitScript.Run(ctx, um.SessionRedis.Connection, []string{key}, SessionIdFromSessionKey(sessionKey), entry, maxSessions,
+		SessionDeviceImpersonationPrefix).Int()
+	if err != nil {
+		return false, err
+	}
+	if result == 0 {
+		return false, nil
+	}
+	return true, um.sessionIndexExtend(ctx, key, ttl)
+}
+
+func (um *DxmUserManagement) sessionIndexExtend(ctx context.Context, key string, ttl time.Duration) (err error) {
+	currentTTL, err := um.SessionRedis.Connection.TTL(ctx, key).Result()
+	if err != nil {
+		return err
+	}
+	if currentTTL >= 0 && currentTTL >= ttl {
+		return nil
+	}
+	return um.SessionRedis.Connection.Expire(ctx, key, ttl).Err()
+}
+
+// SessionIndexTouch updates last_seen_at of a session in use, at most once per sessionIndexTouchInterval. Best-effort.
+func (um *DxmUserManagement) SessionIndexTouch(ctx context.Context, userId int64, sessionKey string, ttl time.Duration) {
+	key := userSessionsKey(userId)
+	sessionId := SessionIdFromSessionKey(sessionKey)
+	value, err := um.SessionRedis.Connection.HGet(ctx, key, sessionId).Result()
+	if err != nil {
+		// Missing entry: session issued before the index existed, or the index expired
+		return
+	}
+	entry := utils.JSON{}
+	err = json.Unmarshal([]byte(value), &entry)
+	if err != nil {
+		return
+	}
+	lastSeenAtAsString, _ := entry["last_seen_at"].(string)
+	lastSeenAt, err := time.Parse(time.RFC3339, lastSeenAtAsString)
+	if err == nil && time.Since(lastSeenAt) < sessionIndexTouchInterval {
+		return
+	}
+
+	entry["last_seen_at"] = time.Now().UTC().Format(time.RFC3339)
+	newValue, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	_ = um.SessionRedis.Connection.HSet(ctx, key, sessionId, newValue).Err()
+	_ = um.sessionIndexExtend(ctx, key, ttl)
+}
+
+// SessionIndexRemove drops a session from the index, the session itself must be deleted by the caller
+func (um *DxmUserManagement) SessionIndexRemove(ctx context.Context, userId int64, sessionKey string) (err error) {
+	return um.SessionRedis.Connection.HDel(ctx, userSessionsKey(userId), SessionIdFromSessionKey(sessionKey)).Err()
+}
+
+// SessionIndexList returns the live sessions of the user, most recently seen first, pruning expired ones.
+// Entries still contain session_key, callers must strip it before writing a response.
+func (um *DxmUserManagement) SessionIndexList(ctx context.Context, userId int64) (sessions []utils.JSON, err error) {
+	key := userSessionsKey(userId)
+	values, err := um.SessionRedis.Connection.HGetAll(ctx, key).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	sessions = []utils.JSON{}
+	staleSessionIds := []string{}
+	for sessionId, value := range values {
+		entry := utils.JSON{}
+		err = json.Unmarshal([]byte(value), &entry)
+		if err != nil {
+			staleSessionIds = append(staleSessionIds, sessionId)
+			continue
+		}
+		sessionKey, _ := entry["session_key"].(string)
+		exists, err := um.SessionRedis.Connection.Exists(ctx, sessionKey).Result()
+		if err != nil {
+			return nil, err
+		}
+		if exists == 0 {
+			staleSessionIds = append(staleSessionIds, sessionId)
+			continue
+		}
+		sessions = append(sessions, entry)
+	}
+	if len(staleSessionIds) > 0 {
+		_ = um.SessionRedis.Connection.HDel(ctx, key, staleSessionIds...).Err()
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		a, _ := sessions[i]["last_seen_at"].(string)
+		b, _ := sessions[j]["last_seen_at"].(string)
+		return a > b
+	})
+	return sessions, nil
+}
+
+// SessionIsImpersonated reports whether the request runs in a session a support user opened as another user
+func SessionIsImpersonated(aepr *api.DXAPIEndPointRequest) bool {
+	_, ok := aepr.LocalData["impersonator_user_id"]
+	return ok
+}
+
+// SessionImpersonationForbid refuses a sensitive action (password, 2FA, session management) in an impersonated session.
+// A non-nil error means the response has been written.
+func SessionImpersonationForbid(aepr *api.DXAPIEndPointRequest) (err error) {
+	if !SessionIsImpersonated(aepr) {
+		return nil
+	}
+	return aepr.WriteResponseAndNewErrorf(http.StatusForbidden, "IMPERSONATION_FORBIDDEN", "NOT_ERROR:ACTION_NOT_ALLOWED_IN_IMPERSONATED_SESSION")
+}
+
+// SessionRevoke deletes one session of the user by its session_id; isFound is false when it does not belong to the user
+func (um *DxmUserManagement) SessionRevoke(ctx context.Context, userId int64, sessionId string) (isFound bool, err error) {
+	key := userSessionsKey(userId)
+	value, err := um.SessionRedis.Connection.HGet(ctx, key, sessionId).Result()
+	if err == goredis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	entry := utils.JSON{}
+	err = json.Unmarshal([]byte(value), &entry)
+	if err != nil {
+		return false, err
+	}
+	sessionKey, _ := entry["session_key"].(string)
+	if sessionKey != "" {
+		err = um.SessionRedis.Delete(ctx, sessionKey)
+		if err != nil {
+			return false, err
+		}
+		err = um.JWTDenySession(ctx, sessionKey)
+		if err != nil {
+			return false, err
+		}
+	}
+	err = um.SessionRedis.Connection.HDel(ctx, key, sessionId).Err()
+	if err != nil {
+		return false, err
+	}
+	err = um.RefreshTokenFamilyRevokeForUser(ctx, userId, sessionId, "")
+	if err != nil {
+		return true, err
+	}
+	return true, nil
+}
+
+// SessionEvict deletes one session of the user because a newer login took its place, leaving a tombstone for ttl
+func (um *DxmUserManagement) SessionEvict(ctx context.Context, userId int64, sessionId string, ttl time.Duration) (err error) {
+	key := userSessionsKey(userId)
+	value, err := um.SessionRedis.Connection.HGet(ctx, key, sessionId).Result()
+	if err == goredis.Nil {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// The refresh token family goes first, so the tombstone left below reads SESSION_REPLACED
+	err = um.RefreshTokenFamilyRevokeForUser(ctx, userId, sessionId, "")
+	if err != nil {
+		return err
+	}
+	entry := utils.JSON{}
+	err = json.Unmarshal([]byte(value), &entry)
+	if err == nil {
+		sessionKey, _ := entry["session_key"].(string)
+		if sessionKey != "" {
+			err = um.SessionEnd(ctx, sessionKey, SessionEndedReasonReplaced, ttl)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return um.SessionRedis.Connection.HDel(ctx, key, sessionId).Err()
+}
+
+// SessionEnd deletes a session and remembers for ttl why it was ended
+func (um *DxmUserManagement) SessionEnd(ctx context.Context, sessionKey string, reason string, ttl time.Duration) (err error) {
+	err = um.SessionRedis.Set(ctx, sessionEndedKey(sessionKey), utils.JSON{
+		"reason":   reason,
+		"ended_at": time.Now().UTC().Format(time.RFC3339),
+	}, ttl)
+	if err != nil {
+		return err
+	}
+	err = um.JWTDenySession(ctx, sessionKey)
+	if err != nil {
+		return err
+	}
+	return um.SessionRedis.Delete(ctx, sessionKey)
+}
+
+// SessionEndedReason returns why the server ended a missing session, empty when it just expired or never existed
+func (um *DxmUserManagement) SessionEndedReason(ctx context.Context, sessionKey string) string {
+	if sessionKey == "" {
+		return ""
+	}
+	ended, err := um.SessionRedis.Get(ctx, sessionEndedKey(sessionKey))
+	if err != nil || ended == nil {
+		return ""
+	}
+	reason, _ := ended["reason"].(string)
+	return reason
+}
+
+// SessionRevokeAllForUser deletes every session and refresh token family of the user except exceptSessionKey (empty for all)
+func (um *DxmUserManagement) SessionRevokeAllForUser(ctx context.Context, userId int64, exceptSessionKey string) (revokedCount int, err error) {
+	key := userSessionsKey(userId)
+	values, err := um.SessionRedis.Connection.HGetAll(ctx, key).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	exceptSessionId := ""
+	if exceptSessionKey != "" {
+		exceptSessionId = SessionIdFromSessionKey(exceptSessionKey)
+	}
+	for sessionId, value := range values {
+		if sessionId == exceptSessionId {
+			continue
+		}
+		entry := utils.JSON{}
+		if json.Unmarshal([]byte(value), &entry) == nil {
+			sessionKey, _ := entry["session_key"].(string)
+			if sessionKey != "" {
+				err = um.SessionRedis.Delete(ctx, sessionKey)
+				if err != nil {
+					return revokedCount, err
+				}
+				err = um.JWTDenySession(ctx, sessionKey)
+				if err != nil {
+					return revokedCount, err
+				}
+				revokedCount++
+			}
+		}
+		err = um.SessionRedis.Connection.HDel(ctx, key, sessionId).Err()
+		if err != nil {
+			return revokedCount, err
+		}
+	}
+	err = um.RefreshTokenFamilyRevokeForUser(ctx, userId, "", exceptSessionKey)
+	if err != nil {
+		return revokedCount, err
+	}
+	if exceptSessionKey == "" {
+		// Also covers access tokens of sessions that are no longer in the index
+		err = um.JWTDenyUser(ctx, userId)
+		if err != nil {
+			return revokedCount, err
+		}
+	}
+	return revokedCount, nil
+}
+
+// sessionRevokeAllForUserAfterChange is used after suspend, delete and password reset; the change is already committed, so failures are only logged.
+// The privilege version is bumped as well, so sessions issued before the index existed are re-checked by the middleware.
+func (um *DxmUserManagement) sessionRevokeAllForUserAfterChange(aepr *api.DXAPIEndPointRequest, userId int64) {
+	um.IncrementUserPrivilegeVersion(aepr.Context, userId)
+	revokedCount, err := um.SessionRevokeAllForUser(aepr.Context, userId, "")
+	if err != nil {
+		aepr.Log.Warnf("SESSION_REVOKE_ALL_ERROR:user_id=%d:%v", userId, err)
+		return
+	}
+	aepr.Log.Infof("Revoked %d sessions of user_id=%d", revokedCount, userId)
+}
+
+// SessionIndexEntriesForResponse strips the session keys from index entries
+func SessionIndexEntriesForResponse(sessions []utils.JSON) []utils.JSON {
+	r := make([]utils.JSON, 0, len(sessions))
+	for _, session := range sessions {
+		entry := utils.JSON{}
+		for k, v := range session {
+			if k != "session_key" {
+				entry[k] = v
+			}
+		}
+		r = append(r, entry)
+	}
+	return r
+}
+
+func (um *DxmUserManagement) UserSessionList(aepr *api.DXAPIEndPointRequest) (err error) {
+	_, userId, err := aepr.GetParameterValueAsInt64("user_id")
+	if err != nil {
+		return err
+	}
+
+	sessions, err := um.SessionIndexList(aepr.Context, userId)
+	if err != nil {
+		return err
+	}
+
+	aepr.WriteResponseAsJSON(http.StatusOK, nil, utils.JSON{"data": utils.JSON{
+		"sessions": SessionIndexEntriesForResponse(sessions),
+	}})
+	return nil
+}
+
+func (um *DxmUserManagement) UserSessionRevokeAll(aepr *api.DXAPIEndPointRequest) (err error) {
+	_, userId, err := aepr.GetParameterValueAsInt64("user_id")
+	if err != nil {
+		return err
+	}
+
+	revokedCount, err := um.SessionRevokeAllForUser(aepr.Context, userId, "")
+	if err != nil {
+		return err
+	}
+	aepr.Log.Infof("Revoked %d sessions of user_id=%d by admin", revokedCount, userId)
+
+	aepr.WriteResponseAsJSON(http.StatusOK, nil, utils.JSON{"data": utils.JSON{
+		"revoked_count": revokedCount,
+	}})
+	return nil
+}
@@ -429,10 +429,11 @@ func (um *DxmUserManagement) UserCreate(aepr *api.DXAPIEndPointRequest) (err err
 	if !ok {
 		return aepr.WriteResponseAndNewErrorf(http.StatusBadRequest, "ORGANIZATION_ID_MISSING", "")
 	}
-	_, _, err = um.Organization.ShouldGetById(aepr.Context, &aepr.Log, organizationId)
+	_, organization, err := um.Organization.ShouldGetById(aepr.Context, &aepr.Log, organizationId)
 	if err != nil {
 		return aepr.WriteResponseAndNewErrorf(http.StatusBadRequest, "ORGANIZATION_NOT_FOUND", "")
 	}
+	organizationUid, _ := utils.GetStringFromKV(organization, "uid")
 
 	roleId, ok := aepr.ParameterValues["role_id"].Value.(int64)
 	if !ok {
@@ -461,15 +462,6 @@ func (um *DxmUserManagement) UserCreate(aepr *api.DXAPIEndPointRequest) (err err
 	lvPayloadPassword := lvPayloadElements[0]
 	userPassword := string(lvPayloadPassword.Value)
 
-	if um.OnUserFormatPasswordValidation != nil {
-		err = um.OnUserFormatPasswordValidation(userPassword)
-		if err != nil {
-			return aepr.WriteResponseAndNewErrorf(http.StatusUnprocessableEntity,
-				"INVALID_PASSWORD_FORMAT",
-				"NOT_ERROR:INVALID_PASSWORD_FORMAT:DETAIL=%s", err.Error())
-		}
-	}
-
 	attribute, ok := aepr.ParameterValues["attribute"].Value.(string)
 	if !ok {
 		attribute = ""
@@ -504,6 +496,16 @@ func (um *DxmUserManagement) UserCreate(aepr *api.DXAPIEndPointRequest) (err err
 		"is_avatar_exist":      false,
 	}
 
+	passwordViolation, err := um.UserPasswordValidate(aepr.Context, &aepr.Log, um.PasswordPolicyGet(organizationUid), 0, userPassword, PasswordPolicyUserInputs(p)...)
+	if err != nil {
+		return err
+	}
+	if passwordViolation != "" {
+		return aepr.WriteResponseAndNewErrorf(http.StatusUnprocessableEntity,
+			"INVALID_PASSWORD_FORMAT",
+			"NOT_ERROR:INVALID_PASSWORD_FORMAT:DETAIL=%s", passwordViolation)
+	}
+
 	identityNumber, ok := aepr.ParameterValues["identity_number"].Value.(string)
 	if ok {
 		p["identity_number"] = identityNumber
@@ -622,6 +624,7 @@ func (um *DxmUserManagement) UserCreate(aepr *api.DXAPIEndPointRequest) (err err
 
 func (um *DxmUserManagement) UserCreateV2(aepr *api.DXAPIEndPointRequest) (err error) {
 	var organizationId int64
+	var organizationUid string
 	var roleId int64
 	var ok bool
 	hasOrganizationRole := false
@@ -631,10 +634,11 @@ func (um *DxmUserManagement) UserCreateV2(aepr *api.DXAPIEndPointRequest) (err e
 		if !ok {
 			return aepr.WriteResponseAndNewErrorf(http.StatusBadRequest, "ORGANIZATION_ID_MISSING", "")
 		}
-		_, _, err = um.Organization.ShouldGetById(aepr.Context, &aepr.Log, organizationId)
+		_, organization, err := um.Organization.ShouldGetById(aepr.Context, &aepr.Log, organizationId)
 		if err != nil {
 			return aepr.WriteResponseAndNewErrorf(http.StatusBadRequest, "ORGANIZATION_NOT_FOUND", "")
 		}
+		organizationUid, _ = utils.GetStringFromKV(organization, "uid")
 
 		if paramValueRole, existsRole := aepr.ParameterValues["role_id"]; existsRole && paramValueRole != nil {
 			roleId, ok = paramValueRole.Value.(int64)
@@ -748,13 +752,18 @@ func (um *DxmUserManagement) UserCreateV2(aepr *api.DXAPIEndPointRequest) (err e
 		p["address_on_identity_card"] = addressOnIdentityCard
 	}
 
-	if um.OnUserFormatPasswordValidation != nil {
-		err = um.OnUserFormatPasswordValidation(userPassword)
-		if err != nil {
-			return aepr.WriteResponseAndNewErrorf(http.StatusUnprocessableEntity,
-				"INVALID_PASSWORD_FORMAT",
-				"NOT_ERROR:INVALID_PASSWORD_FORMAT:DETAIL=%s", err.Error())
-		}
+	policy := um.PasswordPolicyGet()
+	if organizationUid != "" {
+		policy = um.PasswordPolicyGet(organizationUid)
+	}
+	passwordViolation, err := um.UserPasswordValidate(aepr.Context, &aepr.Log, policy, 0, userPassword, PasswordPolicyUserInputs(p)...)
+	if err != nil {
+		return err
+	}
+	if passwordViolation != "" {
+		return aepr.WriteResponseAndNewErrorf(http.StatusUnprocessableEntity,
+			"INVALID_PASSWORD_FORMAT",
+			"NOT_ERROR:INVALID_PASSWORD_FORMAT:DETAIL=%s", passwordViolation)
 	}
 
 	var userId int64
@@ -1065,6 +1074,8 @@ func (um *DxmUserManagement) DoUserDelete(aepr *api.DXAPIEndPointRequest, userId
 		return 0, nil, err
 	}
 
+	um.sessionRevokeAllForUserAfterChange(aepr, userId)
+
 	return userId, uid, nil
 }
 
@@ -1174,6 +1185,8 @@ func (um *DxmUserManagement) UserSuspend(aepr *api.DXAPIEndPointRequest) (err er
 		return err
 	}
 
+	um.sessionRevokeAllForUserAfterChange(aepr, userId)
+
 	aepr.WriteResponseAsJSON(http.StatusOK, nil, nil)
 	return nil
 }
@@ -1278,15 +1291,15 @@ func (um *DxmUserManagement) TxUserPasswordCreate(tx *databases.DXDatabaseTx, us
 func hashBlock(saltValue []byte, saltMethod byte, data []byte) ([]byte, error) {
 	var hashPasswordBlock []byte
 	switch saltMethod {
-	case 1:
+	case PasswordHashMethodSHA512:
 		hashPasswordBlock = security.HashSHA512(data)
-	case 2:
+	case PasswordHashMethodBcrypt:
 		var err error
 		hashPasswordBlock, err = security.HashBcrypt(data)
 		if err != nil {
 			return hashPasswordBlock, err
 		}
-	case 3:
+	case PasswordHashMethodArgon2id:
 		hashPasswordBlock = security.HashArgon2id(data, saltValue)
 	default:
 		return hashPasswordBlock, errors.New(fmt.Sprintf("Unknown salt method %d", saltMethod))
@@ -1311,14 +1324,31 @@ func (um *DxmUserManagement) passwordHashCreate(password string) (hashedString s
 		return "", err
 	}
 
-	hashPasswordBlock, err := hashBlock(lvSalt.Value, lvSaltMethod.Value[0], passwordAsBytes)
+	var hashPasswordBlock []byte
+	if saltMethod == PasswordHashMethodArgon2idV2 {
+		hashPasswordBlock = hashBlockArgon2id(lvSalt.Value, passwordAsBytes, um.PasswordHashArgon2idParameters)
+	} else {
+		hashPasswordBlock, err = hashBlock(lvSalt.Value, lvSaltMethod.Value[0], passwordAsBytes)
+		if err != nil {
+			return "", err
+		}
+	}
 
 	lvHashedPasswordBlock, err := lv.NewLV(hashPasswordBlock)
 	if err != nil {
 		return "", err
 	}
 
-	lvHashedPassword, err := lv.CombineLV(lvSalt, lvSaltMethod, lvHashedPasswordBlock)
+	lvElements := []*lv.LV{lvSalt, lvSaltMethod, lvHashedPasswordBlock}
+	if saltMethod == PasswordHashMethodArgon2idV2 {
+		lvParameters, err := argon2idParametersLVs(um.PasswordHashArgon2idParameters)
+		if err != nil {
+			return "", err
+		}
+		lvElements = append(lvElements, lvParameters...)
+	}
+
+	lvHashedPassword, err := lv.CombineLV(lvElements...)
 	if err != nil {
 		return "", err
 	}
@@ -1365,18 +1395,23 @@ func (um *DxmUserManagement) passwordHashVerify(tryPassword string, hashedPasswo
 	tryPasswordAsBytes := []byte(tryPassword)
 
 	switch saltMethod {
-	case 1:
+	case PasswordHashMethodSHA512:
 		tryHashPasswordBlock, err := hashBlock(lvSalt.Value, saltMethod, tryPasswordAsBytes)
 		if err != nil {
 			return false, err
 		}
 		verificationResult = bytes.Equal(tryHashPasswordBlock, lvHashedUserPasswordBlock.Value)
-	case 2:
+	case PasswordHashMethodBcrypt:
 		err = security.HashBcryptVerify(lvHashedUserPasswordBlock.Value, tryPasswordAsBytes)
 		verificationResult = err == nil
 		err = nil
-	case 3:
+	case PasswordHashMethodArgon2id:
 		verificationResult = security.HashArgon2idVerify(tryPasswordAsBytes, lvSalt.Value, lvHashedUserPasswordBlock.Value)
+	case PasswordHashMethodArgon2idV2:
+		verificationResult, err = argon2idVerify(tryPasswordAsBytes, lvSeparateElements)
+		if err != nil {
+			return false, err
+		}
 	default:
 		return false, errors.New(fmt.Sprintf("Unknown salt method %d", saltMethod))
 	}
@@ -1404,42 +1439,54 @@ func (um *DxmUserManagement) extractSaltMethodFromHash(hexHash string) (byte, er
 	return elements[1].Value[0], nil
 }
 
-func (um *DxmUserManagement) UserPasswordVerify(ctx context.Context, l *dxlibLog.DXLog, userId int64, tryPassword string) (verificationResult bool, err error) {
-	_, userPasswordRow, err := um.UserPassword.SelectOneAuto(ctx, l, []string{"id", "user_id", "value"}, utils.JSON{
-		"user_id": userId,
-	}, nil, db.DXDatabaseTableFieldsOrderBy{"id": "DESC"})
+// passwordHashIsOutdated reports whether a stored hash uses an older method, or Argon2id parameters other than
+// the current ones, than a new hash would
+func (um *DxmUserManagement) passwordHashIsOutdated(hexHash string) (storedMethod byte, isOutdated bool) {
+	storedMethod, err := um.extractSaltMethodFromHash(hexHash)
 	if err != nil {
-		return false, err
+		return 0, false
 	}
-	if userPasswordRow == nil {
-		return false, errors.New("userPasswordVerify:USER_PASSWORD_NOT_FOUND")
+	if storedMethod < um.CurrentPasswordHashMethod {
+		return storedMethod, true
 	}
-	userPasswordValue, err := utils.GetStringFromKV(userPasswordRow, "value")
+	if storedMethod != PasswordHashMethodArgon2idV2 || um.CurrentPasswordHashMethod != PasswordHashMethodArgon2idV2 {
+		return storedMethod, false
+	}
+	hashedBytes, err := hex.DecodeString(hexHash)
 	if err != nil {
-		return false, err
+		return storedMethod, false
 	}
-	verificationResult, err = um.passwordHashVerify(tryPassword, userPasswordValue)
+	lvHashed := lv.LV{}
+	err = lvHashed.UnmarshalBinary(hashedBytes)
 	if err != nil {
-		return false, err
+		return storedMethod, false
 	}
-
-	if verificationResult {
-		storedMethod, extractErr := um.extractSaltMethodFromHash(userPasswordValue)
-		if extractErr == nil && storedMethod != um.CurrentPasswordHashMethod {
-			newHash, hashErr := um.passwordHashCreate(tryPassword)
-			if hashErr == nil {
-				_, _, rehashErr := um.UserPassword.UpdateAuto(ctx, l, utils.JSON{"value": newHash},
-					utils.JSON{"user_id": userId}, nil)
-				if rehashErr != nil {
-					l.Warnf("UserPasswordVerify: failed to rehash password for user_id=%d: %v", userId, rehashErr)
-				}
-			}
-		}
+	elements, err := lvHashed.Expand()
+	if err != nil {
+		return storedMethod, false
 	}
+	p, err := argon2idParametersFromLVs(elements)
+	if err != nil {
+		return storedMethod, false
+	}
+	return storedMethod, p != um.PasswordHashArgon2idParameters
+}
 
+// UserPasswordVerify verifies the current password of the user, see TxUserPasswordVerify
+func (um *DxmUserManagement) UserPasswordVerify(ctx context.Context, l *dxlibLog.DXLog, userId int64, tryPassword string) (verificationResult bool, err error) {
+	err = databases.Manager.GetOrCreate(um.DatabaseNameId).Tx(ctx, l, sql.LevelReadCommitted, func(tx *databases.DXDatabaseTx) (err error) {
+		verificationResult, err = um.TxUserPasswordVerify(tx, userId, tryPassword)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
 	return verificationResult, nil
 }
 
+// TxUserPasswordVerify verifies the current password of the user. On success a password stored with an older
+// hash method than CurrentPasswordHashMethod, or older Argon2id parameters, is hashed again with the current one,
+// in the same row and transaction.
 func (um *DxmUserManagement) TxUserPasswordVerify(tx *databases.DXDatabaseTx, userId int64, tryPassword string) (verificationResult bool, err error) {
 	_, userPasswordRow, err := um.UserPassword.TxSelectOneAuto(tx, []string{"id", "user_id", "value"}, utils.JSON{
 		"user_id": userId,
@@ -1460,14 +1507,18 @@ func (um *DxmUserManagement) TxUserPasswordVerify(tx *databases.DXDatabaseTx, us
 	}
 
 	if verificationResult {
-		storedMethod, extractErr := um.extractSaltMethodFromHash(userPasswordValue)
-		if extractErr == nil && storedMethod != um.CurrentPasswordHashMethod {
+		storedMethod, isOutdated := um.passwordHashIsOutdated(userPasswordValue)
+		if isOutdated {
+			// Only the current row, the older rows are the password history
+			userPasswordId, _ := utils.GetInt64FromKV(userPasswordRow, "id")
 			newHash, hashErr := um.passwordHashCreate(tryPassword)
 			if hashErr == nil {
 				_, _, rehashErr := um.UserPassword.TxUpdateAuto(tx, utils.JSON{"value": newHash},
-					utils.JSON{"user_id": userId}, nil)
+					utils.JSON{"id": userPasswordId}, nil)
 				if rehashErr != nil {
 					dxlibLog.Log.Warnf("TxUserPasswordVerify: failed to rehash password for user_id=%d: %v", userId, rehashErr)
+				} else {
+					dxlibLog.Log.Infof("TxUserPasswordVerify: password of user_id=%d rehashed from method %d to %d", userId, storedMethod, um.CurrentPasswordHashMethod)
 				}
 			}
 		}
@@ -1603,6 +1654,10 @@ func generateRandomString(n int) string {
 }
 
 func (um *DxmUserManagement) UserResetPassword(aepr *api.DXAPIEndPointRequest) (err error) {
+	err = SessionImpersonationForbid(aepr)
+	if err != nil {
+		return err
+	}
 	_, userId, err := aepr.GetParameterValueAsInt64("user_id")
 	if err != nil {
 		return err
@@ -1641,6 +1696,11 @@ func (um *DxmUserManagement) UserResetPassword(aepr *api.DXAPIEndPointRequest) (
 
 		return nil
 	})
+	if err != nil {
+		return err
+	}
 
-	return err
+	um.sessionRevokeAllForUserAfterChange(aepr, userId)
+
+	return nil
 }
@@ -0,0 +1,332 @@
+package user_management
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/donnyhardyanto/dxlib/databases"
+	"github.com/donnyhardyanto/dxlib/errors"
+	dxlibLog "github.com/donnyhardyanto/dxlib/log"
+	"github.com/donnyhardyanto/dxlib/utils"
+	"github.com/donnyhardyanto/dxlib/utils/crypto/rand"
+	"github.com/donnyhardyanto/dxlib_module/lib"
+)
+
+const (
+	RecoveryCodeCount = 10
+	// TotpSkewSteps accepts the code of the previous and next time step to absorb clock drift on the device
+	TotpSkewSteps = 1
+)
+
+/*
+  - TOTP second factor
+    user_totp holds one row per user, the secret is encrypted with the user password key.
+    A row with is_confirmed=false is a pending enrollment, it only becomes active after the first valid code.
+    last_used_time_step stops a code from being accepted twice.
+  - Recovery codes
+    Stored in user_recovery_code with the same LV(LV(SALT),LV(SALT_METHOD),LV(HASH)) scheme as user passwords,
+    each code can be used once.
+*/
+
+func (um *DxmUserManagement) UserTotpGet(ctx context.Context, l *dxlibLog.DXLog, userId int64) (userTotp utils.JSON, err error) {
+	_, userTotp, err = um.UserTotp.SelectOneAuto(ctx, l, []string{"id", "user_id", "secret", "is_confirmed", "confirmed_at", "last_used_time_step"}, utils.JSON{
+		"user_id": userId,
+	}, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return userTotp, nil
+}
+
+// UserTotpIsEnabled reports whether the user has a confirmed TOTP secret
+func (um *DxmUserManagement) UserTotpIsEnabled(ctx context.Context, l *dxlibLog.DXLog, userId int64) (isEnabled bool, err error) {
+	userTotp, err := um.UserTotpGet(ctx, l, userId)
+	if err != nil {
+		return false, err
+	}
+	if userTotp == nil {
+		return false, nil
+	}
+	isEnabled, _ = userTotp["is_confirmed"].(bool)
+	return isEnabled, nil
+}
+
+// UserTotpEnrollBegin generates a new pending secret, replacing an earlier unconfirmed one
+func (um *DxmUserManagement) UserTotpEnrollBegin(ctx context.Context, l *dxlibLog.DXLog, userId int64) (secret string, err error) {
+	secret, err = lib.TOTPGenerateSecret()
+	if err != nil {
+		return "", err
+	}
+	err = databases.Manager.GetOrCreate(um.DatabaseNameId).Tx(ctx, l, sql.LevelReadCommitted, func(tx *databases.DXDatabaseTx) (err2 error) {
+		_, userTotp, err2 := um.UserTotp.TxSelectOneAuto(tx, []string{"id", "is_confirmed"}, utils.JSON{
+			"user_id": userId,
+		}, nil, nil, "FOR UPDATE")
+		if err2 != nil {
+			return err2
+		}
+		if userTotp != nil {
+			isConfirmed, _ := userTotp["is_confirmed"].(bool)
+			if isConfirmed {
+				return errors.New("TOTP_ALREADY_ENABLED")
+			}
+			_, err2 = um.UserTotp.TxHardDelete(tx, utils.JSON{
+				"user_id": userId,
+			})
+			if err2 != nil {
+				return err2
+			}
+		}
+		_, err2 = um.UserTotp.TxInsertAutoReturningId(tx, utils.JSON{
+			"user_id":             userId,
+			"secret":              secret,
+			"is_confirmed":        false,
+			"last_used_time_step": 0,
+		})
+		return err2
+	})
+	if err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// UserTotpEnrollConfirm activates the pending secret when code matches it and issues a fresh set of recovery codes
+func (um *DxmUserManagement) UserTotpEnrollConfirm(ctx context.Context, l *dxlibLog.DXLog, userId int64, code string) (isValid bool, recoveryCodes []string, err error) {
+	err = databases.Manager.GetOrCreate(um.DatabaseNameId).Tx(ctx, l, sql.LevelReadCommitted, func(tx *databases.DXDatabaseTx) (err2 error) {
+		_, userTotp, err2 := um.UserTotp.TxSelectOneAuto(tx, []string{"id", "secret", "is_confirmed"}, utils.JSON{
+			"user_id": userId,
+		}, nil, nil, "FOR UPDATE")
+		if err2 != nil {
+			return err2
+		}
+		if userTotp == nil {
+			return errors.New("TOTP_ENROLLMENT_NOT_FOUND")
+		}
+		isConfirmed, _ := userTotp["is_confirmed"].(bool)
+		if isConfirmed {
+			return errors.New("TOTP_ALREADY_ENABLED")
+		}
+		secret, err2 := utils.GetStringFromKV(userTotp, "secret")
+		if err2 != nil {
+			return err2
+		}
+
+		timeStep, ok, err2 := lib.TOTPVerify(secret, code, time.Now(), TotpSkewSteps)
+		if err2 != nil {
+			return err2
+		}
+		if !ok {
+			return nil
+		}
+		isValid = true
+
+		_, err2 = um.UserTotp.TxUpdateSimple(tx, utils.JSON{
+			"is_confirmed":        true,
+			"confirmed_at":        time.Now().UTC(),
+			"last_used_time_step": timeStep,
+		}, utils.JSON{
+			"user_id": userId,
+		})
+		if err2 != nil {
+			return err2
+		}
+
+		recoveryCodes, err2 = um.TxUserRecoveryCodesRegenerate(tx, userId)
+		return err2
+	})
+	if err != nil {
+		return false, nil, err
+	}
+	return isValid, recoveryCodes, nil
+}
+
+// UserTotpVerify checks code against the confirmed secret, a code already accepted once is rejected
+func (um *DxmUserManagement) UserTotpVerify(ctx context.Context, l *dxlibLog.DXLog, userId int64, code string) (isValid bool, err error) {
+	err = databases.Manager.GetOrCreate(um.DatabaseNameId).Tx(ctx, l, sql.LevelReadCommitted, func(tx *databases.DXDatabaseTx) (err2 error) {
+		_, userTotp, err2 := um.UserTotp.TxSelectOneAuto(tx, []string{"id", "secret", "is_confirmed", "last_used_time_step"}, utils.JSON{
+			"user_id": userId,
+		}, nil, nil, "FOR UPDATE")
+		if err2 != nil {
+			return err2
+		}
+		if userTotp == nil {
+			return nil
+		}
+		isConfirmed, _ := userTotp["is_confirmed"].(bool)
+		if !isConfirmed {
+			return nil
+		}
+		secret, err2 := utils.GetStringFromKV(userTotp, "secret")
+		if err2 != nil {
+			return err2
+		}
+		lastUsedTimeStep, err2 := utils.GetInt64FromKV(userTotp, "last_used_time_step")
+		if err2 != nil {
+			return err2
+		}
+
+		timeStep, ok, err2 := lib.TOTPVerify(secret, code, time.Now(), TotpSkewSteps)
+		if err2 != nil {
+			return err2
+		}
+		if !ok || timeStep <= lastUsedTimeStep {
+			return nil
+		}
+		isValid = true
+
+		_, err2 = um.UserTotp.TxUpdateSimple(tx, utils.JSON{
+			"last_used_time_step": timeStep,
+		}, utils.JSON{
+			"user_id": userId,
+		})
+		return err2
+	})
+	if err != nil {
+		return false, err
+	}
+	return isValid, nil
+}
+
+// UserTotpDisable removes the TOTP secret and all recovery codes of the user
+func (um *DxmUserManagement) UserTotpDisable(ctx context.Context, l *dxlibLog.DXLog, userId int64) (err error) {
+	return databases.Manager.GetOrCreate(um.DatabaseNameId).Tx(ctx, l, sql.LevelReadCommitted, func(tx *databases.DXDatabaseTx) (err2 error) {
+		_, err2 = um.UserTotp.TxHardDelete(tx, utils.JSON{
+			"user_id": userId,
+		})
+		if err2 != nil {
+			return err2
+		}
+		_, err2 = um.UserRecoveryCode.TxHardDelete(tx, utils.JSON{
+			"user_id": userId,
+		})
+		return err2
+	})
+}
+
+func recoveryCodeGenerate() string {
+	s := hex.EncodeToString(rand.RandomData(5))
+	return s[:5] + "-" + s[5:]
+}
+
+func recoveryCodeNormalize(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// TxUserRecoveryCodesRegenerate replaces all recovery codes of the user, the plain codes are only ever returned here
+func (um *DxmUserManagement) TxUserRecoveryCodesRegenerate(tx *databases.DXDatabaseTx, userId int64) (recoveryCodes []string, err error) {
+	_, err = um.UserRecoveryCode.TxHardDelete(tx, utils.JSON{
+		"user_id": userId,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	recoveryCodes = make([]string, 0, RecoveryCodeCount)
+	for i := 0; i < RecoveryCodeCount; i++ {
+		recoveryCode := recoveryCodeGenerate()
+		hashedRecoveryCodeAsHexString, err := um.passwordHashCreate(recoveryCodeNormalize(recoveryCode))
+		if err != nil {
+			return nil, err
+		}
+		_, err = um.UserRecoveryCode.TxInsertReturningId(tx, utils.JSON{
+			"user_id": userId,
+			"value":   hashedRecoveryCodeAsHexString,
+			"is_used": false,
+		})
+		if err != nil {
+			return nil, err
+		}
+		recoveryCodes = append(recoveryCodes, recoveryCode)
+	}
+	return recoveryCodes, nil
+}
+
+func (um *DxmUserManagement) UserRecoveryCodesRegenerate(ctx context.Context, l *dxlibLog.DXLog, userId int64) (recoveryCodes []string, err error) {
+	err = databases.Manager.GetOrCreate(um.DatabaseNameId).Tx(ctx, l, sql.LevelReadCommitted, func(tx *databases.DXDatabaseTx) (err2 error) {
+		recoveryCodes, err2 = um.TxUserRecoveryCodesRegenerate(tx, userId)
+		return err2
+	})
+	if err != nil {
+		return nil, err
+	}
+	return recoveryCodes, nil
+}
+
+// UserRecoveryCodeVerify consumes the matching unused recovery code of the user
+func (um *DxmUserManagement) UserRecoveryCodeVerify(ctx context.Context, l *dxlibLog.DXLog, userId int64, code string) (isValid bool, err error) {
+	code = recoveryCodeNormalize(code)
+	if code == "" {
+		return false, nil
+	}
+
+	_, userRecoveryCodes, err := um.UserRecoveryCode.Select(ctx, l, []string{"id", "value"}, utils.JSON{
+		"user_id": userId,
+		"is_used": false,
+	}, nil, nil, nil, nil)
+	if err != nil {
+		return false, err
+	}
+
+	for _, userRecoveryCode := range userRecoveryCodes {
+		value, err := utils.GetStringFromKV(userRecoveryCode, "value")
+		if err != nil {
+			return false, err
+		}
+		ok, err := um.passwordHashVerify(code, value)
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			continue
+		}
+
+		userRecoveryCodeId, err := utils.GetInt64FromKV(userRecoveryCode, "id")
+		if err != nil {
+			return false, err
+		}
+		err = databases.Manager.GetOrCreate(um.DatabaseNameId).Tx(ctx, l, sql.LevelReadCommitted, func(tx *databases.DXDatabaseTx) (err2 error) {
+			// Re-read under lock, a concurrent login may have consumed the same code
+			_, row, err2 := um.UserRecoveryCode.TxSelectOne(tx, []string{"id"}, utils.JSON{
+				"id":      userRecoveryCodeId,
+				"is_used": false,
+			}, nil, nil, "FOR UPDATE")
+			if err2 != nil {
+				return err2
+			}
+			if row == nil {
+				return nil
+			}
+			_, err2 = um.UserRecoveryCode.TxUpdateSimple(tx, utils.JSON{
+				"is_used": true,
+				"used_at": time.Now().UTC(),
+			}, utils.JSON{
+				"id": userRecoveryCodeId,
+			})
+			if err2 != nil {
+				return err2
+			}
+			isValid = true
+			return nil
+		})
+		if err != nil {
+			return false, err
+		}
+		return isValid, nil
+	}
+	return false, nil
+}
+
+func (um *DxmUserManagement) UserRecoveryCodeRemainingCount(ctx context.Context, l *dxlibLog.DXLog, userId int64) (count int, err error) {
+	_, userRecoveryCodes, err := um.UserRecoveryCode.Select(ctx, l, []string{"id"}, utils.JSON{
+		"user_id": userId,
+		"is_used": false,
+	}, nil, nil, nil, nil)
+	if err != nil {
+		return 0, err
+	}
+	return len(userRecoveryCodes), nil
+}